@@ -1,39 +1,135 @@
 package main
 
-import "time"
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
 
 type Config struct {
+	// DataDir holds every file of the store, laid out as the path methods
+	// below describe. A process writing to it holds the lock on LockPath,
+	// others can only open it read-only.
+	DataDir  string
+	ReadOnly bool
+
 	VacuumInterval time.Duration
 
-	TxManifestPath        string
+	// ShutdownTimeout is how long active transactions get to finish once
+	// the process is asked to stop.
+	ShutdownTimeout time.Duration
+
 	ReservedTxIDsPerBatch uint64
 	MaxActiveTx           uint16
 
+	// LockTimeout is how long a transaction waits for a key locked by
+	// another one.
+	LockTimeout time.Duration
+
+	// WaitOnConflict has a write conflicting with an active transaction wait
+	// up to ConflictTimeout for it to end rather than fail right away.
+	WaitOnConflict  bool
+	ConflictTimeout time.Duration
+
 	MaxKeySize   int
 	MaxValueSize int
 
-	WalBufferSize   int
-	WalCommitWait   time.Duration
-	LogSegmentSize  int64
-	LogDir          string
-	LogManifestPath string
+	WalBufferSize  int
+	WalCommitWait  time.Duration
+	LogSegmentSize int64
+
+	LogRecycledSegments     int
+	WalCompressionThreshold int
+
+	CompactLogOnStartup bool
+	RecoveryWorkers     int
+
+	// AutoCommit runs a command given outside of a transaction in one of its
+	// own, tried up to AutoCommitRetries more times on conflicts.
+	AutoCommit        bool
+	AutoCommitRetries int
+
+	// ScriptFile is run instead of reading commands from standard input.
+	// Lines entered at a terminal are kept in HistoryFile, up to
+	// HistorySize of them.
+	ScriptFile  string
+	HistoryFile string
+	HistorySize int
+
+	// Encryption keys are read from the environment variable if set, from
+	// the file otherwise. There is no default file, so that keys are not kept
+	// next to the data they protect. Without keys, data is stored
+	// unencrypted.
+	EncryptionKeyFile string
+	EncryptionKeyEnv  string
 }
 
 func DefaultConfig() Config {
 	return Config{
+		DataDir:  "./internals",
+		ReadOnly: false,
+
 		VacuumInterval: 120 * time.Second,
 
-		TxManifestPath:        "./internals/transactions/manifest.json",
+		ShutdownTimeout: 10 * time.Second,
+
 		ReservedTxIDsPerBatch: 1000,
 		MaxActiveTx:           100,
 
+		LockTimeout: 5 * time.Second,
+
+		WaitOnConflict:  false,
+		ConflictTimeout: time.Second,
+
 		MaxKeySize:   1024,
 		MaxValueSize: 128 * 1024,
 
-		WalBufferSize:   512 * 1024,
-		WalCommitWait:   5 * time.Millisecond,
-		LogSegmentSize:  512 * 1024,
-		LogDir:          "./internals/log",
-		LogManifestPath: "./internals/log/manifest.json",
+		WalBufferSize:  512 * 1024,
+		WalCommitWait:  5 * time.Millisecond,
+		LogSegmentSize: 512 * 1024,
+
+		LogRecycledSegments:     4,
+		WalCompressionThreshold: 512,
+
+		CompactLogOnStartup: false,
+		RecoveryWorkers:     runtime.NumCPU(),
+
+		AutoCommit:        true,
+		AutoCommitRetries: 3,
+
+		ScriptFile:  "",
+		HistoryFile: defaultHistoryFile(),
+		HistorySize: 1000,
+
+		EncryptionKeyFile: "",
+		EncryptionKeyEnv:  "KV_ENCRYPTION_KEYS",
 	}
 }
+
+func (c Config) LockPath() string {
+	return filepath.Join(c.DataDir, "LOCK")
+}
+
+func (c Config) LogDir() string {
+	return filepath.Join(c.DataDir, "log")
+}
+
+func (c Config) LogManifestPath() string {
+	return filepath.Join(c.LogDir(), "manifest.json")
+}
+
+func (c Config) TxManifestPath() string {
+	return filepath.Join(c.DataDir, "transactions", "manifest.json")
+}
+
+// defaultHistoryFile keeps history in the home directory, or nowhere if
+// there is none.
+func defaultHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(home, ".kv_history")
+}
@@ -0,0 +1,9 @@
+package encryption
+
+import "errors"
+
+var InvalidKeyError = errors.New("encryption: invalid key")
+var KeyNotFoundError = errors.New("encryption: key not found")
+var KeyRequiredError = errors.New("encryption: data is encrypted but no keys are loaded")
+var DecryptionFailedError = errors.New("encryption: decryption failed")
+var UnencryptedDataError = errors.New("encryption: keys are loaded but data is not encrypted")
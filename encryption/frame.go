@@ -0,0 +1,54 @@
+package encryption
+
+import "bytes"
+
+// frameMagic marks sealed frames, so that files written before encryption
+// was enabled can be told apart from them.
+var frameMagic = []byte("KVE1")
+
+// SealFrame seals the content of a small file, e.g. a manifest, that is
+// always written as a whole. With a nil keyring the content is returned
+// unchanged.
+func SealFrame(k *Keyring, content, additionalData []byte) []byte {
+	if k == nil {
+		return content
+	}
+
+	frame := append([]byte(nil), frameMagic...)
+	return k.Seal(frame, content, additionalData)
+}
+
+// OpenFrame returns the content of a frame written by SealFrame. Content
+// written without encryption is returned as is with a nil keyring, and
+// refused otherwise, so that a plain file cannot be swapped in for a sealed
+// one.
+func OpenFrame(k *Keyring, frame, additionalData []byte) ([]byte, error) {
+	if !bytes.HasPrefix(frame, frameMagic) {
+		if k != nil {
+			return nil, UnencryptedDataError
+		}
+
+		return frame, nil
+	}
+
+	return openSealed(k, frame, additionalData)
+}
+
+// OpenFrameForMigration is OpenFrame, except that it returns content written
+// without encryption as is even with keys loaded. It is meant for encrypting
+// a store for the first time.
+func OpenFrameForMigration(k *Keyring, frame, additionalData []byte) ([]byte, error) {
+	if !bytes.HasPrefix(frame, frameMagic) {
+		return frame, nil
+	}
+
+	return openSealed(k, frame, additionalData)
+}
+
+func openSealed(k *Keyring, frame, additionalData []byte) ([]byte, error) {
+	if k == nil {
+		return nil, KeyRequiredError
+	}
+
+	return k.Open(nil, frame[len(frameMagic):], additionalData)
+}
@@ -0,0 +1,187 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	keyIDSize = 4
+	nonceSize = 12
+	tagSize   = 16
+
+	// Overhead is how much larger sealing makes a message.
+	Overhead = keyIDSize + nonceSize + tagSize
+)
+
+// Keyring holds the AES-GCM keys data can be encrypted with, by ID. New data
+// is sealed with the active key, the one with the highest ID, while older
+// keys stay available to open data sealed before a rotation. Key ID zero is
+// reserved to mean "not encrypted".
+//
+// A nil Keyring is valid and means encryption is disabled.
+type Keyring struct {
+	ciphers map[uint32]cipher.AEAD
+	active  uint32
+}
+
+func NewKeyring(keys map[uint32][]byte) (*Keyring, error) {
+	k := &Keyring{
+		ciphers: make(map[uint32]cipher.AEAD, len(keys)),
+	}
+
+	for id, key := range keys {
+		if id == 0 {
+			return nil, fmt.Errorf("%w: key id 0 is reserved", InvalidKeyError)
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("%w: key %d: %v", InvalidKeyError, id, err)
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		k.ciphers[id] = aead
+		k.active = max(k.active, id)
+	}
+
+	if len(k.ciphers) == 0 {
+		return nil, fmt.Errorf("%w: no keys", InvalidKeyError)
+	}
+
+	return k, nil
+}
+
+// ParseKeyring reads keys written as "<id>:<hex key>" entries, separated by
+// whitespace or commas. Lines starting with # are ignored.
+func ParseKeyring(text string) (*Keyring, error) {
+	keys := make(map[uint32][]byte)
+
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+
+		for _, entry := range strings.FieldsFunc(line, isSeparator) {
+			idText, keyText, ok := strings.Cut(entry, ":")
+			if !ok {
+				return nil, fmt.Errorf("%w: expected <id>:<hex key>", InvalidKeyError)
+			}
+
+			id, err := strconv.ParseUint(idText, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid key id %q", InvalidKeyError, idText)
+			}
+
+			key, err := hex.DecodeString(keyText)
+			if err != nil {
+				return nil, fmt.Errorf("%w: key %d is not hex encoded", InvalidKeyError, id)
+			}
+
+			if _, ok = keys[uint32(id)]; ok {
+				return nil, fmt.Errorf("%w: duplicate key id %d", InvalidKeyError, id)
+			}
+
+			keys[uint32(id)] = key
+		}
+	}
+
+	return NewKeyring(keys)
+}
+
+// LoadKeyring reads keys from the environment variable if it is set, from
+// the file otherwise. Either may be empty to skip it, but a file that is
+// given must exist. Without any keys it returns a nil Keyring, leaving
+// encryption disabled.
+func LoadKeyring(path string, envVar string) (*Keyring, error) {
+	if envVar != "" {
+		if text, ok := os.LookupEnv(envVar); ok && strings.TrimSpace(text) != "" {
+			return ParseKeyring(text)
+		}
+	}
+
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseKeyring(string(data))
+}
+
+// ActiveKeyID returns the ID of the key new data is sealed with, zero when
+// encryption is disabled.
+func (k *Keyring) ActiveKeyID() uint32 {
+	if k == nil {
+		return 0
+	}
+
+	return k.active
+}
+
+func (k *Keyring) HasKey(id uint32) bool {
+	if k == nil {
+		return false
+	}
+
+	_, ok := k.ciphers[id]
+	return ok
+}
+
+// Seal encrypts plaintext with the active key and appends the result,
+// prefixed with the key ID and a random nonce, to dst.
+func (k *Keyring) Seal(dst, plaintext, additionalData []byte) []byte {
+	dst = binary.LittleEndian.AppendUint32(dst, k.active)
+
+	nonceStart := len(dst)
+	dst = append(dst, make([]byte, nonceSize)...)
+	_, _ = rand.Read(dst[nonceStart:])
+
+	return k.ciphers[k.active].Seal(dst, dst[nonceStart:], plaintext, additionalData)
+}
+
+// Open decrypts a message produced by Seal, with whichever key it was sealed
+// with, and appends the plaintext to dst.
+func (k *Keyring) Open(dst, sealed, additionalData []byte) ([]byte, error) {
+	if k == nil {
+		return nil, KeyRequiredError
+	}
+
+	if len(sealed) < Overhead {
+		return nil, DecryptionFailedError
+	}
+
+	id := binary.LittleEndian.Uint32(sealed)
+
+	aead, ok := k.ciphers[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", KeyNotFoundError, id)
+	}
+
+	nonce := sealed[keyIDSize : keyIDSize+nonceSize]
+
+	plaintext, err := aead.Open(dst, nonce, sealed[keyIDSize+nonceSize:], additionalData)
+	if err != nil {
+		return nil, DecryptionFailedError
+	}
+
+	return plaintext, nil
+}
+
+func isSeparator(r rune) bool {
+	return r == ',' || r == ' ' || r == '\t' || r == '\r'
+}
@@ -0,0 +1,136 @@
+package encryption
+
+import (
+	"bytes"
+	"kv/test"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	testKey1 = "000102030405060708090a0b0c0d0e0f000102030405060708090a0b0c0d0e0f"
+	testKey2 = "101112131415161718191a1b1c1d1e1f101112131415161718191a1b1c1d1e1f"
+)
+
+func TestKeyring(t *testing.T) {
+	t.Run("it parses keys and activates the highest id", func(t *testing.T) {
+		k, err := ParseKeyring("# keys\n1:" + testKey1 + "\n2:" + testKey2 + "\n")
+		test.AssertNoError(t, err)
+
+		test.AssertEqual(t, k.ActiveKeyID(), uint32(2))
+		test.AssertTrue(t, k.HasKey(1))
+		test.AssertFalse(t, k.HasKey(3))
+	})
+
+	t.Run("it rejects invalid keys", func(t *testing.T) {
+		for _, text := range []string{"", "1", "x:" + testKey1, "1:zz", "1:0011", "0:" + testKey1, "1:" + testKey1 + ",1:" + testKey2} {
+			_, err := ParseKeyring(text)
+			test.AssertError(t, err, InvalidKeyError)
+		}
+	})
+
+	t.Run("it opens what it sealed", func(t *testing.T) {
+		k, _ := ParseKeyring("1:" + testKey1)
+
+		sealed := k.Seal(nil, []byte("secret"), []byte("aad"))
+		test.AssertEqual(t, len(sealed), len("secret")+Overhead)
+		test.AssertFalse(t, bytes.Contains(sealed, []byte("secret")))
+
+		opened, err := k.Open(nil, sealed, []byte("aad"))
+		test.AssertNoError(t, err)
+		test.AssertBytesEqual(t, opened, []byte("secret"))
+	})
+
+	t.Run("it opens data sealed before a rotation", func(t *testing.T) {
+		old, _ := ParseKeyring("1:" + testKey1)
+		rotated, _ := ParseKeyring("1:" + testKey1 + ",2:" + testKey2)
+
+		opened, err := rotated.Open(nil, old.Seal(nil, []byte("secret"), nil), nil)
+		test.AssertNoError(t, err)
+		test.AssertBytesEqual(t, opened, []byte("secret"))
+
+		_, err = old.Open(nil, rotated.Seal(nil, []byte("secret"), nil), nil)
+		test.AssertError(t, err, KeyNotFoundError)
+	})
+
+	t.Run("it detects tampering", func(t *testing.T) {
+		k, _ := ParseKeyring("1:" + testKey1)
+		sealed := k.Seal(nil, []byte("secret"), nil)
+		sealed[len(sealed)-1] ^= 1
+
+		_, err := k.Open(nil, sealed, nil)
+		test.AssertError(t, err, DecryptionFailedError)
+
+		_, err = k.Open(nil, k.Seal(nil, []byte("secret"), []byte("a")), []byte("b"))
+		test.AssertError(t, err, DecryptionFailedError)
+	})
+
+	t.Run("it loads keys from the environment before the file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "keys")
+		_ = os.WriteFile(path, []byte("1:"+testKey1), 0600)
+
+		k, err := LoadKeyring(path, "KV_TEST_KEYS")
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, k.ActiveKeyID(), uint32(1))
+
+		t.Setenv("KV_TEST_KEYS", "2:"+testKey2)
+
+		k, err = LoadKeyring(path, "KV_TEST_KEYS")
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, k.ActiveKeyID(), uint32(2))
+	})
+
+	t.Run("it leaves encryption disabled without keys", func(t *testing.T) {
+		k, err := LoadKeyring("", "")
+		test.AssertNoError(t, err)
+		test.AssertTrue(t, k == nil)
+		test.AssertEqual(t, k.ActiveKeyID(), uint32(0))
+	})
+
+	t.Run("it fails on a missing key file", func(t *testing.T) {
+		_, err := LoadKeyring(filepath.Join(t.TempDir(), "missing"), "")
+		test.AssertError(t, err, os.ErrNotExist)
+	})
+}
+
+func TestFrame(t *testing.T) {
+	k, _ := ParseKeyring("1:" + testKey1)
+
+	t.Run("it seals and opens frames", func(t *testing.T) {
+		frame := SealFrame(k, []byte("manifest"), nil)
+		test.AssertTrue(t, strings.HasPrefix(string(frame), "KVE1"))
+
+		content, err := OpenFrame(k, frame, nil)
+		test.AssertNoError(t, err)
+		test.AssertBytesEqual(t, content, []byte("manifest"))
+	})
+
+	t.Run("it passes plain content through without keys", func(t *testing.T) {
+		content, err := OpenFrame(nil, []byte("manifest"), nil)
+		test.AssertNoError(t, err)
+		test.AssertBytesEqual(t, content, []byte("manifest"))
+		test.AssertBytesEqual(t, SealFrame(nil, []byte("manifest"), nil), []byte("manifest"))
+	})
+
+	t.Run("it refuses plain content with keys loaded", func(t *testing.T) {
+		_, err := OpenFrame(k, []byte("manifest"), nil)
+		test.AssertError(t, err, UnencryptedDataError)
+	})
+
+	t.Run("it passes plain content through when migrating", func(t *testing.T) {
+		content, err := OpenFrameForMigration(k, []byte("manifest"), nil)
+		test.AssertNoError(t, err)
+		test.AssertBytesEqual(t, content, []byte("manifest"))
+
+		content, err = OpenFrameForMigration(k, SealFrame(k, []byte("manifest"), nil), nil)
+		test.AssertNoError(t, err)
+		test.AssertBytesEqual(t, content, []byte("manifest"))
+	})
+
+	t.Run("it requires keys for sealed frames", func(t *testing.T) {
+		_, err := OpenFrame(nil, SealFrame(k, []byte("manifest"), nil), nil)
+		test.AssertError(t, err, KeyRequiredError)
+	})
+}
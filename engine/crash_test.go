@@ -0,0 +1,311 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"kv/engine/mvcc"
+	"kv/engine/tx"
+	"kv/engine/wal"
+	"kv/observability"
+	"kv/storage"
+	"maps"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	crashTestWorkers       = 4
+	crashTestKeysPerWorker = 8
+	crashTestRounds        = 8
+)
+
+// TestCrashRecovery runs transactional workloads against a store on a
+// FaultFS, crashes it at random points, sometimes after making the disk fail,
+// and restarts it through recovery. Every commit that was acknowledged has to
+// survive, nothing that was not committed may show up, and a commit that
+// failed is either there as a whole or not at all.
+func TestCrashRecovery(t *testing.T) {
+	observability.DisableLogging()
+
+	for seed := range int64(4) {
+		t.Run(fmt.Sprintf("seed %d", seed), func(t *testing.T) {
+			runCrashTest(t, seed)
+		})
+	}
+}
+
+// crashTestStore is a store wired up the way main does it.
+type crashTestStore struct {
+	txManager *tx.Manager
+	engine    *Engine
+}
+
+func openCrashTestStore(fs storage.FS) (*crashTestStore, error) {
+	storageManager := storage.NewManager(fs)
+
+	logManifestFile, err := storageManager.Open(filepath.Join("log", "manifest"), os.O_RDWR|os.O_CREATE)
+	if err != nil {
+		return nil, err
+	}
+
+	logStream, err := wal.NewLog(wal.NewManifest(logManifestFile), wal.LogOptions{
+		FS:               fs,
+		LogsDirectory:    "log",
+		SegmentSize:      1024,
+		RecycledSegments: 2,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	writeAheadLog := wal.NewWriteAheadLog(wal.Options{
+		BatchCommitWaitTime:  100 * time.Microsecond,
+		WriterBufferSize:     256,
+		CompressionThreshold: 64,
+	}, logStream)
+
+	txManifestFile, err := storageManager.Open(filepath.Join("tx", "manifest"), os.O_RDWR|os.O_CREATE)
+	if err != nil {
+		return nil, err
+	}
+
+	txManager := tx.NewManager(tx.NewManifest(txManifestFile), writeAheadLog, tx.ManagerOptions{
+		ReservedIDsPerBatch:   16,
+		MaxActiveTransactions: 64,
+	})
+
+	versionMap := mvcc.NewVersionMap()
+	if err = NewRecoveryManager(versionMap, writeAheadLog, RecoveryOptions{Workers: 2}).Run(); err != nil {
+		return nil, fmt.Errorf("recovery failed: %w", err)
+	}
+
+	return &crashTestStore{
+		txManager: txManager,
+		engine:    New(mvcc.NewStore(versionMap, mvcc.StoreOptions{}), writeAheadLog),
+	}, nil
+}
+
+// crashTestWorker runs transactions on keys of its own, so that its view of
+// the committed state does not depend on other workers.
+type crashTestWorker struct {
+	id        int
+	rand      *rand.Rand
+	committed map[string]string
+
+	// uncertain holds the writes of a transaction whose commit failed, it
+	// may or may not have made it to the log. Deletes are empty strings.
+	uncertain map[string]string
+
+	// err is an unexpected error, failures caused by the FaultFS are not.
+	err error
+}
+
+func (w *crashTestWorker) key(i int) string {
+	return "w" + strconv.Itoa(w.id) + "-k" + strconv.Itoa(i)
+}
+
+func (w *crashTestWorker) run(store *crashTestStore, round int, stop <-chan struct{}) {
+	for n := 0; ; n++ {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		if err := w.runTransaction(store, fmt.Sprintf("r%d-w%d-%d", round, w.id, n)); err != nil {
+			if !isInjectedFault(err) {
+				w.err = err
+			}
+
+			return
+		}
+	}
+}
+
+func (w *crashTestWorker) runTransaction(store *crashTestStore, name string) error {
+	transaction, err := store.txManager.Begin()
+	if err != nil {
+		return err
+	}
+
+	view := maps.Clone(w.committed)
+	var beforeSavepoint map[string]string
+
+	for i := range 1 + w.rand.Intn(4) {
+		if i == 1 && w.rand.Intn(3) == 0 {
+			if err = transaction.Savepoint("sp"); err != nil {
+				transaction.Abort()
+				return err
+			}
+
+			beforeSavepoint = maps.Clone(view)
+		}
+
+		key := w.key(w.rand.Intn(crashTestKeysPerWorker))
+
+		if _, ok := view[key]; ok && w.rand.Intn(4) == 0 {
+			err = store.engine.Delete(key, transaction)
+			delete(view, key)
+		} else {
+			value := name + "-" + strconv.Itoa(i) + strings.Repeat("x", w.rand.Intn(2)*100)
+			err = store.engine.Set(key, []byte(value), transaction)
+			view[key] = value
+		}
+
+		// Conflicts abort the transaction, like they would for a client.
+		if errors.Is(err, mvcc.SerializationError) {
+			transaction.Abort()
+			return nil
+		}
+
+		if err != nil {
+			transaction.Abort()
+			return err
+		}
+	}
+
+	if beforeSavepoint != nil && w.rand.Intn(2) == 0 {
+		if err = transaction.RollbackTo("sp"); err != nil {
+			transaction.Abort()
+			return err
+		}
+
+		view = beforeSavepoint
+	}
+
+	if w.rand.Intn(8) == 0 {
+		transaction.Abort()
+		return nil
+	}
+
+	if err = transaction.Commit(); err != nil {
+		w.uncertain = view
+		return err
+	}
+
+	w.committed = view
+	return nil
+}
+
+// verify checks the recovered store against the committed state and settles
+// the outcome of an uncertain commit.
+func (w *crashTestWorker) verify(store *crashTestStore) error {
+	transaction, err := store.txManager.Begin()
+	if err != nil {
+		return err
+	}
+	defer transaction.Abort()
+
+	recovered := make(map[string]string)
+
+	for i := range crashTestKeysPerWorker {
+		key := w.key(i)
+
+		value, err := store.engine.Get(key, transaction)
+		if errors.Is(err, mvcc.KeyNotFoundError) {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		recovered[key] = string(value)
+	}
+
+	switch {
+	case maps.Equal(recovered, w.committed):
+	case w.uncertain != nil && maps.Equal(recovered, w.uncertain):
+		w.committed = w.uncertain
+	default:
+		return fmt.Errorf("worker %d recovered %v, expected %v or %v", w.id, recovered, w.committed, w.uncertain)
+	}
+
+	w.uncertain = nil
+	return nil
+}
+
+func runCrashTest(t *testing.T, seed int64) {
+	fs := storage.NewFaultFS(storage.NewMemFS(), seed)
+	rnd := rand.New(rand.NewSource(seed))
+
+	workers := make([]*crashTestWorker, crashTestWorkers)
+	for i := range workers {
+		workers[i] = &crashTestWorker{
+			id:        i,
+			rand:      rand.New(rand.NewSource(rnd.Int63())),
+			committed: make(map[string]string),
+		}
+	}
+
+	for round := range crashTestRounds {
+		store, err := openCrashTestStore(fs)
+		if err != nil {
+			t.Fatalf("round %d: %v", round, err)
+		}
+
+		for _, w := range workers {
+			if err = w.verify(store); err != nil {
+				t.Fatalf("round %d: %v", round, err)
+			}
+		}
+
+		stop := make(chan struct{})
+		var wg sync.WaitGroup
+
+		for _, w := range workers {
+			wg.Go(func() {
+				w.run(store, round, stop)
+			})
+		}
+
+		time.Sleep(time.Duration(1+rnd.Intn(10)) * time.Millisecond)
+
+		if rnd.Intn(3) == 0 {
+			injectFaults(fs, rnd.Int63())
+			time.Sleep(time.Duration(1+rnd.Intn(5)) * time.Millisecond)
+		}
+
+		if err = fs.Crash(); err != nil {
+			t.Fatalf("round %d: crash: %v", round, err)
+		}
+
+		fs.FailWith(nil)
+		close(stop)
+		wg.Wait()
+
+		for _, w := range workers {
+			if w.err != nil {
+				t.Fatalf("round %d: worker %d: %v", round, w.id, w.err)
+			}
+		}
+	}
+}
+
+// injectFaults makes writes and syncs of segments and manifests fail at
+// random.
+func injectFaults(fs *storage.FaultFS, seed int64) {
+	rnd := rand.New(rand.NewSource(seed))
+
+	fs.FailWith(func(op storage.FaultOp, name string) error {
+		if op != storage.FaultOpWrite && op != storage.FaultOpSync {
+			return nil
+		}
+
+		if rnd.Intn(4) == 0 {
+			return storage.InjectedFaultError
+		}
+
+		return nil
+	})
+}
+
+func isInjectedFault(err error) bool {
+	return errors.Is(err, storage.CrashedError) || errors.Is(err, storage.InjectedFaultError)
+}
@@ -0,0 +1,249 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kv/engine/wal"
+	"kv/storage"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var IncompatibleStoreError = errors.New("engine: incompatible store")
+
+// StoreFormatVersion is the on-disk format this build reads and writes. Bump
+// it along with an upgrade from the previous version for every change older
+// builds would misread.
+const StoreFormatVersion = 1
+
+const descriptorFileName = "STORE"
+
+// StoreDescriptor describes the store kept in a data directory. It is written
+// when the store is created and holds what has to stay the same for the
+// lifetime of the store.
+type StoreDescriptor struct {
+	FormatVersion uint32      `json:"format_version"`
+	CreatedAt     time.Time   `json:"created_at"`
+	StoreID       wal.StoreID `json:"store_id"`
+
+	// SegmentSize maps log positions onto segments, changing it would move
+	// every record of the log.
+	SegmentSize int64 `json:"segment_size"`
+
+	// stored is whether the descriptor was read from the data directory.
+	stored bool
+}
+
+// Upgrade migrates the files of a store in a data directory from one format
+// version to the next.
+type Upgrade func(fs storage.FS, dataDir string) error
+
+// StoreUpgrades are the upgrades OpenStoreDescriptor applies by default, keyed
+// by the format version they upgrade from.
+var StoreUpgrades = map[uint32]Upgrade{}
+
+type DescriptorOptions struct {
+	FS      storage.FS
+	DataDir string
+
+	// LockFileName is the file in DataDir the store is locked through, which
+	// exists before the store does.
+	LockFileName string
+
+	// SegmentSize is what the store is opened with. A new descriptor records
+	// it, an existing one has to match it.
+	SegmentSize int64
+
+	// ReadOnly neither creates nor upgrades the descriptor.
+	ReadOnly bool
+
+	// Upgrades replaces StoreUpgrades.
+	Upgrades map[uint32]Upgrade
+}
+
+// OpenStoreDescriptor reads the descriptor of the store in the data directory
+// and refuses a store this build cannot open as configured. A store in an
+// older format is upgraded one version at a time, the descriptor is rewritten
+// after each step, so that an interrupted upgrade resumes where it stopped.
+//
+// A data directory without a descriptor holds a new store if it is empty and
+// a store created before descriptors, format version 0, otherwise. Either
+// gets its descriptor written by Complete once the store has been opened, so
+// that a failed open leaves the directory as it was.
+func OpenStoreDescriptor(options DescriptorOptions) (*StoreDescriptor, error) {
+	if options.Upgrades == nil {
+		options.Upgrades = StoreUpgrades
+	}
+
+	path := filepath.Join(options.DataDir, descriptorFileName)
+
+	data, err := storage.ReadFile(options.FS, path)
+	if errors.Is(err, os.ErrNotExist) {
+		return newStoreDescriptor(options)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	descriptor := &StoreDescriptor{stored: true}
+	if err = json.Unmarshal(data, descriptor); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if descriptor.FormatVersion > StoreFormatVersion {
+		return nil, fmt.Errorf("%w: format version %d is newer than supported version %d",
+			IncompatibleStoreError, descriptor.FormatVersion, StoreFormatVersion)
+	}
+
+	if err = descriptor.upgrade(options); err != nil {
+		return nil, err
+	}
+
+	if descriptor.SegmentSize != options.SegmentSize {
+		return nil, fmt.Errorf("%w: store uses segments of %d bytes, configured for %d",
+			IncompatibleStoreError, descriptor.SegmentSize, options.SegmentSize)
+	}
+
+	return descriptor, nil
+}
+
+// newStoreDescriptor describes a new store, or one created before stores had
+// descriptors. The latter is taken to match the configuration, its segments
+// are checked against it as they are upgraded and loaded.
+func newStoreDescriptor(options DescriptorOptions) (*StoreDescriptor, error) {
+	empty, err := isNewStore(options)
+	if err != nil {
+		return nil, err
+	}
+
+	descriptor := &StoreDescriptor{
+		FormatVersion: StoreFormatVersion,
+		CreatedAt:     time.Now().UTC(),
+		SegmentSize:   options.SegmentSize,
+	}
+
+	if !empty {
+		descriptor.FormatVersion = 0
+	}
+
+	if err = descriptor.upgrade(options); err != nil {
+		return nil, err
+	}
+
+	return descriptor, nil
+}
+
+// isNewStore reports whether the data directory holds nothing of a store yet.
+func isNewStore(options DescriptorOptions) (bool, error) {
+	names, err := options.FS.List(options.DataDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return true, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	for _, name := range names {
+		if name != options.LockFileName && name != descriptorFileName+".tmp" {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// Complete checks the descriptor against the ID of the store whose log was
+// opened, and writes it for a store that did not have one yet.
+func (d *StoreDescriptor) Complete(storeID wal.StoreID, options DescriptorOptions) error {
+	if d.stored {
+		if d.StoreID != storeID {
+			return fmt.Errorf("%w: directory belongs to store %s, its log to store %s",
+				IncompatibleStoreError, d.StoreID, storeID)
+		}
+
+		return nil
+	}
+
+	d.StoreID = storeID
+
+	if options.ReadOnly {
+		return nil
+	}
+
+	if err := d.write(options); err != nil {
+		return err
+	}
+
+	d.stored = true
+	return nil
+}
+
+func (d *StoreDescriptor) upgrade(options DescriptorOptions) error {
+	if d.FormatVersion < StoreFormatVersion && options.ReadOnly {
+		return fmt.Errorf("%w: format version %d needs an upgrade, which cannot run read-only",
+			IncompatibleStoreError, d.FormatVersion)
+	}
+
+	for d.FormatVersion < StoreFormatVersion {
+		upgrade, ok := options.Upgrades[d.FormatVersion]
+		if !ok {
+			return fmt.Errorf("%w: no upgrade from format version %d", IncompatibleStoreError, d.FormatVersion)
+		}
+
+		if err := upgrade(options.FS, options.DataDir); err != nil {
+			return fmt.Errorf("upgrade from format version %d failed: %w", d.FormatVersion, err)
+		}
+
+		d.FormatVersion++
+
+		// A descriptor is only written once the store is known to open.
+		if !d.stored {
+			continue
+		}
+
+		if err := d.write(options); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// write replaces the descriptor through a temporary file, so that a crash
+// leaves either the old or the new one behind.
+func (d *StoreDescriptor) write(options DescriptorOptions) error {
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(options.DataDir, descriptorFileName)
+	tmpPath := path + ".tmp"
+
+	file, err := options.FS.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write(append(data, '\n')); err != nil {
+		return errors.Join(err, file.Close())
+	}
+
+	if err = file.Sync(); err != nil {
+		return errors.Join(err, file.Close())
+	}
+
+	if err = file.Close(); err != nil {
+		return err
+	}
+
+	if err = options.FS.Rename(tmpPath, path); err != nil {
+		return err
+	}
+
+	return options.FS.SyncDir(options.DataDir)
+}
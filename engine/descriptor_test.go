@@ -0,0 +1,223 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"kv/engine/wal"
+	"kv/storage"
+	"kv/test"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpenStoreDescriptor(t *testing.T) {
+	setup := func(t *testing.T) DescriptorOptions {
+		t.Helper()
+
+		fs := storage.NewMemFS()
+		test.AssertNoError(t, fs.MkdirAll("data"))
+
+		return DescriptorOptions{
+			FS:           fs,
+			DataDir:      "data",
+			LockFileName: "LOCK",
+			SegmentSize:  1024,
+		}
+	}
+
+	create := func(t *testing.T, options DescriptorOptions) *StoreDescriptor {
+		t.Helper()
+
+		descriptor, err := OpenStoreDescriptor(options)
+		test.AssertNoError(t, err)
+		test.AssertNoError(t, descriptor.Complete(wal.NewStoreID(), options))
+
+		return descriptor
+	}
+
+	list := func(t *testing.T, options DescriptorOptions) []string {
+		t.Helper()
+
+		names, err := options.FS.List("data")
+		test.AssertNoError(t, err)
+
+		return names
+	}
+
+	writeVersion := func(t *testing.T, options DescriptorOptions, version uint32) {
+		t.Helper()
+
+		descriptor := &StoreDescriptor{
+			FormatVersion: version,
+			StoreID:       wal.NewStoreID(),
+			SegmentSize:   options.SegmentSize,
+		}
+		test.AssertNoError(t, descriptor.write(options))
+	}
+
+	t.Run("it creates a descriptor and reads it back", func(t *testing.T) {
+		options := setup(t)
+
+		created := create(t, options)
+		test.AssertEqual(t, created.FormatVersion, uint32(StoreFormatVersion))
+
+		opened, err := OpenStoreDescriptor(options)
+		test.AssertNoError(t, err)
+		test.AssertNoError(t, opened.Complete(created.StoreID, options))
+		test.AssertEqual(t, opened.StoreID, created.StoreID)
+		test.AssertEqual(t, opened.SegmentSize, options.SegmentSize)
+		test.AssertTrue(t, opened.CreatedAt.Equal(created.CreatedAt))
+	})
+
+	t.Run("it does not create a descriptor when read-only", func(t *testing.T) {
+		options := setup(t)
+		options.ReadOnly = true
+
+		descriptor, err := OpenStoreDescriptor(options)
+		test.AssertNoError(t, err)
+		test.AssertNoError(t, descriptor.Complete(wal.NewStoreID(), options))
+		test.AssertEqual(t, len(list(t, options)), 0)
+	})
+
+	t.Run("it writes a new descriptor only once the store is opened", func(t *testing.T) {
+		options := setup(t)
+		lock, err := options.FS.Create(filepath.Join("data", "LOCK"))
+		test.AssertNoError(t, err)
+		test.AssertNoError(t, lock.Close())
+
+		descriptor, err := OpenStoreDescriptor(options)
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, descriptor.FormatVersion, uint32(StoreFormatVersion))
+		test.AssertEqual(t, len(list(t, options)), 1)
+
+		storeID := wal.NewStoreID()
+		test.AssertNoError(t, descriptor.Complete(storeID, options))
+
+		opened, err := OpenStoreDescriptor(options)
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, opened.StoreID, storeID)
+	})
+
+	t.Run("it upgrades a store from before descriptors as format 0", func(t *testing.T) {
+		options := setup(t)
+		test.AssertNoError(t, options.FS.MkdirAll(filepath.Join("data", "log")))
+
+		var applied []uint32
+		options.Upgrades = map[uint32]Upgrade{
+			0: func(fs storage.FS, dataDir string) error {
+				applied = append(applied, 0)
+				return nil
+			},
+		}
+
+		descriptor, err := OpenStoreDescriptor(options)
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, descriptor.FormatVersion, uint32(StoreFormatVersion))
+		test.AssertEqual(t, len(applied), 1)
+
+		// Nothing is written until the store has been opened.
+		test.AssertEqual(t, len(list(t, options)), 1)
+
+		test.AssertNoError(t, descriptor.Complete(wal.NewStoreID(), options))
+		test.AssertEqual(t, len(list(t, options)), 2)
+	})
+
+	t.Run("it leaves a store from before descriptors alone when its upgrade fails", func(t *testing.T) {
+		options := setup(t)
+		test.AssertNoError(t, options.FS.MkdirAll(filepath.Join("data", "log")))
+
+		failure := errors.New("failure")
+		options.Upgrades = map[uint32]Upgrade{
+			0: func(fs storage.FS, dataDir string) error {
+				return failure
+			},
+		}
+
+		_, err := OpenStoreDescriptor(options)
+		test.AssertError(t, err, failure)
+		test.AssertEqual(t, len(list(t, options)), 1)
+
+		options.Upgrades = map[uint32]Upgrade{}
+		_, err = OpenStoreDescriptor(options)
+		test.AssertError(t, err, IncompatibleStoreError)
+	})
+
+	t.Run("it refuses a different segment size", func(t *testing.T) {
+		options := setup(t)
+		create(t, options)
+
+		options.SegmentSize *= 2
+		_, err := OpenStoreDescriptor(options)
+		test.AssertError(t, err, IncompatibleStoreError)
+	})
+
+	t.Run("it refuses a log of another store", func(t *testing.T) {
+		options := setup(t)
+		create(t, options)
+
+		descriptor, err := OpenStoreDescriptor(options)
+		test.AssertNoError(t, err)
+
+		err = descriptor.Complete(wal.NewStoreID(), options)
+		test.AssertError(t, err, IncompatibleStoreError)
+	})
+
+	t.Run("it refuses a newer format", func(t *testing.T) {
+		options := setup(t)
+		writeVersion(t, options, StoreFormatVersion+1)
+
+		_, err := OpenStoreDescriptor(options)
+		test.AssertError(t, err, IncompatibleStoreError)
+	})
+
+	t.Run("it upgrades an older format step by step", func(t *testing.T) {
+		options := setup(t)
+		writeVersion(t, options, StoreFormatVersion-1)
+
+		var applied []uint32
+		options.Upgrades = map[uint32]Upgrade{
+			StoreFormatVersion - 1: func(fs storage.FS, dataDir string) error {
+				applied = append(applied, StoreFormatVersion-1)
+				return nil
+			},
+		}
+
+		descriptor, err := OpenStoreDescriptor(options)
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, descriptor.FormatVersion, uint32(StoreFormatVersion))
+		test.AssertEqual(t, len(applied), 1)
+
+		data, err := storage.ReadFile(options.FS, filepath.Join("data", descriptorFileName))
+		test.AssertNoError(t, err)
+		test.AssertTrue(t, strings.Contains(string(data), fmt.Sprintf(`"format_version": %d`, StoreFormatVersion)))
+	})
+
+	t.Run("it keeps the old version when an upgrade fails", func(t *testing.T) {
+		options := setup(t)
+		writeVersion(t, options, StoreFormatVersion-1)
+
+		failure := errors.New("failure")
+		options.Upgrades = map[uint32]Upgrade{
+			StoreFormatVersion - 1: func(fs storage.FS, dataDir string) error {
+				return failure
+			},
+		}
+
+		_, err := OpenStoreDescriptor(options)
+		test.AssertError(t, err, failure)
+
+		options.Upgrades = map[uint32]Upgrade{}
+		_, err = OpenStoreDescriptor(options)
+		test.AssertError(t, err, IncompatibleStoreError)
+	})
+
+	t.Run("it refuses to upgrade when read-only", func(t *testing.T) {
+		options := setup(t)
+		writeVersion(t, options, StoreFormatVersion-1)
+		options.ReadOnly = true
+
+		_, err := OpenStoreDescriptor(options)
+		test.AssertError(t, err, IncompatibleStoreError)
+	})
+}
@@ -24,6 +24,15 @@ func (e *Engine) Get(key string, transaction *tx.Transaction) ([]byte, error) {
 	return value, err
 }
 
+func (e *Engine) GetForUpdate(key string, transaction *tx.Transaction) ([]byte, error) {
+	return e.mvccStore.GetForUpdate(key, transaction)
+}
+
+// Lock is not logged, locks do not outlive the transaction holding them.
+func (e *Engine) Lock(key string, transaction *tx.Transaction) error {
+	return transaction.Lock(key)
+}
+
 func (e *Engine) Set(key string, value []byte, transaction *tx.Transaction) error {
 	if err := e.mvccStore.Set(key, value, transaction); err != nil {
 		return err
@@ -21,3 +21,30 @@ func (m *MockAppender) Append(record *record.Record) error {
 	m.Records = append(m.Records, record)
 	return nil
 }
+
+type MockReplayer struct {
+	Records []record.Record
+	Err     error
+}
+
+func NewReplayer(records ...*record.Record) *MockReplayer {
+	m := &MockReplayer{}
+
+	for _, r := range records {
+		m.Records = append(m.Records, *r)
+	}
+
+	return m
+}
+
+func (m *MockReplayer) Replay(apply func(record.Record)) error {
+	if m.Err != nil {
+		return m.Err
+	}
+
+	for _, r := range m.Records {
+		apply(r)
+	}
+
+	return nil
+}
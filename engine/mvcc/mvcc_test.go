@@ -1,7 +1,9 @@
 package mvcc
 
 import (
+	"context"
 	"errors"
+	"kv/engine/tx"
 	"kv/test"
 	"sync"
 	"sync/atomic"
@@ -12,12 +14,16 @@ func TestMVCC(t *testing.T) {
 	txManager := setupTxManager()
 	coordinator, _ := setup()
 
-	givenEntryCommitted := func(key string, value []byte) {
+	givenEntryCommittedIn := func(txManager *tx.Manager, key string, value []byte) {
 		setupTx := beginTransaction(t, txManager)
 		_ = coordinator.Set(key, value, setupTx)
 		_ = setupTx.Commit()
 	}
 
+	givenEntryCommitted := func(key string, value []byte) {
+		givenEntryCommittedIn(txManager, key, value)
+	}
+
 	t.Run("it rollbacks inserts when transaction is aborted", func(t *testing.T) {
 		key := "rollbacks-inserts"
 		givenEntryCommitted(key, []byte("100"))
@@ -90,6 +96,52 @@ func TestMVCC(t *testing.T) {
 		test.AssertBytesEqual(t, got3, value3)
 	})
 
+	t.Run("it rollbacks changes made after savepoint", func(t *testing.T) {
+		key1 := "rollbacks-to-savepoint-1"
+		key2 := "rollbacks-to-savepoint-2"
+		key3 := "rollbacks-to-savepoint-3"
+		givenEntryCommitted(key3, []byte("300"))
+
+		txA := beginTransaction(t, txManager)
+
+		err := coordinator.Set(key1, []byte("100"), txA)
+		test.AssertNoError(t, err)
+		err = txA.Savepoint("sp")
+		test.AssertNoError(t, err)
+		err = coordinator.Set(key1, []byte("101"), txA)
+		test.AssertNoError(t, err)
+		err = coordinator.Set(key2, []byte("200"), txA)
+		test.AssertNoError(t, err)
+		err = coordinator.Delete(key3, txA)
+		test.AssertNoError(t, err)
+
+		err = txA.RollbackTo("sp")
+		test.AssertNoError(t, err)
+
+		got1, err1 := coordinator.Get(key1, txA)
+		_, err2 := coordinator.Get(key2, txA)
+		got3, err3 := coordinator.Get(key3, txA)
+
+		test.AssertNoError(t, err1)
+		test.AssertBytesEqual(t, got1, []byte("100"))
+		test.AssertError(t, err2, KeyNotFoundError)
+		test.AssertNoError(t, err3)
+		test.AssertBytesEqual(t, got3, []byte("300"))
+
+		err = txA.Commit()
+		test.AssertNoError(t, err)
+
+		txB := beginTransaction(t, txManager)
+		got1, _ = coordinator.Get(key1, txB)
+		_, err2 = coordinator.Get(key2, txB)
+		got3, _ = coordinator.Get(key3, txB)
+		_ = txB.Commit()
+
+		test.AssertBytesEqual(t, got1, []byte("100"))
+		test.AssertError(t, err2, KeyNotFoundError)
+		test.AssertBytesEqual(t, got3, []byte("300"))
+	})
+
 	t.Run("it allows set -> set in the same transaction", func(t *testing.T) {
 		key := "set-to-set"
 		value := []byte("100")
@@ -194,4 +246,143 @@ func TestMVCC(t *testing.T) {
 
 		test.AssertEqual(t, len(finalVal)-1, int(successfulUpdates.Load()))
 	})
+
+	t.Run("it updates a locked key past the snapshot", func(t *testing.T) {
+		key := "locked"
+		lockTxManager := setupTxManager()
+		givenEntryCommittedIn(lockTxManager, key, []byte("0"))
+
+		txA := beginTransaction(t, lockTxManager)
+		txB := beginTransaction(t, lockTxManager)
+
+		val, err := coordinator.GetForUpdate(key, txA)
+		test.AssertNoError(t, err)
+		test.AssertNoError(t, coordinator.Set(key, append(val, '+'), txA))
+
+		done := make(chan error, 1)
+		go func() {
+			val, err := coordinator.GetForUpdate(key, txB)
+			if err == nil {
+				err = coordinator.Set(key, append(val, '+'), txB)
+			}
+			done <- err
+		}()
+
+		test.AssertNoError(t, txA.Commit())
+		test.AssertNoError(t, <-done)
+		test.AssertNoError(t, txB.Commit())
+
+		finalTx := beginTransaction(t, lockTxManager)
+		got, _ := coordinator.Get(key, finalTx)
+		test.AssertBytesEqual(t, got, []byte("0++"))
+	})
+
+	t.Run("it reads a key deleted past the snapshot as deleted once locked", func(t *testing.T) {
+		key := "locked_deleted"
+		lockTxManager := setupTxManager()
+		givenEntryCommittedIn(lockTxManager, key, []byte("0"))
+
+		reader := beginTransaction(t, lockTxManager)
+		deleter := beginTransaction(t, lockTxManager)
+
+		test.AssertNoError(t, coordinator.Delete(key, deleter))
+		test.AssertNoError(t, deleter.Commit())
+
+		got, err := coordinator.Get(key, reader)
+		test.AssertNoError(t, err)
+		test.AssertBytesEqual(t, got, []byte("0"))
+
+		_, err = coordinator.GetForUpdate(key, reader)
+		test.AssertError(t, err, KeyNotFoundError)
+	})
+
+	t.Run("it reads a key whose delete was aborted once locked", func(t *testing.T) {
+		key := "locked_delete_aborted"
+		lockTxManager := setupTxManager()
+		givenEntryCommittedIn(lockTxManager, key, []byte("0"))
+
+		reader := beginTransaction(t, lockTxManager)
+		deleter := beginTransaction(t, lockTxManager)
+
+		test.AssertNoError(t, coordinator.Delete(key, deleter))
+		deleter.Abort()
+
+		got, err := coordinator.GetForUpdate(key, reader)
+		test.AssertNoError(t, err)
+		test.AssertBytesEqual(t, got, []byte("0"))
+	})
+
+	t.Run("it refuses writes of a transaction aborted by shutdown", func(t *testing.T) {
+		key := "shut_down"
+		shutDownTxManager := setupTxManager()
+		store, versionMap := setup()
+
+		setupTx := beginTransaction(t, shutDownTxManager)
+		test.AssertNoError(t, store.Set(key, []byte("0"), setupTx))
+		test.AssertNoError(t, setupTx.Commit())
+
+		txA := beginTransaction(t, shutDownTxManager)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		test.AssertEqual(t, shutDownTxManager.Shutdown(ctx), 1)
+
+		test.AssertError(t, store.Set(key, []byte("1"), txA), tx.TransactionNotActiveError)
+		test.AssertError(t, store.Delete(key, txA), tx.TransactionNotActiveError)
+		test.AssertError(t, txA.Commit(), tx.TransactionNotActiveError)
+
+		chain, _ := versionMap.GetChain(key)
+		test.AssertBytesEqual(t, chain.Head().Value, []byte("0"))
+		test.AssertTrue(t, chain.Head().XMax().IsAlive())
+	})
+
+	t.Run("it makes writers wait for the lock holder", func(t *testing.T) {
+		key := "locked_write"
+		lockTxManager := setupTxManager()
+		givenEntryCommittedIn(lockTxManager, key, []byte("0"))
+
+		locker := beginTransaction(t, lockTxManager)
+		writer := beginTransaction(t, lockTxManager)
+
+		test.AssertNoError(t, locker.Lock(key))
+
+		done := make(chan error, 1)
+		go func() { done <- coordinator.Set(key, []byte("writer"), writer) }()
+
+		test.AssertNoError(t, coordinator.Set(key, []byte("locker"), locker))
+		test.AssertNoError(t, locker.Commit())
+		test.AssertError(t, <-done, SerializationError)
+	})
+
+	t.Run("it serializes updates of a hot key with locks", func(t *testing.T) {
+		key := "locked_counter"
+		lockTxManager := setupTxManager()
+		givenEntryCommittedIn(lockTxManager, key, []byte("0"))
+
+		workers := 50
+		iterations := 20
+		var wg sync.WaitGroup
+
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < iterations; j++ {
+					txA := beginTransaction(t, lockTxManager)
+
+					val, err := coordinator.GetForUpdate(key, txA)
+					test.AssertNoError(t, err)
+					test.AssertNoError(t, coordinator.Set(key, append(val, '+'), txA))
+					test.AssertNoError(t, txA.Commit())
+				}
+			}()
+		}
+
+		wg.Wait()
+
+		finalTx := beginTransaction(t, lockTxManager)
+		finalVal, _ := coordinator.Get(key, finalTx)
+
+		test.AssertEqual(t, len(finalVal)-1, workers*iterations)
+	})
 }
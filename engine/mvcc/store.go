@@ -2,19 +2,49 @@ package mvcc
 
 import (
 	"errors"
+	"fmt"
 	"kv/engine/tx"
+	"time"
 )
 
 var KeyNotFoundError = errors.New("mvcc: key not found")
 var SerializationError = errors.New("mvcc: serialization error")
 
+// errLookAgain has a write start over once the transaction it conflicted with
+// ended.
+var errLookAgain = errors.New("mvcc: look again")
+
+// ConflictPolicy says what a write does when it runs into a version of an
+// active transaction.
+type ConflictPolicy uint8
+
+const (
+	// ConflictFail fails the write with SerializationError right away.
+	ConflictFail ConflictPolicy = iota
+
+	// ConflictWait waits for the other transaction to end. The write goes
+	// ahead if it aborted and fails if it committed.
+	ConflictWait
+)
+
+type StoreOptions struct {
+	ConflictPolicy ConflictPolicy
+
+	// ConflictTimeout bounds each wait of ConflictWait, 0 waits for as long
+	// as it takes. A wait that times out or would deadlock fails the write
+	// with SerializationError.
+	ConflictTimeout time.Duration
+}
+
 type Store struct {
 	versionMap *VersionMap
+	options    StoreOptions
 }
 
-func NewStore(versionMap *VersionMap) *Store {
+func NewStore(versionMap *VersionMap, options StoreOptions) *Store {
 	return &Store{
 		versionMap: versionMap,
+		options:    options,
 	}
 }
 
@@ -24,7 +54,7 @@ func (s *Store) Get(key string, t *tx.Transaction) ([]byte, error) {
 		return nil, KeyNotFoundError
 	}
 
-	rec := chain.FindVisible(t)
+	rec := chain.FindLatest(t)
 	if rec == nil || rec.Value == nil {
 		return nil, KeyNotFoundError
 	}
@@ -32,26 +62,48 @@ func (s *Store) Get(key string, t *tx.Transaction) ([]byte, error) {
 	return rec.Value, nil
 }
 
+// GetForUpdate locks the key before reading it, so that the value read is
+// the latest one and stays so until t ends.
+func (s *Store) GetForUpdate(key string, t *tx.Transaction) ([]byte, error) {
+	if err := t.Lock(key); err != nil {
+		return nil, err
+	}
+
+	return s.Get(key, t)
+}
+
+// Set and Delete wait for transactions holding the lock on the key, they
+// conflict with the versions those write otherwise.
 func (s *Store) Set(key string, value []byte, t *tx.Transaction) error {
+	if err := t.AwaitUnlocked(key); err != nil {
+		return err
+	}
+
 	chain := s.versionMap.GetOrCreateChain(key)
+	lookedAgain := false
 
 	for {
-		latest := chain.Head()
+		head := chain.Head()
+		latest := skipLeftovers(head, t)
+
+		if err := s.tryUpdate(latest, t, lookedAgain); err != nil {
+			if errors.Is(err, errLookAgain) {
+				lookedAgain = true
+				continue
+			}
 
-		if err := s.tryUpdate(latest, t); err != nil {
 			return err
 		}
 
 		newVersion := NewVersion(key, value, t.ID)
-		newVersion.SetPreviousVersion(latest)
+		newVersion.SetPreviousVersion(head)
 
-		if chain.CompareHeadAndSwap(latest, newVersion) {
-			if latest != nil {
-				t.Track(latest)
+		if chain.CompareHeadAndSwap(head, newVersion) {
+			if latest == nil {
+				return t.Track(newVersion)
 			}
 
-			t.Track(newVersion)
-			return nil
+			return t.Track(latest, newVersion)
 		}
 
 		if latest != nil {
@@ -61,25 +113,53 @@ func (s *Store) Set(key string, value []byte, t *tx.Transaction) error {
 }
 
 func (s *Store) Delete(key string, t *tx.Transaction) error {
+	if err := t.AwaitUnlocked(key); err != nil {
+		return err
+	}
+
 	chain, ok := s.versionMap.GetChain(key)
 	if !ok {
 		return KeyNotFoundError
 	}
 
-	latest := chain.Head()
-	if latest == nil {
-		return KeyNotFoundError
+	lookedAgain := false
+
+	for {
+		latest := skipLeftovers(chain.Head(), t)
+
+		if err := s.tryDelete(latest, t, lookedAgain); err != nil {
+			if errors.Is(err, errLookAgain) {
+				lookedAgain = true
+				continue
+			}
+
+			return err
+		}
+
+		return t.Track(latest)
 	}
+}
 
-	if err := s.tryDelete(latest, t); err != nil {
-		return err
+// skipLeftovers skips the versions at the head of a chain that an ended
+// transaction inserted and killed itself, mostly left over from aborts. No
+// one sees them, writes go to the version below.
+func skipLeftovers(head *Version, t *tx.Transaction) *Version {
+	curr := head
+
+	for curr != nil {
+		xMin := curr.XMin()
+
+		if xMin != curr.XMax() || xMin == t.ID || t.IsActive(xMin) {
+			break
+		}
+
+		curr = curr.PreviousVersion()
 	}
 
-	t.Track(latest)
-	return nil
+	return curr
 }
 
-func (s *Store) tryUpdate(latest *Version, t *tx.Transaction) error {
+func (s *Store) tryUpdate(latest *Version, t *tx.Transaction, lookedAgain bool) error {
 	if latest == nil {
 		return nil
 	}
@@ -92,21 +172,21 @@ func (s *Store) tryUpdate(latest *Version, t *tx.Transaction) error {
 	}
 
 	if !xMax.IsAlive() {
-		return SerializationError
+		return s.conflict(xMax, t, lookedAgain)
 	}
 
-	if !t.CanSee(latest.XMin(), xMax) {
-		return SerializationError
+	if !t.CanSeeLocked(latest.Key, latest.XMin(), xMax) {
+		return s.conflict(latest.XMin(), t, lookedAgain)
 	}
 
 	if !latest.TryKill(t.ID) {
-		return SerializationError
+		return s.conflict(latest.XMax(), t, lookedAgain)
 	}
 
 	return nil
 }
 
-func (s *Store) tryDelete(latest *Version, t *tx.Transaction) error {
+func (s *Store) tryDelete(latest *Version, t *tx.Transaction, lookedAgain bool) error {
 	if latest == nil {
 		return KeyNotFoundError
 	}
@@ -114,16 +194,41 @@ func (s *Store) tryDelete(latest *Version, t *tx.Transaction) error {
 	xMax := latest.XMax()
 
 	if !xMax.IsAlive() {
-		return SerializationError
+		return s.conflict(xMax, t, lookedAgain)
 	}
 
-	if !t.CanSee(latest.XMin(), xMax) {
-		return SerializationError
+	if !t.CanSeeLocked(latest.Key, latest.XMin(), xMax) {
+		return s.conflict(latest.XMin(), t, lookedAgain)
 	}
 
 	if !latest.TryKill(t.ID) {
-		return SerializationError
+		return s.conflict(latest.XMax(), t, lookedAgain)
 	}
 
 	return nil
 }
+
+// conflict is what a write running into a version of the other transaction
+// gets. With ConflictWait, the writer waits for the other transaction to end
+// and looks at the key again: an abort undid the version, a commit makes it
+// conflict for good. The other transaction may also have ended since the
+// writer looked, so it looks again once before giving up on an ended one.
+func (s *Store) conflict(other tx.ID, t *tx.Transaction, lookedAgain bool) error {
+	if s.options.ConflictPolicy != ConflictWait {
+		return SerializationError
+	}
+
+	if !t.IsActive(other) {
+		if lookedAgain {
+			return SerializationError
+		}
+
+		return errLookAgain
+	}
+
+	if err := t.AwaitEnd(other, s.options.ConflictTimeout); err != nil {
+		return fmt.Errorf("%w: %w", SerializationError, err)
+	}
+
+	return errLookAgain
+}
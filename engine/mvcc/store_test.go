@@ -1,8 +1,10 @@
 package mvcc
 
 import (
+	"kv/engine/tx"
 	"kv/test"
 	"testing"
+	"time"
 )
 
 func TestCoordinator_Get(t *testing.T) {
@@ -132,6 +134,22 @@ func TestCoordinator_Set(t *testing.T) {
 		test.AssertError(t, err, SerializationError)
 	})
 
+	t.Run("it writes over an insert of an aborted transaction", func(t *testing.T) {
+		key := "aborted-insert"
+
+		txA := beginTransaction(t, txManager)
+		test.AssertNoError(t, store.Set(key, []byte("1"), txA))
+		txA.Abort()
+
+		txB := beginTransaction(t, txManager)
+		test.AssertNoError(t, store.Set(key, []byte("2"), txB))
+		test.AssertNoError(t, txB.Commit())
+
+		got, err := store.Get(key, beginTransaction(t, txManager))
+		test.AssertNoError(t, err)
+		test.AssertBytesEqual(t, got, []byte("2"))
+	})
+
 	t.Run("it prevents deadlocks when inserting", func(t *testing.T) {
 		key1 := "deadlock-1-insert"
 		key2 := "deadlock-2-insert"
@@ -257,3 +275,130 @@ func TestCoordinator_Delete(t *testing.T) {
 		test.AssertError(t, err, SerializationError)
 	})
 }
+
+func TestCoordinator_WaitOnConflict(t *testing.T) {
+	txManager := setupTxManager()
+	store := NewStore(NewVersionMap(), StoreOptions{ConflictPolicy: ConflictWait})
+
+	givenEntryCommitted := func(key string, value []byte) {
+		setupTx := beginTransaction(t, txManager)
+		_ = store.Set(key, value, setupTx)
+		_ = setupTx.Commit()
+	}
+
+	setAsync := func(key string, value []byte, transaction *tx.Transaction) <-chan error {
+		done := make(chan error, 1)
+		go func() { done <- store.Set(key, value, transaction) }()
+
+		// Long enough for the write to be waiting.
+		time.Sleep(10 * time.Millisecond)
+		return done
+	}
+
+	t.Run("it proceeds once the other transaction aborts", func(t *testing.T) {
+		key := "wait-abort"
+		givenEntryCommitted(key, []byte("1"))
+
+		txA := beginTransaction(t, txManager)
+		txB := beginTransaction(t, txManager)
+
+		test.AssertNoError(t, store.Set(key, []byte("2"), txA))
+		done := setAsync(key, []byte("3"), txB)
+
+		txA.Abort()
+		test.AssertNoError(t, <-done)
+		test.AssertNoError(t, txB.Commit())
+
+		got, err := store.Get(key, beginTransaction(t, txManager))
+		test.AssertNoError(t, err)
+		test.AssertBytesEqual(t, got, []byte("3"))
+	})
+
+	t.Run("it proceeds once an insert of the other transaction is aborted", func(t *testing.T) {
+		key := "wait-abort-insert"
+
+		txA := beginTransaction(t, txManager)
+		txB := beginTransaction(t, txManager)
+
+		test.AssertNoError(t, store.Set(key, []byte("2"), txA))
+		done := setAsync(key, []byte("3"), txB)
+
+		txA.Abort()
+		test.AssertNoError(t, <-done)
+		test.AssertNoError(t, store.Delete(key, txB))
+	})
+
+	t.Run("it looks again when the other transaction ended since it looked", func(t *testing.T) {
+		key := "wait-aborted-meanwhile"
+		givenEntryCommitted(key, []byte("1"))
+
+		txA := beginTransaction(t, txManager)
+		txB := beginTransaction(t, txManager)
+
+		test.AssertNoError(t, store.Set(key, []byte("2"), txA))
+
+		chain, _ := store.versionMap.GetChain(key)
+		seen := skipLeftovers(chain.Head(), txB)
+
+		txA.Abort()
+
+		test.AssertError(t, store.tryUpdate(seen, txB, false), errLookAgain)
+		test.AssertError(t, store.tryDelete(seen, txB, false), errLookAgain)
+		test.AssertError(t, store.tryUpdate(seen, txB, true), SerializationError)
+
+		test.AssertNoError(t, store.Set(key, []byte("3"), txB))
+		test.AssertNoError(t, txB.Commit())
+	})
+
+	t.Run("it fails once the other transaction commits", func(t *testing.T) {
+		key := "wait-commit"
+		givenEntryCommitted(key, []byte("1"))
+
+		txA := beginTransaction(t, txManager)
+		txB := beginTransaction(t, txManager)
+
+		test.AssertNoError(t, store.Set(key, []byte("2"), txA))
+		done := setAsync(key, []byte("3"), txB)
+
+		test.AssertNoError(t, txA.Commit())
+		test.AssertError(t, <-done, SerializationError)
+	})
+
+	t.Run("it fails when waiting would deadlock", func(t *testing.T) {
+		key1 := "wait-deadlock-1"
+		key2 := "wait-deadlock-2"
+		givenEntryCommitted(key1, []byte("1"))
+		givenEntryCommitted(key2, []byte("1"))
+
+		txA := beginTransaction(t, txManager)
+		txB := beginTransaction(t, txManager)
+
+		test.AssertNoError(t, store.Set(key1, []byte("2"), txA))
+		test.AssertNoError(t, store.Set(key2, []byte("2"), txB))
+
+		done := setAsync(key2, []byte("3"), txA)
+
+		err := store.Set(key1, []byte("3"), txB)
+		test.AssertError(t, err, SerializationError)
+		test.AssertError(t, err, tx.DeadlockError)
+
+		txB.Abort()
+		test.AssertNoError(t, <-done)
+	})
+
+	t.Run("it fails after the timeout", func(t *testing.T) {
+		store := NewStore(NewVersionMap(), StoreOptions{
+			ConflictPolicy:  ConflictWait,
+			ConflictTimeout: 10 * time.Millisecond,
+		})
+
+		txA := beginTransaction(t, txManager)
+		txB := beginTransaction(t, txManager)
+
+		test.AssertNoError(t, store.Set("wait-timeout", []byte("1"), txA))
+
+		err := store.Set("wait-timeout", []byte("2"), txB)
+		test.AssertError(t, err, SerializationError)
+		test.AssertError(t, err, tx.LockTimeoutError)
+	})
+}
@@ -51,5 +51,5 @@ func setupTxManager() *tx.Manager {
 
 func setup() (*Store, *VersionMap) {
 	versionMap := NewVersionMap()
-	return NewStore(versionMap), versionMap
+	return NewStore(versionMap, StoreOptions{}), versionMap
 }
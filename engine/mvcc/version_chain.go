@@ -34,3 +34,28 @@ func (c *VersionChain) FindVisible(t *tx.Transaction) *Version {
 
 	return nil
 }
+
+// FindLatest is FindVisible for a transaction that may hold the lock on the
+// key, which sees the latest committed version then, see
+// tx.Transaction.CanSeeLocked.
+func (c *VersionChain) FindLatest(t *tx.Transaction) *Version {
+	curr := c.head.Load()
+
+	for curr != nil {
+		xMax := curr.XMax()
+
+		if t.CanSeeLocked(curr.Key, curr.XMin(), xMax) {
+			return curr
+		}
+
+		// The transaction that killed the version aborted in the meantime
+		// and resurrected it.
+		if curr.XMax() != xMax {
+			continue
+		}
+
+		curr = curr.PreviousVersion()
+	}
+
+	return nil
+}
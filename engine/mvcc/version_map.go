@@ -42,3 +42,37 @@ func (vm *VersionMap) Remove(key string) {
 func (vm *VersionMap) Set(key string, chain *VersionChain) {
 	vm.data.Store(key, chain)
 }
+
+// VersionMapStats count what the map holds. Versions vacuuming has not
+// removed yet are counted as well.
+type VersionMapStats struct {
+	Keys     int
+	Versions int
+}
+
+// AverageChainLength returns how many versions a key has on average.
+func (s VersionMapStats) AverageChainLength() float64 {
+	if s.Keys == 0 {
+		return 0
+	}
+
+	return float64(s.Versions) / float64(s.Keys)
+}
+
+// Stats walks every chain, it is not a snapshot of the map as chains may
+// change meanwhile.
+func (vm *VersionMap) Stats() VersionMapStats {
+	var stats VersionMapStats
+
+	vm.Range(func(key string, chain *VersionChain) bool {
+		stats.Keys++
+
+		for version := chain.Head(); version != nil; version = version.PreviousVersion() {
+			stats.Versions++
+		}
+
+		return true
+	})
+
+	return stats
+}
@@ -0,0 +1,25 @@
+package mvcc
+
+import (
+	"kv/test"
+	"testing"
+)
+
+func TestVersionMap_Stats(t *testing.T) {
+	txManager := setupTxManager()
+	store, versionMap := setup()
+
+	test.AssertEqual(t, versionMap.Stats().AverageChainLength(), 0.0)
+
+	for _, key := range []string{"a", "a", "a", "b"} {
+		transaction := beginTransaction(t, txManager)
+		test.AssertNoError(t, store.Set(key, []byte("1"), transaction))
+		test.AssertNoError(t, transaction.Commit())
+	}
+
+	stats := versionMap.Stats()
+
+	test.AssertEqual(t, stats.Keys, 2)
+	test.AssertEqual(t, stats.Versions, 4)
+	test.AssertEqual(t, stats.AverageChainLength(), 2.0)
+}
@@ -2,84 +2,184 @@ package engine
 
 import (
 	"kv/engine/mvcc"
-	"kv/engine/tx"
 	"kv/engine/wal"
 	"kv/engine/wal/record"
 	"sync"
+	"time"
 
 	"github.com/rs/zerolog/log"
 )
 
-func NewRecoveryManager(versionMap *mvcc.VersionMap, walReplayer wal.Replayer) *RecoveryManager {
+const progressReportInterval = 5 * time.Second
+
+type RecoveryOptions struct {
+	Workers int
+}
+
+func NewRecoveryManager(versionMap *mvcc.VersionMap, walReplayer wal.Replayer, options RecoveryOptions) *RecoveryManager {
 	return &RecoveryManager{
 		versionMap:  versionMap,
 		walReplayer: walReplayer,
+		options:     options,
 	}
 }
 
 type RecoveryManager struct {
 	versionMap  *mvcc.VersionMap
 	walReplayer wal.Replayer
-	committed   map[uint64]struct{}
-	lock        sync.Mutex
+	options     RecoveryOptions
+
+	pending map[uint64]*pendingTx
+	workers *recoveryWorkers
+
+	startedAt    time.Time
+	lastReported time.Time
+
+	lock sync.Mutex
+}
+
+type pendingTx struct {
+	records    []record.Record
+	savepoints []pendingSavepoint
+}
+
+type pendingSavepoint struct {
+	name    string
+	records int
 }
 
+// Run replays the log once. Writes are buffered per transaction until its
+// commit or abort record shows up, transactions that never finished are
+// dropped once the log ends. Committed writes are applied by a pool of
+// workers, each owning a disjoint set of keys.
 func (rm *RecoveryManager) Run() error {
 	rm.lock.Lock()
 	defer rm.lock.Unlock()
 
-	rm.committed = make(map[uint64]struct{})
+	rm.pending = make(map[uint64]*pendingTx)
+	rm.workers = newRecoveryWorkers(rm.versionMap, rm.options.Workers)
+	rm.startedAt = time.Now()
+	rm.lastReported = rm.startedAt
+
+	err := rm.replay()
+	rm.workers.wait()
 
-	if err := rm.walReplayer.Replay(rm.loadCommittedTransactions); err != nil {
+	if err != nil {
 		return err
 	}
 
-	if err := rm.walReplayer.Replay(rm.applyCommittedRecords); err != nil {
-		return err
+	if len(rm.pending) > 0 {
+		log.Info().Int("transactions", len(rm.pending)).Msg("recovery: discarded unfinished transactions")
 	}
 
+	log.Info().Dur("elapsed", time.Since(rm.startedAt)).Msg("recovery: finished")
+
+	rm.pending = nil
+	rm.workers = nil
 	return nil
 }
 
-func (rm *RecoveryManager) applyCommittedRecords(r record.Record) {
-	if _, ok := rm.committed[r.TxID]; !ok {
-		return
+func (rm *RecoveryManager) replay() error {
+	if replayer, ok := rm.walReplayer.(wal.ProgressReplayer); ok {
+		return replayer.ReplayWithProgress(rm.applyRecord, rm.reportProgress)
 	}
 
-	key := string(r.Key)
+	return rm.walReplayer.Replay(rm.applyRecord)
+}
 
+func (rm *RecoveryManager) applyRecord(r record.Record) {
 	switch r.Kind {
-	case record.Tombstone:
-		rm.versionMap.Remove(key)
-	case record.Value:
-		rm.applyValueRecord(key, r)
+	case record.Tombstone, record.Value:
+		p := rm.pendingTx(r.TxID)
+		p.records = append(p.records, r)
+	case record.Savepoint:
+		p := rm.pendingTx(r.TxID)
+		p.savepoints = append(p.savepoints, pendingSavepoint{name: string(r.Key), records: len(p.records)})
+	case record.RollbackTo:
+		rm.pendingTx(r.TxID).rollbackTo(string(r.Key))
+	case record.Release:
+		rm.pendingTx(r.TxID).release(string(r.Key))
 	case record.Freeze:
-		rm.applyFreezeRecord(key)
+		rm.workers.dispatch(r)
 	case record.Commit:
-		// skip
+		rm.applyPendingRecords(r.TxID)
+	case record.Abort:
+		// An abort following the commit, logged because the commit failed
+		// to be reported written, finds nothing pending: the commit stands.
+		delete(rm.pending, r.TxID)
 	default:
-		log.Error().Uint8("kind", r.Kind).Msg("recovery: unknown committed record kind")
+		log.Error().Uint8("kind", r.Kind).Msg("recovery: unknown record kind")
 	}
 }
 
-func (rm *RecoveryManager) applyValueRecord(key string, r record.Record) {
-	chain := rm.versionMap.GetOrCreateChain(key)
-	newVersion := mvcc.NewVersion(key, r.Value, tx.ID(r.TxID))
-	chain.CompareHeadAndSwap(chain.Head(), newVersion)
+func (rm *RecoveryManager) applyPendingRecords(txID uint64) {
+	p, ok := rm.pending[txID]
+	if !ok {
+		return
+	}
+
+	delete(rm.pending, txID)
+	rm.workers.dispatch(p.records...)
 }
 
-func (rm *RecoveryManager) applyFreezeRecord(key string) {
-	chain, _ := rm.versionMap.GetChain(key)
+func (rm *RecoveryManager) reportProgress(p wal.ReplayProgress) {
+	now := time.Now()
+	finished := p.SegmentsDone >= p.SegmentsTotal
 
-	if head := chain.Head(); head != nil {
-		head.Freeze()
+	if !finished && now.Sub(rm.lastReported) < progressReportInterval {
+		return
 	}
+
+	rm.lastReported = now
+	elapsed := now.Sub(rm.startedAt)
+
+	var eta time.Duration
+	if p.SegmentsDone > 0 {
+		eta = elapsed * time.Duration(p.SegmentsTotal-p.SegmentsDone) / time.Duration(p.SegmentsDone)
+	}
+
+	log.Info().
+		Uint64("segments_done", p.SegmentsDone).
+		Uint64("segments_total", p.SegmentsTotal).
+		Dur("elapsed", elapsed).
+		Dur("eta", eta).
+		Msg("recovery: progress")
 }
 
-func (rm *RecoveryManager) loadCommittedTransactions(r record.Record) {
-	if r.Kind != record.Commit {
+func (rm *RecoveryManager) pendingTx(txID uint64) *pendingTx {
+	p, ok := rm.pending[txID]
+
+	if !ok {
+		p = &pendingTx{}
+		rm.pending[txID] = p
+	}
+
+	return p
+}
+
+func (p *pendingTx) rollbackTo(name string) {
+	i, ok := p.findSavepoint(name)
+	if !ok {
+		log.Warn().Str("savepoint", name).Msg("recovery: rollback to unknown savepoint")
 		return
 	}
 
-	rm.committed[r.TxID] = struct{}{}
+	p.records = p.records[:p.savepoints[i].records]
+	p.savepoints = p.savepoints[:i+1]
+}
+
+func (p *pendingTx) release(name string) {
+	if i, ok := p.findSavepoint(name); ok {
+		p.savepoints = p.savepoints[:i]
+	}
+}
+
+func (p *pendingTx) findSavepoint(name string) (int, bool) {
+	for i := len(p.savepoints) - 1; i >= 0; i-- {
+		if p.savepoints[i].name == name {
+			return i, true
+		}
+	}
+
+	return 0, false
 }
@@ -0,0 +1,183 @@
+package engine
+
+import (
+	"kv/engine/internal/mocks"
+	"kv/engine/mvcc"
+	"kv/engine/wal/record"
+	"kv/observability"
+	"kv/test"
+	"strconv"
+	"testing"
+)
+
+func TestRecoveryManager_Run(t *testing.T) {
+	observability.DisableLogging()
+
+	runRecovery := func(t *testing.T, records ...*record.Record) *mvcc.VersionMap {
+		t.Helper()
+
+		versionMap := mvcc.NewVersionMap()
+		err := NewRecoveryManager(versionMap, mocks.NewReplayer(records...), RecoveryOptions{Workers: 4}).Run()
+		test.AssertNoError(t, err)
+
+		return versionMap
+	}
+
+	assertValue := func(t *testing.T, versionMap *mvcc.VersionMap, key string, want []byte) {
+		t.Helper()
+
+		chain, ok := versionMap.GetChain(key)
+		test.AssertTrue(t, ok)
+		test.AssertBytesEqual(t, chain.Head().Value, want)
+	}
+
+	assertMissing := func(t *testing.T, versionMap *mvcc.VersionMap, key string) {
+		t.Helper()
+
+		_, ok := versionMap.GetChain(key)
+		test.AssertFalse(t, ok)
+	}
+
+	t.Run("it applies committed records", func(t *testing.T) {
+		versionMap := runRecovery(t,
+			record.NewValue("key", []byte("value"), 2),
+			record.NewCommit(2),
+		)
+
+		assertValue(t, versionMap, "key", []byte("value"))
+	})
+
+	t.Run("it skips uncommitted records", func(t *testing.T) {
+		versionMap := runRecovery(t,
+			record.NewValue("key", []byte("value"), 2),
+		)
+
+		assertMissing(t, versionMap, "key")
+	})
+
+	t.Run("it skips aborted records", func(t *testing.T) {
+		versionMap := runRecovery(t,
+			record.NewValue("key", []byte("value"), 2),
+			record.NewAbort(2),
+			record.NewCommit(3),
+		)
+
+		assertMissing(t, versionMap, "key")
+	})
+
+	t.Run("it ignores an abort following the commit", func(t *testing.T) {
+		versionMap := runRecovery(t,
+			record.NewValue("key", []byte("value"), 2),
+			record.NewCommit(2),
+			record.NewAbort(2),
+		)
+
+		assertValue(t, versionMap, "key", []byte("value"))
+	})
+
+	t.Run("it applies records in commit order", func(t *testing.T) {
+		versionMap := runRecovery(t,
+			record.NewValue("key-1", []byte("100"), 2),
+			record.NewValue("key-2", []byte("200"), 3),
+			record.NewCommit(3),
+			record.NewValue("key-2", []byte("201"), 4),
+			record.NewCommit(2),
+			record.NewCommit(4),
+		)
+
+		assertValue(t, versionMap, "key-1", []byte("100"))
+		assertValue(t, versionMap, "key-2", []byte("201"))
+	})
+
+	t.Run("it freezes versions written by frozen transaction", func(t *testing.T) {
+		versionMap := runRecovery(t,
+			record.NewValue("key", []byte("value"), 2),
+			record.NewCommit(2),
+			record.NewFreeze("key", 2),
+		)
+
+		chain, _ := versionMap.GetChain("key")
+		mvcc.AssertFrozen(t, chain.Head())
+	})
+
+	t.Run("it does not freeze versions replaced by later transactions", func(t *testing.T) {
+		versionMap := runRecovery(t,
+			record.NewValue("key", []byte("100"), 2),
+			record.NewCommit(2),
+			record.NewValue("key", []byte("101"), 3),
+			record.NewCommit(3),
+			record.NewFreeze("key", 2),
+		)
+
+		chain, _ := versionMap.GetChain("key")
+		mvcc.AssertNotFrozen(t, chain.Head())
+	})
+
+	t.Run("it ignores freeze records for missing keys", func(t *testing.T) {
+		versionMap := runRecovery(t,
+			record.NewFreeze("key", 2),
+		)
+
+		assertMissing(t, versionMap, "key")
+	})
+
+	t.Run("it keeps per-key order across workers", func(t *testing.T) {
+		var records []*record.Record
+
+		for txID := uint64(2); txID < 200; txID++ {
+			key := "key-" + strconv.Itoa(int(txID%7))
+			records = append(records,
+				record.NewValue(key, []byte(strconv.Itoa(int(txID))), txID),
+				record.NewCommit(txID),
+			)
+		}
+
+		versionMap := runRecovery(t, records...)
+
+		for txID := uint64(193); txID < 200; txID++ {
+			key := "key-" + strconv.Itoa(int(txID%7))
+			assertValue(t, versionMap, key, []byte(strconv.Itoa(int(txID))))
+		}
+	})
+
+	t.Run("it applies tombstones", func(t *testing.T) {
+		versionMap := runRecovery(t,
+			record.NewValue("key", []byte("value"), 2),
+			record.NewCommit(2),
+			record.NewTombstone("key", 3),
+			record.NewCommit(3),
+		)
+
+		assertMissing(t, versionMap, "key")
+	})
+
+	t.Run("it skips records rolled back to a savepoint", func(t *testing.T) {
+		versionMap := runRecovery(t,
+			record.NewValue("key-1", []byte("100"), 2),
+			record.NewSavepoint("sp", 2),
+			record.NewValue("key-1", []byte("101"), 2),
+			record.NewValue("key-2", []byte("200"), 2),
+			record.NewRollbackTo("sp", 2),
+			record.NewValue("key-3", []byte("300"), 2),
+			record.NewCommit(2),
+		)
+
+		assertValue(t, versionMap, "key-1", []byte("100"))
+		assertMissing(t, versionMap, "key-2")
+		assertValue(t, versionMap, "key-3", []byte("300"))
+	})
+
+	t.Run("it keeps records of released savepoints", func(t *testing.T) {
+		versionMap := runRecovery(t,
+			record.NewSavepoint("sp", 2),
+			record.NewValue("key", []byte("100"), 2),
+			record.NewSavepoint("sp", 2),
+			record.NewValue("key", []byte("101"), 2),
+			record.NewRelease("sp", 2),
+			record.NewRollbackTo("sp", 2),
+			record.NewCommit(2),
+		)
+
+		assertMissing(t, versionMap, "key")
+	})
+}
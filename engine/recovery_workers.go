@@ -0,0 +1,117 @@
+package engine
+
+import (
+	"hash/fnv"
+	"kv/engine/mvcc"
+	"kv/engine/tx"
+	"kv/engine/wal/record"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// recoveryWorkers applies records concurrently. Every key is owned by exactly
+// one worker, and records reach that worker in the order they were
+// dispatched, so writes to a single key keep their log order.
+type recoveryWorkers struct {
+	versionMap *mvcc.VersionMap
+	queues     []chan []record.Record
+	batches    [][]record.Record
+	wg         sync.WaitGroup
+}
+
+const recoveryQueueSize = 64
+
+func newRecoveryWorkers(versionMap *mvcc.VersionMap, count int) *recoveryWorkers {
+	count = max(count, 1)
+
+	w := &recoveryWorkers{
+		versionMap: versionMap,
+		queues:     make([]chan []record.Record, count),
+		batches:    make([][]record.Record, count),
+	}
+
+	for i := range w.queues {
+		queue := make(chan []record.Record, recoveryQueueSize)
+		w.queues[i] = queue
+
+		w.wg.Go(func() {
+			for batch := range queue {
+				for _, r := range batch {
+					w.apply(r)
+				}
+			}
+		})
+	}
+
+	return w
+}
+
+func (w *recoveryWorkers) dispatch(records ...record.Record) {
+	for _, r := range records {
+		i := w.owner(r.Key)
+		w.batches[i] = append(w.batches[i], r)
+	}
+
+	for i, batch := range w.batches {
+		if len(batch) == 0 {
+			continue
+		}
+
+		w.queues[i] <- batch
+		w.batches[i] = nil
+	}
+}
+
+func (w *recoveryWorkers) wait() {
+	for _, queue := range w.queues {
+		close(queue)
+	}
+
+	w.wg.Wait()
+}
+
+func (w *recoveryWorkers) owner(key []byte) int {
+	if len(w.queues) == 1 {
+		return 0
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write(key)
+
+	return int(h.Sum32() % uint32(len(w.queues)))
+}
+
+func (w *recoveryWorkers) apply(r record.Record) {
+	key := string(r.Key)
+
+	switch r.Kind {
+	case record.Tombstone:
+		w.versionMap.Remove(key)
+	case record.Value:
+		w.applyValueRecord(key, r)
+	case record.Freeze:
+		w.applyFreezeRecord(key, tx.ID(r.TxID))
+	default:
+		log.Error().Uint8("kind", r.Kind).Msg("recovery: unexpected record kind")
+	}
+}
+
+func (w *recoveryWorkers) applyValueRecord(key string, r record.Record) {
+	chain := w.versionMap.GetOrCreateChain(key)
+	newVersion := mvcc.NewVersion(key, r.Value, tx.ID(r.TxID))
+	chain.CompareHeadAndSwap(chain.Head(), newVersion)
+}
+
+// applyFreezeRecord freezes the head only if it is still the version written
+// by the frozen transaction, a later commit may have replaced it already.
+func (w *recoveryWorkers) applyFreezeRecord(key string, xMin tx.ID) {
+	chain, ok := w.versionMap.GetChain(key)
+	if !ok {
+		return
+	}
+
+	if head := chain.Head(); head != nil && head.XMin() == xMin {
+		head.Freeze()
+	}
+}
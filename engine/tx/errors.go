@@ -5,3 +5,7 @@ import "errors"
 var TransactionNotActiveError = errors.New("tx: transaction not activeTx")
 var MaxActiveTransactionsExceededError = errors.New("tx: max activeTx transactions reached")
 var ManifestChecksumMismatchError = errors.New("tx: checksum mismatch")
+var SavepointNotFoundError = errors.New("tx: savepoint not found")
+var ManagerShutDownError = errors.New("tx: manager is shutting down")
+var DeadlockError = errors.New("tx: deadlock detected")
+var LockTimeoutError = errors.New("tx: lock timeout")
@@ -9,6 +9,18 @@ func (txID ID) Precedes(candidate ID) bool {
 	return candidate-txID < HalfSpace
 }
 
+// compareIDs orders IDs the way Precedes does, for sorting.
+func compareIDs(a, b ID) int {
+	switch {
+	case a == b:
+		return 0
+	case a.Precedes(b):
+		return -1
+	default:
+		return 1
+	}
+}
+
 func (txID ID) IsFrozen() bool {
 	return txID == IdFrozen
 }
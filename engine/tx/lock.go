@@ -0,0 +1,155 @@
+package tx
+
+import (
+	"sync"
+	"time"
+)
+
+// keyLock is held by one transaction until it ends. released is closed when
+// it lets go of it, waking those waiting.
+type keyLock struct {
+	holder   ID
+	released chan struct{}
+}
+
+// lockTable holds the key locks of pessimistic transactions, along with the
+// wait-for graph between transactions waiting for locks or for each other to
+// end. A waiting transaction waits for one other at a time, so the graph is a
+// map from waiter to holder, and a deadlock is a path leading back to where
+// it started.
+type lockTable struct {
+	mutex    sync.Mutex
+	locks    map[string]*keyLock
+	held     map[ID][]string
+	waitsFor map[ID]ID
+}
+
+func newLockTable() *lockTable {
+	return &lockTable{
+		locks:    make(map[string]*keyLock),
+		held:     make(map[ID][]string),
+		waitsFor: make(map[ID]ID),
+	}
+}
+
+// acquire takes the lock on key for txID, waiting for whoever holds it to end.
+// With take unset it only waits, leaving the key unlocked. A timeout of 0
+// waits for as long as it takes.
+func (lt *lockTable) acquire(key string, txID ID, take bool, timeout time.Duration) error {
+	expired, stop := expiry(timeout)
+	defer stop()
+
+	for {
+		lt.mutex.Lock()
+
+		lock, locked := lt.locks[key]
+
+		if !locked || lock.holder == txID {
+			if take && !locked {
+				lt.locks[key] = &keyLock{holder: txID, released: make(chan struct{})}
+				lt.held[txID] = append(lt.held[txID], key)
+			}
+
+			lt.mutex.Unlock()
+			return nil
+		}
+
+		if err := lt.waitFor(txID, lock.holder, lock.released, expired); err != nil {
+			return err
+		}
+	}
+}
+
+// awaitEnd waits for the other transaction until done is closed, as acquire
+// waits for a lock holder.
+func (lt *lockTable) awaitEnd(txID, other ID, done <-chan struct{}, timeout time.Duration) error {
+	expired, stop := expiry(timeout)
+	defer stop()
+
+	lt.mutex.Lock()
+	return lt.waitFor(txID, other, done, expired)
+}
+
+// waitFor blocks txID until released is closed, with an edge to holder in the
+// wait-for graph meanwhile. It is called with the mutex locked and unlocks it.
+func (lt *lockTable) waitFor(txID, holder ID, released <-chan struct{}, expired <-chan time.Time) error {
+	lt.waitsFor[txID] = holder
+
+	if lt.leadsBackTo(txID) {
+		delete(lt.waitsFor, txID)
+		lt.mutex.Unlock()
+
+		return DeadlockError
+	}
+
+	lt.mutex.Unlock()
+
+	var err error
+
+	select {
+	case <-released:
+	case <-expired:
+		err = LockTimeoutError
+	}
+
+	lt.mutex.Lock()
+	delete(lt.waitsFor, txID)
+	lt.mutex.Unlock()
+
+	return err
+}
+
+// expiry returns a channel firing after timeout, or never for a timeout of 0.
+func expiry(timeout time.Duration) (<-chan time.Time, func() bool) {
+	if timeout <= 0 {
+		return nil, func() bool { return false }
+	}
+
+	timer := time.NewTimer(timeout)
+	return timer.C, timer.Stop
+}
+
+// leadsBackTo follows the wait-for graph from txID, reporting whether it
+// comes back to it.
+func (lt *lockTable) leadsBackTo(txID ID) bool {
+	current, waiting := lt.waitsFor[txID]
+
+	for steps := 0; waiting && steps <= len(lt.waitsFor); steps++ {
+		if current == txID {
+			return true
+		}
+
+		current, waiting = lt.waitsFor[current]
+	}
+
+	return false
+}
+
+func (lt *lockTable) holds(key string, txID ID) bool {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+
+	lock, locked := lt.locks[key]
+	return locked && lock.holder == txID
+}
+
+// heldBy returns how many keys txID holds the lock of.
+func (lt *lockTable) heldBy(txID ID) int {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+
+	return len(lt.held[txID])
+}
+
+func (lt *lockTable) releaseAll(txID ID) {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+
+	for _, key := range lt.held[txID] {
+		close(lt.locks[key].released)
+		delete(lt.locks, key)
+	}
+
+	delete(lt.held, txID)
+	delete(lt.waitsFor, txID)
+}
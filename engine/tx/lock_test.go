@@ -0,0 +1,145 @@
+package tx
+
+import (
+	"kv/test"
+	"testing"
+	"time"
+)
+
+func beginAll(t *testing.T, tm *Manager, n int) []*Transaction {
+	t.Helper()
+
+	transactions := make([]*Transaction, n)
+
+	for i := range transactions {
+		transaction, err := tm.Begin()
+		test.AssertNoError(t, err)
+
+		transactions[i] = transaction
+	}
+
+	return transactions
+}
+
+// lockAsync locks key in the background, the returned channel gets the
+// result.
+func lockAsync(transaction *Transaction, key string) <-chan error {
+	done := make(chan error, 1)
+
+	go func() {
+		done <- transaction.Lock(key)
+	}()
+
+	return done
+}
+
+func assertWaiting(t *testing.T, done <-chan error) {
+	t.Helper()
+
+	select {
+	case err := <-done:
+		t.Fatalf("expected to wait for the lock, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func awaitLock(t *testing.T, done <-chan error) error {
+	t.Helper()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(time.Second):
+		t.Fatal("expected the lock to be taken")
+		return nil
+	}
+}
+
+func TestTransaction_Lock(t *testing.T) {
+	t.Run("it waits for the holder to commit", func(t *testing.T) {
+		tm, _ := setup()
+		txs := beginAll(t, tm, 2)
+
+		test.AssertNoError(t, txs[0].Lock("a"))
+		test.AssertNoError(t, txs[0].Lock("a"))
+		test.AssertEqual(t, txs[0].LockCount(), 1)
+
+		done := lockAsync(txs[1], "a")
+		assertWaiting(t, done)
+
+		test.AssertNoError(t, txs[0].Commit())
+		test.AssertNoError(t, awaitLock(t, done))
+		test.AssertEqual(t, txs[0].LockCount(), 0)
+		test.AssertEqual(t, txs[1].LockCount(), 1)
+	})
+
+	t.Run("it waits for the holder to abort", func(t *testing.T) {
+		tm, _ := setup()
+		txs := beginAll(t, tm, 2)
+
+		test.AssertNoError(t, txs[0].Lock("a"))
+
+		done := lockAsync(txs[1], "a")
+		assertWaiting(t, done)
+
+		txs[0].Abort()
+		test.AssertNoError(t, awaitLock(t, done))
+	})
+
+	t.Run("it does not take the lock when only waiting for it", func(t *testing.T) {
+		tm, _ := setup()
+		txs := beginAll(t, tm, 3)
+
+		test.AssertNoError(t, txs[0].Lock("a"))
+
+		done := make(chan error, 1)
+		go func() { done <- txs[1].AwaitUnlocked("a") }()
+		assertWaiting(t, done)
+
+		test.AssertNoError(t, txs[0].Commit())
+		test.AssertNoError(t, awaitLock(t, done))
+		test.AssertEqual(t, txs[1].LockCount(), 0)
+		test.AssertNoError(t, txs[2].Lock("a"))
+	})
+
+	t.Run("it detects deadlocks", func(t *testing.T) {
+		tm, _ := setup()
+		txs := beginAll(t, tm, 3)
+
+		test.AssertNoError(t, txs[0].Lock("a"))
+		test.AssertNoError(t, txs[1].Lock("b"))
+		test.AssertNoError(t, txs[2].Lock("c"))
+
+		first := lockAsync(txs[0], "b")
+		assertWaiting(t, first)
+
+		second := lockAsync(txs[1], "c")
+		assertWaiting(t, second)
+
+		test.AssertError(t, txs[2].Lock("a"), DeadlockError)
+
+		txs[2].Abort()
+		test.AssertNoError(t, awaitLock(t, second))
+
+		txs[1].Abort()
+		test.AssertNoError(t, awaitLock(t, first))
+	})
+
+	t.Run("it gives up after the lock timeout", func(t *testing.T) {
+		tm, _ := setup()
+		tm.options.LockTimeout = 10 * time.Millisecond
+		txs := beginAll(t, tm, 2)
+
+		test.AssertNoError(t, txs[0].Lock("a"))
+		test.AssertError(t, txs[1].Lock("a"), LockTimeoutError)
+		test.AssertNoError(t, txs[1].Lock("b"))
+	})
+
+	t.Run("it fails for a finished transaction", func(t *testing.T) {
+		tm, _ := setup()
+		txs := beginAll(t, tm, 1)
+
+		test.AssertNoError(t, txs[0].Commit())
+		test.AssertError(t, txs[0].Lock("a"), TransactionNotActiveError)
+	})
+}
@@ -1,18 +1,35 @@
 package tx
 
 import (
+	"context"
 	"kv/engine/wal"
 	"kv/engine/wal/record"
+	"slices"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
+// shutdownPollInterval is how often Shutdown checks for transactions that
+// are still active.
+const shutdownPollInterval = 10 * time.Millisecond
+
 // TODO: Timeouts
 // TODO: Add a way to reuse transactions (return a pointer to a transaction that is activeTx)
 
 type ManagerOptions struct {
 	ReservedIDsPerBatch   uint64
 	MaxActiveTransactions uint16
+
+	// LockTimeout is how long a transaction waits for a key lock before
+	// giving up, 0 to wait for as long as it takes.
+	LockTimeout time.Duration
+
+	// ReadOnly is for inspecting a store another process writes to. IDs are
+	// handed out past the last one that process reserved, without reserving
+	// them, so that everything it committed precedes the transactions started
+	// here. Nothing is logged, transactions only get to read.
+	ReadOnly bool
 }
 
 type Manager struct {
@@ -22,9 +39,12 @@ type Manager struct {
 	activeTxCount atomic.Int32
 	activeTx      sync.Map
 
+	locks *lockTable
+
 	nextIDLock    sync.Mutex
 	nextTxID      ID
 	maxReservedID ID
+	shutDown      bool
 
 	options ManagerOptions
 }
@@ -33,6 +53,7 @@ func NewManager(manifest *Manifest, walAppender wal.Appender, options ManagerOpt
 	return &Manager{
 		manifest:    manifest,
 		walAppender: walAppender,
+		locks:       newLockTable(),
 		options:     options,
 	}
 }
@@ -41,6 +62,10 @@ func (tm *Manager) Begin() (*Transaction, error) {
 	tm.nextIDLock.Lock()
 	defer tm.nextIDLock.Unlock()
 
+	if tm.shutDown {
+		return nil, ManagerShutDownError
+	}
+
 	txID, err := tm.allocateNextID()
 
 	if err != nil {
@@ -48,15 +73,110 @@ func (tm *Manager) Begin() (*Transaction, error) {
 	}
 
 	oldestTxID, found := tm.oldestActiveTx()
-	tm.trackActive(txID)
+	transaction := newTransaction(txID, tm, Snapshot{})
+
+	// Others can list the transaction as soon as it is tracked, it is locked
+	// until it has its snapshot.
+	transaction.mutex.Lock()
+	defer transaction.mutex.Unlock()
+
+	tm.trackActive(transaction)
 
 	if !found {
 		oldestTxID = txID
 	}
 
 	activeTx := tm.copyActiveTx()
-	snapshot := newSnapshot(oldestTxID, txID, activeTx)
-	return newTransaction(txID, tm, snapshot), nil
+	transaction.snapshot = newSnapshot(oldestTxID, txID, activeTx)
+	return transaction, nil
+}
+
+// Shutdown stops new transactions from starting and waits for the active
+// ones to finish. Those still active once ctx is done are aborted, Shutdown
+// returns how many.
+func (tm *Manager) Shutdown(ctx context.Context) int {
+	tm.nextIDLock.Lock()
+	tm.shutDown = true
+	tm.nextIDLock.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for tm.ActiveCount() > 0 {
+		select {
+		case <-ctx.Done():
+			return tm.abortActive()
+		case <-ticker.C:
+		}
+	}
+
+	return 0
+}
+
+// ActiveCount returns how many transactions are active.
+func (tm *Manager) ActiveCount() int {
+	return int(tm.activeTxCount.Load())
+}
+
+type ManagerStats struct {
+	Active int
+
+	// NextID is the ID the next transaction gets, unless the manifest has to
+	// reserve more first.
+	NextID ID
+
+	// ReservedUntil is the last ID reserved in the manifest.
+	ReservedUntil ID
+
+	Horizon ID
+}
+
+func (tm *Manager) Stats() (ManagerStats, error) {
+	reservedUntil, err := tm.manifest.LastReservedID()
+	if err != nil {
+		return ManagerStats{}, err
+	}
+
+	tm.nextIDLock.Lock()
+	nextID := tm.nextTxID + 1
+	tm.nextIDLock.Unlock()
+
+	for nextID.IsReserved() {
+		nextID++
+	}
+
+	return ManagerStats{
+		Active:        tm.ActiveCount(),
+		NextID:        nextID,
+		ReservedUntil: ID(reservedUntil),
+		Horizon:       tm.FindTxHorizon(),
+	}, nil
+}
+
+// ActiveTransactions returns the active transactions, oldest first.
+func (tm *Manager) ActiveTransactions() []*Transaction {
+	var active []*Transaction
+
+	tm.activeTx.Range(func(key, value any) bool {
+		active = append(active, value.(*Transaction))
+		return true
+	})
+
+	slices.SortFunc(active, func(a, b *Transaction) int {
+		return compareIDs(a.ID, b.ID)
+	})
+
+	return active
+}
+
+func (tm *Manager) abortActive() int {
+	active := tm.ActiveTransactions()
+
+	for _, transaction := range active {
+		transaction.Abort()
+	}
+
+	return len(active)
 }
 
 func (tm *Manager) FindTxHorizon() ID {
@@ -78,7 +198,7 @@ func (tm *Manager) allocateNextID() (ID, error) {
 	}
 
 	if tm.maxReservedID <= tm.nextTxID {
-		from, until, err := tm.manifest.ReserveIDs(tm.options.ReservedIDsPerBatch)
+		from, until, err := tm.reserveIDs()
 
 		if err != nil {
 			return 0, err
@@ -99,22 +219,94 @@ func (tm *Manager) allocateNextID() (ID, error) {
 	}
 }
 
+func (tm *Manager) reserveIDs() (from, until uint64, err error) {
+	if !tm.options.ReadOnly {
+		return tm.manifest.ReserveIDs(tm.options.ReservedIDsPerBatch)
+	}
+
+	last := uint64(tm.maxReservedID)
+
+	if last == 0 {
+		if last, err = tm.manifest.LastReservedID(); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return last + 1, last + tm.options.ReservedIDsPerBatch, nil
+}
+
 func (tm *Manager) commit(txID ID) error {
 	if !tm.isActive(txID) {
 		return TransactionNotActiveError
 	}
 
 	rec := record.NewCommit(txID.Uint64())
-	if err := tm.walAppender.Append(rec); err != nil {
+	if err := tm.append(rec); err != nil {
 		return err
 	}
 
 	tm.stopTrackingActive(txID)
+	tm.locks.releaseAll(txID)
 	return nil
 }
 
+func (tm *Manager) savepoint(txID ID, name string) error {
+	return tm.appendIfActive(txID, record.NewSavepoint(name, txID.Uint64()))
+}
+
+func (tm *Manager) rollbackTo(txID ID, name string) error {
+	return tm.appendIfActive(txID, record.NewRollbackTo(name, txID.Uint64()))
+}
+
+func (tm *Manager) release(txID ID, name string) error {
+	return tm.appendIfActive(txID, record.NewRelease(name, txID.Uint64()))
+}
+
+// lock takes the lock on key for txID, or with take unset waits until no
+// other transaction holds it.
+func (tm *Manager) lock(txID ID, key string, take bool) error {
+	if !tm.isActive(txID) {
+		return TransactionNotActiveError
+	}
+
+	return tm.locks.acquire(key, txID, take, tm.options.LockTimeout)
+}
+
+func (tm *Manager) awaitEnd(txID, other ID, timeout time.Duration) error {
+	value, ok := tm.activeTx.Load(other)
+	if !ok {
+		return nil
+	}
+
+	return tm.locks.awaitEnd(txID, other, value.(*Transaction).done, timeout)
+}
+
+func (tm *Manager) appendIfActive(txID ID, rec *record.Record) error {
+	if !tm.isActive(txID) {
+		return TransactionNotActiveError
+	}
+
+	return tm.append(rec)
+}
+
+func (tm *Manager) append(rec *record.Record) error {
+	if tm.options.ReadOnly {
+		return nil
+	}
+
+	return tm.walAppender.Append(rec)
+}
+
+// abort logs the abort on a best-effort basis. A transaction without a commit
+// record is treated as aborted by recovery anyway, the record only lets the
+// log drop its writes early.
 func (tm *Manager) abort(txID ID) {
+	if tm.isActive(txID) {
+		_ = tm.append(record.NewAbort(txID.Uint64()))
+	}
+
 	tm.stopTrackingActive(txID)
+	tm.locks.releaseAll(txID)
 }
 
 func (tm *Manager) oldestActiveTx() (oldestTxID ID, found bool) {
@@ -144,13 +336,16 @@ func (tm *Manager) copyActiveTx() map[ID]struct{} {
 	return m
 }
 
-func (tm *Manager) trackActive(txID ID) {
-	tm.activeTx.Store(txID, struct{}{})
+func (tm *Manager) trackActive(transaction *Transaction) {
+	tm.activeTx.Store(transaction.ID, transaction)
 	tm.activeTxCount.Add(1)
 }
 
 func (tm *Manager) stopTrackingActive(txID ID) {
-	tm.activeTx.Delete(txID)
+	if value, ok := tm.activeTx.LoadAndDelete(txID); ok {
+		close(value.(*Transaction).done)
+	}
+
 	tm.activeTxCount.Add(-1)
 }
 
@@ -1,10 +1,13 @@
 package tx
 
 import (
+	"context"
 	"kv/engine/internal/mocks"
+	"kv/engine/wal/record"
 	storagemocks "kv/storage/mocks"
 	"kv/test"
 	"testing"
+	"time"
 )
 
 func TestTransactionManager_Begin(t *testing.T) {
@@ -181,3 +184,119 @@ func TestTransactionManager_Horizon(t *testing.T) {
 		_ = tx1.Commit()
 	})
 }
+
+func TestTransactionManager_ReadOnly(t *testing.T) {
+	manifest := NewManifest(storagemocks.NewFile())
+	_, until, err := manifest.ReserveIDs(10)
+	test.AssertNoError(t, err)
+
+	appender := mocks.NewAppender()
+	tm := NewManager(manifest, appender, ManagerOptions{
+		ReservedIDsPerBatch:   5,
+		MaxActiveTransactions: 5,
+		ReadOnly:              true,
+	})
+
+	t.Run("it hands out IDs past the reserved ones without reserving them", func(t *testing.T) {
+		for range 12 {
+			tx, err := tm.Begin()
+			test.AssertNoError(t, err)
+			test.AssertTrue(t, tx.ID > ID(until))
+			test.AssertNoError(t, tx.Commit())
+		}
+
+		last, err := manifest.LastReservedID()
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, last, until)
+	})
+
+	t.Run("it logs nothing", func(t *testing.T) {
+		tx, _ := tm.Begin()
+		test.AssertNoError(t, tx.Savepoint("sp"))
+		test.AssertNoError(t, tx.Commit())
+
+		tx, _ = tm.Begin()
+		tx.Abort()
+
+		test.AssertEqual(t, len(appender.Records), 0)
+	})
+}
+
+func TestTransactionManager_Shutdown(t *testing.T) {
+	t.Run("it refuses new transactions", func(t *testing.T) {
+		tm, _ := setup()
+
+		test.AssertEqual(t, tm.Shutdown(context.Background()), 0)
+
+		_, err := tm.Begin()
+		test.AssertError(t, err, ManagerShutDownError)
+	})
+
+	t.Run("it waits for active transactions to finish", func(t *testing.T) {
+		tm, _ := setup()
+		transaction, _ := tm.Begin()
+
+		go func() {
+			time.Sleep(2 * shutdownPollInterval)
+			_ = transaction.Commit()
+		}()
+
+		test.AssertEqual(t, tm.Shutdown(context.Background()), 0)
+		test.AssertEqual(t, tm.ActiveCount(), 0)
+	})
+
+	t.Run("it aborts transactions still active when the context is done", func(t *testing.T) {
+		tm, appender := setup()
+		_, _ = tm.Begin()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*shutdownPollInterval)
+		defer cancel()
+
+		test.AssertEqual(t, tm.Shutdown(ctx), 1)
+		test.AssertEqual(t, tm.ActiveCount(), 0)
+		test.AssertEqual(t, appender.Records[len(appender.Records)-1].Kind, record.Abort)
+	})
+}
+
+func TestTransactionManager_ActiveTransactions(t *testing.T) {
+	tm, _ := setup()
+
+	tx1, _ := tm.Begin()
+	tx2, _ := tm.Begin()
+	tx3, _ := tm.Begin()
+	_ = tx2.Commit()
+
+	active := tm.ActiveTransactions()
+	test.AssertEqual(t, len(active), 2)
+	test.AssertEqual(t, active[0], tx1)
+	test.AssertEqual(t, active[1], tx3)
+
+	snapshot := tx3.Snapshot()
+	test.AssertEqual(t, snapshot.XMin(), tx1.ID)
+	test.AssertEqual(t, snapshot.XMax(), tx3.ID)
+
+	ids := snapshot.Active()
+	test.AssertEqual(t, len(ids), 3)
+	test.AssertEqual(t, ids[0], tx1.ID)
+	test.AssertEqual(t, ids[1], tx2.ID)
+	test.AssertEqual(t, ids[2], tx3.ID)
+}
+
+func TestTransactionManager_Stats(t *testing.T) {
+	tm, _ := setup()
+
+	tx1, _ := tm.Begin()
+	tx2, _ := tm.Begin()
+	_ = tx1.Commit()
+
+	stats, err := tm.Stats()
+	test.AssertNoError(t, err)
+
+	test.AssertEqual(t, stats.Active, 1)
+	test.AssertEqual(t, stats.NextID, tx2.ID+1)
+	test.AssertEqual(t, stats.ReservedUntil, tm.maxReservedID)
+	test.AssertEqual(t, stats.Horizon, tx2.ID)
+
+	tx3, _ := tm.Begin()
+	test.AssertEqual(t, tx3.ID, stats.NextID)
+}
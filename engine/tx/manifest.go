@@ -5,6 +5,7 @@ import (
 	"errors"
 	"hash/crc32"
 	"io"
+	"kv/encryption"
 	"kv/storage"
 	"sync"
 )
@@ -18,8 +19,10 @@ const (
 )
 
 type Manifest struct {
-	file  storage.File
-	mutex sync.Mutex
+	file    *storage.SlotFile
+	keyring *encryption.Keyring
+	migrate bool
+	mutex   sync.Mutex
 }
 
 type state struct {
@@ -27,9 +30,32 @@ type state struct {
 	reservedUntil uint64
 }
 
+// manifestAdditionalData binds a sealed manifest to its purpose, so that it
+// cannot be swapped for another file sealed with the same key.
+var manifestAdditionalData = []byte("tx-manifest")
+
 func NewManifest(file storage.File) *Manifest {
+	return NewEncryptedManifest(file, nil)
+}
+
+// NewEncryptedManifest returns a manifest sealed with the active key of the
+// keyring. With keys loaded, a manifest written without encryption is
+// refused.
+func NewEncryptedManifest(file storage.File, keyring *encryption.Keyring) *Manifest {
 	return &Manifest{
-		file: file,
+		file:    storage.NewSlotFile(file),
+		keyring: keyring,
+	}
+}
+
+// NewMigratingManifest is NewEncryptedManifest, except that it still reads a
+// manifest written before encryption was enabled, sealing it the next time
+// it is written.
+func NewMigratingManifest(file storage.File, keyring *encryption.Keyring) *Manifest {
+	return &Manifest{
+		file:    storage.NewSlotFile(file),
+		keyring: keyring,
+		migrate: true,
 	}
 }
 
@@ -66,23 +92,47 @@ func (m *Manifest) ReserveIDs(count uint64) (from, until uint64, err error) {
 	return c.reservedFrom, c.reservedUntil, nil
 }
 
-func (m *Manifest) read() (state, error) {
-	if _, err := m.file.Seek(0, io.SeekStart); err != nil {
-		return state{}, err
+// Reseal writes the manifest again, sealing it with the current active key.
+func (m *Manifest) Reseal() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	s, err := m.read()
+	if err != nil {
+		return err
 	}
 
-	buf := make([]byte, manifestSize)
+	return m.write(s)
+}
+
+func (m *Manifest) read() (state, error) {
+	content, err := m.file.Read()
+	if errors.Is(err, storage.SlotsCorruptedError) {
+		return state{}, ManifestChecksumMismatchError
+	}
 
-	n, err := io.ReadFull(m.file, buf)
+	if err != nil {
+		return state{}, err
+	}
 
-	if errors.Is(err, io.EOF) && n == 0 {
+	if len(content) == 0 {
 		return state{reservedUntil: 0}, nil
 	}
 
+	open := encryption.OpenFrame
+	if m.migrate {
+		open = encryption.OpenFrameForMigration
+	}
+
+	buf, err := open(m.keyring, content, manifestAdditionalData)
 	if err != nil {
 		return state{}, err
 	}
 
+	if len(buf) < manifestSize {
+		return state{}, io.ErrUnexpectedEOF
+	}
+
 	reservedUntil := binary.LittleEndian.Uint64(buf[reservedUntilOffset : reservedUntilOffset+reservedUntilSize])
 	expectedChecksum := binary.LittleEndian.Uint32(buf[checksumOffset:])
 
@@ -103,19 +153,7 @@ func (m *Manifest) write(s state) error {
 	binary.LittleEndian.PutUint64(buf[reservedUntilOffset:reservedUntilOffset+reservedUntilSize], s.reservedUntil)
 	binary.LittleEndian.PutUint32(buf[checksumOffset:checksumOffset+checksumSize], s.checksum())
 
-	if _, err := m.file.Seek(0, io.SeekStart); err != nil {
-		return err
-	}
-
-	if _, err := m.file.Write(buf); err != nil {
-		return err
-	}
-
-	if err := m.file.Sync(); err != nil {
-		return err
-	}
-
-	return nil
+	return m.file.Write(encryption.SealFrame(m.keyring, buf, manifestAdditionalData))
 }
 
 func (s state) checksum() uint32 {
@@ -1,6 +1,7 @@
 package tx
 
 import (
+	"kv/encryption"
 	"kv/storage/mocks"
 	"kv/test"
 	"testing"
@@ -56,9 +57,50 @@ func TestManifest(t *testing.T) {
 		manifest := NewManifest(file)
 
 		_, _, _ = manifest.ReserveIDs(100)
-		file.Data[2] = ^file.Data[2]
+		file.Data[20] = ^file.Data[20]
 		_, err := manifest.LastReservedID()
 
 		test.AssertError(t, err, ManifestChecksumMismatchError)
 	})
+
+	t.Run("it encrypts the manifest", func(t *testing.T) {
+		keyring, _ := encryption.ParseKeyring("1:000102030405060708090a0b0c0d0e0f")
+		file := mocks.NewFile()
+
+		_, _, err := NewEncryptedManifest(file, keyring).ReserveIDs(100)
+		test.AssertNoError(t, err)
+
+		reserved, err := NewEncryptedManifest(file, keyring).LastReservedID()
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, uint64(100), reserved)
+
+		_, err = NewManifest(file).LastReservedID()
+		test.AssertError(t, err, encryption.KeyRequiredError)
+	})
+
+	t.Run("it refuses a plain manifest once encryption is enabled", func(t *testing.T) {
+		keyring, _ := encryption.ParseKeyring("1:000102030405060708090a0b0c0d0e0f")
+		file := mocks.NewFile()
+		_, _, _ = NewManifest(file).ReserveIDs(100)
+
+		_, err := NewEncryptedManifest(file, keyring).LastReservedID()
+		test.AssertError(t, err, encryption.UnencryptedDataError)
+	})
+
+	t.Run("it seals a plain manifest when migrating", func(t *testing.T) {
+		keyring, _ := encryption.ParseKeyring("1:000102030405060708090a0b0c0d0e0f")
+		file := mocks.NewFile()
+		_, _, _ = NewManifest(file).ReserveIDs(100)
+
+		test.AssertNoError(t, NewMigratingManifest(file, keyring).Reseal())
+
+		manifest := NewEncryptedManifest(file, keyring)
+
+		reserved, err := manifest.LastReservedID()
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, uint64(100), reserved)
+
+		_, err = NewManifest(file).LastReservedID()
+		test.AssertError(t, err, encryption.KeyRequiredError)
+	})
 }
@@ -1,5 +1,7 @@
 package tx
 
+import "slices"
+
 type Snapshot struct {
 	xMin   ID
 	xMax   ID
@@ -18,3 +20,26 @@ func (s Snapshot) IsActive(id ID) bool {
 	_, ok := s.active[id]
 	return ok
 }
+
+// XMin is the oldest transaction active when the snapshot was taken.
+func (s Snapshot) XMin() ID {
+	return s.xMin
+}
+
+// XMax is the transaction the snapshot was taken for, those after it are
+// not visible.
+func (s Snapshot) XMax() ID {
+	return s.xMax
+}
+
+// Active returns the transactions active when the snapshot was taken, in
+// order.
+func (s Snapshot) Active() []ID {
+	active := make([]ID, 0, len(s.active))
+	for id := range s.active {
+		active = append(active, id)
+	}
+
+	slices.SortFunc(active, compareIDs)
+	return active
+}
@@ -1,7 +1,9 @@
 package tx
 
 import (
+	"errors"
 	"sync"
+	"time"
 )
 
 const (
@@ -11,12 +13,20 @@ const (
 type Transaction struct {
 	ID ID
 
-	writes   []version
-	manager  *Manager
-	snapshot Snapshot
+	writes     []write
+	savepoints []savepoint
+	manager    *Manager
+	snapshot   Snapshot
 
 	once  sync.Once
 	mutex sync.Mutex
+
+	// aborted is set once the transaction is aborted, which Manager.Shutdown
+	// may do while its owner is still running commands.
+	aborted bool
+
+	// done is closed once the transaction is no longer active.
+	done chan struct{}
 }
 
 func newTransaction(id ID, manager *Manager, snapshot Snapshot) *Transaction {
@@ -24,28 +34,60 @@ func newTransaction(id ID, manager *Manager, snapshot Snapshot) *Transaction {
 		ID:       id,
 		manager:  manager,
 		snapshot: snapshot,
+		done:     make(chan struct{}),
 	}
 }
 
-func (tx *Transaction) Track(x version) {
-	if x == nil {
-		return
-	}
-
+// Track records versions the transaction inserted or killed, to be undone if
+// it aborts. If it has been aborted already, they are undone right away and
+// Track fails with TransactionNotActiveError.
+func (tx *Transaction) Track(versions ...version) error {
 	tx.mutex.Lock()
 	defer tx.mutex.Unlock()
 
-	tx.writes = append(tx.writes, x)
+	from := len(tx.writes)
+
+	for _, x := range versions {
+		if x == nil {
+			continue
+		}
+
+		tx.writes = append(tx.writes, write{
+			version: x,
+			killed:  x.XMax() == tx.ID,
+		})
+	}
+
+	if tx.aborted {
+		tx.undo(from)
+		tx.writes = tx.writes[:from]
+		return TransactionNotActiveError
+	}
+
+	return nil
 }
 
+// Commit ends the transaction once its commit record is logged. If logging
+// it fails, the transaction is aborted instead, so that it neither keeps its
+// locks nor stays active.
 func (tx *Transaction) Commit() error {
 	tx.mutex.Lock()
 	defer tx.mutex.Unlock()
 
+	if tx.aborted {
+		return TransactionNotActiveError
+	}
+
 	var err error
 
 	tx.once.Do(func() {
 		err = tx.manager.commit(tx.ID)
+
+		if err != nil && !errors.Is(err, TransactionNotActiveError) {
+			tx.aborted = true
+			tx.undo(0)
+			tx.manager.abort(tx.ID)
+		}
 	})
 
 	return err
@@ -56,28 +98,187 @@ func (tx *Transaction) Abort() {
 	defer tx.mutex.Unlock()
 
 	tx.once.Do(func() {
-		for i := len(tx.writes) - 1; i >= 0; i-- {
-			e := tx.writes[i]
-
-			if e == nil {
-				continue
-			}
-
-			if e.XMin() == tx.ID {
-				e.TryKill(tx.ID)
-				continue
-			}
-
-			if e.XMax() == tx.ID {
-				e.Resurrect()
-				continue
-			}
-		}
-
+		tx.aborted = true
+		tx.undo(0)
 		tx.manager.abort(tx.ID)
 	})
 }
 
+func (tx *Transaction) Savepoint(name string) error {
+	tx.mutex.Lock()
+	defer tx.mutex.Unlock()
+
+	if err := tx.manager.savepoint(tx.ID, name); err != nil {
+		return err
+	}
+
+	tx.savepoints = append(tx.savepoints, savepoint{
+		name:   name,
+		writes: len(tx.writes),
+	})
+
+	return nil
+}
+
+// RollbackTo undoes every write made after the named savepoint. The savepoint
+// itself is kept, so it can be rolled back to again.
+func (tx *Transaction) RollbackTo(name string) error {
+	tx.mutex.Lock()
+	defer tx.mutex.Unlock()
+
+	i, ok := tx.findSavepoint(name)
+	if !ok {
+		return SavepointNotFoundError
+	}
+
+	if err := tx.manager.rollbackTo(tx.ID, name); err != nil {
+		return err
+	}
+
+	sp := tx.savepoints[i]
+	tx.undo(sp.writes)
+	tx.writes = tx.writes[:sp.writes]
+	tx.savepoints = tx.savepoints[:i+1]
+
+	return nil
+}
+
+// Release forgets the named savepoint and every savepoint created after it,
+// keeping the writes made since.
+func (tx *Transaction) Release(name string) error {
+	tx.mutex.Lock()
+	defer tx.mutex.Unlock()
+
+	i, ok := tx.findSavepoint(name)
+	if !ok {
+		return SavepointNotFoundError
+	}
+
+	if err := tx.manager.release(tx.ID, name); err != nil {
+		return err
+	}
+
+	tx.savepoints = tx.savepoints[:i]
+	return nil
+}
+
+// undo reverts writes made at or after position from. Versions inserted since
+// then are killed, versions killed since then are resurrected unless they
+// were already killed by this transaction before that position.
+func (tx *Transaction) undo(from int) {
+	killedBefore := make(map[version]struct{})
+	insertedAfter := make(map[version]struct{})
+
+	for i, w := range tx.writes {
+		switch {
+		case i < from && w.killed:
+			killedBefore[w.version] = struct{}{}
+		case i >= from && !w.killed:
+			insertedAfter[w.version] = struct{}{}
+		}
+	}
+
+	for i := len(tx.writes) - 1; i >= from; i-- {
+		w := tx.writes[i]
+
+		if !w.killed {
+			w.version.TryKill(tx.ID)
+			continue
+		}
+
+		if _, ok := insertedAfter[w.version]; ok {
+			continue
+		}
+
+		if _, ok := killedBefore[w.version]; ok {
+			continue
+		}
+
+		w.version.Resurrect()
+	}
+}
+
+func (tx *Transaction) findSavepoint(name string) (int, bool) {
+	for i := len(tx.savepoints) - 1; i >= 0; i-- {
+		if tx.savepoints[i].name == name {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+// Snapshot returns what the transaction sees of others.
+func (tx *Transaction) Snapshot() Snapshot {
+	tx.mutex.Lock()
+	defer tx.mutex.Unlock()
+
+	return tx.snapshot
+}
+
+// WriteCount returns how many versions the transaction wrote or killed.
+func (tx *Transaction) WriteCount() int {
+	tx.mutex.Lock()
+	defer tx.mutex.Unlock()
+
+	return len(tx.writes)
+}
+
+// Lock takes the lock on key, held until the transaction ends. Others locking
+// the key or writing to it wait for the transaction in the meantime. When
+// waiting would close a cycle of transactions waiting for each other it fails
+// with DeadlockError, after ManagerOptions.LockTimeout with LockTimeoutError.
+func (tx *Transaction) Lock(key string) error {
+	return tx.manager.lock(tx.ID, key, true)
+}
+
+// AwaitUnlocked waits until no other transaction holds the lock on key, failing
+// as Lock does.
+func (tx *Transaction) AwaitUnlocked(key string) error {
+	return tx.manager.lock(tx.ID, key, false)
+}
+
+// AwaitEnd waits for the other transaction to commit or abort, failing as Lock
+// does. It returns right away if other is not active.
+func (tx *Transaction) AwaitEnd(other ID, timeout time.Duration) error {
+	return tx.manager.awaitEnd(tx.ID, other, timeout)
+}
+
+// IsActive reports whether the transaction with the given ID has yet to
+// commit or abort.
+func (tx *Transaction) IsActive(id ID) bool {
+	return tx.manager.isActive(id)
+}
+
+// LockCount returns how many keys the transaction holds the lock of.
+func (tx *Transaction) LockCount() int {
+	return tx.manager.locks.heldBy(tx.ID)
+}
+
+// CanSeeLocked is CanSee for a version of key. Once the transaction holds the
+// lock on key, it also sees the inserts and deletes committed after its
+// snapshot was taken, nobody else can change the key until it ends.
+//
+// A delete counts once its transaction has ended. An aborted transaction
+// resurrects the versions it killed before it ends, so xMax still being set
+// then means it committed, provided it is read again after this call, see
+// mvcc.VersionChain.FindLatest.
+func (tx *Transaction) CanSeeLocked(key string, xMin, xMax ID) bool {
+	if tx.CanSee(xMin, xMax) {
+		if xMax.IsAlive() || tx.manager.isActive(xMax) {
+			return true
+		}
+
+		return !tx.manager.locks.holds(key, tx.ID)
+	}
+
+	if !xMax.IsAlive() || xMin == tx.ID || tx.manager.isActive(xMin) {
+		return false
+	}
+
+	return tx.manager.locks.holds(key, tx.ID)
+}
+
 func (tx *Transaction) CanSee(xMin, xMax ID) bool {
 	// Own insert
 	if xMin == tx.ID && xMax.IsAlive() {
@@ -1,6 +1,7 @@
 package tx
 
 import (
+	"errors"
 	"kv/engine/internal/mocks"
 	"kv/engine/wal/record"
 	storagemocks "kv/storage/mocks"
@@ -34,10 +35,34 @@ func TestTransaction_Commit(t *testing.T) {
 
 		test.AssertFalse(t, tm.isActive(tx.ID))
 	})
+
+	t.Run("it aborts the transaction when the commit cannot be logged", func(t *testing.T) {
+		tm, appender := setup()
+
+		tx, err := tm.Begin()
+		test.AssertNoError(t, err)
+		test.AssertNoError(t, tx.Lock("a"))
+
+		newVersion := newMockVersion("key", []byte("value"), tx.ID)
+		tx.Track(newVersion)
+
+		appender.Err = errors.New("wal failed")
+		test.AssertError(t, tx.Commit(), appender.Err)
+
+		test.AssertFalse(t, tm.isActive(tx.ID))
+		test.AssertEqual(t, tm.ActiveCount(), 0)
+		test.AssertEqual(t, tx.LockCount(), 0)
+		test.AssertEqual(t, newVersion.XMax(), tx.ID)
+
+		appender.Err = nil
+		other, err := tm.Begin()
+		test.AssertNoError(t, err)
+		test.AssertNoError(t, other.Lock("a"))
+	})
 }
 
 func TestTransaction_Abort(t *testing.T) {
-	tm, _ := setup()
+	tm, appender := setup()
 
 	setup := func(t *testing.T) (*Transaction, version) {
 		tx, err := tm.Begin()
@@ -55,6 +80,16 @@ func TestTransaction_Abort(t *testing.T) {
 		test.AssertFalse(t, tm.isActive(tx.ID))
 	})
 
+	t.Run("it appends 'abort' record", func(t *testing.T) {
+		tx, _ := setup(t)
+
+		tx.Abort()
+
+		abortRecord := appender.Records[len(appender.Records)-1]
+		test.AssertEqual(t, abortRecord.TxID, tx.ID.Uint64())
+		test.AssertEqual(t, abortRecord.Kind, record.Abort)
+	})
+
 	t.Run("it restores tracked removed records", func(t *testing.T) {
 		tx, rec := setup(t)
 		rec.TryKill(tx.ID)
@@ -93,6 +128,193 @@ func TestTransaction_Abort(t *testing.T) {
 
 		test.AssertFalse(t, tx.CanSee(rec.XMin(), rec.XMax()))
 	})
+
+	t.Run("it undoes writes tracked after it was aborted", func(t *testing.T) {
+		tx, rec := setup(t)
+		tx.Abort()
+
+		newVersion := newMockVersion("key", []byte("value"), tx.ID)
+		rec.TryKill(tx.ID)
+
+		err := tx.Track(rec, newVersion)
+		test.AssertError(t, err, TransactionNotActiveError)
+		test.AssertTrue(t, rec.XMax().IsAlive())
+		test.AssertEqual(t, newVersion.XMax(), tx.ID)
+
+		test.AssertError(t, tx.Commit(), TransactionNotActiveError)
+	})
+}
+
+func TestTransaction_Savepoints(t *testing.T) {
+	tm, appender := setup()
+
+	setup := func(t *testing.T) (*Transaction, version) {
+		tx, err := tm.Begin()
+		test.AssertNoError(t, err)
+		newVersion := newMockVersion("key", []byte("value"), IdFrozen)
+		return tx, newVersion
+	}
+
+	t.Run("it appends savepoint records", func(t *testing.T) {
+		tx, err := tm.Begin()
+		test.AssertNoError(t, err)
+
+		test.AssertNoError(t, tx.Savepoint("sp"))
+		test.AssertNoError(t, tx.RollbackTo("sp"))
+		test.AssertNoError(t, tx.Release("sp"))
+
+		records := appender.Records[len(appender.Records)-3:]
+		test.AssertEqual(t, records[0].Kind, record.Savepoint)
+		test.AssertEqual(t, records[1].Kind, record.RollbackTo)
+		test.AssertEqual(t, records[2].Kind, record.Release)
+		test.AssertBytesEqual(t, records[0].Key, []byte("sp"))
+
+		tx.Abort()
+	})
+
+	t.Run("it removes records added after savepoint", func(t *testing.T) {
+		tx, err := tm.Begin()
+		test.AssertNoError(t, err)
+
+		before := newMockVersion("key", []byte("before"), tx.ID)
+		tx.Track(before)
+		_ = tx.Savepoint("sp")
+		after := newMockVersion("key", []byte("after"), tx.ID)
+		tx.Track(after)
+
+		err = tx.RollbackTo("sp")
+		test.AssertNoError(t, err)
+
+		test.AssertTrue(t, tx.CanSee(before.XMin(), before.XMax()))
+		test.AssertFalse(t, tx.CanSee(after.XMin(), after.XMax()))
+
+		tx.Abort()
+	})
+
+	t.Run("it restores records removed after savepoint", func(t *testing.T) {
+		tx, rec := setup(t)
+		_ = tx.Savepoint("sp")
+		rec.TryKill(tx.ID)
+		tx.Track(rec)
+
+		err := tx.RollbackTo("sp")
+		test.AssertNoError(t, err)
+
+		test.AssertTrue(t, tx.CanSee(rec.XMin(), rec.XMax()))
+
+		tx.Abort()
+	})
+
+	t.Run("it keeps records removed before savepoint", func(t *testing.T) {
+		tx, rec := setup(t)
+		rec.TryKill(tx.ID)
+		tx.Track(rec)
+		_ = tx.Savepoint("sp")
+		tx.Track(rec)
+
+		err := tx.RollbackTo("sp")
+		test.AssertNoError(t, err)
+
+		test.AssertFalse(t, tx.CanSee(rec.XMin(), rec.XMax()))
+
+		tx.Abort()
+	})
+
+	t.Run("it resurrects own inserts removed after savepoint", func(t *testing.T) {
+		tx, err := tm.Begin()
+		test.AssertNoError(t, err)
+
+		rec := newMockVersion("key", []byte("value"), tx.ID)
+		tx.Track(rec)
+		_ = tx.Savepoint("sp")
+		rec.TryKill(tx.ID)
+		tx.Track(rec)
+
+		err = tx.RollbackTo("sp")
+		test.AssertNoError(t, err)
+
+		test.AssertTrue(t, tx.CanSee(rec.XMin(), rec.XMax()))
+
+		tx.Abort()
+	})
+
+	t.Run("it allows rolling back to the same savepoint twice", func(t *testing.T) {
+		tx, err := tm.Begin()
+		test.AssertNoError(t, err)
+
+		_ = tx.Savepoint("sp")
+		first := newMockVersion("key", []byte("first"), tx.ID)
+		tx.Track(first)
+		test.AssertNoError(t, tx.RollbackTo("sp"))
+
+		second := newMockVersion("key", []byte("second"), tx.ID)
+		tx.Track(second)
+		test.AssertNoError(t, tx.RollbackTo("sp"))
+
+		test.AssertFalse(t, tx.CanSee(first.XMin(), first.XMax()))
+		test.AssertFalse(t, tx.CanSee(second.XMin(), second.XMax()))
+
+		tx.Abort()
+	})
+
+	t.Run("it forgets nested savepoints after rollback", func(t *testing.T) {
+		tx, err := tm.Begin()
+		test.AssertNoError(t, err)
+
+		_ = tx.Savepoint("outer")
+		_ = tx.Savepoint("inner")
+
+		test.AssertNoError(t, tx.RollbackTo("outer"))
+		test.AssertError(t, tx.RollbackTo("inner"), SavepointNotFoundError)
+
+		tx.Abort()
+	})
+
+	t.Run("it forgets released savepoint and newer ones", func(t *testing.T) {
+		tx, err := tm.Begin()
+		test.AssertNoError(t, err)
+
+		_ = tx.Savepoint("outer")
+		_ = tx.Savepoint("inner")
+
+		test.AssertNoError(t, tx.Release("outer"))
+		test.AssertError(t, tx.RollbackTo("outer"), SavepointNotFoundError)
+		test.AssertError(t, tx.RollbackTo("inner"), SavepointNotFoundError)
+
+		tx.Abort()
+	})
+
+	t.Run("it keeps changes of released savepoint", func(t *testing.T) {
+		tx, err := tm.Begin()
+		test.AssertNoError(t, err)
+
+		_ = tx.Savepoint("sp")
+		rec := newMockVersion("key", []byte("value"), tx.ID)
+		tx.Track(rec)
+		_ = tx.Release("sp")
+
+		test.AssertTrue(t, tx.CanSee(rec.XMin(), rec.XMax()))
+
+		tx.Abort()
+	})
+
+	t.Run("it returns error if savepoint does not exist", func(t *testing.T) {
+		tx, err := tm.Begin()
+		test.AssertNoError(t, err)
+
+		test.AssertError(t, tx.RollbackTo("missing"), SavepointNotFoundError)
+		test.AssertError(t, tx.Release("missing"), SavepointNotFoundError)
+
+		tx.Abort()
+	})
+
+	t.Run("it returns error if transaction is not active", func(t *testing.T) {
+		tx, err := tm.Begin()
+		test.AssertNoError(t, err)
+		_ = tx.Commit()
+
+		test.AssertError(t, tx.Savepoint("sp"), TransactionNotActiveError)
+	})
 }
 
 func TestTransaction_CanSee(t *testing.T) {
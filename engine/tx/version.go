@@ -7,3 +7,13 @@ type version interface {
 	Resurrect()
 	TryKill(x ID) (ok bool)
 }
+
+type write struct {
+	version version
+	killed  bool
+}
+
+type savepoint struct {
+	name   string
+	writes int
+}
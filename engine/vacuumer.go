@@ -24,10 +24,16 @@ func NewVacuumer(versionMap *mvcc.VersionMap, walAppender wal.Appender) *Vacuume
 	}
 }
 
-func (v *Vacuumer) RunOnInterval(txManager *tx.Manager, interval time.Duration, ctx context.Context) {
+// RunOnInterval vacuums in the background until ctx is done. The returned
+// channel is closed once it stopped, a vacuum in progress is finished first.
+func (v *Vacuumer) RunOnInterval(txManager *tx.Manager, interval time.Duration, ctx context.Context) <-chan struct{} {
 	ticker := time.NewTicker(interval)
+	stopped := make(chan struct{})
 
 	go func() {
+		defer close(stopped)
+		defer ticker.Stop()
+
 		for {
 			v.RunOnce(txManager)
 
@@ -39,6 +45,8 @@ func (v *Vacuumer) RunOnInterval(txManager *tx.Manager, interval time.Duration,
 			}
 		}
 	}()
+
+	return stopped
 }
 
 func (v *Vacuumer) RunOnce(tm *tx.Manager) {
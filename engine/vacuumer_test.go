@@ -272,5 +272,5 @@ func setup() (*mvcc.Store, *Vacuumer, *mvcc.VersionMap, *mocks.MockAppender) {
 	versionMap := mvcc.NewVersionMap()
 	mockWriteAheadLog := mocks.NewAppender()
 	vacuumer := NewVacuumer(versionMap, mockWriteAheadLog)
-	return mvcc.NewStore(versionMap), vacuumer, versionMap, mockWriteAheadLog
+	return mvcc.NewStore(versionMap, mvcc.StoreOptions{}), vacuumer, versionMap, mockWriteAheadLog
 }
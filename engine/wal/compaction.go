@@ -0,0 +1,144 @@
+package wal
+
+import (
+	"bufio"
+	"io"
+	"kv/engine/wal/record"
+)
+
+type Rewriter interface {
+	Rewrite(rewrite func(src io.ReadSeeker, dst io.Writer) error) error
+}
+
+// Compact rewrites the log without records of aborted transactions. The log
+// is locked for the duration, so it is meant to run while the store is idle,
+// e.g. right after recovery.
+func (w *WriteAheadLog) Compact() error {
+	return w.rewrite(compactAborted)
+}
+
+// Reencrypt rewrites the whole log with the active encryption key, or in
+// plain form if encryption is disabled. Keys the log was written with before
+// have to be loaded still, they can be dropped once it is done.
+func (w *WriteAheadLog) Reencrypt() error {
+	return w.rewrite(reencode)
+}
+
+func (w *WriteAheadLog) rewrite(rewrite func(src io.ReadSeeker, dst io.Writer, options Options) error) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.closed {
+		return WriteAheadLogClosedError
+	}
+
+	rewriter, ok := w.file.(Rewriter)
+	if !ok {
+		return CompactionNotSupportedError
+	}
+
+	if err := w.commit(); err != nil {
+		return err
+	}
+
+	err := rewriter.Rewrite(func(src io.ReadSeeker, dst io.Writer) error {
+		return rewrite(src, dst, w.options)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if err = w.commit(); err != nil {
+		return err
+	}
+
+	w.encoder.SetPosition(uint64(w.committedEnd))
+	return nil
+}
+
+// compactAborted drops the records of aborted transactions. An abort logged
+// after the commit of its transaction, because the commit failed to be
+// reported written, is ignored as recovery does: the commit stands.
+func compactAborted(src io.ReadSeeker, dst io.Writer, options Options) error {
+	committed := make(map[uint64]struct{})
+	aborted := make(map[uint64]struct{})
+
+	err := decodeAll(src, options, func(r *record.Record) error {
+		switch r.Kind {
+		case record.Commit:
+			committed[r.TxID] = struct{}{}
+		case record.Abort:
+			if _, ok := committed[r.TxID]; !ok {
+				aborted[r.TxID] = struct{}{}
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if _, err = src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(dst)
+	encoder := record.NewEncoder(writer, options.encoderOptions(positionOf(dst)))
+
+	err = decodeAll(src, options, func(r *record.Record) error {
+		if _, ok := aborted[r.TxID]; ok {
+			return nil
+		}
+
+		return encoder.Encode(r)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return writer.Flush()
+}
+
+func reencode(src io.ReadSeeker, dst io.Writer, options Options) error {
+	writer := bufio.NewWriter(dst)
+	encoder := record.NewEncoder(writer, options.encoderOptions(positionOf(dst)))
+
+	if err := decodeAll(src, options, encoder.Encode); err != nil {
+		return err
+	}
+
+	return writer.Flush()
+}
+
+func decodeAll(src io.Reader, options Options, fn func(r *record.Record) error) error {
+	decoder := record.NewDecoder(src, options.decoderOptions(positionOf(src)))
+	var r record.Record
+
+	for {
+		if err := decoder.Decode(&r); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+
+			return err
+		}
+
+		if err := fn(&r); err != nil {
+			return err
+		}
+	}
+}
+
+// positionOf returns where in the log a reader or writer of the log is, zero
+// for a plain file.
+func positionOf(rw any) LSN {
+	if positioned, ok := rw.(interface{ LSN() LSN }); ok {
+		return positioned.LSN()
+	}
+
+	return 0
+}
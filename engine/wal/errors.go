@@ -3,3 +3,14 @@ package wal
 import "errors"
 
 var WriteAheadLogClosedError = errors.New("wal: closed")
+var CompactionNotSupportedError = errors.New("wal: compaction not supported")
+var SegmentNotFoundError = errors.New("wal: segment not found")
+var SegmentGapError = errors.New("wal: segment ends before its capacity")
+var LSNOutOfRangeError = errors.New("wal: lsn out of range")
+var SegmentHeaderCorruptedError = errors.New("wal: segment header corrupted")
+var SegmentMagicMismatchError = errors.New("wal: not a segment file")
+var SegmentVersionError = errors.New("wal: unsupported segment format")
+var ForeignSegmentError = errors.New("wal: segment belongs to another store")
+var SegmentHeaderMismatchError = errors.New("wal: segment header does not match its place in the log")
+var NotALogError = errors.New("wal: not backed by a log")
+var UnversionedLogError = errors.New("wal: log predates segment headers and needs an upgrade")
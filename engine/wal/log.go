@@ -2,227 +2,483 @@ package wal
 
 import (
 	"fmt"
-	"io"
+	"kv/encryption"
 	"kv/storage"
-	"path/filepath"
+	"slices"
+	"sync"
 )
 
-const initialSegmentsBufferSize = 16
-
 type LogOptions struct {
+	FS            storage.FS
 	LogsDirectory string
 	SegmentSize   int64
+
+	// RecycledSegments is how many segments dropped from the start of the log
+	// are kept around to be reused instead of allocating new files.
+	RecycledSegments int
+
+	// Keyring is the set of encryption keys in use. New segments record its
+	// active key, existing ones are only accepted if their key is present.
+	Keyring *encryption.Keyring
+
+	// ReadOnly opens the log for reading alongside the process that writes
+	// it. The log is taken as it is, an interrupted rewrite is left for the
+	// writer to finish. FS should refuse changes then, see storage.ReadOnlyFS.
+	ReadOnly bool
 }
 
+// Log is a byte stream split across fixed-size segment files. Every segment
+// but the last one is full, so a position in the stream maps directly onto
+// a segment and an offset within it, see LSN.
+//
+// Log is written by a single appender. Its own Read and Seek serve that same
+// owner, while other readers should use NewReader or Segments, which can run
+// concurrently with appends.
 type Log struct {
-	options             LogOptions
-	manifest            *Manifest
-	segments            []*Segment
-	activeSegmentOffset uint64
+	options  LogOptions
+	manifest *Manifest
+	storeID  StoreID
+
+	mutex    sync.RWMutex
+	segments []*Segment
+
+	reader *LogReader
+
+	// recycled holds paths of segment files waiting to be reused.
+	recycled []string
 }
 
 func NewLog(manifest *Manifest, options LogOptions) (*Log, error) {
 	l := &Log{
-		options:             options,
-		segments:            make([]*Segment, initialSegmentsBufferSize),
-		manifest:            manifest,
-		activeSegmentOffset: 0,
+		options:  options,
+		manifest: manifest,
+	}
+
+	if err := options.FS.MkdirAll(options.LogsDirectory); err != nil {
+		return nil, err
 	}
 
-	if err := storage.EnsureDirectoryExists(options.LogsDirectory); err != nil {
+	var err error
+
+	if err = l.loadStoreID(); err != nil {
 		return nil, err
 	}
 
-	err := l.loadMostRecentSegment()
-	return l, err
+	if err = l.loadRecycled(); err != nil {
+		return nil, err
+	}
+
+	if !options.ReadOnly {
+		if err = l.completeRewrite(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = l.loadSegments(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
 }
 
 func (l *Log) Write(p []byte) (n int, err error) {
-	var space int64
-	var written int
-
 	for len(p) > 0 {
-		if space, err = l.activeSegment().Space(); err != nil {
+		active := l.activeSegment()
+
+		full, err := active.IsFull()
+		if err != nil {
 			return n, err
 		}
 
-		if space <= 0 {
-			if err = l.loadSegment(l.activeSegmentOffset + 1); err != nil {
+		if full {
+			if err = l.rotate(); err != nil {
 				return n, err
 			}
 
-			if space, err = l.activeSegment().Space(); err != nil {
-				return n, err
-			}
+			continue
 		}
 
-		toWrite := len(p)
-		if int64(toWrite) > space {
-			toWrite = int(space)
-		}
+		written, err := active.Write(p)
+		n += written
+		p = p[written:]
 
-		if written, err = l.activeSegment().Write(p[:toWrite]); err != nil {
+		if err != nil {
 			return n, err
 		}
-
-		n += written
-		p = p[written:]
 	}
 
 	return n, nil
 }
 
 func (l *Log) Read(p []byte) (n int, err error) {
-	for len(p) > 0 {
-		read, readErr := l.activeSegment().Read(p)
-		n += read
-		p = p[read:]
+	reader, err := l.ownReader()
+	if err != nil {
+		return 0, err
+	}
 
-		if readErr == io.EOF {
-			if !l.hasNextSegment() {
-				return n, io.EOF
-			}
+	return reader.Read(p)
+}
 
-			if err = l.loadSegment(l.activeSegmentOffset + 1); err != nil {
-				return n, err
-			}
+// Seek moves the position of the log's own reader. Offsets are relative to
+// the start of the log, i.e. the first byte of its first segment.
+func (l *Log) Seek(offset int64, whence int) (int64, error) {
+	reader, err := l.ownReader()
+	if err != nil {
+		return 0, err
+	}
 
-			continue
-		}
+	return reader.Seek(offset, whence)
+}
+
+func (l *Log) Close() error {
+	var err error
+
+	if l.reader != nil {
+		err = l.reader.Close()
+		l.reader = nil
+	}
+
+	if closeErr := l.activeSegment().Close(); closeErr != nil {
+		return closeErr
+	}
+
+	return err
+}
+
+func (l *Log) Sync() error {
+	return l.activeSegment().Sync()
+}
+
+// Truncate drops the end of the log from the given LSN on, e.g. a record torn
+// by a crash. Segments past it are removed before the one holding it is cut
+// short, so that stopping in between leaves no gap in the log.
+func (l *Log) Truncate(end LSN) error {
+	start, current, err := l.bounds()
+	if err != nil {
+		return err
+	}
+
+	if end < start || end > current {
+		return fmt.Errorf("%w: %d not in [%d, %d]", LSNOutOfRangeError, end, start, current)
+	}
+
+	if end == current {
+		return nil
+	}
 
-		if readErr != nil {
-			return n, readErr
+	seq, offset := l.position(end)
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	keep := int(seq-l.segments[0].Seq()) + 1
+
+	for i := len(l.segments) - 1; i >= keep; i-- {
+		segment := l.segments[i]
+
+		if err = segment.Close(); err != nil {
+			return err
 		}
 
-		if read == 0 {
-			break
+		if err = l.options.FS.Remove(segment.Path()); err != nil {
+			return err
 		}
+
+		l.segments = l.segments[:i]
 	}
-	return n, nil
+
+	if err = l.options.FS.SyncDir(l.options.LogsDirectory); err != nil {
+		return err
+	}
+
+	return l.segments[keep-1].Truncate(offset)
 }
 
-func (l *Log) Seek(offset int64, whence int) (int64, error) {
-	if whence == io.SeekStart && offset == 0 {
-		if err := l.loadSegment(0); err != nil {
-			return 0, err
-		}
-		return 0, nil
+// NewReader returns a reader positioned at the given LSN. The reader is
+// independent of the appender and follows the log as it grows.
+func (l *Log) NewReader(from LSN) (*LogReader, error) {
+	start, end, err := l.bounds()
+	if err != nil {
+		return nil, err
 	}
 
-	return l.activeSegment().Seek(offset, whence)
+	if from < start || from > end {
+		return nil, fmt.Errorf("%w: %d not in [%d, %d]", LSNOutOfRangeError, from, start, end)
+	}
+
+	return newLogReader(l, from), nil
 }
 
-func (l *Log) Close() error {
-	if l.activeSegment() != nil {
-		err := l.activeSegment().Close()
+// NewSegmentReader returns a reader positioned at an offset of the given
+// segment.
+func (l *Log) NewSegmentReader(seq uint64, offset int64) (*LogReader, error) {
+	if offset < 0 || offset > l.options.SegmentSize {
+		return nil, fmt.Errorf("%w: offset %d in segment %d", LSNOutOfRangeError, offset, seq)
+	}
+
+	return l.NewReader(l.lsn(seq, offset))
+}
 
+// StartLSN returns the position of the first byte still kept in the log.
+func (l *Log) StartLSN() LSN {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	return l.lsn(l.segments[0].Seq(), 0)
+}
+
+// EndLSN returns the position right after the last byte written to the log.
+func (l *Log) EndLSN() (LSN, error) {
+	_, end, err := l.bounds()
+	return end, err
+}
+
+// LogStats describe the segments of a log.
+type LogStats struct {
+	Segments int
+
+	// Bytes is the data held by the segments, without their headers.
+	Bytes int64
+
+	CurrentSegment uint64
+	StartLSN       LSN
+	EndLSN         LSN
+
+	// LogStart is the first segment as recorded by the manifest.
+	LogStart uint64
+}
+
+func (l *Log) Stats() (LogStats, error) {
+	l.mutex.RLock()
+	segments := slices.Clone(l.segments)
+	l.mutex.RUnlock()
+
+	stats := LogStats{
+		Segments:       len(segments),
+		CurrentSegment: segments[len(segments)-1].Seq(),
+		StartLSN:       l.lsn(segments[0].Seq(), 0),
+	}
+
+	for _, segment := range segments {
+		size, err := segment.Size()
 		if err != nil {
-			return err
+			return LogStats{}, err
 		}
+
+		stats.Bytes += size
+		stats.EndLSN = l.lsn(segment.Seq(), size)
 	}
 
-	return nil
+	logStart, err := l.manifest.GetLogStart()
+	if err != nil {
+		return LogStats{}, err
+	}
+
+	stats.LogStart = logStart
+	return stats, nil
 }
 
-func (l *Log) Sync() error {
-	return l.activeSegment().Sync()
+func (l *Log) bounds() (start, end LSN, err error) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	first := l.segments[0]
+	last := l.segments[len(l.segments)-1]
+
+	size, err := last.Size()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return l.lsn(first.Seq(), 0), l.lsn(last.Seq(), size), nil
 }
 
-func (l *Log) grow() {
-	newCapacity := cap(l.segments) * 2
-	newSegments := make([]*Segment, newCapacity)
+func (l *Log) lsn(seq uint64, offset int64) LSN {
+	return LSN(seq*uint64(l.options.SegmentSize) + uint64(offset))
+}
 
-	copy(newSegments, l.segments)
-	l.segments = newSegments
+func (l *Log) position(lsn LSN) (seq uint64, offset int64) {
+	segmentSize := uint64(l.options.SegmentSize)
+	return uint64(lsn) / segmentSize, int64(uint64(lsn) % segmentSize)
+}
+
+// segment returns the segment with the given sequence number and whether it
+// is the last one in the log.
+func (l *Log) segment(seq uint64) (segment *Segment, last bool, err error) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	first := l.segments[0].Seq()
+
+	if seq < first || seq-first >= uint64(len(l.segments)) {
+		return nil, false, fmt.Errorf("%w: segment %d", SegmentNotFoundError, seq)
+	}
+
+	i := seq - first
+	return l.segments[i], i == uint64(len(l.segments)-1), nil
 }
 
 func (l *Log) activeSegment() *Segment {
-	return l.segments[l.activeSegmentOffset]
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	return l.segments[len(l.segments)-1]
 }
 
-func (l *Log) hasNextSegment() bool {
-	nextOffset := l.activeSegmentOffset + 1
+func (l *Log) rotate() error {
+	active := l.activeSegment()
 
-	if uint64(len(l.segments)) <= nextOffset {
-		return false
+	if err := active.Close(); err != nil {
+		return err
+	}
+
+	next := l.newSegment(active.Seq() + 1)
+
+	if err := l.reuseSegment(next); err != nil {
+		return err
 	}
 
-	if l.segments[nextOffset] == nil {
-		return false
+	// Allocating the file now keeps it off the path of the next write.
+	if err := next.Create(); err != nil {
+		return err
 	}
 
-	return true
+	l.mutex.Lock()
+	l.segments = append(l.segments, next)
+	l.mutex.Unlock()
+
+	return nil
 }
 
-func (l *Log) loadMostRecentSegment() error {
-	nextOffset := l.activeSegmentOffset
+func (l *Log) ownReader() (*LogReader, error) {
+	if l.reader != nil {
+		return l.reader, nil
+	}
 
-	for {
-		if err := l.loadSegment(nextOffset); err != nil {
-			return err
-		}
+	reader, err := l.NewReader(l.StartLSN())
+	if err != nil {
+		return nil, err
+	}
 
-		space, err := l.activeSegment().Space()
+	l.reader = reader
+	return reader, nil
+}
 
-		if err != nil {
-			return err
+// loadSegments picks up every segment from the log start onwards. Segments
+// have to be contiguous, a missing one means lost data, and each has to carry
+// the header expected at its place in the log.
+func (l *Log) loadSegments() error {
+	logStart, err := l.manifest.GetLogStart()
+	if err != nil {
+		return err
+	}
+
+	sequences, err := l.listSegments(segmentSuffix)
+	if err != nil {
+		return err
+	}
+
+	segments := make([]*Segment, 0, len(sequences)+1)
+	expected := logStart
+
+	for _, seq := range sequences {
+		if seq < logStart {
+			continue
 		}
 
-		if space > 0 {
-			return nil
+		if seq != expected {
+			return fmt.Errorf("%w: segment %d", SegmentNotFoundError, expected)
 		}
 
-		nextOffset++
+		segments = append(segments, l.newSegment(seq))
+		expected++
 	}
-}
 
-func (l *Log) loadSegment(offset uint64) error {
-	if l.activeSegment() != nil {
-		err := l.activeSegment().Close()
+	if len(segments) == 0 {
+		segments = append(segments, l.newSegment(logStart))
+	}
 
+	// Reading the sizes validates every segment header up front, rather
+	// than when a reader first reaches the segment.
+	for _, segment := range segments {
+		keyID, err := segment.KeyID()
 		if err != nil {
 			return err
 		}
-	}
-
-	l.activeSegmentOffset = offset
 
-	for uint64(cap(l.segments)) <= offset {
-		l.grow()
+		if keyID != 0 && !l.options.Keyring.HasKey(keyID) {
+			return fmt.Errorf("%w: segment %d needs key %d", encryption.KeyNotFoundError, segment.Seq(), keyID)
+		}
 	}
 
-	if l.segments[offset] != nil {
-		return nil
+	for _, segment := range segments[:len(segments)-1] {
+		full, err := segment.IsFull()
+		if err != nil {
+			return err
+		}
+
+		if !full {
+			return fmt.Errorf("%w: segment %d", SegmentGapError, segment.Seq())
+		}
 	}
 
-	path, err := l.getSegmentPath(offset)
+	if l.reader != nil {
+		if err = l.reader.Close(); err != nil {
+			return err
+		}
 
-	if err != nil {
-		return err
+		l.reader = nil
 	}
 
-	l.segments[offset] = NewSegment(path, l.options.SegmentSize)
+	l.mutex.Lock()
+	l.segments = segments
+	l.mutex.Unlock()
 
 	return nil
 }
 
-func (l *Log) getSegmentPath(offset uint64) (string, error) {
-	seqNumber, err := l.findSegmentSequenceNumber(offset)
-
+// loadStoreID picks up the store ID from the manifest. A log without one yet
+// is new and gets one, unless it already has segments, which then predate
+// segment headers and have to go through UpgradeLog first.
+func (l *Log) loadStoreID() error {
+	hasStoreID, err := l.manifest.HasStoreID()
 	if err != nil {
-		return "", err
+		return err
+	}
+
+	if !hasStoreID {
+		sequences, err := l.listSegments(segmentSuffix)
+		if err != nil {
+			return err
+		}
+
+		if len(sequences) > 0 {
+			return UnversionedLogError
+		}
 	}
 
-	filename := fmt.Sprintf("wal-%09d.log", seqNumber)
-	return filepath.Join(l.options.LogsDirectory, filename), nil
+	l.storeID, err = l.manifest.GetStoreID()
+	return err
 }
 
-func (l *Log) findSegmentSequenceNumber(offset uint64) (uint64, error) {
-	lsnStart, err := l.manifest.GetLogStart()
+// StoreID returns the ID of the store the log belongs to.
+func (l *Log) StoreID() StoreID {
+	return l.storeID
+}
 
-	if err != nil {
-		return 0, err
-	}
+func (l *Log) newSegment(seq uint64) *Segment {
+	return l.newSegmentAt(l.segmentPath(seq, segmentSuffix), seq)
+}
 
-	return lsnStart + offset, nil
+// newSegmentAt returns the segment with the given sequence number, kept at a
+// path other than its usual one, e.g. while it is staged.
+func (l *Log) newSegmentAt(path string, seq uint64) *Segment {
+	return NewSegment(path, SegmentOptions{
+		FS:       l.options.FS,
+		StoreID:  l.storeID,
+		Seq:      seq,
+		StartLSN: l.lsn(seq, 0),
+		Capacity: l.options.SegmentSize,
+		KeyID:    l.options.Keyring.ActiveKeyID(),
+	})
 }
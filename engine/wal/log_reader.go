@@ -0,0 +1,205 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"kv/storage"
+)
+
+// LogReader reads the log sequentially, crossing segment boundaries. It keeps
+// its own file handles, so any number of readers can run next to the
+// appender. Reaching the end of the log yields io.EOF, but more data can be
+// read once it is appended.
+type LogReader struct {
+	log *Log
+	lsn LSN
+
+	// limit only applies once limited is set, a limit of 0 stops the reader
+	// right away.
+	limit   LSN
+	limited bool
+
+	file    storage.FileHandle
+	fileSeq uint64
+}
+
+func newLogReader(log *Log, from LSN) *LogReader {
+	return &LogReader{
+		log: log,
+		lsn: from,
+	}
+}
+
+// LimitTo makes the reader stop at the given LSN, as if the log ended there.
+func (r *LogReader) LimitTo(end LSN) {
+	r.limit = end
+	r.limited = true
+}
+
+func (r *LogReader) LSN() LSN {
+	return r.lsn
+}
+
+func (r *LogReader) Read(p []byte) (n int, err error) {
+	for n < len(p) {
+		if r.limited && r.lsn >= r.limit {
+			break
+		}
+
+		read, err := r.readSegment(p[n:])
+		n += read
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return n, err
+		}
+	}
+
+	if n == 0 && len(p) > 0 {
+		return 0, io.EOF
+	}
+
+	return n, nil
+}
+
+// readSegment reads from the segment holding the current position. Reaching
+// the end of a segment that is not full, while later segments exist, means
+// the log is broken.
+func (r *LogReader) readSegment(p []byte) (int, error) {
+	_, end, err := r.log.bounds()
+	if err != nil {
+		return 0, err
+	}
+
+	if r.lsn >= end {
+		return 0, io.EOF
+	}
+
+	seq, offset := r.log.position(r.lsn)
+
+	segment, _, err := r.log.segment(seq)
+	if err != nil {
+		return 0, err
+	}
+
+	size, err := segment.Size()
+	if err != nil {
+		return 0, err
+	}
+
+	if offset >= size {
+		if size < segment.Capacity() {
+			return 0, fmt.Errorf("%w: segment %d", SegmentGapError, seq)
+		}
+
+		r.lsn = r.log.lsn(seq+1, 0)
+		return 0, nil
+	}
+
+	available := size - offset
+	if r.limited {
+		available = min(available, int64(r.limit-r.lsn))
+	}
+
+	if int64(len(p)) > available {
+		p = p[:available]
+	}
+
+	file, err := r.open(segment)
+	if err != nil {
+		return 0, err
+	}
+
+	read, err := file.ReadAt(p, segment.DataOffset()+offset)
+	r.lsn += LSN(read)
+
+	if errors.Is(err, io.EOF) && read == len(p) {
+		err = nil
+	}
+
+	return read, err
+}
+
+// Seek moves the reader. Offsets are relative to the start of the log.
+func (r *LogReader) Seek(offset int64, whence int) (int64, error) {
+	start, end, err := r.log.bounds()
+	if err != nil {
+		return 0, err
+	}
+
+	var target int64
+
+	switch whence {
+	case io.SeekStart:
+		target = offset
+	case io.SeekCurrent:
+		target = int64(r.lsn-start) + offset
+	case io.SeekEnd:
+		target = int64(end-start) + offset
+	default:
+		return 0, fmt.Errorf("%w: invalid whence %d", LSNOutOfRangeError, whence)
+	}
+
+	if target < 0 {
+		return 0, fmt.Errorf("%w: negative position %d", LSNOutOfRangeError, target)
+	}
+
+	r.lsn = start + LSN(target)
+	return target, nil
+}
+
+// ReadProgress reports how many segments the reader has moved past.
+func (r *LogReader) ReadProgress() ReplayProgress {
+	start, end, err := r.log.bounds()
+	if err != nil {
+		return ReplayProgress{}
+	}
+
+	if r.limited {
+		end = min(end, r.limit)
+	}
+
+	first, _ := r.log.position(start)
+	current, _ := r.log.position(min(r.lsn, end))
+	last, _ := r.log.position(end)
+
+	return ReplayProgress{
+		SegmentsDone:  current - first,
+		SegmentsTotal: last - first + 1,
+	}
+}
+
+func (r *LogReader) Close() error {
+	if r.file == nil {
+		return nil
+	}
+
+	err := r.file.Close()
+	r.file = nil
+
+	return err
+}
+
+func (r *LogReader) open(segment *Segment) (storage.FileHandle, error) {
+	if r.file != nil && r.fileSeq == segment.Seq() {
+		return r.file, nil
+	}
+
+	if err := r.Close(); err != nil {
+		return nil, err
+	}
+
+	file, err := segment.OpenReader()
+	if err != nil {
+		return nil, err
+	}
+
+	r.file = file
+	r.fileSeq = segment.Seq()
+
+	return file, nil
+}
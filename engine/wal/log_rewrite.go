@@ -0,0 +1,238 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	segmentPrefix        = "wal-"
+	segmentSuffix        = ".log"
+	rewriteSegmentSuffix = ".log.compact"
+	recycleSegmentSuffix = ".log.recycle"
+)
+
+// Rewrite streams the whole log through rewrite into segments that follow the
+// current last one. Rewritten segments are staged under a temporary suffix and
+// become the log once the manifest points at the first of them, which makes
+// the manifest update the commit point of the rewrite.
+//
+// The log must not be appended to while it is being rewritten.
+func (l *Log) Rewrite(rewrite func(src io.ReadSeeker, dst io.Writer) error) error {
+	lastSeq := l.activeSegment().Seq()
+
+	src, err := l.NewReader(l.StartLSN())
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = src.Close()
+	}()
+
+	dst := &rewriteWriter{
+		log:     l,
+		nextSeq: lastSeq + 1,
+		lsn:     l.lsn(lastSeq+1, 0),
+	}
+
+	if err = rewrite(src, dst); err != nil {
+		return errors.Join(err, dst.Close(), dst.remove())
+	}
+
+	if err = dst.Close(); err != nil {
+		return errors.Join(err, dst.remove())
+	}
+
+	if err = l.manifest.UpdateLogStart(lastSeq + 1); err != nil {
+		return errors.Join(err, dst.remove())
+	}
+
+	// The segments replaced by the rewrite are only let go of once it is
+	// committed, the log stays usable as it was until then. Past that point
+	// the rewritten segments are taken on even if closing the old ones fails.
+	closeErr := errors.Join(l.activeSegment().Close(), src.Close())
+
+	if err = l.completeRewrite(); err != nil {
+		return errors.Join(closeErr, err)
+	}
+
+	return errors.Join(closeErr, l.loadSegments())
+}
+
+// completeRewrite finishes or discards a rewrite interrupted by a crash.
+// Staged segments starting exactly at the log start were committed by the
+// manifest and are renamed into place, any other staged segments are left
+// over from a rewrite that never committed. Segments preceding the log start
+// are recycled or removed in both cases.
+func (l *Log) completeRewrite() error {
+	logStart, err := l.manifest.GetLogStart()
+	if err != nil {
+		return err
+	}
+
+	staged, err := l.listSegments(rewriteSegmentSuffix)
+	if err != nil {
+		return err
+	}
+
+	committed := len(staged) > 0 && staged[0] == logStart
+
+	// Renaming the first staged segment last keeps the commit detectable
+	// until every other segment is in place.
+	for i := len(staged) - 1; i >= 0; i-- {
+		stagedPath := l.segmentPath(staged[i], rewriteSegmentSuffix)
+
+		if committed {
+			err = l.options.FS.Rename(stagedPath, l.segmentPath(staged[i], segmentSuffix))
+		} else {
+			err = l.options.FS.Remove(stagedPath)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	live, err := l.listSegments(segmentSuffix)
+	if err != nil {
+		return err
+	}
+
+	for _, seq := range live {
+		if seq >= logStart {
+			break
+		}
+
+		if err = l.recycleSegment(seq); err != nil {
+			return err
+		}
+	}
+
+	return l.options.FS.SyncDir(l.options.LogsDirectory)
+}
+
+func (l *Log) listSegments(suffix string) ([]uint64, error) {
+	names, err := l.options.FS.List(l.options.LogsDirectory)
+	if err != nil {
+		return nil, err
+	}
+
+	var sequences []uint64
+
+	for _, name := range names {
+
+		if !strings.HasPrefix(name, segmentPrefix) || !strings.HasSuffix(name, suffix) {
+			continue
+		}
+
+		seq, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, segmentPrefix), suffix), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		sequences = append(sequences, seq)
+	}
+
+	sort.Slice(sequences, func(i, j int) bool {
+		return sequences[i] < sequences[j]
+	})
+
+	return sequences, nil
+}
+
+func (l *Log) segmentPath(seq uint64, suffix string) string {
+	return segmentPathIn(l.options.LogsDirectory, seq, suffix)
+}
+
+func segmentPathIn(directory string, seq uint64, suffix string) string {
+	filename := fmt.Sprintf("%s%09d%s", segmentPrefix, seq, suffix)
+	return filepath.Join(directory, filename)
+}
+
+type rewriteWriter struct {
+	log     *Log
+	nextSeq uint64
+	lsn     LSN
+
+	segment *Segment
+	written []string
+}
+
+func (w *rewriteWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		if w.segment == nil {
+			w.rotate()
+		}
+
+		space, err := w.segment.Space()
+		if err != nil {
+			return n, err
+		}
+
+		if space <= 0 {
+			if err = w.segment.Close(); err != nil {
+				return n, err
+			}
+
+			w.rotate()
+			continue
+		}
+
+		written, err := w.segment.Write(p)
+		n += written
+		p = p[written:]
+		w.lsn += LSN(written)
+
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// LSN is where the next write lands in the rewritten log.
+func (w *rewriteWriter) LSN() LSN {
+	return w.lsn
+}
+
+// Close syncs the last staged segment. A rewrite always produces at least one
+// segment, even if empty, so that the commit stays detectable.
+func (w *rewriteWriter) Close() error {
+	if w.segment == nil {
+		w.rotate()
+	}
+
+	if err := w.segment.Create(); err != nil {
+		return err
+	}
+
+	return w.segment.Close()
+}
+
+func (w *rewriteWriter) rotate() {
+	path := w.log.segmentPath(w.nextSeq, rewriteSegmentSuffix)
+
+	w.segment = w.log.newSegmentAt(path, w.nextSeq)
+	w.written = append(w.written, path)
+	w.nextSeq++
+}
+
+func (w *rewriteWriter) remove() error {
+	var err error
+
+	for _, path := range w.written {
+		if removeErr := w.log.options.FS.Remove(path); removeErr != nil && !os.IsNotExist(removeErr) {
+			err = errors.Join(err, removeErr)
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,815 @@
+package wal
+
+import (
+	"errors"
+	"io"
+	"kv/storage"
+	"kv/storage/mocks"
+	"kv/test"
+	"os"
+	"sync"
+	"testing"
+)
+
+const (
+	testSegmentSize   = 16
+	testLogsDirectory = "logs"
+)
+
+func setupTestLog(t *testing.T, fs storage.FS, manifest *Manifest) *Log {
+	t.Helper()
+
+	log, err := NewLog(manifest, LogOptions{
+		FS:            fs,
+		LogsDirectory: testLogsDirectory,
+		SegmentSize:   testSegmentSize,
+	})
+	test.AssertNoError(t, err)
+
+	return log
+}
+
+func TestLog_ReadSeek(t *testing.T) {
+	content := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+
+	setup := func(t *testing.T) *Log {
+		t.Helper()
+
+		log := setupTestLog(t, storage.NewMemFS(), NewManifest(mocks.NewFile()))
+		_, err := log.Write(content)
+		test.AssertNoError(t, err)
+
+		return log
+	}
+
+	readN := func(t *testing.T, r io.Reader, n int) []byte {
+		t.Helper()
+
+		buf := make([]byte, n)
+		_, err := io.ReadFull(r, buf)
+		test.AssertNoError(t, err)
+
+		return buf
+	}
+
+	t.Run("it reads across segments", func(t *testing.T) {
+		log := setup(t)
+
+		data, err := io.ReadAll(log)
+		test.AssertNoError(t, err)
+		test.AssertBytesEqual(t, data, content)
+	})
+
+	t.Run("it seeks to any position from start", func(t *testing.T) {
+		log := setup(t)
+
+		pos, err := log.Seek(20, io.SeekStart)
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, pos, int64(20))
+		test.AssertBytesEqual(t, readN(t, log, 4), content[20:24])
+	})
+
+	t.Run("it seeks relative to current position", func(t *testing.T) {
+		log := setup(t)
+
+		_, _ = log.Seek(4, io.SeekStart)
+		pos, err := log.Seek(10, io.SeekCurrent)
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, pos, int64(14))
+		test.AssertBytesEqual(t, readN(t, log, 4), content[14:18])
+	})
+
+	t.Run("it seeks relative to end", func(t *testing.T) {
+		log := setup(t)
+
+		pos, err := log.Seek(-6, io.SeekEnd)
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, pos, int64(len(content)-6))
+		test.AssertBytesEqual(t, readN(t, log, 6), content[len(content)-6:])
+	})
+
+	t.Run("it returns EOF past the end", func(t *testing.T) {
+		log := setup(t)
+
+		_, err := log.Seek(100, io.SeekStart)
+		test.AssertNoError(t, err)
+
+		_, err = log.Read(make([]byte, 1))
+		test.AssertError(t, err, io.EOF)
+	})
+
+	t.Run("it returns error on negative position", func(t *testing.T) {
+		log := setup(t)
+
+		_, err := log.Seek(-1, io.SeekStart)
+		test.AssertError(t, err, LSNOutOfRangeError)
+	})
+
+	t.Run("it reads from a reader positioned at an LSN", func(t *testing.T) {
+		log := setup(t)
+
+		reader, err := log.NewReader(log.StartLSN() + 18)
+		test.AssertNoError(t, err)
+
+		test.AssertBytesEqual(t, readN(t, reader, 4), content[18:22])
+		test.AssertEqual(t, reader.LSN(), log.StartLSN()+22)
+	})
+
+	t.Run("it stops a reader at its limit", func(t *testing.T) {
+		log := setup(t)
+		start := log.StartLSN()
+
+		reader, err := log.NewReader(start)
+		test.AssertNoError(t, err)
+
+		reader.LimitTo(start + 4)
+		test.AssertBytesEqual(t, readN(t, reader, 4), content[:4])
+
+		_, err = reader.Read(make([]byte, 1))
+		test.AssertError(t, err, io.EOF)
+	})
+
+	t.Run("it stops a reader limited to LSN 0", func(t *testing.T) {
+		log := setup(t)
+		test.AssertEqual(t, log.StartLSN(), LSN(0))
+
+		reader, err := log.NewReader(0)
+		test.AssertNoError(t, err)
+
+		reader.LimitTo(0)
+		_, err = reader.Read(make([]byte, 1))
+		test.AssertError(t, err, io.EOF)
+		test.AssertEqual(t, reader.ReadProgress().SegmentsTotal, uint64(1))
+	})
+
+	t.Run("it reads from a reader positioned in a segment", func(t *testing.T) {
+		log := setup(t)
+
+		reader, err := log.NewSegmentReader(1, 2)
+		test.AssertNoError(t, err)
+
+		test.AssertBytesEqual(t, readN(t, reader, 4), content[18:22])
+	})
+
+	t.Run("it rejects readers outside of the log", func(t *testing.T) {
+		log := setup(t)
+		end, _ := log.EndLSN()
+
+		_, err := log.NewReader(end + 1)
+		test.AssertError(t, err, LSNOutOfRangeError)
+	})
+
+	t.Run("it does not move own reader when appending", func(t *testing.T) {
+		log := setup(t)
+
+		test.AssertBytesEqual(t, readN(t, log, 4), content[:4])
+		_, _ = log.Write([]byte("more"))
+		test.AssertBytesEqual(t, readN(t, log, 4), content[4:8])
+	})
+
+	t.Run("it lets readers follow appended data", func(t *testing.T) {
+		log := setup(t)
+		end, _ := log.EndLSN()
+
+		reader, err := log.NewReader(end)
+		test.AssertNoError(t, err)
+
+		_, err = reader.Read(make([]byte, 1))
+		test.AssertError(t, err, io.EOF)
+
+		_, _ = log.Write([]byte("more"))
+		test.AssertBytesEqual(t, readN(t, reader, 4), []byte("more"))
+	})
+}
+
+func rewriteSegmentHeader(t *testing.T, fs storage.FS, path string, change func(h *SegmentHeader)) {
+	t.Helper()
+
+	file, err := fs.Open(path, os.O_RDWR)
+	test.AssertNoError(t, err)
+
+	buf := make([]byte, segmentHeaderSize)
+	_, err = file.ReadAt(buf, 0)
+	test.AssertNoError(t, err)
+
+	h, err := decodeSegmentHeader(buf)
+	test.AssertNoError(t, err)
+
+	change(h)
+	encodeSegmentHeader(buf, *h)
+
+	_, err = file.WriteAt(buf, 0)
+	test.AssertNoError(t, err)
+	test.AssertNoError(t, file.Close())
+}
+
+// rewriteSegmentLength records another length for a segment, the way a sync
+// would.
+func rewriteSegmentLength(t *testing.T, fs storage.FS, path string, change func(length int64) int64) {
+	t.Helper()
+
+	file, err := fs.Open(path, os.O_RDWR)
+	test.AssertNoError(t, err)
+
+	buf := make([]byte, segmentDataOffset)
+	_, err = file.ReadAt(buf, 0)
+	test.AssertNoError(t, err)
+
+	size, err := file.Size()
+	test.AssertNoError(t, err)
+
+	length, generation, err := decodeSegmentSlots(buf, size-segmentDataOffset)
+	test.AssertNoError(t, err)
+
+	slot := make([]byte, segmentSlotSize)
+	encodeSegmentSlot(slot, change(length), generation+1)
+
+	_, err = file.WriteAt(slot, segmentSlotOffset(generation+1))
+	test.AssertNoError(t, err)
+	test.AssertNoError(t, file.Close())
+}
+
+func TestLog_Continuity(t *testing.T) {
+	t.Run("it refuses to open log with missing segment", func(t *testing.T) {
+		fs := storage.NewMemFS()
+		manifest := NewManifest(mocks.NewFile())
+		log := setupTestLog(t, fs, manifest)
+		_, _ = log.Write(make([]byte, testSegmentSize*3))
+		_ = log.Close()
+
+		_ = fs.Remove(segmentPathIn(testLogsDirectory, 1, segmentSuffix))
+
+		_, err := NewLog(manifest, LogOptions{FS: fs, LogsDirectory: testLogsDirectory, SegmentSize: testSegmentSize})
+		test.AssertError(t, err, SegmentNotFoundError)
+	})
+
+	t.Run("it refuses to open log with truncated segment", func(t *testing.T) {
+		fs := storage.NewMemFS()
+		manifest := NewManifest(mocks.NewFile())
+		log := setupTestLog(t, fs, manifest)
+		_, _ = log.Write(make([]byte, testSegmentSize*2+1))
+		_ = log.Close()
+
+		rewriteSegmentLength(t, fs, segmentPathIn(testLogsDirectory, 1, segmentSuffix), func(int64) int64 {
+			return testSegmentSize - 1
+		})
+
+		_, err := NewLog(manifest, LogOptions{FS: fs, LogsDirectory: testLogsDirectory, SegmentSize: testSegmentSize})
+		test.AssertError(t, err, SegmentGapError)
+	})
+
+	t.Run("it returns error when reading past a truncated segment", func(t *testing.T) {
+		fs := storage.NewMemFS()
+		log := setupTestLog(t, fs, NewManifest(mocks.NewFile()))
+		_, _ = log.Write(make([]byte, testSegmentSize*2+1))
+
+		log.segments[0].size.Store(testSegmentSize - 1)
+
+		_, err := io.ReadAll(log)
+		test.AssertTrue(t, errors.Is(err, SegmentGapError))
+	})
+}
+
+func TestLog_Truncate(t *testing.T) {
+	t.Run("it drops segments past the new end", func(t *testing.T) {
+		fs := storage.NewMemFS()
+		manifest := NewManifest(mocks.NewFile())
+		log := setupTestLog(t, fs, manifest)
+		_, _ = log.Write([]byte("0123456789abcdefghijklmnopqrstuvwxyz"))
+
+		test.AssertNoError(t, log.Truncate(20))
+		_, _ = log.Write([]byte("!"))
+		_ = log.Close()
+
+		live, err := log.listSegments(segmentSuffix)
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, len(live), 2)
+
+		data, err := io.ReadAll(setupTestLog(t, fs, manifest))
+		test.AssertNoError(t, err)
+		test.AssertBytesEqual(t, data, []byte("0123456789abcdefghij!"))
+	})
+
+	t.Run("it refuses to truncate past the end", func(t *testing.T) {
+		log := setupTestLog(t, storage.NewMemFS(), NewManifest(mocks.NewFile()))
+		_, _ = log.Write([]byte("0123"))
+
+		test.AssertTrue(t, errors.Is(log.Truncate(5), LSNOutOfRangeError))
+	})
+}
+
+func TestLog_Segments(t *testing.T) {
+	t.Run("it iterates over all segments", func(t *testing.T) {
+		log := setupTestLog(t, storage.NewMemFS(), NewManifest(mocks.NewFile()))
+		content := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+		_, _ = log.Write(content)
+
+		var got []byte
+		var sequences []uint64
+
+		it := log.Segments()
+		for it.Next() {
+			sequences = append(sequences, it.Segment().Seq)
+
+			reader, err := it.Reader()
+			test.AssertNoError(t, err)
+
+			data, err := io.ReadAll(reader)
+			test.AssertNoError(t, err)
+			got = append(got, data...)
+		}
+
+		test.AssertNoError(t, it.Err())
+		test.AssertEqual(t, len(sequences), 3)
+		test.AssertBytesEqual(t, got, content)
+	})
+
+	t.Run("it reads concurrently with appends", func(t *testing.T) {
+		log := setupTestLog(t, storage.NewMemFS(), NewManifest(mocks.NewFile()))
+		_, _ = log.Write(make([]byte, testSegmentSize*4))
+
+		var wg sync.WaitGroup
+		wg.Go(func() {
+			for range 100 {
+				_, _ = log.Write([]byte("appended"))
+			}
+		})
+
+		total := int64(0)
+		it := log.Segments()
+		for it.Next() {
+			total += it.Segment().Size
+		}
+
+		wg.Wait()
+
+		test.AssertNoError(t, it.Err())
+		test.AssertTrue(t, total >= testSegmentSize*4)
+	})
+}
+
+func TestLog_Rewrite(t *testing.T) {
+	readAll := func(t *testing.T, log *Log) []byte {
+		t.Helper()
+
+		_, err := log.Seek(0, io.SeekStart)
+		test.AssertNoError(t, err)
+
+		data, err := io.ReadAll(log)
+		test.AssertNoError(t, err)
+
+		return data
+	}
+
+	stage := func(t *testing.T, log *Log, seq uint64, data string) {
+		t.Helper()
+
+		segment := log.newSegmentAt(log.segmentPath(seq, rewriteSegmentSuffix), seq)
+		_, err := segment.Write([]byte(data))
+		test.AssertNoError(t, err)
+		test.AssertNoError(t, segment.Close())
+	}
+
+	t.Run("it discards staged segments of uncommitted rewrite", func(t *testing.T) {
+		fs := storage.NewMemFS()
+		manifest := NewManifest(mocks.NewFile())
+		log := setupTestLog(t, fs, manifest)
+		_, _ = log.Write([]byte("original-content"))
+		_ = log.Close()
+
+		stage(t, log, 1, "rewritten")
+
+		reopened := setupTestLog(t, fs, manifest)
+		test.AssertBytesEqual(t, readAll(t, reopened), []byte("original-content"))
+	})
+
+	t.Run("it completes staged segments of committed rewrite", func(t *testing.T) {
+		fs := storage.NewMemFS()
+		manifest := NewManifest(mocks.NewFile())
+		log := setupTestLog(t, fs, manifest)
+		_, _ = log.Write([]byte("original-content"))
+		_ = log.Close()
+
+		stage(t, log, 1, "rewritten-conten")
+		stage(t, log, 2, "t")
+		_ = manifest.UpdateLogStart(1)
+
+		reopened := setupTestLog(t, fs, manifest)
+		test.AssertBytesEqual(t, readAll(t, reopened), []byte("rewritten-content"))
+
+		exists, err := storage.Exists(fs, segmentPathIn(testLogsDirectory, 0, segmentSuffix))
+		test.AssertNoError(t, err)
+		test.AssertFalse(t, exists)
+	})
+
+	t.Run("it removes staged segments and keeps the log when the commit fails", func(t *testing.T) {
+		fs := storage.NewFaultFS(storage.NewMemFS(), 1)
+		manifestFile, err := fs.Open("manifest", os.O_RDWR|os.O_CREATE)
+		test.AssertNoError(t, err)
+
+		log := setupTestLog(t, fs, NewManifest(manifestFile))
+		_, err = log.Write([]byte("original-contentxy"))
+		test.AssertNoError(t, err)
+
+		fs.FailWith(func(op storage.FaultOp, name string) error {
+			if op == storage.FaultOpWrite && name == "manifest" {
+				return storage.InjectedFaultError
+			}
+
+			return nil
+		})
+
+		err = log.Rewrite(func(src io.ReadSeeker, dst io.Writer) error {
+			_, err := io.Copy(dst, src)
+			return err
+		})
+		test.AssertError(t, err, storage.InjectedFaultError)
+
+		staged, err := log.listSegments(rewriteSegmentSuffix)
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, len(staged), 0)
+
+		fs.FailWith(nil)
+
+		_, err = log.Write([]byte("z"))
+		test.AssertNoError(t, err)
+		test.AssertBytesEqual(t, readAll(t, log), []byte("original-contentxyz"))
+	})
+
+	t.Run("it takes on the rewritten log when closing the old one fails", func(t *testing.T) {
+		fs := storage.NewFaultFS(storage.NewMemFS(), 1)
+		manifestFile, err := fs.Open("manifest", os.O_RDWR|os.O_CREATE)
+		test.AssertNoError(t, err)
+
+		log := setupTestLog(t, fs, NewManifest(manifestFile))
+		_, err = log.Write([]byte("original-contentxy"))
+		test.AssertNoError(t, err)
+
+		active := segmentPathIn(testLogsDirectory, 1, segmentSuffix)
+		fs.FailWith(func(op storage.FaultOp, name string) error {
+			if op == storage.FaultOpWrite && name == active {
+				return storage.InjectedFaultError
+			}
+
+			return nil
+		})
+
+		err = log.Rewrite(func(src io.ReadSeeker, dst io.Writer) error {
+			_, err := io.Copy(dst, src)
+			return err
+		})
+		test.AssertError(t, err, storage.InjectedFaultError)
+
+		fs.FailWith(nil)
+
+		_, err = log.Write([]byte("z"))
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, log.StartLSN(), LSN(2*testSegmentSize))
+		test.AssertBytesEqual(t, readAll(t, log), []byte("original-contentxyz"))
+	})
+}
+
+func TestLog_Preallocation(t *testing.T) {
+	t.Run("it preallocates segments and records written length", func(t *testing.T) {
+		fs := storage.NewMemFS()
+		manifest := NewManifest(mocks.NewFile())
+		log := setupTestLog(t, fs, manifest)
+		_, _ = log.Write([]byte("abc"))
+		test.AssertNoError(t, log.Sync())
+
+		size, err := storage.FileSize(fs, segmentPathIn(testLogsDirectory, 0, segmentSuffix))
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, size, int64(segmentDataOffset+testSegmentSize))
+
+		_ = log.Close()
+
+		reopened := setupTestLog(t, fs, manifest)
+		end, err := reopened.EndLSN()
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, end, LSN(3))
+	})
+
+	t.Run("it ignores data written after the last sync", func(t *testing.T) {
+		fs := storage.NewMemFS()
+		manifest := NewManifest(mocks.NewFile())
+		log := setupTestLog(t, fs, manifest)
+		_, _ = log.Write([]byte("abc"))
+		test.AssertNoError(t, log.Sync())
+		_, _ = log.Write([]byte("def"))
+
+		reopened := setupTestLog(t, fs, manifest)
+		data, err := io.ReadAll(reopened)
+		test.AssertNoError(t, err)
+		test.AssertBytesEqual(t, data, []byte("abc"))
+	})
+}
+
+func TestLog_SegmentHeaders(t *testing.T) {
+	setup := func(t *testing.T) (storage.FS, *Manifest, string) {
+		t.Helper()
+
+		fs := storage.NewMemFS()
+		manifest := NewManifest(mocks.NewFile())
+		log := setupTestLog(t, fs, manifest)
+		_, _ = log.Write(make([]byte, testSegmentSize+1))
+		_ = log.Close()
+
+		return fs, manifest, segmentPathIn(testLogsDirectory, 1, segmentSuffix)
+	}
+
+	reopen := func(fs storage.FS, manifest *Manifest) error {
+		_, err := NewLog(manifest, LogOptions{FS: fs, LogsDirectory: testLogsDirectory, SegmentSize: testSegmentSize})
+		return err
+	}
+
+	t.Run("it writes segment identity into the header", func(t *testing.T) {
+		fs, manifest, path := setup(t)
+		storeID, _ := manifest.GetStoreID()
+
+		rewriteSegmentHeader(t, fs, path, func(h *SegmentHeader) {
+			test.AssertEqual(t, h.Version, SegmentFormatVersion)
+			test.AssertEqual(t, h.StoreID, storeID)
+			test.AssertEqual(t, h.Seq, uint64(1))
+			test.AssertEqual(t, h.StartLSN, LSN(testSegmentSize))
+		})
+
+		rewriteSegmentLength(t, fs, path, func(length int64) int64 {
+			test.AssertEqual(t, length, int64(1))
+			return length
+		})
+	})
+
+	t.Run("it refuses a file that is not a segment", func(t *testing.T) {
+		fs, manifest, path := setup(t)
+
+		file, _ := fs.Open(path, os.O_WRONLY)
+		_, _ = file.WriteAt([]byte("not a segment"), 0)
+		_ = file.Close()
+
+		test.AssertTrue(t, errors.Is(reopen(fs, manifest), SegmentMagicMismatchError))
+	})
+
+	t.Run("it refuses a short file that is not a segment and leaves it alone", func(t *testing.T) {
+		fs := storage.NewMemFS()
+		manifest := NewManifest(mocks.NewFile())
+		path := segmentPathIn(testLogsDirectory, 0, segmentSuffix)
+
+		_, err := manifest.GetStoreID()
+		test.AssertNoError(t, err)
+
+		test.AssertNoError(t, fs.MkdirAll(testLogsDirectory))
+		file, _ := fs.Create(path)
+		_, _ = file.Write([]byte("raw records"))
+		_ = file.Close()
+
+		test.AssertTrue(t, errors.Is(reopen(fs, manifest), SegmentMagicMismatchError))
+
+		data, err := storage.ReadFile(fs, path)
+		test.AssertNoError(t, err)
+		test.AssertBytesEqual(t, data, []byte("raw records"))
+	})
+
+	t.Run("it takes a file of zeroes shorter than the header as not created yet", func(t *testing.T) {
+		fs := storage.NewMemFS()
+		manifest := NewManifest(mocks.NewFile())
+		path := segmentPathIn(testLogsDirectory, 0, segmentSuffix)
+
+		_, err := manifest.GetStoreID()
+		test.AssertNoError(t, err)
+
+		test.AssertNoError(t, fs.MkdirAll(testLogsDirectory))
+		file, _ := fs.Create(path)
+		_, _ = file.Write(make([]byte, 10))
+		_ = file.Close()
+
+		log := setupTestLog(t, fs, manifest)
+		_, _ = log.Write([]byte("abc"))
+		test.AssertNoError(t, log.Close())
+
+		data, err := io.ReadAll(setupTestLog(t, fs, manifest))
+		test.AssertNoError(t, err)
+		test.AssertBytesEqual(t, data, []byte("abc"))
+	})
+
+	t.Run("it refuses a segment cut short within its length slots", func(t *testing.T) {
+		fs, manifest, path := setup(t)
+
+		data, err := storage.ReadFile(fs, path)
+		test.AssertNoError(t, err)
+
+		file, _ := fs.Create(path)
+		_, _ = file.Write(data[:segmentHeaderSize])
+		_ = file.Close()
+
+		test.AssertTrue(t, errors.Is(reopen(fs, manifest), SegmentHeaderCorruptedError))
+	})
+
+	t.Run("it refuses a corrupted header", func(t *testing.T) {
+		fs, manifest, path := setup(t)
+
+		file, _ := fs.Open(path, os.O_WRONLY)
+		_, _ = file.WriteAt([]byte{0xff}, segmentSeqOffset)
+		_ = file.Close()
+
+		test.AssertTrue(t, errors.Is(reopen(fs, manifest), SegmentHeaderCorruptedError))
+	})
+
+	t.Run("it refuses a newer format", func(t *testing.T) {
+		fs, manifest, path := setup(t)
+
+		rewriteSegmentHeader(t, fs, path, func(h *SegmentHeader) {
+			h.Version = SegmentFormatVersion + 1
+		})
+
+		test.AssertTrue(t, errors.Is(reopen(fs, manifest), SegmentVersionError))
+	})
+
+	t.Run("it refuses a segment of another store", func(t *testing.T) {
+		fs, manifest, path := setup(t)
+
+		rewriteSegmentHeader(t, fs, path, func(h *SegmentHeader) {
+			h.StoreID = NewStoreID()
+		})
+
+		test.AssertTrue(t, errors.Is(reopen(fs, manifest), ForeignSegmentError))
+	})
+
+	t.Run("it refuses a segment out of place", func(t *testing.T) {
+		fs, manifest, path := setup(t)
+
+		rewriteSegmentHeader(t, fs, path, func(h *SegmentHeader) {
+			h.Seq = 7
+		})
+
+		test.AssertTrue(t, errors.Is(reopen(fs, manifest), SegmentHeaderMismatchError))
+	})
+
+	t.Run("it refuses segments after a segment size change", func(t *testing.T) {
+		fs, manifest, _ := setup(t)
+
+		_, err := NewLog(manifest, LogOptions{FS: fs, LogsDirectory: testLogsDirectory, SegmentSize: testSegmentSize * 2})
+		test.AssertTrue(t, errors.Is(err, SegmentHeaderMismatchError))
+	})
+}
+
+func TestLog_SegmentLength(t *testing.T) {
+	setup := func(t *testing.T) (storage.FS, *Manifest, string) {
+		t.Helper()
+
+		fs := storage.NewMemFS()
+		manifest := NewManifest(mocks.NewFile())
+		log := setupTestLog(t, fs, manifest)
+		_, _ = log.Write(make([]byte, testSegmentSize+1))
+		_ = log.Close()
+
+		return fs, manifest, segmentPathIn(testLogsDirectory, 1, segmentSuffix)
+	}
+
+	readPrefix := func(t *testing.T, fs storage.FS, path string) []byte {
+		t.Helper()
+
+		data, err := storage.ReadFile(fs, path)
+		test.AssertNoError(t, err)
+
+		return data[:segmentDataOffset]
+	}
+
+	corruptSlot := func(t *testing.T, fs storage.FS, path string, generation uint64) {
+		t.Helper()
+
+		file, err := fs.Open(path, os.O_WRONLY)
+		test.AssertNoError(t, err)
+
+		_, err = file.WriteAt([]byte{0xff}, segmentSlotOffset(generation)+segmentSlotLengthOffset)
+		test.AssertNoError(t, err)
+		test.AssertNoError(t, file.Close())
+	}
+
+	t.Run("it leaves the header alone when syncing", func(t *testing.T) {
+		fs, manifest, path := setup(t)
+		before := readPrefix(t, fs, path)[:segmentHeaderSize]
+
+		log := setupTestLog(t, fs, manifest)
+		_, _ = log.Write([]byte("abc"))
+		test.AssertNoError(t, log.Sync())
+		_, _ = log.Write([]byte("def"))
+		test.AssertNoError(t, log.Close())
+
+		test.AssertBytesEqual(t, readPrefix(t, fs, path)[:segmentHeaderSize], before)
+
+		length, _, err := decodeSegmentSlots(readPrefix(t, fs, path), testSegmentSize)
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, length, int64(7))
+	})
+
+	t.Run("it falls back to the previous length when the latest slot is torn", func(t *testing.T) {
+		fs, manifest, path := setup(t)
+
+		rewriteSegmentLength(t, fs, path, func(int64) int64 {
+			return 5
+		})
+
+		_, generation, err := decodeSegmentSlots(readPrefix(t, fs, path), testSegmentSize)
+		test.AssertNoError(t, err)
+		corruptSlot(t, fs, path, generation)
+
+		log := setupTestLog(t, fs, manifest)
+		end, err := log.EndLSN()
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, end, LSN(testSegmentSize+1))
+	})
+
+	t.Run("it refuses a segment whose length slots are both corrupted", func(t *testing.T) {
+		fs, manifest, path := setup(t)
+
+		_, generation, err := decodeSegmentSlots(readPrefix(t, fs, path), testSegmentSize)
+		test.AssertNoError(t, err)
+		corruptSlot(t, fs, path, generation)
+		corruptSlot(t, fs, path, generation+1)
+
+		_, err = NewLog(manifest, LogOptions{FS: fs, LogsDirectory: testLogsDirectory, SegmentSize: testSegmentSize})
+		test.AssertTrue(t, errors.Is(err, SegmentHeaderCorruptedError))
+	})
+}
+
+func TestLog_Recycling(t *testing.T) {
+	setup := func(t *testing.T, fs storage.FS, manifest *Manifest) *Log {
+		t.Helper()
+
+		log, err := NewLog(manifest, LogOptions{
+			FS:               fs,
+			LogsDirectory:    testLogsDirectory,
+			SegmentSize:      testSegmentSize,
+			RecycledSegments: 2,
+		})
+		test.AssertNoError(t, err)
+
+		return log
+	}
+
+	truncate := func(t *testing.T, log *Log) {
+		t.Helper()
+
+		err := log.Rewrite(func(src io.ReadSeeker, dst io.Writer) error {
+			_, err := dst.Write([]byte("kept"))
+			return err
+		})
+		test.AssertNoError(t, err)
+	}
+
+	t.Run("it recycles segments dropped by a rewrite", func(t *testing.T) {
+		fs := storage.NewMemFS()
+		log := setup(t, fs, NewManifest(mocks.NewFile()))
+		_, _ = log.Write(make([]byte, testSegmentSize*3))
+
+		truncate(t, log)
+
+		recycled, err := log.listSegments(recycleSegmentSuffix)
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, len(recycled), 2)
+
+		live, err := log.listSegments(segmentSuffix)
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, len(live), 1)
+	})
+
+	t.Run("it reuses recycled segments without exposing their data", func(t *testing.T) {
+		fs := storage.NewMemFS()
+		manifest := NewManifest(mocks.NewFile())
+		log := setup(t, fs, manifest)
+		_, _ = log.Write([]byte("0123456789abcdefghijklmnopqrstuv"))
+		truncate(t, log)
+		_ = log.Close()
+
+		log = setup(t, fs, manifest)
+		test.AssertEqual(t, len(log.recycled), 2)
+
+		_, _ = log.Write(make([]byte, testSegmentSize))
+		_, _ = log.Write([]byte("new"))
+		test.AssertEqual(t, len(log.recycled), 1)
+		_ = log.Close()
+
+		reopened := setup(t, fs, manifest)
+		data, err := io.ReadAll(reopened)
+		test.AssertNoError(t, err)
+
+		expected := append([]byte("kept"), make([]byte, testSegmentSize)...)
+		test.AssertBytesEqual(t, data, append(expected, []byte("new")...))
+	})
+}
+
+func TestLog_Stats(t *testing.T) {
+	log := setupTestLog(t, storage.NewMemFS(), NewManifest(mocks.NewFile()))
+
+	_, err := log.Write([]byte("0123456789abcdefghijklmnopqrstuvwxyz"))
+	test.AssertNoError(t, err)
+
+	stats, err := log.Stats()
+	test.AssertNoError(t, err)
+
+	test.AssertEqual(t, stats.Segments, 3)
+	test.AssertEqual(t, stats.Bytes, int64(36))
+	test.AssertEqual(t, stats.CurrentSegment, stats.LogStart+2)
+	test.AssertEqual(t, stats.StartLSN, log.StartLSN())
+	test.AssertEqual(t, stats.EndLSN, stats.StartLSN+36)
+}
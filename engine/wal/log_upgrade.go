@@ -0,0 +1,179 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"kv/storage"
+	"os"
+)
+
+const upgradeSegmentSuffix = ".log.upgrade"
+
+// UpgradeLog brings a log written before segments had headers to the current
+// format. Such segments hold nothing but records, the file size being the
+// length of the data. The manifest gets a store ID and each segment is
+// written again behind a header, staged under a temporary suffix and renamed
+// over the original.
+//
+// Every segment is checked before anything is written, so that a log that
+// cannot be upgraded, e.g. because the segment size does not match, is left
+// as it was. Segments that already have a header are skipped, an interrupted
+// upgrade resumes where it stopped.
+func UpgradeLog(manifest *Manifest, options LogOptions) error {
+	l := &Log{
+		options:  options,
+		manifest: manifest,
+	}
+
+	if err := options.FS.MkdirAll(options.LogsDirectory); err != nil {
+		return err
+	}
+
+	unversioned, err := l.unversionedSegments()
+	if err != nil {
+		return err
+	}
+
+	if len(unversioned) == 0 {
+		return nil
+	}
+
+	if l.storeID, err = manifest.GetStoreID(); err != nil {
+		return err
+	}
+
+	for _, seq := range unversioned {
+		if err = l.upgradeSegment(seq); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unversionedSegments returns the segments from the log start onwards that
+// have no header yet. Each of them but the last has to be full.
+func (l *Log) unversionedSegments() ([]uint64, error) {
+	logStart, err := l.manifest.GetLogStart()
+	if err != nil {
+		return nil, err
+	}
+
+	sequences, err := l.listSegments(segmentSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	var live []uint64
+	expected := logStart
+
+	for _, seq := range sequences {
+		if seq < logStart {
+			continue
+		}
+
+		if seq != expected {
+			return nil, fmt.Errorf("%w: segment %d", SegmentNotFoundError, expected)
+		}
+
+		live = append(live, seq)
+		expected++
+	}
+
+	var unversioned []uint64
+
+	for i, seq := range live {
+		size, ok, err := l.unversionedSize(seq)
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			continue
+		}
+
+		if size > l.options.SegmentSize {
+			return nil, fmt.Errorf("%w: segment %d holds %d bytes, more than segments of %d (was the segment size changed?)",
+				SegmentHeaderMismatchError, seq, size, l.options.SegmentSize)
+		}
+
+		if i < len(live)-1 && size < l.options.SegmentSize {
+			return nil, fmt.Errorf("%w: segment %d", SegmentGapError, seq)
+		}
+
+		unversioned = append(unversioned, seq)
+	}
+
+	return unversioned, nil
+}
+
+// unversionedSize returns the length of the data of a segment without a
+// header, reporting false for one that has a header. So does a file of
+// zeroes larger than any such segment, which was allocated for a segment
+// with a header right before a crash.
+func (l *Log) unversionedSize(seq uint64) (int64, bool, error) {
+	file, err := l.options.FS.Open(l.segmentPath(seq, segmentSuffix), os.O_RDONLY)
+	if err != nil {
+		return 0, false, err
+	}
+
+	defer func() {
+		_ = file.Close()
+	}()
+
+	size, err := file.Size()
+	if err != nil {
+		return 0, false, err
+	}
+
+	var header [segmentHeaderSize]byte
+
+	n, err := file.ReadAt(header[:], 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0, false, err
+	}
+
+	if string(header[:min(n, segmentMagicSize)]) == segmentMagic {
+		return 0, false, nil
+	}
+
+	if size > l.options.SegmentSize && isZero(header[:n]) {
+		return 0, false, nil
+	}
+
+	return size, true, nil
+}
+
+// upgradeSegment writes the data of a segment without a header into a new
+// segment file and puts that in its place.
+func (l *Log) upgradeSegment(seq uint64) error {
+	path := l.segmentPath(seq, segmentSuffix)
+	stagedPath := l.segmentPath(seq, upgradeSegmentSuffix)
+
+	data, err := storage.ReadFile(l.options.FS, path)
+	if err != nil {
+		return err
+	}
+
+	// A file staged by an interrupted upgrade is started over.
+	if err = l.options.FS.Remove(stagedPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	staged := l.newSegmentAt(stagedPath, seq)
+
+	if _, err = staged.Write(data); err != nil {
+		return errors.Join(err, staged.Close())
+	}
+
+	if err = staged.Close(); err != nil {
+		return err
+	}
+
+	if err = l.options.FS.Rename(stagedPath, path); err != nil {
+		return err
+	}
+
+	return l.options.FS.SyncDir(l.options.LogsDirectory)
+}
@@ -0,0 +1,115 @@
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"kv/storage"
+	"kv/storage/mocks"
+	"kv/test"
+	"testing"
+)
+
+func TestUpgradeLog(t *testing.T) {
+	options := LogOptions{LogsDirectory: testLogsDirectory, SegmentSize: testSegmentSize}
+
+	// setup lays out a log the way it was written before segment headers:
+	// records only, and a manifest holding the log start and its checksum.
+	setup := func(t *testing.T, segments ...string) (storage.FS, *mocks.File) {
+		t.Helper()
+
+		fs := storage.NewMemFS()
+		test.AssertNoError(t, fs.MkdirAll(testLogsDirectory))
+
+		for seq, data := range segments {
+			file, err := fs.Create(segmentPathIn(testLogsDirectory, uint64(seq), segmentSuffix))
+			test.AssertNoError(t, err)
+			_, _ = file.Write([]byte(data))
+			test.AssertNoError(t, file.Close())
+		}
+
+		var logStart [legacyManifestSize]byte
+		manifestFile := mocks.NewFile()
+		_, _ = manifestFile.Write(binary.LittleEndian.AppendUint32(logStart[:logStartSize], crc32.ChecksumIEEE(logStart[:])))
+
+		return fs, manifestFile
+	}
+
+	t.Run("it upgrades segments and manifest written before segment headers", func(t *testing.T) {
+		fs, manifestFile := setup(t, "0123456789abcdef", "xyz")
+		options.FS = fs
+
+		test.AssertNoError(t, UpgradeLog(NewManifest(manifestFile), options))
+
+		manifest := NewManifest(manifestFile)
+		hasStoreID, err := manifest.HasStoreID()
+		test.AssertNoError(t, err)
+		test.AssertTrue(t, hasStoreID)
+
+		log, err := NewLog(manifest, options)
+		test.AssertNoError(t, err)
+
+		_, _ = log.Write([]byte("!"))
+		data, err := io.ReadAll(log)
+		test.AssertNoError(t, err)
+		test.AssertBytesEqual(t, data, []byte("0123456789abcdefxyz!"))
+	})
+
+	t.Run("it refuses to open a log that was not upgraded without writing to it", func(t *testing.T) {
+		fs, manifestFile := setup(t, "xyz")
+		options.FS = fs
+		manifestBefore := append([]byte(nil), manifestFile.Data...)
+
+		_, err := NewLog(NewManifest(manifestFile), options)
+		test.AssertError(t, err, UnversionedLogError)
+
+		test.AssertBytesEqual(t, manifestFile.Data, manifestBefore)
+
+		data, err := storage.ReadFile(fs, segmentPathIn(testLogsDirectory, 0, segmentSuffix))
+		test.AssertNoError(t, err)
+		test.AssertBytesEqual(t, data, []byte("xyz"))
+	})
+
+	t.Run("it leaves a log alone that does not fit the segment size", func(t *testing.T) {
+		fs, manifestFile := setup(t, "0123456789", "xyz")
+		options.FS = fs
+		manifestBefore := append([]byte(nil), manifestFile.Data...)
+
+		err := UpgradeLog(NewManifest(manifestFile), options)
+		test.AssertTrue(t, errors.Is(err, SegmentGapError))
+
+		test.AssertBytesEqual(t, manifestFile.Data, manifestBefore)
+
+		data, err := storage.ReadFile(fs, segmentPathIn(testLogsDirectory, 0, segmentSuffix))
+		test.AssertNoError(t, err)
+		test.AssertBytesEqual(t, data, []byte("0123456789"))
+	})
+
+	t.Run("it resumes an interrupted upgrade", func(t *testing.T) {
+		fs, manifestFile := setup(t, "0123456789abcdef", "xyz")
+		options.FS = fs
+
+		// Stopped after the first segment, with the second one half staged.
+		manifest := NewManifest(manifestFile)
+		storeID, err := manifest.GetStoreID()
+		test.AssertNoError(t, err)
+
+		interrupted := &Log{options: options, manifest: manifest, storeID: storeID}
+		test.AssertNoError(t, interrupted.upgradeSegment(0))
+
+		staged, _ := fs.Create(segmentPathIn(testLogsDirectory, 1, upgradeSegmentSuffix))
+		_, _ = staged.Write([]byte("partial"))
+		_ = staged.Close()
+
+		test.AssertNoError(t, UpgradeLog(NewManifest(manifestFile), options))
+
+		log, err := NewLog(NewManifest(manifestFile), options)
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, log.StoreID(), storeID)
+
+		data, err := io.ReadAll(log)
+		test.AssertNoError(t, err)
+		test.AssertBytesEqual(t, data, []byte("0123456789abcdefxyz"))
+	})
+}
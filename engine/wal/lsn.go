@@ -0,0 +1,5 @@
+package wal
+
+// LSN is a position in the log, counted in bytes from the very first segment
+// ever written. It stays valid when older segments are removed.
+type LSN uint64
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"hash/crc32"
 	"io"
+	"kv/encryption"
 	"kv/storage"
 	"sync"
 )
@@ -14,24 +15,56 @@ var ManifestChecksumMismatchError = errors.New("wal: manifest checksum mismatch"
 const (
 	logStartOffset = 0
 	logStartSize   = 8
-	checksumOffset = logStartOffset + logStartSize
+	storeIDOffset  = logStartOffset + logStartSize
+	storeIDSize    = 16
+	checksumOffset = storeIDOffset + storeIDSize
 	checksumSize   = 4
-	manifestSize   = logStartSize + checksumSize
+	manifestSize   = logStartSize + storeIDSize + checksumSize
+
+	// legacyManifestSize is the size of a manifest written before store IDs,
+	// which held the log start and a checksum of it only.
+	legacyManifestSize = logStartSize + checksumSize
 )
 
 type Manifest struct {
-	file  storage.File
-	mutex sync.Mutex
-	state *state
+	file    *storage.SlotFile
+	keyring *encryption.Keyring
+	migrate bool
+	mutex   sync.Mutex
+	state   *state
 }
 
 type state struct {
 	logStart uint64
+	storeID  StoreID
 }
 
+// manifestAdditionalData binds a sealed manifest to its purpose, so that it
+// cannot be swapped for another file sealed with the same key.
+var manifestAdditionalData = []byte("wal-manifest")
+
 func NewManifest(file storage.File) *Manifest {
+	return NewEncryptedManifest(file, nil)
+}
+
+// NewEncryptedManifest returns a manifest sealed with the active key of the
+// keyring. With keys loaded, a manifest written without encryption is
+// refused.
+func NewEncryptedManifest(file storage.File, keyring *encryption.Keyring) *Manifest {
+	return &Manifest{
+		file:    storage.NewSlotFile(file),
+		keyring: keyring,
+	}
+}
+
+// NewMigratingManifest is NewEncryptedManifest, except that it still reads a
+// manifest written before encryption was enabled, sealing it the next time
+// it is written.
+func NewMigratingManifest(file storage.File, keyring *encryption.Keyring) *Manifest {
 	return &Manifest{
-		file: file,
+		file:    storage.NewSlotFile(file),
+		keyring: keyring,
+		migrate: true,
 	}
 }
 
@@ -71,23 +104,88 @@ func (m *Manifest) GetLogStart() (uint64, error) {
 	return s.logStart, nil
 }
 
-func (m *Manifest) read() (state, error) {
-	if _, err := m.file.Seek(0, io.SeekStart); err != nil {
-		return state{}, err
+// HasStoreID reports whether a store ID was generated yet. A manifest written
+// before store IDs existed has none.
+func (m *Manifest) HasStoreID() (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	s, err := m.read()
+	if err != nil {
+		return false, err
 	}
 
-	buf := make([]byte, manifestSize)
+	return !s.storeID.IsZero(), nil
+}
+
+// GetStoreID returns the ID of the store the log belongs to, generating and
+// persisting one the first time it is asked for.
+func (m *Manifest) GetStoreID() (StoreID, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	s, err := m.read()
+	if err != nil {
+		return StoreID{}, err
+	}
 
-	n, err := io.ReadFull(m.file, buf)
+	if s.storeID.IsZero() {
+		s.storeID = NewStoreID()
+
+		if err = m.write(s); err != nil {
+			return StoreID{}, err
+		}
+	}
+
+	m.state = &s
+	return s.storeID, nil
+}
+
+// Reseal writes the manifest again, sealing it with the current active key.
+func (m *Manifest) Reseal() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 
-	if errors.Is(err, io.EOF) && n == 0 {
+	s, err := m.read()
+	if err != nil {
+		return err
+	}
+
+	return m.write(s)
+}
+
+func (m *Manifest) read() (state, error) {
+	content, err := m.file.Read()
+	if errors.Is(err, storage.SlotsCorruptedError) {
+		return state{}, ManifestChecksumMismatchError
+	}
+
+	if err != nil {
+		return state{}, err
+	}
+
+	if len(content) == 0 {
 		return state{logStart: 0}, nil
 	}
 
+	open := encryption.OpenFrame
+	if m.migrate {
+		open = encryption.OpenFrameForMigration
+	}
+
+	buf, err := open(m.keyring, content, manifestAdditionalData)
 	if err != nil {
 		return state{}, err
 	}
 
+	if len(buf) == legacyManifestSize {
+		return decodeLegacyManifest(buf)
+	}
+
+	if len(buf) < manifestSize {
+		return state{}, io.ErrUnexpectedEOF
+	}
+
 	logStart := binary.LittleEndian.Uint64(
 		buf[logStartOffset : logStartOffset+logStartSize],
 	)
@@ -100,6 +198,8 @@ func (m *Manifest) read() (state, error) {
 		logStart: logStart,
 	}
 
+	copy(s.storeID[:], buf[storeIDOffset:storeIDOffset+storeIDSize])
+
 	if s.checksum() != expectedChecksum {
 		return state{}, ManifestChecksumMismatchError
 	}
@@ -115,24 +215,35 @@ func (m *Manifest) write(s state) error {
 		s.logStart,
 	)
 
+	copy(buf[storeIDOffset:storeIDOffset+storeIDSize], s.storeID[:])
+
 	binary.LittleEndian.PutUint32(
 		buf[checksumOffset:checksumOffset+checksumSize],
 		s.checksum(),
 	)
 
-	if _, err := m.file.Seek(0, io.SeekStart); err != nil {
-		return err
+	return m.file.Write(encryption.SealFrame(m.keyring, buf, manifestAdditionalData))
+}
+
+func decodeLegacyManifest(buf []byte) (state, error) {
+	s := state{
+		logStart: binary.LittleEndian.Uint64(buf[logStartOffset : logStartOffset+logStartSize]),
 	}
 
-	if _, err := m.file.Write(buf); err != nil {
-		return err
+	// The checksum covered the log start padded to the size of the manifest.
+	var padded [legacyManifestSize]byte
+	binary.LittleEndian.PutUint64(padded[logStartOffset:], s.logStart)
+
+	if crc32.ChecksumIEEE(padded[:]) != binary.LittleEndian.Uint32(buf[logStartSize:]) {
+		return state{}, ManifestChecksumMismatchError
 	}
 
-	return m.file.Sync()
+	return s, nil
 }
 
 func (s state) checksum() uint32 {
-	var buf [manifestSize]byte
-	binary.LittleEndian.PutUint64(buf[:], s.logStart)
+	var buf [checksumOffset]byte
+	binary.LittleEndian.PutUint64(buf[logStartOffset:], s.logStart)
+	copy(buf[storeIDOffset:], s.storeID[:])
 	return crc32.ChecksumIEEE(buf[:])
 }
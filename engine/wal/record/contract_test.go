@@ -2,6 +2,7 @@ package record
 
 import (
 	"bytes"
+	"kv/encryption"
 	"kv/test"
 	"testing"
 )
@@ -13,29 +14,41 @@ func TestEncodingContract(t *testing.T) {
 			original *Record
 		}{
 			{"value", NewValue("Key", []byte("Value"), 1)},
+			{"compressible value", NewValue("Key", bytes.Repeat([]byte("Value"), 100), 1)},
 			{"tombstone", NewTombstone("Key", 1)},
 			{"commit", NewCommit(1)},
+			{"abort", NewAbort(1)},
 			{"freeze", NewFreeze("Key", 1)},
+			{"savepoint", NewSavepoint("Name", 1)},
+			{"rollback to", NewRollbackTo("Name", 1)},
+			{"release", NewRelease("Name", 1)},
 		}
 
+		keyring, err := encryption.ParseKeyring("1:000102030405060708090a0b0c0d0e0f")
+		test.AssertNoError(t, err)
+
+		keyrings := map[string]*encryption.Keyring{"plain": nil, "encrypted": keyring}
+
 		for _, tt := range tests {
-			t.Run(tt.name, func(t *testing.T) {
-				buf := new(bytes.Buffer)
-				encoder := NewEncoder(buf)
-				decoder := NewDecoder(buf)
-
-				err := encoder.Encode(tt.original)
-				test.AssertNoError(t, err)
-
-				decoded := &Record{}
-				err = decoder.Decode(decoded)
-				test.AssertNoError(t, err)
-
-				test.AssertEqual(t, decoded.Kind, tt.original.Kind)
-				test.AssertEqual(t, decoded.TxID, tt.original.TxID)
-				test.AssertBytesEqual(t, decoded.Key, tt.original.Key)
-				test.AssertBytesEqual(t, decoded.Value, tt.original.Value)
-			})
+			for mode, keyring := range keyrings {
+				t.Run(tt.name+" "+mode, func(t *testing.T) {
+					buf := new(bytes.Buffer)
+					encoder := NewEncoder(buf, EncoderOptions{CompressionThreshold: 1, Keyring: keyring})
+					decoder := NewDecoder(buf, DecoderOptions{Keyring: keyring})
+
+					err := encoder.Encode(tt.original)
+					test.AssertNoError(t, err)
+
+					decoded := &Record{}
+					err = decoder.Decode(decoded)
+					test.AssertNoError(t, err)
+
+					test.AssertEqual(t, decoded.Kind, tt.original.Kind)
+					test.AssertEqual(t, decoded.TxID, tt.original.TxID)
+					test.AssertBytesEqual(t, decoded.Key, tt.original.Key)
+					test.AssertBytesEqual(t, decoded.Value, tt.original.Value)
+				})
+			}
 		}
 	})
 }
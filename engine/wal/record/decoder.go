@@ -1,37 +1,143 @@
 package record
 
 import (
+	"bytes"
 	"encoding/binary"
+	"fmt"
 	"io"
+	"kv/encryption"
 )
 
+// lz4MaxRatio bounds how much a compressed value can expand, which keeps a
+// corrupted length from causing a huge allocation.
+const lz4MaxRatio = 255
+
+type DecoderOptions struct {
+	// Keyring opens encrypted records. Plain records are read without it.
+	Keyring *encryption.Keyring
+
+	// StoreID and Position, where the first record is read from, must match
+	// those the records were encoded with.
+	StoreID  []byte
+	Position uint64
+}
+
 type Decoder struct {
-	reader    io.Reader
+	reader    countingReader
 	headerBuf header
+	valueBuf  []byte
+	options   DecoderOptions
+
+	frameBuf    []byte
+	plainBuf    []byte
+	plainReader bytes.Reader
+	adBuf       []byte
+}
+
+// countingReader keeps track of the position in the log it reads from.
+type countingReader struct {
+	reader   io.Reader
+	position uint64
 }
 
-func NewDecoder(reader io.Reader) *Decoder {
-	return &Decoder{reader: reader}
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.position += uint64(n)
+	return n, err
 }
 
+func NewDecoder(reader io.Reader, options DecoderOptions) *Decoder {
+	return &Decoder{
+		reader:  countingReader{reader: reader, position: options.Position},
+		options: options,
+	}
+}
+
+// SetPosition tells where the next record is read from, after the reader was
+// moved elsewhere than where the decoder left off.
+func (d *Decoder) SetPosition(position uint64) {
+	d.reader.position = position
+}
+
+// Decode reads the next record, decrypting and decompressing it as needed.
 func (d *Decoder) Decode(r *Record) error {
-	if _, err := io.ReadFull(d.reader, d.headerBuf[:]); err != nil {
+	position := d.reader.position
+
+	if _, err := io.ReadFull(&d.reader, d.headerBuf[:kindSize]); err != nil {
+		return err
+	}
+
+	if d.headerBuf[kindOffset] == encryptedFlag {
+		return d.decodeEncrypted(r, position)
+	}
+
+	return d.decodeRecord(&d.reader, r)
+}
+
+// decodeEncrypted opens a sealed record, its frame starting at position, and
+// decodes it from the plaintext.
+func (d *Decoder) decodeEncrypted(r *Record, position uint64) error {
+	var lengthBuf [frameHeaderSize - kindSize]byte
+
+	if _, err := io.ReadFull(&d.reader, lengthBuf[:]); err != nil {
+		return unexpectedEOF(err)
+	}
+
+	length := int(binary.LittleEndian.Uint32(lengthBuf[:]))
+	if length > MaxRecordSize+encryption.Overhead {
+		return fmt.Errorf("%w: sealed record of %d bytes", RecordTooLargeError, length)
+	}
+
+	d.frameBuf = growSlice(d.frameBuf, length)
+	if _, err := io.ReadFull(&d.reader, d.frameBuf); err != nil {
+		return unexpectedEOF(err)
+	}
+
+	d.adBuf = additionalData(d.adBuf, d.options.StoreID, position)
+
+	plain, err := d.options.Keyring.Open(d.plainBuf[:0], d.frameBuf, d.adBuf)
+	if err != nil {
 		return err
 	}
 
-	r.Kind = d.headerBuf[kindOffset]
+	d.plainBuf = plain
+	d.plainReader.Reset(plain)
+
+	if _, err = io.ReadFull(&d.plainReader, d.headerBuf[:kindSize]); err != nil {
+		return unexpectedEOF(err)
+	}
+
+	return d.decodeRecord(&d.plainReader, r)
+}
+
+// decodeRecord reads the rest of a plain record, its kind already read.
+func (d *Decoder) decodeRecord(reader io.Reader, r *Record) error {
+	if _, err := io.ReadFull(reader, d.headerBuf[kindSize:]); err != nil {
+		return unexpectedEOF(err)
+	}
+
+	kind := d.headerBuf[kindOffset]
+	r.Kind = kind &^ compressedFlag
 	r.TxID = binary.LittleEndian.Uint64(d.headerBuf[txIDOffset : txIDOffset+txIDSize])
 	keyLength := binary.LittleEndian.Uint16(d.headerBuf[keyLengthOffset : keyLengthOffset+keyLengthSize])
 	valueLength := binary.LittleEndian.Uint32(d.headerBuf[valueLengthOffset : valueLengthOffset+valueLengthSize])
 	expectedChecksum := binary.LittleEndian.Uint32(d.headerBuf[checksumOffset : checksumOffset+checksumSize])
 
+	if size := headerSize + int(keyLength) + int(valueLength); size > MaxRecordSize {
+		return fmt.Errorf("%w: %d bytes", RecordTooLargeError, size)
+	}
+
 	r.Key = growSlice(r.Key, int(keyLength))
-	if _, err := io.ReadFull(d.reader, r.Key); err != nil {
-		return err
+	if _, err := io.ReadFull(reader, r.Key); err != nil {
+		return unexpectedEOF(err)
 	}
 
-	r.Value = growSlice(r.Value, int(valueLength))
-	if _, err := io.ReadFull(d.reader, r.Value); err != nil {
+	if kind&compressedFlag == 0 {
+		r.Value = growSlice(r.Value, int(valueLength))
+		if _, err := io.ReadFull(reader, r.Value); err != nil {
+			return unexpectedEOF(err)
+		}
+	} else if err := d.decompress(reader, r, int(valueLength)); err != nil {
 		return err
 	}
 
@@ -42,6 +148,35 @@ func (d *Decoder) Decode(r *Record) error {
 	return nil
 }
 
+func (d *Decoder) decompress(reader io.Reader, r *Record, length int) error {
+	d.valueBuf = growSlice(d.valueBuf, length)
+	if _, err := io.ReadFull(reader, d.valueBuf); err != nil {
+		return unexpectedEOF(err)
+	}
+
+	if length < 4 {
+		return CompressedDataCorruptedError
+	}
+
+	original := int(binary.LittleEndian.Uint32(d.valueBuf))
+	if original > (length-4)*lz4MaxRatio || original > MaxRecordSize {
+		return CompressedDataCorruptedError
+	}
+
+	r.Value = growSlice(r.Value, original)
+	return lz4Decompress(r.Value, d.valueBuf[4:])
+}
+
+// unexpectedEOF reports running out of data in the middle of a record as
+// io.ErrUnexpectedEOF, leaving io.EOF to mean the log ended between records.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
 func growSlice(b []byte, n int) []byte {
 	if cap(b) < n {
 		return make([]byte, n)
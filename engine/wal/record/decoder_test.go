@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"io"
 	"kv/conversion"
+	"kv/encryption"
 	"kv/test"
 	"testing"
 )
@@ -28,7 +29,7 @@ func TestDecoder_Decode(t *testing.T) {
 		buf := writeRecord(want, want.Checksum())
 
 		got := &Record{}
-		err := NewDecoder(buf).Decode(got)
+		err := NewDecoder(buf, DecoderOptions{}).Decode(got)
 
 		test.AssertNoError(t, err)
 		test.AssertEqual(t, got.Kind, want.Kind)
@@ -40,18 +41,96 @@ func TestDecoder_Decode(t *testing.T) {
 		want := NewValue("session_id", []byte("val_987654321"), 1)
 		buf := writeRecord(want, want.Checksum()+1)
 
-		err := NewDecoder(buf).Decode(&Record{})
+		err := NewDecoder(buf, DecoderOptions{}).Decode(&Record{})
 		test.AssertError(t, err, ChecksumMismatchError)
 	})
 
 	t.Run("it returns EOF on empty reader", func(t *testing.T) {
-		err := NewDecoder(new(bytes.Buffer)).Decode(&Record{})
+		err := NewDecoder(new(bytes.Buffer), DecoderOptions{}).Decode(&Record{})
 		test.AssertError(t, err, io.EOF)
 	})
 
 	t.Run("it returns error if header is truncated", func(t *testing.T) {
 		buf := bytes.NewReader([]byte{1, 0, 5})
-		err := NewDecoder(buf).Decode(&Record{})
+		err := NewDecoder(buf, DecoderOptions{}).Decode(&Record{})
 		test.AssertError(t, err, io.ErrUnexpectedEOF)
 	})
+
+	t.Run("it returns error on corrupted compressed value", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		err := NewEncoder(buf, EncoderOptions{CompressionThreshold: 1}).Encode(
+			NewValue("Key", bytes.Repeat([]byte("Value"), 100), 1),
+		)
+		test.AssertNoError(t, err)
+
+		data := buf.Bytes()
+		data[headerSize+3] = 0xff
+
+		err = NewDecoder(buf, DecoderOptions{}).Decode(&Record{})
+		test.AssertError(t, err, CompressedDataCorruptedError)
+	})
+
+	t.Run("it requires keys for encrypted records", func(t *testing.T) {
+		keyring, _ := encryption.ParseKeyring("1:000102030405060708090a0b0c0d0e0f")
+
+		buf := new(bytes.Buffer)
+		err := NewEncoder(buf, EncoderOptions{Keyring: keyring}).Encode(NewValue("Key", []byte("secret"), 1))
+		test.AssertNoError(t, err)
+		test.AssertFalse(t, bytes.Contains(buf.Bytes(), []byte("secret")))
+
+		err = NewDecoder(buf, DecoderOptions{}).Decode(&Record{})
+		test.AssertError(t, err, encryption.KeyRequiredError)
+	})
+
+	t.Run("it binds encrypted records to their store and position", func(t *testing.T) {
+		keyring, _ := encryption.ParseKeyring("1:000102030405060708090a0b0c0d0e0f")
+		storeID := []byte("store-1")
+
+		buf := new(bytes.Buffer)
+		encoder := NewEncoder(buf, EncoderOptions{Keyring: keyring, StoreID: storeID, Position: 100})
+		test.AssertNoError(t, encoder.Encode(NewValue("a", []byte("1"), 1)))
+		test.AssertNoError(t, encoder.Encode(NewValue("b", []byte("2"), 1)))
+
+		tests := []struct {
+			name    string
+			options DecoderOptions
+			err     error
+		}{
+			{"same store and position", DecoderOptions{Keyring: keyring, StoreID: storeID, Position: 100}, nil},
+			{"other position", DecoderOptions{Keyring: keyring, StoreID: storeID, Position: 101}, encryption.DecryptionFailedError},
+			{"other store", DecoderOptions{Keyring: keyring, StoreID: []byte("store-2"), Position: 100}, encryption.DecryptionFailedError},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				decoder := NewDecoder(bytes.NewReader(buf.Bytes()), tt.options)
+
+				var r Record
+				for range 2 {
+					if err := decoder.Decode(&r); tt.err != nil {
+						test.AssertError(t, err, tt.err)
+					} else {
+						test.AssertNoError(t, err)
+					}
+				}
+			})
+		}
+	})
+
+	t.Run("it refuses a sealed record longer than the maximum", func(t *testing.T) {
+		keyring, _ := encryption.ParseKeyring("1:000102030405060708090a0b0c0d0e0f")
+
+		buf := bytes.NewBuffer([]byte{encryptedFlag, 0xff, 0xff, 0xff, 0xff})
+		err := NewDecoder(buf, DecoderOptions{Keyring: keyring}).Decode(&Record{})
+		test.AssertError(t, err, RecordTooLargeError)
+	})
+
+	t.Run("it refuses a value longer than the maximum", func(t *testing.T) {
+		r := NewValue("Key", nil, 1)
+		buf := writeRecord(r, r.Checksum())
+		copy(buf.Bytes()[valueLengthOffset:], conversion.Uint32ToBytes(MaxRecordSize))
+
+		err := NewDecoder(buf, DecoderOptions{}).Decode(&Record{})
+		test.AssertError(t, err, RecordTooLargeError)
+	})
 }
@@ -2,36 +2,170 @@ package record
 
 import (
 	"encoding/binary"
+	"fmt"
 	"io"
+	"kv/encryption"
 )
 
+type EncoderOptions struct {
+	// CompressionThreshold is the value size from which values get
+	// compressed. Zero disables compression.
+	CompressionThreshold int
+
+	// Keyring encrypts records with its active key, nil disables encryption.
+	Keyring *encryption.Keyring
+
+	// StoreID and Position, where the first record is written, are bound to
+	// encrypted records. Decoding them takes the same.
+	StoreID  []byte
+	Position uint64
+}
+
+// EncoderStats count what an encoder wrote. RawBytes is what records would
+// take without compression, EncodedBytes what they actually took.
+type EncoderStats struct {
+	Records           uint64
+	CompressedRecords uint64
+	RawBytes          uint64
+	EncodedBytes      uint64
+}
+
+// CompressionRatio returns how many times smaller compression made the
+// encoded records, 1 if nothing was compressed.
+func (s EncoderStats) CompressionRatio() float64 {
+	if s.EncodedBytes == 0 {
+		return 1
+	}
+
+	return float64(s.RawBytes) / float64(s.EncodedBytes)
+}
+
 type Encoder struct {
 	writer    io.Writer
 	headerBuf header
+	options   EncoderOptions
+	stats     EncoderStats
+	position  uint64
+
+	compressor *lz4Compressor
+	valueBuf   []byte
+	plainBuf   []byte
+	frameBuf   []byte
+	adBuf      []byte
 }
 
-func NewEncoder(writer io.Writer) *Encoder {
-	return &Encoder{writer: writer}
+func NewEncoder(writer io.Writer, options EncoderOptions) *Encoder {
+	return &Encoder{
+		writer:   writer,
+		options:  options,
+		position: options.Position,
+	}
 }
 
+// Encode writes r, refusing records that would take more than MaxRecordSize.
 func (e *Encoder) Encode(r *Record) error {
-	e.headerBuf[kindOffset] = r.Kind
+	if size := headerSize + len(r.Key) + len(r.Value); size > MaxRecordSize {
+		return fmt.Errorf("%w: %d bytes", RecordTooLargeError, size)
+	}
+
+	kind, value := e.compress(r)
+
+	e.headerBuf[kindOffset] = kind
 	binary.LittleEndian.PutUint64(e.headerBuf[txIDOffset:txIDOffset+txIDSize], r.TxID)
 	binary.LittleEndian.PutUint16(e.headerBuf[keyLengthOffset:keyLengthOffset+keyLengthSize], uint16(len(r.Key)))
-	binary.LittleEndian.PutUint32(e.headerBuf[valueLengthOffset:valueLengthOffset+valueLengthSize], uint32(len(r.Value)))
+	binary.LittleEndian.PutUint32(e.headerBuf[valueLengthOffset:valueLengthOffset+valueLengthSize], uint32(len(value)))
 	binary.LittleEndian.PutUint32(e.headerBuf[checksumOffset:checksumOffset+checksumSize], r.Checksum())
 
+	encoded := headerSize + len(r.Key) + len(value)
+
+	if e.options.Keyring != nil {
+		var err error
+		if encoded, err = e.encrypt(r.Key, value); err != nil {
+			return err
+		}
+	} else if err := e.write(r.Key, value); err != nil {
+		return err
+	}
+
+	e.position += uint64(encoded)
+	e.stats.Records++
+	e.stats.RawBytes += uint64(headerSize + len(r.Key) + len(r.Value))
+	e.stats.EncodedBytes += uint64(encoded)
+
+	if kind&compressedFlag != 0 {
+		e.stats.CompressedRecords++
+	}
+
+	return nil
+}
+
+func (e *Encoder) write(key, value []byte) error {
 	if _, err := e.writer.Write(e.headerBuf[:]); err != nil {
 		return err
 	}
 
-	if _, err := e.writer.Write(r.Key); err != nil {
+	if _, err := e.writer.Write(key); err != nil {
 		return err
 	}
 
-	if _, err := e.writer.Write(r.Value); err != nil {
+	if _, err := e.writer.Write(value); err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// encrypt writes the record sealed into a frame: the encrypted flag, the
+// length of the sealed record and the sealed record itself.
+func (e *Encoder) encrypt(key, value []byte) (int, error) {
+	e.plainBuf = append(e.plainBuf[:0], e.headerBuf[:]...)
+	e.plainBuf = append(e.plainBuf, key...)
+	e.plainBuf = append(e.plainBuf, value...)
+
+	e.frameBuf = append(e.frameBuf[:0], encryptedFlag, 0, 0, 0, 0)
+	e.adBuf = additionalData(e.adBuf, e.options.StoreID, e.position)
+	e.frameBuf = e.options.Keyring.Seal(e.frameBuf, e.plainBuf, e.adBuf)
+
+	sealedLength := len(e.frameBuf) - frameHeaderSize
+	binary.LittleEndian.PutUint32(e.frameBuf[kindSize:frameHeaderSize], uint32(sealedLength))
+
+	if _, err := e.writer.Write(e.frameBuf); err != nil {
+		return 0, err
+	}
+
+	return len(e.frameBuf), nil
+}
+
+// SetPosition tells where the next record is written, after the log was
+// moved elsewhere than where the encoder left off.
+func (e *Encoder) SetPosition(position uint64) {
+	e.position = position
+}
+
+func (e *Encoder) Stats() EncoderStats {
+	return e.stats
+}
+
+// compress returns the kind byte and value to write. Values are stored
+// compressed, prefixed with their original length, only when that makes
+// them smaller.
+func (e *Encoder) compress(r *Record) (uint8, []byte) {
+	threshold := e.options.CompressionThreshold
+
+	if threshold <= 0 || len(r.Value) < threshold {
+		return r.Kind, r.Value
+	}
+
+	if e.compressor == nil {
+		e.compressor = new(lz4Compressor)
+	}
+
+	e.valueBuf = binary.LittleEndian.AppendUint32(e.valueBuf[:0], uint32(len(r.Value)))
+	e.valueBuf = e.compressor.compress(e.valueBuf, r.Value)
+
+	if len(e.valueBuf) >= len(r.Value) {
+		return r.Kind, r.Value
+	}
+
+	return r.Kind | compressedFlag, e.valueBuf
+}
@@ -5,53 +5,103 @@ import (
 	"encoding/binary"
 	"io"
 	"kv/test"
+	"math/rand"
 	"testing"
 )
 
-func TestEncoder_Encode(t *testing.T) {
-	verifyLayout := func(t *testing.T, data []byte, r *Record) {
-		t.Helper()
-		offset := 0
+func verifyLayout(t *testing.T, data []byte, r *Record) {
+	t.Helper()
+	offset := 0
 
-		test.AssertEqual(t, data[offset], r.Kind)
-		offset += kindSize
+	test.AssertEqual(t, data[offset], r.Kind)
+	offset += kindSize
 
-		gotTxID := binary.LittleEndian.Uint64(data[offset : offset+txIDSize])
-		test.AssertEqual(t, gotTxID, r.TxID)
-		offset += txIDSize
+	gotTxID := binary.LittleEndian.Uint64(data[offset : offset+txIDSize])
+	test.AssertEqual(t, gotTxID, r.TxID)
+	offset += txIDSize
 
-		gotKeyLen := binary.LittleEndian.Uint16(data[offset : offset+keyLengthSize])
-		test.AssertEqual(t, gotKeyLen, uint16(len(r.Key)))
-		offset += keyLengthSize
+	gotKeyLen := binary.LittleEndian.Uint16(data[offset : offset+keyLengthSize])
+	test.AssertEqual(t, gotKeyLen, uint16(len(r.Key)))
+	offset += keyLengthSize
 
-		gotValLen := binary.LittleEndian.Uint32(data[offset : offset+valueLengthSize])
-		test.AssertEqual(t, gotValLen, uint32(len(r.Value)))
-		offset += valueLengthSize
+	gotValLen := binary.LittleEndian.Uint32(data[offset : offset+valueLengthSize])
+	test.AssertEqual(t, gotValLen, uint32(len(r.Value)))
+	offset += valueLengthSize
 
-		gotChecksum := binary.LittleEndian.Uint32(data[offset : offset+checksumSize])
-		test.AssertEqual(t, gotChecksum, r.Checksum())
-		offset += checksumSize
+	gotChecksum := binary.LittleEndian.Uint32(data[offset : offset+checksumSize])
+	test.AssertEqual(t, gotChecksum, r.Checksum())
+	offset += checksumSize
 
-		test.AssertBytesEqual(t, data[offset:offset+len(r.Key)], r.Key)
-		test.AssertBytesEqual(t, data[offset+len(r.Key):], r.Value)
-	}
+	test.AssertBytesEqual(t, data[offset:offset+len(r.Key)], r.Key)
+	test.AssertBytesEqual(t, data[offset+len(r.Key):], r.Value)
+}
 
+func TestEncoder_Encode(t *testing.T) {
 	t.Run("it encodes record with correct binary layout", func(t *testing.T) {
 		buf := new(bytes.Buffer)
 		record := NewValue("Key", []byte("Value"), 1)
 
-		err := NewEncoder(buf).Encode(record)
+		err := NewEncoder(buf, EncoderOptions{}).Encode(record)
 
 		test.AssertNoError(t, err)
 		verifyLayout(t, buf.Bytes(), record)
 	})
 
 	t.Run("it returns error on writer failure", func(t *testing.T) {
-		encoder := NewEncoder(&limitedWriter{limit: 3})
+		encoder := NewEncoder(&limitedWriter{limit: 3}, EncoderOptions{})
 		err := encoder.Encode(NewValue("long-Key", []byte("Value"), 1))
 
 		test.AssertError(t, err, io.ErrShortWrite)
 	})
+
+	t.Run("it refuses records longer than the maximum", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		err := NewEncoder(buf, EncoderOptions{}).Encode(NewValue("Key", make([]byte, MaxRecordSize), 1))
+
+		test.AssertError(t, err, RecordTooLargeError)
+		test.AssertEqual(t, buf.Len(), 0)
+	})
+}
+
+func TestEncoder_Compression(t *testing.T) {
+	compressible := bytes.Repeat([]byte(`{"id":42,"name":"value"},`), 40)
+	options := EncoderOptions{CompressionThreshold: 64}
+
+	t.Run("it compresses values from the threshold", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		encoder := NewEncoder(buf, options)
+
+		err := encoder.Encode(NewValue("Key", compressible, 1))
+		test.AssertNoError(t, err)
+
+		test.AssertEqual(t, buf.Bytes()[kindOffset], Value|compressedFlag)
+		test.AssertTrue(t, buf.Len() < len(compressible))
+		test.AssertEqual(t, encoder.Stats().CompressedRecords, uint64(1))
+		test.AssertTrue(t, encoder.Stats().CompressionRatio() > 5)
+	})
+
+	t.Run("it keeps values below the threshold plain", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		record := NewValue("Key", compressible[:63], 1)
+
+		err := NewEncoder(buf, options).Encode(record)
+		test.AssertNoError(t, err)
+		verifyLayout(t, buf.Bytes(), record)
+	})
+
+	t.Run("it keeps incompressible values plain", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		value := make([]byte, 256)
+		rand.New(rand.NewSource(1)).Read(value)
+		record := NewValue("Key", value, 1)
+
+		encoder := NewEncoder(buf, options)
+		err := encoder.Encode(record)
+		test.AssertNoError(t, err)
+
+		verifyLayout(t, buf.Bytes(), record)
+		test.AssertEqual(t, encoder.Stats().CompressionRatio(), float64(1))
+	})
 }
 
 type limitedWriter struct {
@@ -3,3 +3,5 @@ package record
 import "errors"
 
 var ChecksumMismatchError = errors.New("record: checksum mismatch")
+var CompressedDataCorruptedError = errors.New("record: compressed data corrupted")
+var RecordTooLargeError = errors.New("record: record too large")
@@ -0,0 +1,188 @@
+package record
+
+import "encoding/binary"
+
+// A minimal LZ4 block codec, compatible with the LZ4 block format. Values
+// are compressed one record at a time, so there is no frame format and no
+// dictionary carried between blocks.
+const (
+	lz4MinMatch     = 4
+	lz4HashLog      = 14
+	lz4MaxOffset    = 65535
+	lz4LastLiterals = 5  // the block always ends with at least this many literals
+	lz4MatchLimit   = 12 // no match starts within this many bytes of the end
+)
+
+type lz4Compressor struct {
+	// table maps hashes of 4 byte sequences to their last position plus one,
+	// zero meaning none.
+	table [1 << lz4HashLog]int32
+}
+
+// compress appends the compressed form of src to dst.
+func (c *lz4Compressor) compress(dst, src []byte) []byte {
+	clear(c.table[:])
+
+	anchor := 0
+
+	for i := 0; i+lz4MatchLimit < len(src); {
+		sequence := binary.LittleEndian.Uint32(src[i:])
+		h := lz4Hash(sequence)
+
+		ref := int(c.table[h]) - 1
+		c.table[h] = int32(i + 1)
+
+		if ref < 0 || i-ref > lz4MaxOffset || binary.LittleEndian.Uint32(src[ref:]) != sequence {
+			i++
+			continue
+		}
+
+		for i > anchor && ref > 0 && src[i-1] == src[ref-1] {
+			i--
+			ref--
+		}
+
+		end := i + lz4MinMatch
+		for end < len(src)-lz4LastLiterals && src[end] == src[ref+end-i] {
+			end++
+		}
+
+		dst = lz4AppendSequence(dst, src[anchor:i], i-ref, end-i)
+
+		i = end
+		anchor = end
+	}
+
+	return lz4AppendLiterals(dst, src[anchor:])
+}
+
+// lz4Decompress decompresses src into dst, which has to be exactly as long
+// as the original data.
+func lz4Decompress(dst, src []byte) error {
+	d, s := 0, 0
+
+	for s < len(src) {
+		token := src[s]
+		s++
+
+		literals := int(token >> 4)
+		if literals == 15 {
+			n, next, err := lz4ReadLength(src, s)
+			if err != nil {
+				return err
+			}
+
+			literals += n
+			s = next
+		}
+
+		if literals > len(src)-s || literals > len(dst)-d {
+			return CompressedDataCorruptedError
+		}
+
+		d += copy(dst[d:], src[s:s+literals])
+		s += literals
+
+		if s == len(src) {
+			break
+		}
+
+		if s+2 > len(src) {
+			return CompressedDataCorruptedError
+		}
+
+		offset := int(binary.LittleEndian.Uint16(src[s:]))
+		s += 2
+
+		if offset == 0 || offset > d {
+			return CompressedDataCorruptedError
+		}
+
+		match := int(token & 15)
+		if match == 15 {
+			n, next, err := lz4ReadLength(src, s)
+			if err != nil {
+				return err
+			}
+
+			match += n
+			s = next
+		}
+
+		match += lz4MinMatch
+
+		if match > len(dst)-d {
+			return CompressedDataCorruptedError
+		}
+
+		// Matches may overlap the bytes they produce, so copy byte by byte.
+		for j := range match {
+			dst[d+j] = dst[d-offset+j]
+		}
+
+		d += match
+	}
+
+	if d != len(dst) {
+		return CompressedDataCorruptedError
+	}
+
+	return nil
+}
+
+func lz4Hash(sequence uint32) uint32 {
+	return (sequence * 2654435761) >> (32 - lz4HashLog)
+}
+
+func lz4AppendSequence(dst, literals []byte, offset, match int) []byte {
+	match -= lz4MinMatch
+
+	dst = append(dst, byte(min(len(literals), 15))<<4|byte(min(match, 15)))
+
+	if len(literals) >= 15 {
+		dst = lz4AppendLength(dst, len(literals)-15)
+	}
+
+	dst = append(dst, literals...)
+	dst = binary.LittleEndian.AppendUint16(dst, uint16(offset))
+
+	if match >= 15 {
+		dst = lz4AppendLength(dst, match-15)
+	}
+
+	return dst
+}
+
+func lz4AppendLiterals(dst, literals []byte) []byte {
+	dst = append(dst, byte(min(len(literals), 15))<<4)
+
+	if len(literals) >= 15 {
+		dst = lz4AppendLength(dst, len(literals)-15)
+	}
+
+	return append(dst, literals...)
+}
+
+func lz4AppendLength(dst []byte, n int) []byte {
+	for ; n >= 255; n -= 255 {
+		dst = append(dst, 255)
+	}
+
+	return append(dst, byte(n))
+}
+
+func lz4ReadLength(src []byte, s int) (n int, next int, err error) {
+	for {
+		if s >= len(src) {
+			return 0, 0, CompressedDataCorruptedError
+		}
+
+		b := src[s]
+		s++
+		n += int(b)
+
+		if b != 255 {
+			return n, s, nil
+		}
+	}
+}
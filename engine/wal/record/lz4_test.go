@@ -0,0 +1,53 @@
+package record
+
+import (
+	"bytes"
+	"kv/test"
+	"math/rand"
+	"testing"
+)
+
+func TestLZ4(t *testing.T) {
+	random := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short", []byte("abc")},
+		{"repeated byte", bytes.Repeat([]byte{'a'}, 1000)},
+		{"json", bytes.Repeat([]byte(`{"id":42,"name":"value","tags":["a","b"]},`), 200)},
+		{"random", random},
+		{"random with repeats", append(append(random[:300:300], random[:300]...), random[100:700]...)},
+	}
+
+	for _, tt := range tests {
+		t.Run("it round trips "+tt.name, func(t *testing.T) {
+			compressed := new(lz4Compressor).compress(nil, tt.data)
+
+			decompressed := make([]byte, len(tt.data))
+			test.AssertNoError(t, lz4Decompress(decompressed, compressed))
+			test.AssertBytesEqual(t, decompressed, tt.data)
+		})
+	}
+
+	t.Run("it compresses repetitive data", func(t *testing.T) {
+		data := bytes.Repeat([]byte(`{"id":42,"name":"value"},`), 100)
+		compressed := new(lz4Compressor).compress(nil, data)
+
+		test.AssertTrue(t, len(compressed)*5 < len(data))
+	})
+
+	t.Run("it rejects corrupted data", func(t *testing.T) {
+		data := bytes.Repeat([]byte("abcdefgh"), 100)
+		compressed := new(lz4Compressor).compress(nil, data)
+
+		err := lz4Decompress(make([]byte, len(data)), compressed[:len(compressed)/2])
+		test.AssertError(t, err, CompressedDataCorruptedError)
+
+		err = lz4Decompress(make([]byte, len(data)+1), compressed)
+		test.AssertError(t, err, CompressedDataCorruptedError)
+	})
+}
@@ -1,6 +1,7 @@
 package record
 
 import (
+	"encoding/binary"
 	"hash/crc32"
 	"kv/conversion"
 )
@@ -10,8 +11,41 @@ const (
 	Value
 	Commit
 	Freeze
+	Savepoint
+	RollbackTo
+	Release
+	Abort
 )
 
+// FormatVersion is the version of the record encoding. Segment headers carry
+// it, so that logs written in a newer format are refused instead of misread.
+// Version 2 added compressed values and encrypted records.
+const FormatVersion uint16 = 2
+
+// compressedFlag marks, in the kind byte, a record whose value is stored
+// compressed.
+const compressedFlag uint8 = 0x80
+
+// encryptedFlag, as the whole kind byte, starts a frame holding a sealed
+// record instead of a plain one. The frame header is the flag followed by
+// the length of the sealed record.
+const (
+	encryptedFlag   uint8 = 0x40
+	frameHeaderSize       = kindSize + 4
+)
+
+// additionalData binds a sealed record to the store and to the position of
+// its frame in the log, so that it cannot be moved elsewhere unnoticed.
+func additionalData(dst, storeID []byte, position uint64) []byte {
+	dst = append(dst[:0], storeID...)
+	return binary.LittleEndian.AppendUint64(dst, position)
+}
+
+// MaxRecordSize bounds an encoded record, before it gets sealed. Lengths read
+// from the log are checked against it, so that a corrupted one is reported
+// instead of leading to a huge allocation.
+const MaxRecordSize = 64 << 20
+
 const (
 	kindSize   = 1
 	kindOffset = 0
@@ -52,10 +86,26 @@ func NewCommit(txID uint64) *Record {
 	return newRecord(Commit, "", nil, txID)
 }
 
+func NewAbort(txID uint64) *Record {
+	return newRecord(Abort, "", nil, txID)
+}
+
 func NewFreeze(key string, txID uint64) *Record {
 	return newRecord(Freeze, key, nil, txID)
 }
 
+func NewSavepoint(name string, txID uint64) *Record {
+	return newRecord(Savepoint, name, nil, txID)
+}
+
+func NewRollbackTo(name string, txID uint64) *Record {
+	return newRecord(RollbackTo, name, nil, txID)
+}
+
+func NewRelease(name string, txID uint64) *Record {
+	return newRecord(Release, name, nil, txID)
+}
+
 func (r *Record) Checksum() uint32 {
 	h := crc32.NewIEEE()
 
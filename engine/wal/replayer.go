@@ -5,3 +5,14 @@ import "kv/engine/wal/record"
 type Replayer interface {
 	Replay(apply func(record.Record)) error
 }
+
+// ProgressReplayer is implemented by replayers able to tell how far a replay
+// has advanced. progress is called whenever a segment is finished.
+type ProgressReplayer interface {
+	ReplayWithProgress(apply func(record.Record), progress func(ReplayProgress)) error
+}
+
+type ReplayProgress struct {
+	SegmentsDone  uint64
+	SegmentsTotal uint64
+}
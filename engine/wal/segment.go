@@ -1,86 +1,166 @@
 package wal
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"kv/engine/wal/record"
+	"kv/storage"
 	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 )
 
+// Segment is a single log file, preallocated to hold its whole capacity. The
+// length slots in front of the data record how much of it was written,
+// anything after that is zeroes or left over from a previous use of the file.
+// Its file handle is used for writing only, readers open their own handles.
 type Segment struct {
-	path string
-	file *os.File
+	path    string
+	options SegmentOptions
 
-	size     int64
-	capacity int64
+	size  atomic.Int64
+	keyID atomic.Uint32
+
+	mutex  sync.Mutex
+	file   storage.FileHandle
+	synced int64
+	buf    [segmentDataOffset]byte
+
+	// generation is the one of the latest length slot, zero while the
+	// header is still to be written.
+	generation uint64
 }
 
-func NewSegment(path string, capacity int64) *Segment {
-	return &Segment{
-		capacity: capacity,
-		path:     path,
-		size:     -1,
+// SegmentOptions describe the segment expected at a place in the log. They
+// are written into the header of new segments and checked against the header
+// of existing ones.
+type SegmentOptions struct {
+	FS       storage.FS
+	StoreID  StoreID
+	Seq      uint64
+	StartLSN LSN
+	Capacity int64
+
+	// KeyID is recorded in the header of a new segment.
+	KeyID uint32
+}
+
+func NewSegment(path string, options SegmentOptions) *Segment {
+	s := &Segment{
+		path:    path,
+		options: options,
 	}
+
+	s.size.Store(-1)
+	return s
+}
+
+func (s *Segment) Path() string {
+	return s.path
+}
+
+func (s *Segment) Seq() uint64 {
+	return s.options.Seq
 }
 
-func (s *Segment) init() (*os.File, error) {
-	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+// Capacity returns how many bytes of data the segment holds, not counting
+// its header.
+func (s *Segment) Capacity() int64 {
+	return s.options.Capacity
+}
+
+// FileSize returns the size of the preallocated segment file.
+func (s *Segment) FileSize() int64 {
+	return segmentDataOffset + s.options.Capacity
+}
 
+// Size returns how many bytes of data were written, as recorded by the
+// length slots for segments not written by this process yet. Reading the header
+// validates it, a segment of another store, format or place in the log is
+// refused.
+func (s *Segment) Size() (int64, error) {
+	if size := s.size.Load(); size != -1 {
+		return size, nil
+	}
+
+	size, err := s.readHeader()
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 
-	fi, err := file.Stat()
+	s.size.CompareAndSwap(-1, size)
+	return s.size.Load(), nil
+}
 
-	if err != nil {
-		defer func(file *os.File) {
-			if err := file.Close(); err != nil {
-				// TODO: Proper clean up
-			}
-		}(file)
+func (s *Segment) Space() (int64, error) {
+	size, err := s.Size()
 
-		return nil, err
+	if err != nil {
+		return 0, err
 	}
 
-	s.size = fi.Size()
-	s.file = file
+	return s.options.Capacity - size, nil
+}
 
-	return s.file, nil
+func (s *Segment) IsFull() (bool, error) {
+	space, err := s.Space()
+	return space <= 0, err
 }
 
-func (s *Segment) File() (*os.File, error) {
-	if s.file != nil {
-		return s.file, nil
-	}
+// Create makes sure the segment file exists, even if nothing was written yet.
+func (s *Segment) Create() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-	return s.init()
+	_, err := s.writeHandle()
+	return err
 }
 
-func (s *Segment) Size() (int64, error) {
-	if s.size == -1 {
-		if _, err := s.init(); err != nil {
-			return 0, err
-		}
+// KeyID returns the encryption key recorded in the segment header.
+func (s *Segment) KeyID() (uint32, error) {
+	if _, err := s.Size(); err != nil {
+		return 0, err
 	}
 
-	return s.size, nil
+	return s.keyID.Load(), nil
 }
 
-func (s *Segment) Space() (int64, error) {
-	size, err := s.Size()
+// Reset marks the segment as empty, keeping its file and the space allocated
+// for it. The header is written again, the file may have held another
+// segment.
+func (s *Segment) Reset() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
+	s.size.Store(0)
+	s.keyID.Store(s.options.KeyID)
+
+	file, err := s.writeHandle()
 	if err != nil {
-		return 0, err
+		return err
 	}
 
-	return s.capacity - size, nil
+	s.synced = -1
+	s.generation = 0
+
+	return s.sync(file)
 }
 
-func (s *Segment) Read(buffer []byte) (n int, err error) {
-	file, err := s.File()
+// Truncate drops the data past size. The file keeps its space, only the
+// recorded length stops covering the dropped data.
+func (s *Segment) Truncate(size int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
+	file, err := s.writeHandle()
 	if err != nil {
-		return 0, err
+		return err
 	}
 
-	return file.Read(buffer)
+	s.size.Store(size)
+	return s.sync(file)
 }
 
 func (s *Segment) Write(buffer []byte) (n int, err error) {
@@ -94,7 +174,10 @@ func (s *Segment) Write(buffer []byte) (n int, err error) {
 		return 0, nil
 	}
 
-	file, err := s.File()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	file, err := s.writeHandle()
 	if err != nil {
 		return 0, err
 	}
@@ -103,46 +186,237 @@ func (s *Segment) Write(buffer []byte) (n int, err error) {
 		buffer = buffer[:space]
 	}
 
-	n, err = file.Write(buffer)
-	s.size += int64(n)
+	size := s.size.Load()
+
+	n, err = file.WriteAt(buffer, segmentDataOffset+size)
+	s.size.Add(int64(n))
 
 	return n, err
 }
 
 func (s *Segment) Sync() error {
-	file, err := s.File()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-	if err != nil {
-		return err
+	if s.file == nil {
+		return nil
 	}
 
-	return file.Sync()
+	return s.sync(s.file)
 }
 
 func (s *Segment) Close() error {
-	file, err := s.File()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-	if err != nil {
-		return err
+	if s.file == nil {
+		return nil
 	}
 
-	if err = file.Sync(); err != nil {
+	if err := s.sync(s.file); err != nil {
 		return err
 	}
 
-	if err = file.Close(); err == nil {
+	err := s.file.Close()
+	if err == nil {
 		s.file = nil
 	}
 
 	return err
 }
 
-func (s *Segment) Seek(offset int64, whence int) (int64, error) {
-	file, err := s.File()
+// OpenReader opens a new handle on the segment file. Data starts at
+// DataOffset.
+func (s *Segment) OpenReader() (storage.FileHandle, error) {
+	return s.options.FS.Open(s.path, os.O_RDONLY)
+}
+
+// AllocatedSize returns the size of the segment file, including its header
+// and the space allocated ahead of data.
+func (s *Segment) AllocatedSize() (int64, error) {
+	return storage.FileSize(s.options.FS, s.path)
+}
+
+// DataOffset returns the file offset of the first byte of data.
+func (s *Segment) DataOffset() int64 {
+	return segmentDataOffset
+}
+
+// sync makes written data durable before the length covering it, so the
+// recorded length never covers data that could be lost. The length goes into
+// the slot not holding the latest one, a torn write leaves that one intact.
+func (s *Segment) sync(file storage.FileHandle) error {
+	size := s.size.Load()
+
+	if size == s.synced {
+		return nil
+	}
+
+	if err := file.Datasync(); err != nil {
+		return err
+	}
+
+	var err error
+
+	if s.generation == 0 {
+		clear(s.buf[:])
+		encodeSegmentHeader(s.buf[:], s.expectedHeader())
+		encodeSegmentSlot(s.buf[segmentSlotOffset(1):], size, 1)
+		_, err = file.WriteAt(s.buf[:], 0)
+	} else {
+		slot := s.buf[:segmentSlotSize]
+		encodeSegmentSlot(slot, size, s.generation+1)
+		_, err = file.WriteAt(slot, segmentSlotOffset(s.generation+1))
+	}
 
 	if err != nil {
+		return err
+	}
+
+	if err = file.Datasync(); err != nil {
+		return err
+	}
+
+	s.synced = size
+	s.generation++
+	return nil
+}
+
+// readHeader returns the data length recorded for the segment and picks up
+// the key ID from its header. Segments without a header yet get the key ID
+// of a new segment.
+func (s *Segment) readHeader() (int64, error) {
+	s.keyID.Store(s.options.KeyID)
+
+	file, err := s.options.FS.Open(s.path, os.O_RDONLY)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		_ = file.Close()
+	}()
+
+	var buf [segmentDataOffset]byte
+
+	n, err := file.ReadAt(buf[:], 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0, err
+	}
+
+	// A file that is all zeroes as far as the header goes was being created
+	// when the process stopped, it holds no data yet. Anything else needs a
+	// header and the length slots after it.
+	h, err := decodeSegmentHeader(buf[:])
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", s.path, err)
+	}
+
+	if h == nil {
+		return 0, nil
+	}
+
+	if n < segmentDataOffset {
+		return 0, fmt.Errorf("%s: %w: file of %d bytes ends within the header", s.path, SegmentHeaderCorruptedError, n)
+	}
+
+	if err = h.validate(s.expectedHeader()); err != nil {
+		return 0, fmt.Errorf("%s: %w", s.path, err)
+	}
+
+	length, _, err := decodeSegmentSlots(buf[:], s.options.Capacity)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", s.path, err)
+	}
+
+	s.keyID.Store(h.KeyID)
+	return length, nil
+}
+
+// readGeneration returns the generation of the latest length slot, zero for
+// a file whose header was not written yet.
+func (s *Segment) readGeneration(file storage.FileHandle) (uint64, error) {
+	if _, err := file.ReadAt(s.buf[:], 0); err != nil && !errors.Is(err, io.EOF) {
 		return 0, err
 	}
 
-	return file.Seek(offset, whence)
+	if isZero(s.buf[:segmentHeaderSize]) {
+		return 0, nil
+	}
+
+	_, generation, err := decodeSegmentSlots(s.buf[:], s.options.Capacity)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", s.path, err)
+	}
+
+	return generation, nil
+}
+
+func (s *Segment) expectedHeader() SegmentHeader {
+	return SegmentHeader{
+		Version:      SegmentFormatVersion,
+		RecordFormat: record.FormatVersion,
+		StoreID:      s.options.StoreID,
+		Seq:          s.options.Seq,
+		StartLSN:     s.options.StartLSN,
+		KeyID:        s.keyID.Load(),
+	}
+}
+
+// writeHandle opens the segment file for writing, creating and preallocating
+// it first if needed.
+func (s *Segment) writeHandle() (storage.FileHandle, error) {
+	if s.file != nil {
+		return s.file, nil
+	}
+
+	size, err := s.Size()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := s.options.FS.Open(s.path, os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		return nil, err
+	}
+
+	allocated, err := file.Size()
+	if err != nil {
+		return nil, errors.Join(err, file.Close())
+	}
+
+	if allocated < s.FileSize() {
+		if err = file.Allocate(s.FileSize()); err != nil {
+			return nil, errors.Join(err, file.Close())
+		}
+	}
+
+	s.synced = size
+
+	if s.generation, err = s.readGeneration(file); err != nil {
+		return nil, errors.Join(err, file.Close())
+	}
+
+	// A new file, or one allocated right before a crash, gets its header.
+	if s.generation == 0 {
+		s.keyID.Store(s.options.KeyID)
+		s.synced = -1
+
+		if err = s.sync(file); err != nil {
+			return nil, errors.Join(err, file.Close())
+		}
+
+		// A new file is only there for sure once its directory is synced.
+		if err = s.options.FS.SyncDir(filepath.Dir(s.path)); err != nil {
+			return nil, errors.Join(err, file.Close())
+		}
+	}
+
+	s.file = file
+	return file, nil
 }
@@ -0,0 +1,214 @@
+package wal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"kv/engine/wal/record"
+)
+
+// Every segment file starts with a header identifying it. The file itself is
+// preallocated, so its size says nothing about where the valid data ends,
+// that is recorded by the length slots following the header. Bytes up to the
+// checksum that are not assigned yet are reserved for future fields and kept
+// zero.
+const (
+	segmentMagicOffset        = 0
+	segmentMagicSize          = 4
+	segmentVersionOffset      = segmentMagicOffset + segmentMagicSize
+	segmentVersionSize        = 2
+	segmentRecordFormatOffset = segmentVersionOffset + segmentVersionSize
+	segmentRecordFormatSize   = 2
+	segmentStoreIDOffset      = segmentRecordFormatOffset + segmentRecordFormatSize
+	segmentStoreIDSize        = 16
+	segmentSeqOffset          = segmentStoreIDOffset + segmentStoreIDSize
+	segmentSeqSize            = 8
+	segmentStartLSNOffset     = segmentSeqOffset + segmentSeqSize
+	segmentStartLSNSize       = 8
+	segmentKeyIDOffset        = segmentStartLSNOffset + segmentStartLSNSize
+	segmentKeyIDSize          = 4
+	segmentChecksumOffset     = segmentHeaderSize - segmentChecksumSize
+	segmentChecksumSize       = 4
+	segmentHeaderSize         = 64
+)
+
+// The header is written once, when the segment is created. The length of its
+// data is kept in two slots written in turns, each in a sector of its own so
+// that a write torn within one slot never reaches into the other or the
+// header. The slot with the highest generation and a valid checksum holds the
+// latest length. Data starts after the slots.
+//
+// Each slot is laid out as generation (8 bytes), length (8) and a CRC32 of
+// both.
+const (
+	segmentSectorSize           = 512
+	segmentSlotGenerationOffset = 0
+	segmentSlotGenerationSize   = 8
+	segmentSlotLengthOffset     = segmentSlotGenerationOffset + segmentSlotGenerationSize
+	segmentSlotLengthSize       = 8
+	segmentSlotChecksumOffset   = segmentSlotLengthOffset + segmentSlotLengthSize
+	segmentSlotChecksumSize     = 4
+	segmentSlotSize             = segmentSlotChecksumOffset + segmentSlotChecksumSize
+	segmentDataOffset           = 3 * segmentSectorSize
+)
+
+const segmentMagic = "KVWL"
+
+// SegmentFormatVersion is the version of the segment header layout.
+const SegmentFormatVersion uint16 = 1
+
+type SegmentHeader struct {
+	Version      uint16
+	RecordFormat uint16
+	StoreID      StoreID
+	Seq          uint64
+	StartLSN     LSN
+
+	// KeyID is the encryption key that was active when the segment was
+	// created, zero if encryption was disabled.
+	KeyID uint32
+}
+
+func encodeSegmentHeader(buf []byte, h SegmentHeader) {
+	clear(buf[:segmentHeaderSize])
+
+	copy(buf[segmentMagicOffset:segmentMagicOffset+segmentMagicSize], segmentMagic)
+	binary.LittleEndian.PutUint16(buf[segmentVersionOffset:segmentVersionOffset+segmentVersionSize], h.Version)
+	binary.LittleEndian.PutUint16(buf[segmentRecordFormatOffset:segmentRecordFormatOffset+segmentRecordFormatSize], h.RecordFormat)
+	copy(buf[segmentStoreIDOffset:segmentStoreIDOffset+segmentStoreIDSize], h.StoreID[:])
+	binary.LittleEndian.PutUint64(buf[segmentSeqOffset:segmentSeqOffset+segmentSeqSize], h.Seq)
+	binary.LittleEndian.PutUint64(buf[segmentStartLSNOffset:segmentStartLSNOffset+segmentStartLSNSize], uint64(h.StartLSN))
+	binary.LittleEndian.PutUint32(buf[segmentKeyIDOffset:segmentKeyIDOffset+segmentKeyIDSize], h.KeyID)
+	binary.LittleEndian.PutUint32(buf[segmentChecksumOffset:segmentChecksumOffset+segmentChecksumSize], segmentHeaderChecksum(buf))
+}
+
+// decodeSegmentHeader parses a header, checking only that it is an intact
+// segment header of a version this build can read. An all zero header
+// belongs to a segment that was allocated but not initialized before a
+// crash, it is reported as nil.
+func decodeSegmentHeader(buf []byte) (*SegmentHeader, error) {
+	if isZero(buf[:segmentHeaderSize]) {
+		return nil, nil
+	}
+
+	if string(buf[segmentMagicOffset:segmentMagicOffset+segmentMagicSize]) != segmentMagic {
+		return nil, SegmentMagicMismatchError
+	}
+
+	checksum := binary.LittleEndian.Uint32(buf[segmentChecksumOffset : segmentChecksumOffset+segmentChecksumSize])
+
+	if checksum != segmentHeaderChecksum(buf) {
+		return nil, fmt.Errorf("%w: checksum mismatch", SegmentHeaderCorruptedError)
+	}
+
+	h := &SegmentHeader{
+		Version:      binary.LittleEndian.Uint16(buf[segmentVersionOffset : segmentVersionOffset+segmentVersionSize]),
+		RecordFormat: binary.LittleEndian.Uint16(buf[segmentRecordFormatOffset : segmentRecordFormatOffset+segmentRecordFormatSize]),
+		Seq:          binary.LittleEndian.Uint64(buf[segmentSeqOffset : segmentSeqOffset+segmentSeqSize]),
+		StartLSN:     LSN(binary.LittleEndian.Uint64(buf[segmentStartLSNOffset : segmentStartLSNOffset+segmentStartLSNSize])),
+		KeyID:        binary.LittleEndian.Uint32(buf[segmentKeyIDOffset : segmentKeyIDOffset+segmentKeyIDSize]),
+	}
+
+	copy(h.StoreID[:], buf[segmentStoreIDOffset:segmentStoreIDOffset+segmentStoreIDSize])
+
+	if h.Version == 0 || h.Version > SegmentFormatVersion {
+		return nil, fmt.Errorf("%w: segment format %d, supported up to %d", SegmentVersionError, h.Version, SegmentFormatVersion)
+	}
+
+	if h.RecordFormat == 0 || h.RecordFormat > record.FormatVersion {
+		return nil, fmt.Errorf("%w: record format %d, supported up to %d", SegmentVersionError, h.RecordFormat, record.FormatVersion)
+	}
+
+	return h, nil
+}
+
+// validate checks that a header read from disk belongs to the segment
+// expected at its place in the log.
+func (h *SegmentHeader) validate(expected SegmentHeader) error {
+	if h.StoreID != expected.StoreID {
+		return fmt.Errorf("%w: store %s, expected %s", ForeignSegmentError, h.StoreID, expected.StoreID)
+	}
+
+	if h.Seq != expected.Seq {
+		return fmt.Errorf("%w: sequence %d, expected %d", SegmentHeaderMismatchError, h.Seq, expected.Seq)
+	}
+
+	if h.StartLSN != expected.StartLSN {
+		return fmt.Errorf(
+			"%w: starts at lsn %d, expected %d (was the segment size changed?)",
+			SegmentHeaderMismatchError, h.StartLSN, expected.StartLSN,
+		)
+	}
+
+	return nil
+}
+
+// segmentSlotOffset returns the file offset of the length slot a generation
+// is written to.
+func segmentSlotOffset(generation uint64) int64 {
+	return segmentSectorSize * int64(1+generation%2)
+}
+
+func encodeSegmentSlot(buf []byte, length int64, generation uint64) {
+	binary.LittleEndian.PutUint64(buf[segmentSlotGenerationOffset:segmentSlotGenerationOffset+segmentSlotGenerationSize], generation)
+	binary.LittleEndian.PutUint64(buf[segmentSlotLengthOffset:segmentSlotLengthOffset+segmentSlotLengthSize], uint64(length))
+	binary.LittleEndian.PutUint32(buf[segmentSlotChecksumOffset:segmentSlotChecksumOffset+segmentSlotChecksumSize], segmentSlotChecksum(buf))
+}
+
+// decodeSegmentSlots returns the latest length recorded by the slots of a
+// segment, given the bytes in front of its data. A segment whose first slot
+// write was torn or never happened has no data yet, neither does one whose
+// slots are both unwritten.
+func decodeSegmentSlots(buf []byte, capacity int64) (length int64, generation uint64, err error) {
+	found, unwritten := false, false
+
+	for slot := range 2 {
+		start := segmentSectorSize * (1 + slot)
+		slotBuf := buf[start : start+segmentSlotSize]
+
+		if isZero(slotBuf) {
+			unwritten = true
+			continue
+		}
+
+		checksum := binary.LittleEndian.Uint32(slotBuf[segmentSlotChecksumOffset : segmentSlotChecksumOffset+segmentSlotChecksumSize])
+		if checksum != segmentSlotChecksum(slotBuf) {
+			continue
+		}
+
+		slotGeneration := binary.LittleEndian.Uint64(slotBuf[segmentSlotGenerationOffset : segmentSlotGenerationOffset+segmentSlotGenerationSize])
+
+		if !found || slotGeneration > generation {
+			found, generation = true, slotGeneration
+			length = int64(binary.LittleEndian.Uint64(slotBuf[segmentSlotLengthOffset : segmentSlotLengthOffset+segmentSlotLengthSize]))
+		}
+	}
+
+	if !found && !unwritten {
+		return 0, 0, fmt.Errorf("%w: no intact length slot", SegmentHeaderCorruptedError)
+	}
+
+	if length < 0 || length > capacity {
+		return 0, 0, fmt.Errorf("%w: length %d exceeds capacity %d", SegmentHeaderCorruptedError, length, capacity)
+	}
+
+	return length, generation, nil
+}
+
+func segmentSlotChecksum(buf []byte) uint32 {
+	return crc32.ChecksumIEEE(buf[:segmentSlotChecksumOffset])
+}
+
+func segmentHeaderChecksum(buf []byte) uint32 {
+	return crc32.ChecksumIEEE(buf[:segmentChecksumOffset])
+}
+
+func isZero(buf []byte) bool {
+	for _, b := range buf {
+		if b != 0 {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,94 @@
+package wal
+
+import "errors"
+
+type SegmentInfo struct {
+	Seq      uint64
+	Path     string
+	StartLSN LSN
+	Size     int64
+	Capacity int64
+	KeyID    uint32
+}
+
+// SegmentIterator walks the log one segment at a time, e.g. to copy segment
+// files for a backup. It does not lock the log, segments appended while
+// iterating are picked up as well. Segment sizes are captured by Next, the
+// last segment may keep growing afterwards.
+type SegmentIterator struct {
+	log     *Log
+	next    uint64
+	current SegmentInfo
+	err     error
+}
+
+// Segments returns an iterator starting at the first segment of the log.
+func (l *Log) Segments() *SegmentIterator {
+	start, _ := l.position(l.StartLSN())
+
+	return &SegmentIterator{
+		log:  l,
+		next: start,
+	}
+}
+
+func (it *SegmentIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+
+	segment, _, err := it.log.segment(it.next)
+
+	if errors.Is(err, SegmentNotFoundError) {
+		return false
+	}
+
+	if err != nil {
+		it.err = err
+		return false
+	}
+
+	size, err := segment.Size()
+	if err != nil {
+		it.err = err
+		return false
+	}
+
+	keyID, err := segment.KeyID()
+	if err != nil {
+		it.err = err
+		return false
+	}
+
+	it.current = SegmentInfo{
+		Seq:      segment.Seq(),
+		Path:     segment.Path(),
+		StartLSN: it.log.lsn(segment.Seq(), 0),
+		Size:     size,
+		Capacity: segment.Capacity(),
+		KeyID:    keyID,
+	}
+
+	it.next++
+	return true
+}
+
+func (it *SegmentIterator) Segment() SegmentInfo {
+	return it.current
+}
+
+// Reader returns a reader over the data the current segment held when Next
+// was called.
+func (it *SegmentIterator) Reader() (*LogReader, error) {
+	reader, err := it.log.NewReader(it.current.StartLSN)
+	if err != nil {
+		return nil, err
+	}
+
+	reader.LimitTo(it.current.StartLSN + LSN(it.current.Size))
+	return reader, nil
+}
+
+func (it *SegmentIterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,85 @@
+package wal
+
+import "errors"
+
+// loadRecycled picks up segment files recycled before the last restart.
+func (l *Log) loadRecycled() error {
+	sequences, err := l.listSegments(recycleSegmentSuffix)
+	if err != nil {
+		return err
+	}
+
+	l.recycled = l.recycled[:0]
+
+	for _, seq := range sequences {
+		l.recycled = append(l.recycled, l.segmentPath(seq, recycleSegmentSuffix))
+	}
+
+	return nil
+}
+
+// recycleSegment takes a segment out of the log. Its file is kept for reuse
+// while there is room in the pool and it is fully allocated, otherwise it is
+// removed.
+func (l *Log) recycleSegment(seq uint64) error {
+	path := l.segmentPath(seq, segmentSuffix)
+
+	if len(l.recycled) >= l.options.RecycledSegments {
+		return l.options.FS.Remove(path)
+	}
+
+	segment := l.newSegment(seq)
+
+	allocated, err := segment.AllocatedSize()
+	if err != nil {
+		return err
+	}
+
+	if allocated != segment.FileSize() {
+		return l.options.FS.Remove(path)
+	}
+
+	// Data left in a recycled file stays on disk until it is overwritten, so
+	// only files holding data sealed with the active key are kept.
+	keyID, err := segment.KeyID()
+	if err != nil || keyID != l.options.Keyring.ActiveKeyID() {
+		return l.options.FS.Remove(path)
+	}
+
+	recycledPath := l.segmentPath(seq, recycleSegmentSuffix)
+
+	if err = l.options.FS.Rename(path, recycledPath); err != nil {
+		return err
+	}
+
+	l.recycled = append(l.recycled, recycledPath)
+	return nil
+}
+
+// reuseSegment moves a recycled file into place for the given segment, if
+// one is available. The file is marked empty before it is renamed, so that
+// its old data never shows up in the log, even after a crash.
+func (l *Log) reuseSegment(segment *Segment) error {
+	if len(l.recycled) == 0 {
+		return nil
+	}
+
+	path := l.recycled[len(l.recycled)-1]
+	l.recycled = l.recycled[:len(l.recycled)-1]
+
+	recycled := l.newSegmentAt(path, segment.Seq())
+
+	if err := recycled.Reset(); err != nil {
+		return errors.Join(err, recycled.Close())
+	}
+
+	if err := recycled.Close(); err != nil {
+		return err
+	}
+
+	if err := l.options.FS.Rename(path, segment.Path()); err != nil {
+		return err
+	}
+
+	return l.options.FS.SyncDir(l.options.LogsDirectory)
+}
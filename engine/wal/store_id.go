@@ -0,0 +1,61 @@
+package wal
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// StoreID identifies the store a log belongs to. It is generated once, kept
+// in the manifest and stamped into every segment header.
+type StoreID [16]byte
+
+// NewStoreID returns a random (version 4) UUID.
+func NewStoreID() StoreID {
+	var id StoreID
+	_, _ = rand.Read(id[:])
+
+	id[6] = (id[6] & 0x0f) | 0x40
+	id[8] = (id[8] & 0x3f) | 0x80
+
+	return id
+}
+
+func (id StoreID) IsZero() bool {
+	return id == StoreID{}
+}
+
+func (id StoreID) String() string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:16])
+}
+
+// ParseStoreID parses the form String returns.
+func ParseStoreID(s string) (StoreID, error) {
+	var id StoreID
+
+	digits := strings.ReplaceAll(s, "-", "")
+	if len(digits) != 2*len(id) || strings.Count(s, "-") != 4 {
+		return StoreID{}, fmt.Errorf("wal: malformed store ID %q", s)
+	}
+
+	if _, err := hex.Decode(id[:], []byte(digits)); err != nil {
+		return StoreID{}, fmt.Errorf("wal: malformed store ID %q: %w", s, err)
+	}
+
+	return id, nil
+}
+
+func (id StoreID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+func (id *StoreID) UnmarshalText(text []byte) error {
+	parsed, err := ParseStoreID(string(text))
+	if err != nil {
+		return err
+	}
+
+	*id = parsed
+	return nil
+}
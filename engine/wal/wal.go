@@ -2,7 +2,10 @@ package wal
 
 import (
 	"bufio"
+	"errors"
+	"fmt"
 	"io"
+	"kv/encryption"
 	"kv/engine/wal/record"
 	"kv/storage"
 	"sync"
@@ -18,8 +21,13 @@ type batchCommitContext struct {
 
 type WriteAheadLog struct {
 	file   storage.File
+	log    *Log
 	closed bool
 
+	// committedEnd is the end of the log as of the last commit. Everything
+	// before it is made of whole, durable records.
+	committedEnd LSN
+
 	writer *bufio.Writer
 	mutex  sync.Mutex
 
@@ -33,20 +41,68 @@ type WriteAheadLog struct {
 type Options struct {
 	BatchCommitWaitTime time.Duration
 	WriterBufferSize    int
+
+	// CompressionThreshold is the value size from which records are written
+	// compressed, zero disables compression.
+	CompressionThreshold int
+
+	// Keyring encrypts appended records and decrypts replayed ones, nil
+	// disables encryption.
+	Keyring *encryption.Keyring
+
+	// storeID is bound to encrypted records along with their LSN, it is
+	// taken from the log.
+	storeID StoreID
 }
 
 func NewWriteAheadLog(options Options, file storage.File) *WriteAheadLog {
-	bufferedWriter := bufio.NewWriterSize(file, options.WriterBufferSize)
+	w := &WriteAheadLog{
+		file:   file,
+		writer: bufio.NewWriterSize(file, options.WriterBufferSize),
+	}
+
+	if log, ok := file.(*Log); ok {
+		w.log = log
+		// Segment sizes are already known once the log is loaded.
+		w.committedEnd, _ = log.EndLSN()
+		options.storeID = log.storeID
+	}
+
+	w.options = options
+	w.encoder = record.NewEncoder(w.writer, options.encoderOptions(w.committedEnd))
+	w.decoder = record.NewDecoder(file, options.decoderOptions(0))
+
+	return w
+}
+
+// encoderOptions returns the options of an encoder writing at position.
+func (o Options) encoderOptions(position LSN) record.EncoderOptions {
+	return record.EncoderOptions{
+		CompressionThreshold: o.CompressionThreshold,
+		Keyring:              o.Keyring,
+		StoreID:              o.recordStoreID(),
+		Position:             uint64(position),
+	}
+}
 
-	return &WriteAheadLog{
-		file:    file,
-		writer:  bufferedWriter,
-		encoder: record.NewEncoder(bufferedWriter),
-		decoder: record.NewDecoder(file),
-		options: options,
+// decoderOptions returns the options of a decoder reading from position.
+func (o Options) decoderOptions(position LSN) record.DecoderOptions {
+	return record.DecoderOptions{
+		Keyring:  o.Keyring,
+		StoreID:  o.recordStoreID(),
+		Position: uint64(position),
 	}
 }
 
+// recordStoreID is nil for plain files, which belong to no store.
+func (o Options) recordStoreID() []byte {
+	if o.storeID.IsZero() {
+		return nil
+	}
+
+	return o.storeID[:]
+}
+
 func (w *WriteAheadLog) Append(record *record.Record) error {
 	w.mutex.Lock()
 
@@ -55,6 +111,11 @@ func (w *WriteAheadLog) Append(record *record.Record) error {
 		return WriteAheadLogClosedError
 	}
 
+	if w.log != nil && w.log.options.ReadOnly {
+		w.mutex.Unlock()
+		return storage.ReadOnlyError
+	}
+
 	if err := w.encoder.Encode(record); err != nil {
 		w.mutex.Unlock()
 		return err
@@ -72,7 +133,139 @@ func (w *WriteAheadLog) Append(record *record.Record) error {
 	return currentBatch.err
 }
 
+// Stats returns counters of the records appended since the log was opened.
+func (w *WriteAheadLog) Stats() record.EncoderStats {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.encoder.Stats()
+}
+
+// LogStats describes the log the records are appended to, if the file
+// written to is a Log.
+func (w *WriteAheadLog) LogStats() (LogStats, error) {
+	if w.log == nil {
+		return LogStats{}, NotALogError
+	}
+
+	return w.log.Stats()
+}
+
 func (w *WriteAheadLog) Replay(apply func(record.Record)) error {
+	return w.ReplayWithProgress(apply, nil)
+}
+
+// ReplayWithProgress reads every committed record. When backed by a Log, the
+// replay reads through its own reader and appends may continue meanwhile,
+// records appended after the replay started are not included.
+func (w *WriteAheadLog) ReplayWithProgress(apply func(record.Record), progress func(ReplayProgress)) error {
+	reader, err := w.committedReader()
+	if err != nil {
+		return err
+	}
+
+	if reader == nil {
+		return w.replayFile(apply)
+	}
+
+	defer func() {
+		_ = reader.Close()
+	}()
+
+	decoder := record.NewDecoder(reader, w.options.decoderOptions(reader.LSN()))
+	var last ReplayProgress
+
+	for {
+		var r record.Record
+		recordStart := reader.LSN()
+
+		if err := decoder.Decode(&r); err != nil {
+			if err == io.EOF {
+				break
+			}
+
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				if err = w.truncateTornRecord(recordStart, reader.LSN()); err != nil {
+					return err
+				}
+
+				break
+			}
+
+			return err
+		}
+
+		apply(r)
+
+		if progress != nil {
+			if current := reader.ReadProgress(); current.SegmentsDone != last.SegmentsDone {
+				progress(current)
+				last = current
+			}
+		}
+	}
+
+	if progress != nil {
+		last = reader.ReadProgress()
+		last.SegmentsDone = last.SegmentsTotal
+		progress(last)
+	}
+
+	return nil
+}
+
+func (w *WriteAheadLog) committedReader() (*LogReader, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.log == nil {
+		return nil, nil
+	}
+
+	reader, err := w.log.NewReader(w.log.StartLSN())
+	if err != nil {
+		return nil, err
+	}
+
+	reader.LimitTo(w.committedEnd)
+	return reader, nil
+}
+
+// truncateTornRecord drops a record cut short by a crash at the end of the
+// log, so that appends continue right after the last whole record. Appends
+// made since the replay started would follow the torn record, in which case
+// the log cannot be repaired. A read-only log keeps the record, it may still
+// be in the middle of being written by another process.
+func (w *WriteAheadLog) truncateTornRecord(start, end LSN) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.log.options.ReadOnly {
+		w.committedEnd = start
+		return nil
+	}
+
+	current, err := w.log.EndLSN()
+	if err != nil {
+		return err
+	}
+
+	if current != end {
+		return fmt.Errorf("%w: torn record at %d", io.ErrUnexpectedEOF, start)
+	}
+
+	if err = w.log.Truncate(start); err != nil {
+		return err
+	}
+
+	w.committedEnd = start
+	w.encoder.SetPosition(uint64(start))
+	return nil
+}
+
+// replayFile replays a plain file, holding the lock since reading moves the
+// same file offset appends rely on.
+func (w *WriteAheadLog) replayFile(apply func(record.Record)) error {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
 
@@ -80,6 +273,8 @@ func (w *WriteAheadLog) Replay(apply func(record.Record)) error {
 		return err
 	}
 
+	w.decoder.SetPosition(0)
+
 	for {
 		var r record.Record
 
@@ -94,7 +289,8 @@ func (w *WriteAheadLog) Replay(apply func(record.Record)) error {
 		apply(r)
 	}
 
-	_, err := w.file.Seek(0, io.SeekEnd)
+	end, err := w.file.Seek(0, io.SeekEnd)
+	w.encoder.SetPosition(uint64(end))
 	return err
 }
 
@@ -130,7 +326,20 @@ func (w *WriteAheadLog) commit() error {
 		return err
 	}
 
-	return w.file.Sync()
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+
+	if w.log != nil {
+		end, err := w.log.EndLSN()
+		if err != nil {
+			return err
+		}
+
+		w.committedEnd = end
+	}
+
+	return nil
 }
 
 func (w *WriteAheadLog) finalizeBatchCommit() {
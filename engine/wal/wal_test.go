@@ -3,8 +3,10 @@ package wal
 import (
 	"bytes"
 	"fmt"
+	"kv/encryption"
 	"kv/engine/wal/record"
 	"kv/observability"
+	"kv/storage"
 	"kv/storage/mocks"
 	"kv/test"
 	"strconv"
@@ -130,6 +132,224 @@ func TestWriteAheadLog_Replay(t *testing.T) {
 		test.AssertEqual(t, firstReplayResult[1], secondReplayResult[1])
 		test.AssertEqual(t, firstReplayResult[2], secondReplayResult[2])
 	})
+
+	t.Run("it replays compressed records", func(t *testing.T) {
+		file := mocks.NewFile()
+		wal := NewWriteAheadLog(Options{
+			BatchCommitWaitTime:  commitWaitTime,
+			WriterBufferSize:     4096,
+			CompressionThreshold: 64,
+		}, file)
+
+		compressed := record.NewValue("key1", bytes.Repeat([]byte(`{"field":"value"},`), 50), 1)
+		plain := record.NewValue("key2", []byte("value2"), 1)
+		_ = wal.Append(compressed)
+		_ = wal.Append(plain)
+
+		got := make([]*record.Record, 0)
+		err := wal.Replay(func(r record.Record) {
+			got = append(got, &r)
+		})
+
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, len(got), 2)
+		test.AssertEqual(t, got[0], compressed)
+		test.AssertEqual(t, got[1], plain)
+
+		stats := wal.Stats()
+		test.AssertEqual(t, stats.Records, uint64(2))
+		test.AssertEqual(t, stats.CompressedRecords, uint64(1))
+		test.AssertTrue(t, stats.CompressionRatio() > 1)
+	})
+}
+
+func TestWriteAheadLog_ReplayWithProgress(t *testing.T) {
+	opts := Options{
+		BatchCommitWaitTime: time.Millisecond,
+		WriterBufferSize:    4096,
+	}
+
+	t.Run("it reports finished segments", func(t *testing.T) {
+		log, err := NewLog(NewManifest(mocks.NewFile()), LogOptions{
+			FS:            storage.NewMemFS(),
+			LogsDirectory: testLogsDirectory,
+			SegmentSize:   64,
+		})
+		test.AssertNoError(t, err)
+
+		wal := NewWriteAheadLog(opts, log)
+
+		for i := range 10 {
+			_ = wal.Append(record.NewValue("key-"+strconv.Itoa(i), []byte("value"), 2))
+		}
+
+		var reported []ReplayProgress
+		err = wal.ReplayWithProgress(func(record.Record) {}, func(p ReplayProgress) {
+			reported = append(reported, p)
+		})
+		test.AssertNoError(t, err)
+
+		last := reported[len(reported)-1]
+		test.AssertTrue(t, len(reported) > 1)
+		test.AssertTrue(t, last.SegmentsTotal > 1)
+		test.AssertEqual(t, last.SegmentsDone, last.SegmentsTotal)
+	})
+}
+
+func TestWriteAheadLog_ReplayLog(t *testing.T) {
+	opts := Options{
+		BatchCommitWaitTime: time.Millisecond,
+		WriterBufferSize:    4096,
+	}
+
+	setupWal := func(t *testing.T) *WriteAheadLog {
+		t.Helper()
+
+		log, err := NewLog(NewManifest(mocks.NewFile()), LogOptions{
+			FS:            storage.NewMemFS(),
+			LogsDirectory: testLogsDirectory,
+			SegmentSize:   64,
+		})
+		test.AssertNoError(t, err)
+
+		return NewWriteAheadLog(opts, log)
+	}
+
+	t.Run("it allows appends while replaying", func(t *testing.T) {
+		wal := setupWal(t)
+		_ = wal.Append(record.NewValue("key1", []byte("value1"), 2))
+
+		replayed := 0
+		err := wal.Replay(func(r record.Record) {
+			replayed++
+			test.AssertNoError(t, wal.Append(record.NewValue("key2", []byte("value2"), 2)))
+		})
+
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, replayed, 1)
+	})
+
+	t.Run("it replays records appended before the replay started", func(t *testing.T) {
+		wal := setupWal(t)
+
+		for i := range 20 {
+			_ = wal.Append(record.NewValue("key-"+strconv.Itoa(i), []byte("value"), 2))
+		}
+
+		replayed := 0
+		err := wal.Replay(func(r record.Record) {
+			replayed++
+		})
+
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, replayed, 20)
+	})
+}
+
+func TestWriteAheadLog_TornRecord(t *testing.T) {
+	opts := Options{
+		BatchCommitWaitTime: time.Millisecond,
+		WriterBufferSize:    4096,
+	}
+
+	open := func(t *testing.T, fs storage.FS, manifest *Manifest) *WriteAheadLog {
+		t.Helper()
+
+		log, err := NewLog(manifest, LogOptions{
+			FS:            fs,
+			LogsDirectory: testLogsDirectory,
+			SegmentSize:   64,
+		})
+		test.AssertNoError(t, err)
+
+		return NewWriteAheadLog(opts, log)
+	}
+
+	replayKeys := func(t *testing.T, wal *WriteAheadLog) []string {
+		t.Helper()
+
+		var keys []string
+		err := wal.Replay(func(r record.Record) {
+			keys = append(keys, string(r.Key))
+		})
+		test.AssertNoError(t, err)
+
+		return keys
+	}
+
+	t.Run("it drops a record torn at the end of the log", func(t *testing.T) {
+		fs := storage.NewMemFS()
+		manifest := NewManifest(mocks.NewFile())
+
+		wal := open(t, fs, manifest)
+		for i := range 5 {
+			_ = wal.Append(record.NewValue("key-"+strconv.Itoa(i), []byte("value"), 1))
+		}
+		_ = wal.Close()
+
+		var last SegmentInfo
+		it := wal.log.Segments()
+		for it.Next() {
+			last = it.Segment()
+		}
+		test.AssertNoError(t, it.Err())
+
+		rewriteSegmentLength(t, fs, last.Path, func(length int64) int64 {
+			return length - 3
+		})
+
+		wal = open(t, fs, manifest)
+		test.AssertEqual(t, len(replayKeys(t, wal)), 4)
+
+		_ = wal.Append(record.NewValue("key-5", []byte("value"), 1))
+		_ = wal.Close()
+
+		keys := replayKeys(t, open(t, fs, manifest))
+		test.AssertEqual(t, len(keys), 5)
+		test.AssertEqual(t, keys[4], "key-5")
+	})
+
+	t.Run("it leaves a torn record in place when read-only", func(t *testing.T) {
+		fs := storage.NewMemFS()
+		manifest := NewManifest(mocks.NewFile())
+
+		wal := open(t, fs, manifest)
+		for i := range 5 {
+			_ = wal.Append(record.NewValue("key-"+strconv.Itoa(i), []byte("value"), 1))
+		}
+		_ = wal.Close()
+
+		var last SegmentInfo
+		it := wal.log.Segments()
+		for it.Next() {
+			last = it.Segment()
+		}
+		test.AssertNoError(t, it.Err())
+
+		rewriteSegmentLength(t, fs, last.Path, func(length int64) int64 {
+			return length - 3
+		})
+
+		before, err := storage.ReadFile(fs, last.Path)
+		test.AssertNoError(t, err)
+
+		log, err := NewLog(manifest, LogOptions{
+			FS:            storage.NewReadOnlyFS(fs),
+			LogsDirectory: testLogsDirectory,
+			SegmentSize:   64,
+			ReadOnly:      true,
+		})
+		test.AssertNoError(t, err)
+
+		readOnly := NewWriteAheadLog(opts, log)
+		test.AssertEqual(t, len(replayKeys(t, readOnly)), 4)
+		test.AssertError(t, readOnly.Append(record.NewValue("key-5", []byte("value"), 1)), storage.ReadOnlyError)
+		test.AssertNoError(t, readOnly.Close())
+
+		after, err := storage.ReadFile(fs, last.Path)
+		test.AssertNoError(t, err)
+		test.AssertBytesEqual(t, after, before)
+	})
 }
 
 func TestWriteAheadLog_Close(t *testing.T) {
@@ -156,6 +376,111 @@ func TestWriteAheadLog_Close(t *testing.T) {
 	})
 }
 
+func TestWriteAheadLog_Compact(t *testing.T) {
+	opts := Options{
+		BatchCommitWaitTime: time.Millisecond,
+		WriterBufferSize:    4096,
+	}
+
+	setupLog := func(t *testing.T, fs storage.FS, manifestFile *mocks.File) *Log {
+		t.Helper()
+
+		log, err := NewLog(NewManifest(manifestFile), LogOptions{
+			FS:            fs,
+			LogsDirectory: testLogsDirectory,
+			SegmentSize:   64,
+		})
+		test.AssertNoError(t, err)
+
+		return log
+	}
+
+	replayAll := func(t *testing.T, wal *WriteAheadLog) []record.Record {
+		t.Helper()
+
+		var got []record.Record
+		err := wal.Replay(func(r record.Record) {
+			got = append(got, r)
+		})
+		test.AssertNoError(t, err)
+
+		return got
+	}
+
+	t.Run("it drops records of aborted transactions", func(t *testing.T) {
+		wal := NewWriteAheadLog(opts, setupLog(t, storage.NewMemFS(), mocks.NewFile()))
+
+		_ = wal.Append(record.NewValue("key1", []byte("value1"), 2))
+		_ = wal.Append(record.NewValue("key2", []byte("value2"), 3))
+		_ = wal.Append(record.NewCommit(2))
+		_ = wal.Append(record.NewAbort(3))
+
+		err := wal.Compact()
+		test.AssertNoError(t, err)
+
+		got := replayAll(t, wal)
+		test.AssertEqual(t, len(got), 2)
+		test.AssertEqual(t, got[0].TxID, uint64(2))
+		test.AssertEqual(t, got[1].Kind, record.Commit)
+	})
+
+	t.Run("it keeps records of a transaction aborted after its commit", func(t *testing.T) {
+		wal := NewWriteAheadLog(opts, setupLog(t, storage.NewMemFS(), mocks.NewFile()))
+
+		_ = wal.Append(record.NewValue("key1", []byte("value1"), 2))
+		_ = wal.Append(record.NewCommit(2))
+		_ = wal.Append(record.NewAbort(2))
+
+		err := wal.Compact()
+		test.AssertNoError(t, err)
+
+		got := replayAll(t, wal)
+		test.AssertEqual(t, len(got), 3)
+		test.AssertBytesEqual(t, got[0].Key, []byte("key1"))
+		test.AssertEqual(t, got[1].Kind, record.Commit)
+	})
+
+	t.Run("it keeps appending after compaction", func(t *testing.T) {
+		wal := NewWriteAheadLog(opts, setupLog(t, storage.NewMemFS(), mocks.NewFile()))
+
+		_ = wal.Append(record.NewValue("key1", []byte("value1"), 2))
+		_ = wal.Append(record.NewAbort(2))
+		_ = wal.Compact()
+		_ = wal.Append(record.NewValue("key2", []byte("value2"), 3))
+
+		got := replayAll(t, wal)
+		test.AssertEqual(t, len(got), 1)
+		test.AssertBytesEqual(t, got[0].Key, []byte("key2"))
+	})
+
+	t.Run("it preserves compacted log after reopening", func(t *testing.T) {
+		fs := storage.NewMemFS()
+		manifestFile := mocks.NewFile()
+		wal := NewWriteAheadLog(opts, setupLog(t, fs, manifestFile))
+
+		for i := range 10 {
+			_ = wal.Append(record.NewValue("key-"+strconv.Itoa(i), []byte("value"), uint64(i+2)))
+			_ = wal.Append(record.NewCommit(uint64(i + 2)))
+		}
+
+		_ = wal.Append(record.NewValue("key", []byte("value"), 100))
+		_ = wal.Append(record.NewAbort(100))
+		_ = wal.Compact()
+		_ = wal.Close()
+
+		reopened := NewWriteAheadLog(opts, setupLog(t, fs, manifestFile))
+		got := replayAll(t, reopened)
+		test.AssertEqual(t, len(got), 20)
+	})
+
+	t.Run("it returns error if file cannot be rewritten", func(t *testing.T) {
+		wal := NewWriteAheadLog(opts, mocks.NewFile())
+
+		err := wal.Compact()
+		test.AssertError(t, err, CompactionNotSupportedError)
+	})
+}
+
 func awaitSync(t *testing.T, channel chan error, timeout time.Duration) {
 	t.Helper()
 
@@ -192,3 +517,153 @@ func assertNotSynced(t *testing.T, file *mocks.File) {
 		t.Error("expected file not to be synced")
 	}
 }
+
+func TestWriteAheadLog_Encryption(t *testing.T) {
+	const (
+		key1 = "1:000102030405060708090a0b0c0d0e0f"
+		key2 = "2:101112131415161718191a1b1c1d1e1f"
+	)
+
+	open := func(t *testing.T, fs storage.FS, manifestFile *mocks.File, keys string) (*WriteAheadLog, *Log) {
+		t.Helper()
+
+		keyring, err := encryption.ParseKeyring(keys)
+		test.AssertNoError(t, err)
+
+		log, err := NewLog(NewEncryptedManifest(manifestFile, keyring), LogOptions{
+			FS:               fs,
+			LogsDirectory:    testLogsDirectory,
+			SegmentSize:      64,
+			RecycledSegments: 4,
+			Keyring:          keyring,
+		})
+		test.AssertNoError(t, err)
+
+		return NewWriteAheadLog(Options{
+			BatchCommitWaitTime: time.Millisecond,
+			WriterBufferSize:    4096,
+			Keyring:             keyring,
+		}, log), log
+	}
+
+	replayValues := func(t *testing.T, wal *WriteAheadLog) []string {
+		t.Helper()
+
+		var got []string
+		err := wal.Replay(func(r record.Record) {
+			got = append(got, string(r.Value))
+		})
+		test.AssertNoError(t, err)
+
+		return got
+	}
+
+	keyIDs := func(t *testing.T, log *Log) []uint32 {
+		t.Helper()
+
+		var ids []uint32
+		it := log.Segments()
+		for it.Next() {
+			ids = append(ids, it.Segment().KeyID)
+		}
+		test.AssertNoError(t, it.Err())
+
+		return ids
+	}
+
+	t.Run("it keeps records encrypted on disk", func(t *testing.T) {
+		fs := storage.NewMemFS()
+		manifestFile := mocks.NewFile()
+
+		wal, log := open(t, fs, manifestFile, key1)
+		for i := range 5 {
+			_ = wal.Append(record.NewValue("key", []byte("secret-"+strconv.Itoa(i)), 1))
+		}
+		_ = wal.Close()
+
+		it := log.Segments()
+		for it.Next() {
+			data, err := storage.ReadFile(fs, it.Segment().Path)
+			test.AssertNoError(t, err)
+			test.AssertFalse(t, bytes.Contains(data, []byte("secret")))
+			test.AssertEqual(t, it.Segment().KeyID, uint32(1))
+		}
+
+		wal, _ = open(t, fs, manifestFile, key1)
+		test.AssertEqual(t, len(replayValues(t, wal)), 5)
+	})
+
+	t.Run("it refuses to open segments without their key", func(t *testing.T) {
+		fs := storage.NewMemFS()
+		manifestFile := mocks.NewFile()
+
+		wal, _ := open(t, fs, manifestFile, key1)
+		_ = wal.Append(record.NewValue("key", []byte("secret"), 1))
+		_ = wal.Close()
+
+		keyring, _ := encryption.ParseKeyring(key2)
+		_, err := NewLog(NewEncryptedManifest(manifestFile, keyring), LogOptions{
+			FS:            fs,
+			LogsDirectory: testLogsDirectory,
+			SegmentSize:   64,
+			Keyring:       keyring,
+		})
+		test.AssertError(t, err, encryption.KeyNotFoundError)
+	})
+
+	t.Run("it re-encrypts the log with a rotated key", func(t *testing.T) {
+		fs := storage.NewMemFS()
+		manifestFile := mocks.NewFile()
+
+		wal, _ := open(t, fs, manifestFile, key1)
+		for i := range 5 {
+			_ = wal.Append(record.NewValue("key", []byte("secret-"+strconv.Itoa(i)), 1))
+		}
+		_ = wal.Close()
+
+		wal, log := open(t, fs, manifestFile, key1+","+key2)
+		test.AssertNoError(t, wal.Reencrypt())
+		for _, id := range keyIDs(t, log) {
+			test.AssertEqual(t, id, uint32(2))
+		}
+
+		recycled, _ := log.listSegments(recycleSegmentSuffix)
+		test.AssertEqual(t, len(recycled), 0)
+		_ = wal.Close()
+
+		wal, _ = open(t, fs, manifestFile, key2)
+		test.AssertEqual(t, len(replayValues(t, wal)), 5)
+	})
+
+	t.Run("it appends where the log continues after a rewrite or a torn record", func(t *testing.T) {
+		fs := storage.NewMemFS()
+		manifestFile := mocks.NewFile()
+
+		wal, log := open(t, fs, manifestFile, key1)
+		for i := range 3 {
+			_ = wal.Append(record.NewValue("key", []byte("secret-"+strconv.Itoa(i)), 1))
+		}
+		test.AssertNoError(t, wal.Reencrypt())
+		_ = wal.Append(record.NewValue("key", []byte("secret-3"), 1))
+		_ = wal.Close()
+
+		var last SegmentInfo
+		it := log.Segments()
+		for it.Next() {
+			last = it.Segment()
+		}
+		test.AssertNoError(t, it.Err())
+
+		rewriteSegmentLength(t, fs, last.Path, func(length int64) int64 {
+			return length - 3
+		})
+
+		wal, _ = open(t, fs, manifestFile, key1)
+		test.AssertEqual(t, len(replayValues(t, wal)), 3)
+		_ = wal.Append(record.NewValue("key", []byte("secret-4"), 1))
+		_ = wal.Close()
+
+		wal, _ = open(t, fs, manifestFile, key1)
+		test.AssertEqual(t, replayValues(t, wal)[3], "secret-4")
+	})
+}
@@ -2,7 +2,10 @@ module kv
 
 go 1.25
 
-require github.com/rs/zerolog v1.34.0
+require (
+	github.com/rs/zerolog v1.34.0
+	golang.org/x/term v0.39.0
+)
 
 require (
 	github.com/mattn/go-colorable v0.1.14 // indirect
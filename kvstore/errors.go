@@ -5,4 +5,5 @@ import "errors"
 var (
 	ErrKeyTooLong   = errors.New("key too long")
 	ErrValueTooLong = errors.New("value too long")
+	ErrReadOnly     = errors.New("store is read-only")
 )
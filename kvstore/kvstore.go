@@ -1,11 +1,15 @@
 package kvstore
 
 import (
+	"errors"
 	"kv/engine/tx"
 )
 
 type Options struct {
 	Validation ValidationOptions
+
+	// ReadOnly refuses changes, reads work as usual.
+	ReadOnly bool
 }
 
 type ValidationOptions struct {
@@ -34,7 +38,42 @@ func (s *KVStore) Get(key string, transaction *tx.Transaction) ([]byte, error) {
 	return value, err
 }
 
+// GetForUpdate is Get taking the lock on the key first, see Lock.
+func (s *KVStore) GetForUpdate(key string, transaction *tx.Transaction) ([]byte, error) {
+	if err := s.validateKey(key); err != nil {
+		return nil, err
+	}
+
+	value, err := s.store.GetForUpdate(key, transaction)
+	if isLockError(err) {
+		transaction.Abort()
+	}
+
+	return value, err
+}
+
+// Lock takes the lock on the key until the transaction ends: others writing
+// to it wait instead of failing with a serialization error, and the
+// transaction reads and writes its latest version. Like Set, a failure aborts
+// the transaction, which releases the locks it held.
+func (s *KVStore) Lock(key string, transaction *tx.Transaction) error {
+	if err := s.validateKey(key); err != nil {
+		return err
+	}
+
+	if err := s.store.Lock(key, transaction); err != nil {
+		transaction.Abort()
+		return err
+	}
+
+	return nil
+}
+
 func (s *KVStore) Set(key string, value []byte, transaction *tx.Transaction) error {
+	if s.options.ReadOnly {
+		return ErrReadOnly
+	}
+
 	if err := s.validateKey(key); err != nil {
 		return err
 	}
@@ -52,6 +91,10 @@ func (s *KVStore) Set(key string, value []byte, transaction *tx.Transaction) err
 }
 
 func (s *KVStore) Delete(key string, transaction *tx.Transaction) error {
+	if s.options.ReadOnly {
+		return ErrReadOnly
+	}
+
 	if err := s.validateKey(key); err != nil {
 		return err
 	}
@@ -64,6 +107,10 @@ func (s *KVStore) Delete(key string, transaction *tx.Transaction) error {
 	return nil
 }
 
+func isLockError(err error) bool {
+	return errors.Is(err, tx.DeadlockError) || errors.Is(err, tx.LockTimeoutError)
+}
+
 func (s *KVStore) validateKey(key string) error {
 	if len(key) > s.options.Validation.MaxKeySize {
 		return ErrKeyTooLong
@@ -0,0 +1,172 @@
+package kvstore
+
+import (
+	"errors"
+	"fmt"
+	"kv/engine/mvcc"
+	"kv/engine/tx"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var RetriesExhaustedError = errors.New("kvstore: retries exhausted")
+
+// maxCountedRetries is the last bucket of RetryStats.RetriesPerCall, calls
+// retried more often are counted in it as well.
+const maxCountedRetries = 8
+
+// RetryPolicy says how often and how far apart RunInTransaction tries again.
+// The wait before the n-th retry is InitialBackoff times Multiplier to the
+// (n-1)-th power, capped at MaxBackoff, and lowered by a random share of up
+// to Jitter of it so that conflicting callers spread out.
+type RetryPolicy struct {
+	// MaxAttempts counts the first attempt, 1 never retries.
+	MaxAttempts int
+
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+
+	// Jitter is between 0 and 1.
+	Jitter float64
+
+	// Retryable tells errors worth another attempt from fatal ones, nil for
+	// IsRetryable.
+	Retryable func(err error) bool
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     100 * time.Millisecond,
+		Multiplier:     2,
+		Jitter:         0.5,
+	}
+}
+
+// IsRetryable reports whether err comes from a conflict with another
+// transaction or a deadlock with others, which a new transaction may not run
+// into.
+func IsRetryable(err error) bool {
+	return errors.Is(err, mvcc.SerializationError) || errors.Is(err, tx.DeadlockError)
+}
+
+func (p RetryPolicy) isRetryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+
+	return IsRetryable(err)
+}
+
+// backoff is the wait before the given retry, counting from 1.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	wait := float64(p.InitialBackoff)
+
+	for i := 1; i < retry && wait < float64(p.MaxBackoff); i++ {
+		wait *= p.Multiplier
+	}
+
+	wait = min(wait, float64(p.MaxBackoff))
+	wait -= wait * p.Jitter * rand.Float64()
+
+	return time.Duration(wait)
+}
+
+// RetryStats count the calls of RunInTransaction since the retrier was
+// created. RetriesPerCall[n] is how many calls needed n retries, the last
+// bucket also holds those that needed more.
+type RetryStats struct {
+	Calls          uint64
+	Retries        uint64
+	Exhausted      uint64
+	Fatal          uint64
+	RetriesPerCall [maxCountedRetries + 1]uint64
+}
+
+type Retrier struct {
+	txManager *tx.Manager
+
+	calls          atomic.Uint64
+	retries        atomic.Uint64
+	exhausted      atomic.Uint64
+	fatal          atomic.Uint64
+	retriesPerCall [maxCountedRetries + 1]atomic.Uint64
+}
+
+func NewRetrier(txManager *tx.Manager) *Retrier {
+	return &Retrier{txManager: txManager}
+}
+
+// RunInTransaction runs fn in a transaction of its own and commits it. When
+// fn or the commit fails, the transaction is aborted, and tried again in a new
+// one if the policy finds the error retryable and allows for more attempts.
+// Fatal errors are returned as they are, the last one wrapped in
+// RetriesExhaustedError once the attempts run out.
+func (r *Retrier) RunInTransaction(fn func(transaction *tx.Transaction) error, policy RetryPolicy) error {
+	retry := 0
+	defer func() { r.record(retry) }()
+
+	for {
+		err := r.runOnce(fn)
+		if err == nil {
+			return nil
+		}
+
+		if !policy.isRetryable(err) {
+			r.fatal.Add(1)
+			return err
+		}
+
+		if retry+1 >= policy.MaxAttempts {
+			r.exhausted.Add(1)
+			return fmt.Errorf("%w after %d attempts: %w", RetriesExhaustedError, retry+1, err)
+		}
+
+		retry++
+		time.Sleep(policy.backoff(retry))
+	}
+}
+
+func (r *Retrier) runOnce(fn func(transaction *tx.Transaction) error) error {
+	transaction, err := r.txManager.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err = fn(transaction); err != nil {
+		transaction.Abort()
+		return err
+	}
+
+	return transaction.Commit()
+}
+
+func (r *Retrier) record(retries int) {
+	r.calls.Add(1)
+	r.retries.Add(uint64(retries))
+	r.retriesPerCall[min(retries, maxCountedRetries)].Add(1)
+
+	if retries > 0 {
+		log.Debug().Int("retries", retries).Msg("kvstore: transaction retried")
+	}
+}
+
+func (r *Retrier) Stats() RetryStats {
+	stats := RetryStats{
+		Calls:     r.calls.Load(),
+		Retries:   r.retries.Load(),
+		Exhausted: r.exhausted.Load(),
+		Fatal:     r.fatal.Load(),
+	}
+
+	for i := range r.retriesPerCall {
+		stats.RetriesPerCall[i] = r.retriesPerCall[i].Load()
+	}
+
+	return stats
+}
@@ -0,0 +1,136 @@
+package kvstore
+
+import (
+	"errors"
+	"kv/engine/mvcc"
+	"kv/engine/tx"
+	"kv/engine/wal/record"
+	storagemocks "kv/storage/mocks"
+	"kv/test"
+	"testing"
+	"time"
+)
+
+type discardAppender struct{}
+
+func (discardAppender) Append(*record.Record) error {
+	return nil
+}
+
+func setupRetrier() (*Retrier, *tx.Manager) {
+	txManager := tx.NewManager(tx.NewManifest(storagemocks.NewFile()), discardAppender{}, tx.ManagerOptions{
+		ReservedIDsPerBatch:   1000,
+		MaxActiveTransactions: 1000,
+	})
+
+	return NewRetrier(txManager), txManager
+}
+
+func testRetryPolicy(maxAttempts int) RetryPolicy {
+	return RetryPolicy{MaxAttempts: maxAttempts, Multiplier: 2}
+}
+
+func TestRetrier_RunInTransaction(t *testing.T) {
+	t.Run("it commits after the conflicts stop", func(t *testing.T) {
+		retrier, txManager := setupRetrier()
+		attempts := 0
+
+		err := retrier.RunInTransaction(func(transaction *tx.Transaction) error {
+			attempts++
+			if attempts < 3 {
+				return mvcc.SerializationError
+			}
+
+			return nil
+		}, testRetryPolicy(5))
+
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, attempts, 3)
+		test.AssertEqual(t, txManager.ActiveCount(), 0)
+
+		stats := retrier.Stats()
+		test.AssertEqual(t, stats.Calls, uint64(1))
+		test.AssertEqual(t, stats.Retries, uint64(2))
+		test.AssertEqual(t, stats.RetriesPerCall[2], uint64(1))
+	})
+
+	t.Run("it gives up after the max attempts", func(t *testing.T) {
+		retrier, txManager := setupRetrier()
+		attempts := 0
+
+		err := retrier.RunInTransaction(func(transaction *tx.Transaction) error {
+			attempts++
+			return mvcc.SerializationError
+		}, testRetryPolicy(3))
+
+		test.AssertError(t, err, RetriesExhaustedError)
+		test.AssertError(t, err, mvcc.SerializationError)
+		test.AssertEqual(t, attempts, 3)
+		test.AssertEqual(t, txManager.ActiveCount(), 0)
+		test.AssertEqual(t, retrier.Stats().Exhausted, uint64(1))
+	})
+
+	t.Run("it does not retry fatal errors", func(t *testing.T) {
+		retrier, txManager := setupRetrier()
+		attempts := 0
+
+		err := retrier.RunInTransaction(func(transaction *tx.Transaction) error {
+			attempts++
+			return ErrKeyTooLong
+		}, testRetryPolicy(3))
+
+		test.AssertError(t, err, ErrKeyTooLong)
+		test.AssertFalse(t, errors.Is(err, RetriesExhaustedError))
+		test.AssertEqual(t, attempts, 1)
+		test.AssertEqual(t, txManager.ActiveCount(), 0)
+		test.AssertEqual(t, retrier.Stats().Fatal, uint64(1))
+	})
+
+	t.Run("it classifies errors with the policy", func(t *testing.T) {
+		retrier, _ := setupRetrier()
+		attempts := 0
+
+		policy := testRetryPolicy(2)
+		policy.Retryable = func(err error) bool { return errors.Is(err, ErrKeyTooLong) }
+
+		err := retrier.RunInTransaction(func(transaction *tx.Transaction) error {
+			attempts++
+			return ErrKeyTooLong
+		}, policy)
+
+		test.AssertError(t, err, RetriesExhaustedError)
+		test.AssertEqual(t, attempts, 2)
+	})
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     5 * time.Millisecond,
+		Multiplier:     2,
+	}
+
+	tests := []struct {
+		retry int
+		want  time.Duration
+	}{
+		{1, time.Millisecond},
+		{2, 2 * time.Millisecond},
+		{3, 4 * time.Millisecond},
+		{4, 5 * time.Millisecond},
+		{40, 5 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		test.AssertEqual(t, policy.backoff(tt.retry), tt.want)
+	}
+
+	t.Run("it lowers the wait by up to the jitter", func(t *testing.T) {
+		policy.Jitter = 0.5
+
+		for range 100 {
+			wait := policy.backoff(3)
+			test.AssertTrue(t, wait > 2*time.Millisecond && wait <= 4*time.Millisecond)
+		}
+	})
+}
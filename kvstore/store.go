@@ -6,6 +6,8 @@ import (
 
 type Store interface {
 	Get(key string, transaction *tx.Transaction) ([]byte, error)
+	GetForUpdate(key string, transaction *tx.Transaction) ([]byte, error)
+	Lock(key string, transaction *tx.Transaction) error
 	Set(key string, value []byte, transaction *tx.Transaction) error
 	Delete(key string, transaction *tx.Transaction) error
 }
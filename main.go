@@ -2,15 +2,23 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"kv/encryption"
 	"kv/engine"
 	"kv/engine/mvcc"
 	"kv/engine/tx"
 	"kv/engine/wal"
 	"kv/kvstore"
 	"kv/observability"
+	"kv/session"
 	"kv/storage"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -19,30 +27,71 @@ import (
 func main() {
 	observability.SetLoggingLevel(zerolog.InfoLevel)
 
-	if err := run(DefaultConfig()); err != nil {
-		log.Fatal().Err(err).Msg("application startup failed")
+	cfg := DefaultConfig()
+	flag.BoolVar(&cfg.ReadOnly, "read-only", cfg.ReadOnly,
+		"open the data directory for reading only, e.g. next to a running instance")
+	flag.BoolVar(&cfg.AutoCommit, "autocommit", cfg.AutoCommit,
+		"run commands given outside of a transaction in one of their own")
+	flag.BoolVar(&cfg.WaitOnConflict, "wait-on-conflict", cfg.WaitOnConflict,
+		"have writes wait for the transaction they conflict with instead of failing right away")
+	flag.StringVar(&cfg.ScriptFile, "file", cfg.ScriptFile,
+		"run the commands of a script file instead of reading them from standard input")
+	flag.StringVar(&cfg.EncryptionKeyFile, "encryption-key-file", cfg.EncryptionKeyFile,
+		"read encryption keys from this file, best kept outside of the data directory")
+	flag.Parse()
+
+	if flag.Arg(0) == "reencrypt" {
+		if err := runReencrypt(cfg); err != nil {
+			log.Fatal().Err(err).Msg("re-encryption failed")
+		}
+
+		return
+	}
+
+	if err := run(cfg); err != nil {
+		log.Fatal().Err(err).Msg("application failed")
 	}
 }
 
 func run(cfg Config) (err error) {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	startedAt := time.Now()
 
-	storageManager := storage.NewManager()
+	signals, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
 
 	var closers Disposer
 	defer func() {
-		if err = closers.Dispose(); err != nil {
-			return
-		}
+		err = errors.Join(err, closers.Dispose())
+		log.Info().Msg("shutdown: finished")
 	}()
 
-	writeAheadLog, err := bootstrapWriteAheadLog(storageManager, cfg, &closers)
+	fs, err := openDataDir(cfg, &closers)
 	if err != nil {
 		return err
 	}
 
-	txManager, err := bootstrapTxManager(storageManager, writeAheadLog, cfg, &closers)
+	storageManager := storage.NewManager(fs)
+
+	// Tracked early so the manifests it opened are closed last.
+	closers.Track(storageManager)
+
+	keyring, err := encryption.LoadKeyring(cfg.EncryptionKeyFile, cfg.EncryptionKeyEnv)
+	if err != nil {
+		return fmt.Errorf("failed to load encryption keys: %w", err)
+	}
+
+	writeAheadLog, err := bootstrapWriteAheadLog(storageManager, keyring, false, cfg, &closers)
+	if err != nil {
+		return err
+	}
+
+	if cfg.ReadOnly {
+		log.Info().Msg("opened read-only, changes made after this point are not visible")
+	} else {
+		defer logWriteAheadLogStats(writeAheadLog)
+	}
+
+	txManager, err := bootstrapTxManager(storageManager, writeAheadLog, keyring, cfg, &closers)
 	if err != nil {
 		return err
 	}
@@ -53,36 +102,180 @@ func run(cfg Config) (err error) {
 		return err
 	}
 
-	// TODO: "Smart" autovacuum - do not run it if there is no need to.
-	vacuumer := engine.NewVacuumer(versionMap, writeAheadLog)
-	vacuumer.RunOnInterval(txManager, cfg.VacuumInterval, ctx)
+	vacuumCtx, stopVacuum := context.WithCancel(context.Background())
+	defer stopVacuum()
+
+	var vacuumStopped <-chan struct{}
+
+	if !cfg.ReadOnly {
+		if cfg.CompactLogOnStartup {
+			if err = writeAheadLog.Compact(); err != nil {
+				return fmt.Errorf("log compaction failed: %w", err)
+			}
+		}
+
+		// TODO: "Smart" autovacuum - do not run it if there is no need to.
+		vacuumer := engine.NewVacuumer(versionMap, writeAheadLog)
+		vacuumStopped = vacuumer.RunOnInterval(txManager, cfg.VacuumInterval, vacuumCtx)
+	}
+
+	console, err := newRepl(session.Backend{
+		TxManager:  txManager,
+		KVStore:    kvStore,
+		Retrier:    kvstore.NewRetrier(txManager),
+		VersionMap: versionMap,
+		WAL:        writeAheadLog,
+		DataDir:    cfg.DataDir,
+		ReadOnly:   cfg.ReadOnly,
+		StartedAt:  startedAt,
+	}, replOptions{
+		ScriptFile:  cfg.ScriptFile,
+		HistoryFile: cfg.HistoryFile,
+		HistorySize: cfg.HistorySize,
+		Session: session.Options{
+			AutoCommit:        cfg.AutoCommit,
+			AutoCommitRetries: cfg.AutoCommitRetries,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	finished := make(chan error, 1)
+	go func() {
+		finished <- console.Run()
+	}()
+
+	select {
+	case err = <-finished:
+	case <-signals.Done():
+		// Restores the default handling, a second signal ends the process
+		// right away.
+		stopSignals()
+		log.Info().Msg("shutdown: signal received")
+	}
+
+	// The terminal is restored before anything else is logged.
+	err = errors.Join(err, console.Close())
+
+	drainTransactions(txManager, cfg.ShutdownTimeout)
+
+	if vacuumStopped != nil {
+		stopVacuum()
+		<-vacuumStopped
+		log.Info().Msg("shutdown: vacuumer stopped")
+	}
+
+	log.Info().Msg("shutdown: flushing and closing storage")
+	return err
+}
+
+// drainTransactions stops new transactions and gives the active ones the
+// timeout to finish, aborting any left after it.
+func drainTransactions(txManager *tx.Manager, timeout time.Duration) {
+	log.Info().
+		Int("active_transactions", txManager.ActiveCount()).
+		Dur("timeout", timeout).
+		Msg("shutdown: draining transactions")
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if aborted := txManager.Shutdown(ctx); aborted > 0 {
+		log.Warn().Int("aborted_transactions", aborted).Msg("shutdown: aborted transactions still active")
+	}
+}
+
+// openDataDir returns the file system the store is kept in. Writing to it
+// takes the lock on the data directory, which stays held until the process
+// is done, so that a second instance fails fast instead of writing over the
+// files of the first one. Read-only access goes without the lock.
+func openDataDir(cfg Config, closers *Disposer) (storage.FS, error) {
+	fs := storage.NewOSFS()
+
+	if cfg.ReadOnly {
+		return storage.NewReadOnlyFS(fs), nil
+	}
+
+	if err := fs.MkdirAll(cfg.DataDir); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	lock, err := storage.LockFile(cfg.LockPath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock data directory: %w", err)
+	}
+
+	closers.Track(lock)
+	return fs, nil
+}
+
+func openFlag(cfg Config) int {
+	if cfg.ReadOnly {
+		return os.O_RDONLY
+	}
 
-	return startRepl(txManager, kvStore)
+	return os.O_RDWR | os.O_CREATE
 }
 
-func bootstrapWriteAheadLog(storageManager *storage.Manager, cfg Config, closers *Disposer) (*wal.WriteAheadLog, error) {
-	logManifestFile, err := storageManager.Open(cfg.LogManifestPath, os.O_RDWR|os.O_CREATE)
+// bootstrapWriteAheadLog opens the log. With migrate set, its manifest may
+// still be unencrypted although keys are loaded.
+func bootstrapWriteAheadLog(
+	storageManager *storage.Manager,
+	keyring *encryption.Keyring,
+	migrate bool,
+	cfg Config,
+	closers *Disposer,
+) (*wal.WriteAheadLog, error) {
+	descriptor, err := openStoreDescriptor(storageManager.FS(), cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	logManifestFile, err := storageManager.Open(cfg.LogManifestPath(), openFlag(cfg))
 	if err != nil {
 		return nil, fmt.Errorf("failed to open log manifest: %w", err)
 	}
-	closers.Track(logManifestFile)
 
-	logManifest := wal.NewManifest(logManifestFile)
+	logManifest := wal.NewEncryptedManifest(logManifestFile, keyring)
+	if migrate {
+		logManifest = wal.NewMigratingManifest(logManifestFile, keyring)
+	}
 
 	logOptions := wal.LogOptions{
-		LogsDirectory: cfg.LogDir,
-		SegmentSize:   cfg.LogSegmentSize,
+		FS:               storageManager.FS(),
+		LogsDirectory:    cfg.LogDir(),
+		SegmentSize:      cfg.LogSegmentSize,
+		RecycledSegments: cfg.LogRecycledSegments,
+		Keyring:          keyring,
+		ReadOnly:         cfg.ReadOnly,
 	}
 
 	logStream, err := wal.NewLog(logManifest, logOptions)
+	if errors.Is(err, encryption.UnencryptedDataError) {
+		return nil, fmt.Errorf("failed to create log stream: %w, run reencrypt to encrypt the store", err)
+	}
+
 	if err != nil {
 		return nil, fmt.Errorf("failed to create log stream: %w", err)
 	}
 	closers.Track(logStream)
 
+	if err = descriptor.Complete(logStream.StoreID(), descriptorOptions(storageManager.FS(), cfg)); err != nil {
+		return nil, fmt.Errorf("failed to open store: %w", err)
+	}
+
+	log.Info().
+		Stringer("store_id", descriptor.StoreID).
+		Uint32("format_version", descriptor.FormatVersion).
+		Time("created_at", descriptor.CreatedAt).
+		Msg("store: opened")
+
 	writeAheadLog := wal.NewWriteAheadLog(wal.Options{
-		WriterBufferSize:    cfg.WalBufferSize,
-		BatchCommitWaitTime: cfg.WalCommitWait,
+		WriterBufferSize:     cfg.WalBufferSize,
+		BatchCommitWaitTime:  cfg.WalCommitWait,
+		CompressionThreshold: cfg.WalCompressionThreshold,
+		Keyring:              keyring,
 	}, logStream)
 
 	closers.Track(writeAheadLog)
@@ -90,31 +283,127 @@ func bootstrapWriteAheadLog(storageManager *storage.Manager, cfg Config, closers
 	return writeAheadLog, nil
 }
 
-func bootstrapTxManager(storageManager *storage.Manager, walAppender wal.Appender, cfg Config, closers *Disposer) (*tx.Manager, error) {
-	tmManifestFile, err := storageManager.Open(cfg.TxManifestPath, os.O_RDWR|os.O_CREATE)
+// openStoreDescriptor makes sure the store in the data directory can be
+// opened with this build and configuration, upgrading its format if needed.
+// It runs before the log is opened, which only reads the current format.
+func openStoreDescriptor(fs storage.FS, cfg Config) (*engine.StoreDescriptor, error) {
+	descriptor, err := engine.OpenStoreDescriptor(descriptorOptions(fs, cfg))
 	if err != nil {
-		return nil, fmt.Errorf("failed to open tx manifest: %w", err)
+		return nil, fmt.Errorf("failed to open store: %w", err)
+	}
+
+	return descriptor, nil
+}
+
+func descriptorOptions(fs storage.FS, cfg Config) engine.DescriptorOptions {
+	return engine.DescriptorOptions{
+		FS:           fs,
+		DataDir:      cfg.DataDir,
+		LockFileName: filepath.Base(cfg.LockPath()),
+		SegmentSize:  cfg.LogSegmentSize,
+		ReadOnly:     cfg.ReadOnly,
+		Upgrades:     storeUpgrades(cfg),
+	}
+}
+
+// storeUpgrades migrates stores written by older builds, keyed by the format
+// version they upgrade from.
+func storeUpgrades(cfg Config) map[uint32]engine.Upgrade {
+	return map[uint32]engine.Upgrade{
+		// Stores from before the descriptor have log segments without
+		// headers, and a log manifest without a store ID.
+		0: func(fs storage.FS, dataDir string) error {
+			if err := fs.MkdirAll(cfg.LogDir()); err != nil {
+				return err
+			}
+
+			manifestFile, err := fs.Open(cfg.LogManifestPath(), os.O_RDWR|os.O_CREATE)
+			if err != nil {
+				return err
+			}
+
+			err = wal.UpgradeLog(wal.NewManifest(manifestFile), wal.LogOptions{
+				FS:            fs,
+				LogsDirectory: cfg.LogDir(),
+				SegmentSize:   cfg.LogSegmentSize,
+			})
+
+			return errors.Join(err, manifestFile.Close())
+		},
 	}
-	closers.Track(tmManifestFile)
+}
+
+func logWriteAheadLogStats(writeAheadLog *wal.WriteAheadLog) {
+	stats := writeAheadLog.Stats()
 
-	txManifest := tx.NewManifest(tmManifestFile)
+	log.Info().
+		Uint64("records", stats.Records).
+		Uint64("compressed_records", stats.CompressedRecords).
+		Uint64("raw_bytes", stats.RawBytes).
+		Uint64("encoded_bytes", stats.EncodedBytes).
+		Float64("compression_ratio", stats.CompressionRatio()).
+		Msg("wal: stats")
+}
+
+func bootstrapTxManager(
+	storageManager *storage.Manager,
+	walAppender wal.Appender,
+	keyring *encryption.Keyring,
+	cfg Config,
+	closers *Disposer,
+) (*tx.Manager, error) {
+	txManifest, err := openTxManifest(storageManager, keyring, false, cfg, closers)
+	if err != nil {
+		return nil, err
+	}
 
 	manager := tx.NewManager(txManifest, walAppender, tx.ManagerOptions{
 		ReservedIDsPerBatch:   cfg.ReservedTxIDsPerBatch,
 		MaxActiveTransactions: cfg.MaxActiveTx,
+		LockTimeout:           cfg.LockTimeout,
+		ReadOnly:              cfg.ReadOnly,
 	})
 
 	return manager, nil
 }
 
+func openTxManifest(
+	storageManager *storage.Manager,
+	keyring *encryption.Keyring,
+	migrate bool,
+	cfg Config,
+	closers *Disposer,
+) (*tx.Manifest, error) {
+	tmManifestFile, err := storageManager.Open(cfg.TxManifestPath(), openFlag(cfg))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open tx manifest: %w", err)
+	}
+
+	if migrate {
+		return tx.NewMigratingManifest(tmManifestFile, keyring), nil
+	}
+
+	return tx.NewEncryptedManifest(tmManifestFile, keyring), nil
+}
+
 func bootstrapKVStore(
 	versionMap *mvcc.VersionMap,
 	walReplayer wal.Replayer,
 	walAppender wal.Appender,
 	cfg Config,
 ) (*kvstore.KVStore, error) {
-	mvccStore := mvcc.NewStore(versionMap)
-	recoveryManager := engine.NewRecoveryManager(versionMap, walReplayer)
+	conflictPolicy := mvcc.ConflictFail
+	if cfg.WaitOnConflict {
+		conflictPolicy = mvcc.ConflictWait
+	}
+
+	mvccStore := mvcc.NewStore(versionMap, mvcc.StoreOptions{
+		ConflictPolicy:  conflictPolicy,
+		ConflictTimeout: cfg.ConflictTimeout,
+	})
+	recoveryManager := engine.NewRecoveryManager(versionMap, walReplayer, engine.RecoveryOptions{
+		Workers: cfg.RecoveryWorkers,
+	})
 
 	if err := recoveryManager.Run(); err != nil {
 		return nil, fmt.Errorf("recovery failed: %w", err)
@@ -127,6 +416,7 @@ func bootstrapKVStore(
 			MaxKeySize:   cfg.MaxKeySize,
 			MaxValueSize: cfg.MaxValueSize,
 		},
+		ReadOnly: cfg.ReadOnly,
 	}
 
 	return kvstore.New(storageEngine, kvOptions), nil
@@ -0,0 +1,123 @@
+package query
+
+// Statement is a parsed command, before it is turned into a Command.
+type Statement interface {
+	// Pos is where the statement starts.
+	Pos() Position
+	statementNode()
+}
+
+// Name is a key or a savepoint name.
+type Name struct {
+	NamePos Position
+	Text    string
+
+	// Placeholder is the number of the ? the key was given as, counting
+	// from 1, 0 for a key written out.
+	Placeholder int
+}
+
+// Literal is a value as written in a query.
+type Literal struct {
+	Token Token
+
+	// Placeholder is the number of the ? the value was given as, counting
+	// from 1, 0 for a value written out.
+	Placeholder int
+}
+
+// Bytes is the value of the literal: the decoded content of strings and
+// blobs, the text of anything else.
+func (l Literal) Bytes() []byte {
+	if l.Token.Value != nil {
+		return l.Token.Value
+	}
+
+	return []byte(l.Token.Text)
+}
+
+type SetStatement struct {
+	StartPos Position
+	Key      Name
+	Value    Literal
+}
+
+type GetStatement struct {
+	StartPos Position
+	Key      Name
+}
+
+type DeleteStatement struct {
+	StartPos Position
+	Key      Name
+}
+
+type LockStatement struct {
+	StartPos Position
+	Key      Name
+}
+
+// TransactionStatement is TRANSACTION BEGIN, COMMIT or ABORT, Action is one
+// of CommandBegin, CommandCommit and CommandAbort.
+type TransactionStatement struct {
+	StartPos Position
+	Action   CommandType
+}
+
+// SavepointStatement is SAVEPOINT, ROLLBACK TO or RELEASE, told apart by
+// Action.
+type SavepointStatement struct {
+	StartPos Position
+	Action   CommandType
+	Name     Name
+}
+
+// DebugStatement is DEBUG VERSIONS, SNAPSHOT or TX, told apart by Action.
+// Key is only set for VERSIONS.
+type DebugStatement struct {
+	StartPos Position
+	Action   CommandType
+	Key      Name
+}
+
+// InfoStatement is INFO, Section is empty for all sections.
+type InfoStatement struct {
+	StartPos Position
+	Section  Name
+}
+
+type DbSizeStatement struct {
+	StartPos Position
+}
+
+type ExitStatement struct {
+	StartPos Position
+}
+
+type HelpStatement struct {
+	StartPos Position
+}
+
+func (s *SetStatement) Pos() Position         { return s.StartPos }
+func (s *GetStatement) Pos() Position         { return s.StartPos }
+func (s *DeleteStatement) Pos() Position      { return s.StartPos }
+func (s *LockStatement) Pos() Position        { return s.StartPos }
+func (s *TransactionStatement) Pos() Position { return s.StartPos }
+func (s *SavepointStatement) Pos() Position   { return s.StartPos }
+func (s *DebugStatement) Pos() Position       { return s.StartPos }
+func (s *InfoStatement) Pos() Position        { return s.StartPos }
+func (s *DbSizeStatement) Pos() Position      { return s.StartPos }
+func (s *ExitStatement) Pos() Position        { return s.StartPos }
+func (s *HelpStatement) Pos() Position        { return s.StartPos }
+
+func (*SetStatement) statementNode()         {}
+func (*GetStatement) statementNode()         {}
+func (*DeleteStatement) statementNode()      {}
+func (*LockStatement) statementNode()        {}
+func (*TransactionStatement) statementNode() {}
+func (*SavepointStatement) statementNode()   {}
+func (*DebugStatement) statementNode()       {}
+func (*InfoStatement) statementNode()        {}
+func (*DbSizeStatement) statementNode()      {}
+func (*ExitStatement) statementNode()        {}
+func (*HelpStatement) statementNode()        {}
@@ -6,19 +6,34 @@ const (
 	CommandSet CommandType = iota
 	CommandGet
 	CommandDelete
+	CommandLock
 
 	CommandBegin
 	CommandCommit
 	CommandAbort
+	CommandSavepoint
+	CommandRollbackTo
+	CommandRelease
+
+	CommandDebugVersions
+	CommandDebugSnapshot
+	CommandDebugTx
+
+	CommandInfo
+	CommandDbSize
 
 	CommandExit
 	CommandHelp
 )
 
 type Command struct {
-	Type  CommandType
-	Key   string
-	Value []byte
+	Type      CommandType
+	Key       string
+	Value     []byte
+	Savepoint string
+
+	// Section narrows INFO down to one section, empty for all of them.
+	Section string
 }
 
 type CommandMeta struct {
@@ -30,18 +45,33 @@ type CommandMeta struct {
 var CommandRegistry = map[CommandType]CommandMeta{
 	CommandBegin: {
 		Name:        "TRANSACTION BEGIN",
-		Usage:       "TRANSACTION BEGIN",
+		Usage:       "[TRANSACTION] BEGIN",
 		Description: "Start a new transaction",
 	},
 	CommandCommit: {
 		Name:        "TRANSACTION COMMIT",
-		Usage:       "TRANSACTION COMMIT",
+		Usage:       "[TRANSACTION] COMMIT",
 		Description: "Commit current transaction",
 	},
 	CommandAbort: {
 		Name:        "TRANSACTION ABORT",
-		Usage:       "TRANSACTION ABORT",
-		Description: "Abort current transaction",
+		Usage:       "[TRANSACTION] ABORT",
+		Description: "Abort current transaction, also ROLLBACK",
+	},
+	CommandSavepoint: {
+		Name:        "SAVEPOINT",
+		Usage:       "SAVEPOINT <name>",
+		Description: "Create a savepoint in current transaction",
+	},
+	CommandRollbackTo: {
+		Name:        "ROLLBACK TO",
+		Usage:       "ROLLBACK TO <name>",
+		Description: "Undo changes made after a savepoint",
+	},
+	CommandRelease: {
+		Name:        "RELEASE",
+		Usage:       "RELEASE <name>",
+		Description: "Forget a savepoint, keeping its changes",
 	},
 	CommandGet: {
 		Name:        "GET",
@@ -58,6 +88,36 @@ var CommandRegistry = map[CommandType]CommandMeta{
 		Usage:       "DELETE <key>",
 		Description: "Delete a key",
 	},
+	CommandLock: {
+		Name:        "LOCK",
+		Usage:       "LOCK <key>",
+		Description: "Lock a key until current transaction ends",
+	},
+	CommandDebugVersions: {
+		Name:        "DEBUG VERSIONS",
+		Usage:       "DEBUG VERSIONS <key>",
+		Description: "Show the versions of a key",
+	},
+	CommandDebugSnapshot: {
+		Name:        "DEBUG SNAPSHOT",
+		Usage:       "DEBUG SNAPSHOT",
+		Description: "Show the snapshot of current transaction",
+	},
+	CommandDebugTx: {
+		Name:        "DEBUG TX",
+		Usage:       "DEBUG TX",
+		Description: "List active transactions",
+	},
+	CommandInfo: {
+		Name:        "INFO",
+		Usage:       "INFO [section]",
+		Description: "Show server, wal, transactions and mvcc stats",
+	},
+	CommandDbSize: {
+		Name:        "DBSIZE",
+		Usage:       "DBSIZE",
+		Description: "Count keys visible to current transaction",
+	},
 	CommandHelp: {
 		Name:        "HELP",
 		Usage:       "HELP",
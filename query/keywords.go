@@ -4,12 +4,26 @@ const (
 	SET    = "SET"
 	GET    = "GET"
 	DELETE = "DELETE"
+	LOCK   = "LOCK"
 
 	TRANSACTION = "TRANSACTION"
 	ABORT       = "ABORT"
 	COMMIT      = "COMMIT"
 	BEGIN       = "BEGIN"
 
+	SAVEPOINT = "SAVEPOINT"
+	ROLLBACK  = "ROLLBACK"
+	TO        = "TO"
+	RELEASE   = "RELEASE"
+
+	DEBUG    = "DEBUG"
+	VERSIONS = "VERSIONS"
+	SNAPSHOT = "SNAPSHOT"
+	TX       = "TX"
+
+	INFO   = "INFO"
+	DBSIZE = "DBSIZE"
+
 	EXIT = "EXIT"
 	HELP = "HELP"
 )
@@ -0,0 +1,324 @@
+package query
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// lexer splits the input into tokens, one at a time.
+type lexer struct {
+	input  string
+	offset int
+
+	// pos is the position of offset, kept up to date as the lexer moves.
+	pos Position
+}
+
+func newLexer(input string) *lexer {
+	return &lexer{
+		input: input,
+		pos:   Position{Line: 1, Column: 1},
+	}
+}
+
+// Tokenize splits the whole input into tokens, ending with TokenEOF.
+func Tokenize(input string) ([]Token, error) {
+	l := newLexer(input)
+
+	var tokens []Token
+
+	for {
+		token, err := l.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, token)
+
+		if token.Kind == TokenEOF {
+			return tokens, nil
+		}
+	}
+}
+
+// Next returns the next token. Malformed strings and blobs are errors, other
+// characters that start no token come back as TokenIllegal, for the parser
+// to report in context.
+func (l *lexer) Next() (Token, error) {
+	l.skipSpace()
+
+	start, startPos := l.offset, l.pos
+
+	if l.offset == len(l.input) {
+		return Token{Kind: TokenEOF, Pos: startPos}, nil
+	}
+
+	c := l.input[l.offset]
+
+	switch {
+	case c == ';':
+		l.advance()
+		return l.token(TokenSemicolon, start, startPos, nil), nil
+
+	case c == '?':
+		l.advance()
+		return l.token(TokenPlaceholder, start, startPos, nil), nil
+
+	case c == '\'' || c == '"':
+		value, err := l.scanString(c)
+		if err != nil {
+			return Token{}, err
+		}
+
+		return l.token(TokenString, start, startPos, value), nil
+
+	case isWordChar(c):
+		word := l.scanWord()
+
+		switch lower := strings.ToLower(word); {
+		case lower == "x" && l.peek() == '\'':
+			return l.scanQuotedBlob(start, startPos, hex.DecodeString)
+		case lower == "b64" && l.peek() == '\'':
+			return l.scanQuotedBlob(start, startPos, base64.StdEncoding.DecodeString)
+		case lower == "b64" && l.peek() == ':':
+			return l.scanBase64Blob(start, startPos)
+		case strings.HasPrefix(lower, "0x"):
+			return l.blob(start, startPos, word[2:], hex.DecodeString)
+		}
+
+		return l.token(classifyWord(word), start, startPos, nil), nil
+
+	default:
+		l.advance()
+		return l.token(TokenIllegal, start, startPos, nil), nil
+	}
+}
+
+func (l *lexer) token(kind TokenKind, start int, pos Position, value []byte) Token {
+	return Token{
+		Kind:  kind,
+		Pos:   pos,
+		Text:  l.input[start:l.offset],
+		Value: value,
+	}
+}
+
+func (l *lexer) skipSpace() {
+	for l.offset < len(l.input) {
+		switch l.input[l.offset] {
+		case ' ', '\t', '\r', '\n':
+			l.advance()
+		default:
+			return
+		}
+	}
+}
+
+func (l *lexer) scanWord() string {
+	start := l.offset
+
+	for l.offset < len(l.input) && isWordChar(l.input[l.offset]) {
+		l.advance()
+	}
+
+	return l.input[start:l.offset]
+}
+
+// scanString reads a quoted string, decoding its escape sequences.
+func (l *lexer) scanString(quote byte) ([]byte, error) {
+	startPos := l.pos
+	l.advance()
+
+	value := []byte{}
+
+	for {
+		if l.offset == len(l.input) {
+			return nil, l.errorAt(UnterminatedStringError, startPos, "missing closing %c", quote)
+		}
+
+		c := l.input[l.offset]
+
+		switch c {
+		case quote:
+			l.advance()
+			return value, nil
+
+		case '\\':
+			decoded, err := l.scanEscape()
+			if err != nil {
+				return nil, err
+			}
+
+			value = append(value, decoded...)
+
+		default:
+			start := l.offset
+			l.advance()
+			value = append(value, l.input[start:l.offset]...)
+		}
+	}
+}
+
+// scanEscape reads an escape sequence: \\, \', \", \n, \r, \t, \0 or a byte
+// written as \xHH.
+func (l *lexer) scanEscape() ([]byte, error) {
+	pos := l.pos
+	l.advance()
+
+	if l.offset == len(l.input) {
+		return nil, l.errorAt(UnterminatedStringError, pos, "missing closing quote")
+	}
+
+	c := l.input[l.offset]
+	l.advance()
+
+	switch c {
+	case '\\', '\'', '"':
+		return []byte{c}, nil
+	case 'n':
+		return []byte{'\n'}, nil
+	case 'r':
+		return []byte{'\r'}, nil
+	case 't':
+		return []byte{'\t'}, nil
+	case '0':
+		return []byte{0}, nil
+	case 'x':
+		if l.offset+2 > len(l.input) {
+			return nil, l.errorAt(InvalidLiteralError, pos, `\x needs two hex digits`)
+		}
+
+		b, err := strconv.ParseUint(l.input[l.offset:l.offset+2], 16, 8)
+		if err != nil {
+			return nil, l.errorAt(InvalidLiteralError, pos, `\x needs two hex digits`)
+		}
+
+		l.advance()
+		l.advance()
+		return []byte{byte(b)}, nil
+	default:
+		return nil, l.errorAt(InvalidLiteralError, pos, `unknown escape sequence \%c`, c)
+	}
+}
+
+// scanQuotedBlob reads the quoted part of a blob, its prefix already read.
+func (l *lexer) scanQuotedBlob(start int, pos Position, decode func(string) ([]byte, error)) (Token, error) {
+	l.advance()
+	contentStart := l.offset
+
+	for l.offset < len(l.input) && l.input[l.offset] != '\'' {
+		l.advance()
+	}
+
+	if l.offset == len(l.input) {
+		return Token{}, l.errorAt(UnterminatedStringError, pos, "missing closing '")
+	}
+
+	content := l.input[contentStart:l.offset]
+	l.advance()
+
+	return l.blob(start, pos, content, decode)
+}
+
+// scanBase64Blob reads the base64 after b64:, up to the first character
+// that cannot be part of it.
+func (l *lexer) scanBase64Blob(start int, pos Position) (Token, error) {
+	l.advance()
+	contentStart := l.offset
+
+	for l.offset < len(l.input) && isBase64Char(l.input[l.offset]) {
+		l.advance()
+	}
+
+	return l.blob(start, pos, l.input[contentStart:l.offset], base64.StdEncoding.DecodeString)
+}
+
+func (l *lexer) blob(start int, pos Position, content string, decode func(string) ([]byte, error)) (Token, error) {
+	value, err := decode(content)
+	if err != nil {
+		return Token{}, l.errorAt(InvalidLiteralError, pos, "malformed blob: %v", err)
+	}
+
+	// Keeps an empty blob apart from no value at all.
+	if value == nil {
+		value = []byte{}
+	}
+
+	return l.token(TokenBlob, start, pos, value), nil
+}
+
+func (l *lexer) peek() byte {
+	if l.offset == len(l.input) {
+		return 0
+	}
+
+	return l.input[l.offset]
+}
+
+// advance moves past one character.
+func (l *lexer) advance() {
+	_, size := utf8.DecodeRuneInString(l.input[l.offset:])
+
+	if l.input[l.offset] == '\n' {
+		l.pos.Line++
+		l.pos.Column = 1
+	} else {
+		l.pos.Column++
+	}
+
+	l.offset += size
+	l.pos.Offset = l.offset
+}
+
+func (l *lexer) errorAt(err error, pos Position, format string, args ...any) error {
+	return &SyntaxError{
+		Err:    err,
+		Pos:    pos,
+		Detail: fmt.Sprintf(format, args...),
+		Input:  l.input,
+	}
+}
+
+func isWordChar(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z':
+	case c >= 'A' && c <= 'Z':
+	case c >= '0' && c <= '9':
+	case c == '_', c == '-', c == '.', c == '+':
+	default:
+		return false
+	}
+
+	return true
+}
+
+func isBase64Char(c byte) bool {
+	return isWordChar(c) && c != '_' && c != '-' && c != '.' || c == '/' || c == '='
+}
+
+// classifyWord tells numbers from identifiers. A word is a number only if
+// all of it is, e.g. 1.2.3 is an identifier.
+func classifyWord(word string) TokenKind {
+	if _, err := strconv.ParseInt(word, 10, 64); err == nil {
+		return TokenInt
+	}
+
+	if isDecimal(word) {
+		if _, err := strconv.ParseFloat(word, 64); err == nil {
+			return TokenFloat
+		}
+	}
+
+	return TokenIdent
+}
+
+// isDecimal rules out what ParseFloat accepts beyond plain decimals, such
+// as "inf", "nan" or hex floats, which are taken as identifiers.
+func isDecimal(word string) bool {
+	word = strings.TrimLeft(word, "+-")
+	return word != "" && (word[0] >= '0' && word[0] <= '9' || word[0] == '.') && !strings.ContainsAny(word, "xXpP_")
+}
@@ -0,0 +1,68 @@
+package query
+
+import (
+	"kv/test"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tokens, err := Tokenize(`SET k-1 42 -1.5e3 1.2.3 'it\'s' "tab\t" X'0A' b64'' 0x0b0C b64:AP8=; !`)
+	test.AssertNoError(t, err)
+
+	want := []struct {
+		kind  TokenKind
+		text  string
+		value []byte
+	}{
+		{TokenIdent, "SET", nil},
+		{TokenIdent, "k-1", nil},
+		{TokenInt, "42", nil},
+		{TokenFloat, "-1.5e3", nil},
+		{TokenIdent, "1.2.3", nil},
+		{TokenString, `'it\'s'`, []byte("it's")},
+		{TokenString, `"tab\t"`, []byte("tab\t")},
+		{TokenBlob, "X'0A'", []byte{0x0a}},
+		{TokenBlob, "b64''", []byte{}},
+		{TokenBlob, "0x0b0C", []byte{0x0b, 0x0c}},
+		{TokenBlob, "b64:AP8=", []byte{0x00, 0xff}},
+		{TokenSemicolon, ";", nil},
+		{TokenIllegal, "!", nil},
+		{TokenEOF, "", nil},
+	}
+
+	test.AssertEqual(t, len(tokens), len(want))
+
+	for i, token := range tokens {
+		test.AssertEqual(t, token.Kind, want[i].kind)
+		test.AssertEqual(t, token.Text, want[i].text)
+		test.AssertBytesEqual(t, token.Value, want[i].value)
+	}
+
+	test.AssertEqual(t, tokens[1].Pos, Position{Offset: 4, Line: 1, Column: 5})
+}
+
+func TestTokenize_Errors(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		wantError error
+	}{
+		{name: "unterminated string", input: `'abc`, wantError: UnterminatedStringError},
+		{name: "unterminated escape", input: `'abc\`, wantError: UnterminatedStringError},
+		{name: "unterminated blob", input: `x'00`, wantError: UnterminatedStringError},
+		{name: "unknown escape", input: `'\q'`, wantError: InvalidLiteralError},
+		{name: "short hex escape", input: `'\x0'`, wantError: InvalidLiteralError},
+		{name: "odd hex blob", input: `x'0'`, wantError: InvalidLiteralError},
+		{name: "malformed base64 blob", input: `b64'@@'`, wantError: InvalidLiteralError},
+		{name: "odd 0x blob", input: `0x0`, wantError: InvalidLiteralError},
+		{name: "0x blob with other letters", input: `0xfg`, wantError: InvalidLiteralError},
+		{name: "short b64: blob", input: `b64:AP8`, wantError: InvalidLiteralError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Tokenize(tt.input)
+			test.AssertError(t, err, tt.wantError)
+		})
+	}
+}
@@ -1,144 +1,154 @@
 package query
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"strings"
 )
 
-// TODO: Parser, lexer, custom (simple) query language
-
 var InvalidCommandError = errors.New("invalid command")
 var InvalidKeyError = errors.New("invalid key")
 var InvalidNumberOfTokens = errors.New("invalid number of tokens")
+var InvalidSavepointNameError = errors.New("invalid savepoint name")
+var InvalidLiteralError = errors.New("invalid literal")
+var UnterminatedStringError = errors.New("unterminated string")
+var ArgumentCountError = errors.New("wrong number of arguments")
+
+// SyntaxError is an error at a position of the input. It wraps one of the
+// errors above, which errors.Is can tell apart.
+type SyntaxError struct {
+	Err    error
+	Pos    Position
+	Detail string
+	Input  string
+}
 
-func Parse(input string) (*Command, error) {
-	trimmedInput := strings.TrimSpace(input)
-	if trimmedInput == "" {
-		return nil, InvalidCommandError
-	}
-
-	tokens, err := tokenize(trimmedInput)
-
-	if err != nil {
-		return nil, err
-	}
-
-	if len(tokens) == 0 {
-		return nil, InvalidCommandError
+func (e *SyntaxError) Error() string {
+	if e.Detail == "" {
+		return fmt.Sprintf("%v at %s", e.Err, e.Pos)
 	}
 
-	switch strings.ToUpper(tokens[0]) {
-	case SET:
-		if len(tokens) != 3 {
-			return nil, InvalidNumberOfTokens
-		}
-
-		key := tokens[1]
-		value := tokens[2]
+	return fmt.Sprintf("%v at %s: %s", e.Err, e.Pos, e.Detail)
+}
 
-		if !isValidKey(key) {
-			return nil, InvalidKeyError
-		}
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
 
-		return &Command{
-			Key:   key,
-			Value: []byte(value),
-			Type:  CommandSet,
-		}, nil
+// Pointer returns the line of the input the error is on, and under it a
+// caret at the offending column.
+func (e *SyntaxError) Pointer() string {
+	lineStart := strings.LastIndexByte(e.Input[:e.Pos.Offset], '\n') + 1
 
-	case GET:
-		if len(tokens) != 2 {
-			return nil, InvalidNumberOfTokens
-		}
+	lineEnd := strings.IndexByte(e.Input[lineStart:], '\n')
+	if lineEnd < 0 {
+		lineEnd = len(e.Input)
+	} else {
+		lineEnd += lineStart
+	}
 
-		key := tokens[1]
+	var caret strings.Builder
 
-		if !isValidKey(key) {
-			return nil, InvalidKeyError
+	// Tabs are kept so that the caret lines up however wide they are shown.
+	for _, r := range e.Input[lineStart:e.Pos.Offset] {
+		if r == '\t' {
+			caret.WriteRune('\t')
+		} else {
+			caret.WriteRune(' ')
 		}
+	}
 
-		return &Command{
-			Key:  key,
-			Type: CommandGet,
-		}, nil
-
-	case DELETE:
-		if len(tokens) != 2 {
-			return nil, InvalidNumberOfTokens
-		}
+	caret.WriteRune('^')
 
-		key := tokens[1]
+	return e.Input[lineStart:lineEnd] + "\n" + caret.String()
+}
 
-		if !isValidKey(key) {
-			return nil, InvalidKeyError
-		}
+// Parse parses one or more commands separated by semicolons. Placeholders
+// are left to Prepare.
+func Parse(input string) ([]*Command, error) {
+	prepared, err := Prepare(input)
+	if err != nil {
+		return nil, err
+	}
 
-		return &Command{
-			Key:  key,
-			Type: CommandDelete,
-		}, nil
+	return prepared.Bind()
+}
 
-	case EXIT:
-		if len(tokens) != 1 {
-			return nil, InvalidNumberOfTokens
-		}
+// ParseScript parses one or more commands separated by semicolons into their
+// syntax trees.
+func ParseScript(input string) ([]Statement, error) {
+	statements, _, err := parseScript(input)
+	return statements, err
+}
 
-		return &Command{
-			Type: CommandExit,
-		}, nil
+// parseScript also returns how many placeholders the statements have.
+func parseScript(input string) ([]Statement, int, error) {
+	p, err := newParser(input)
+	if err != nil {
+		return nil, 0, err
+	}
 
-	case HELP:
-		if len(tokens) != 1 {
-			return nil, InvalidNumberOfTokens
-		}
+	statements, err := p.parseScript()
+	if err != nil {
+		return nil, 0, err
+	}
 
-		return &Command{
-			Type: CommandHelp,
-		}, nil
+	return statements, p.placeholders, nil
+}
 
-	case TRANSACTION:
-		if len(tokens) != 2 {
-			return nil, InvalidNumberOfTokens
+func toCommand(statement Statement, args [][]byte) *Command {
+	switch s := statement.(type) {
+	case *SetStatement:
+		return &Command{Type: CommandSet, Key: bindKey(s.Key, args), Value: bindValue(s.Value, args)}
+	case *GetStatement:
+		return &Command{Type: CommandGet, Key: bindKey(s.Key, args)}
+	case *DeleteStatement:
+		return &Command{Type: CommandDelete, Key: bindKey(s.Key, args)}
+	case *LockStatement:
+		return &Command{Type: CommandLock, Key: bindKey(s.Key, args)}
+	case *TransactionStatement:
+		return &Command{Type: s.Action}
+	case *SavepointStatement:
+		return &Command{Type: s.Action, Savepoint: s.Name.Text}
+	case *DebugStatement:
+		if s.Action == CommandDebugVersions {
+			return &Command{Type: s.Action, Key: bindKey(s.Key, args)}
 		}
 
-		switch strings.ToUpper(tokens[1]) {
-		case BEGIN:
-			return &Command{
-				Type: CommandBegin,
-			}, nil
-
-		case ABORT:
-			return &Command{
-				Type: CommandAbort,
-			}, nil
+		return &Command{Type: s.Action}
+	case *InfoStatement:
+		return &Command{Type: CommandInfo, Section: s.Section.Text}
+	case *DbSizeStatement:
+		return &Command{Type: CommandDbSize}
+	case *ExitStatement:
+		return &Command{Type: CommandExit}
+	case *HelpStatement:
+		return &Command{Type: CommandHelp}
+	default:
+		panic(fmt.Sprintf("query: unknown statement %T", statement))
+	}
+}
 
-		case COMMIT:
-			return &Command{
-				Type: CommandCommit,
-			}, nil
+func bindKey(key Name, args [][]byte) string {
+	if key.Placeholder == 0 {
+		return key.Text
+	}
 
-		default:
-			return nil, InvalidCommandError
-		}
+	return string(args[key.Placeholder-1])
+}
 
-	default:
-		return nil, InvalidCommandError
+// bindValue copies arguments, which callers are free to reuse once the
+// commands are made.
+func bindValue(value Literal, args [][]byte) []byte {
+	if value.Placeholder == 0 {
+		return value.Bytes()
 	}
-}
 
-func isValidKey(key string) bool {
-	for i := 0; i < len(key); i++ {
-		c := key[i]
-
-		switch {
-		case c >= 'a' && c <= 'z':
-		case c >= 'A' && c <= 'Z':
-		case c >= '0' && c <= '9':
-		case c == '_', c == '-', c == '.':
-		default:
-			return false
-		}
+	arg := args[value.Placeholder-1]
+	if arg == nil {
+		return []byte{}
 	}
 
-	return true
+	return bytes.Clone(arg)
 }
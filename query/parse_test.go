@@ -1,6 +1,7 @@
 package query
 
 import (
+	"errors"
 	"kv/test"
 	"testing"
 )
@@ -66,6 +67,19 @@ func TestParse(t *testing.T) {
 			input:     "DELETE",
 			wantError: InvalidNumberOfTokens,
 		},
+		{
+			name:  "LOCK valid",
+			input: "lock foo",
+			wantCommand: &Command{
+				Type: CommandLock,
+				Key:  "foo",
+			},
+		},
+		{
+			name:      "LOCK missing key",
+			input:     "LOCK",
+			wantError: InvalidNumberOfTokens,
+		},
 		{
 			name:  "TRANSACTION BEGIN",
 			input: "TRANSACTION BEGIN",
@@ -92,6 +106,45 @@ func TestParse(t *testing.T) {
 			input:     "TRANSACTION FOO",
 			wantError: InvalidCommandError,
 		},
+		{
+			name:  "SAVEPOINT valid",
+			input: "SAVEPOINT sp1",
+			wantCommand: &Command{
+				Type:      CommandSavepoint,
+				Savepoint: "sp1",
+			},
+		},
+		{
+			name:      "SAVEPOINT invalid name",
+			input:     "SAVEPOINT 'sp 1'",
+			wantError: InvalidSavepointNameError,
+		},
+		{
+			name:  "ROLLBACK TO valid",
+			input: "ROLLBACK TO sp1",
+			wantCommand: &Command{
+				Type:      CommandRollbackTo,
+				Savepoint: "sp1",
+			},
+		},
+		{
+			name:      "ROLLBACK without TO",
+			input:     "ROLLBACK sp1 sp2",
+			wantError: InvalidCommandError,
+		},
+		{
+			name:  "RELEASE valid",
+			input: "RELEASE sp1",
+			wantCommand: &Command{
+				Type:      CommandRelease,
+				Savepoint: "sp1",
+			},
+		},
+		{
+			name:      "RELEASE missing name",
+			input:     "RELEASE",
+			wantError: InvalidNumberOfTokens,
+		},
 		{
 			name:      "unknown command",
 			input:     "FOO bar",
@@ -102,21 +155,304 @@ func TestParse(t *testing.T) {
 			input:     "",
 			wantError: InvalidCommandError,
 		},
+		{
+			name:  "SET with escapes",
+			input: `set foo "a\tb\x00\"c"`,
+			wantCommand: &Command{
+				Type:  CommandSet,
+				Key:   "foo",
+				Value: []byte("a\tb\x00\"c"),
+			},
+		},
+		{
+			name:  "SET number keeps its text",
+			input: "SET 42 1.50",
+			wantCommand: &Command{
+				Type:  CommandSet,
+				Key:   "42",
+				Value: []byte("1.50"),
+			},
+		},
+		{
+			name:  "SET hex blob",
+			input: "SET foo x'00ff'",
+			wantCommand: &Command{
+				Type:  CommandSet,
+				Key:   "foo",
+				Value: []byte{0x00, 0xff},
+			},
+		},
+		{
+			name:  "SET base64 blob",
+			input: "SET foo b64'AP8='",
+			wantCommand: &Command{
+				Type:  CommandSet,
+				Key:   "foo",
+				Value: []byte{0x00, 0xff},
+			},
+		},
+		{
+			name:  "SET across lines",
+			input: "SET foo\n'bar'",
+			wantCommand: &Command{
+				Type:  CommandSet,
+				Key:   "foo",
+				Value: []byte("bar"),
+			},
+		},
+		{
+			name:      "SET unterminated string",
+			input:     "SET foo 'bar",
+			wantError: UnterminatedStringError,
+		},
+		{
+			name:      "SET unknown escape",
+			input:     `SET foo 'a\qb'`,
+			wantError: InvalidLiteralError,
+		},
+		{
+			name:      "SET malformed hex blob",
+			input:     "SET foo x'0g'",
+			wantError: InvalidLiteralError,
+		},
+		{
+			name:  "SET quoted key",
+			input: `SET "a b\n" bar`,
+			wantCommand: &Command{
+				Type:  CommandSet,
+				Key:   "a b\n",
+				Value: []byte("bar"),
+			},
+		},
+		{
+			name:  "SET blob key and value",
+			input: "SET 0x00ff b64:AP8=",
+			wantCommand: &Command{
+				Type:  CommandSet,
+				Key:   "\x00\xff",
+				Value: []byte{0x00, 0xff},
+			},
+		},
+		{
+			name:  "SET empty string",
+			input: `SET foo ""`,
+			wantCommand: &Command{
+				Type:  CommandSet,
+				Key:   "foo",
+				Value: []byte{},
+			},
+		},
+		{
+			name:  "GET empty key",
+			input: "GET ''",
+			wantCommand: &Command{
+				Type: CommandGet,
+				Key:  "",
+			},
+		},
+		{
+			name:      "SET malformed 0x blob",
+			input:     "SET foo 0xabc",
+			wantError: InvalidLiteralError,
+		},
+		{
+			name:  "DEBUG VERSIONS",
+			input: "debug versions 'a b'",
+			wantCommand: &Command{
+				Type: CommandDebugVersions,
+				Key:  "a b",
+			},
+		},
+		{
+			name:  "DEBUG SNAPSHOT",
+			input: "DEBUG SNAPSHOT",
+			wantCommand: &Command{
+				Type: CommandDebugSnapshot,
+			},
+		},
+		{
+			name:  "DEBUG TX",
+			input: "DEBUG TX",
+			wantCommand: &Command{
+				Type: CommandDebugTx,
+			},
+		},
+		{
+			name:      "DEBUG VERSIONS missing key",
+			input:     "DEBUG VERSIONS",
+			wantError: InvalidNumberOfTokens,
+		},
+		{
+			name:      "DEBUG unknown",
+			input:     "DEBUG FOO",
+			wantError: InvalidCommandError,
+		},
+		{
+			name:  "INFO",
+			input: "INFO",
+			wantCommand: &Command{
+				Type: CommandInfo,
+			},
+		},
+		{
+			name:  "INFO section",
+			input: "info WAL",
+			wantCommand: &Command{
+				Type:    CommandInfo,
+				Section: "wal",
+			},
+		},
+		{
+			name:      "INFO quoted section",
+			input:     "INFO 'wal'",
+			wantError: InvalidCommandError,
+		},
+		{
+			name:  "DBSIZE",
+			input: "DBSIZE;",
+			wantCommand: &Command{
+				Type: CommandDbSize,
+			},
+		},
+		{
+			name:      "DELETE invalid key",
+			input:     "DELETE ;",
+			wantError: InvalidNumberOfTokens,
+		},
+		{
+			name:      "GET extra token",
+			input:     "GET foo bar",
+			wantError: InvalidNumberOfTokens,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			cmd, err := Parse(tt.input)
+			commands, err := Parse(tt.input)
 
 			if tt.wantError != nil {
-				test.AssertError(t, tt.wantError, err)
+				test.AssertError(t, err, tt.wantError)
 				return
 			}
 
 			test.AssertNoError(t, err)
+			test.AssertEqual(t, len(commands), 1)
+
+			cmd := commands[0]
 			test.AssertEqual(t, cmd.Type, tt.wantCommand.Type)
 			test.AssertEqual(t, cmd.Key, tt.wantCommand.Key)
+			test.AssertEqual(t, cmd.Savepoint, tt.wantCommand.Savepoint)
+			test.AssertEqual(t, cmd.Section, tt.wantCommand.Section)
 			test.AssertBytesEqual(t, cmd.Value, tt.wantCommand.Value)
+			test.AssertEqual(t, cmd.Value == nil, tt.wantCommand.Value == nil)
+		})
+	}
+}
+
+func TestParse_Script(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		wantTypes []CommandType
+		wantError error
+	}{
+		{
+			name:      "block",
+			input:     "BEGIN; SET a 1; GET b; COMMIT;",
+			wantTypes: []CommandType{CommandBegin, CommandSet, CommandGet, CommandCommit},
+		},
+		{
+			name:      "empty statements",
+			input:     ";; transaction begin ;; rollback",
+			wantTypes: []CommandType{CommandBegin, CommandAbort},
+		},
+		{
+			name:      "statements across lines",
+			input:     "SET a 'x;y'\n;\nROLLBACK TO sp1",
+			wantTypes: []CommandType{CommandSet, CommandRollbackTo},
+		},
+		{
+			name:      "only semicolons",
+			input:     ";;",
+			wantError: InvalidCommandError,
+		},
+		{
+			name:      "missing semicolon",
+			input:     "SET a 1 SET b 2",
+			wantError: InvalidNumberOfTokens,
+		},
+		{
+			name:      "missing value before semicolon",
+			input:     "SET a; SET b 2",
+			wantError: InvalidNumberOfTokens,
+		},
+		{
+			name:      "error in a later statement",
+			input:     "BEGIN; FOO; COMMIT",
+			wantError: InvalidCommandError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commands, err := Parse(tt.input)
+
+			if tt.wantError != nil {
+				test.AssertError(t, err, tt.wantError)
+				return
+			}
+
+			test.AssertNoError(t, err)
+			test.AssertEqual(t, len(commands), len(tt.wantTypes))
+
+			for i, cmd := range commands {
+				test.AssertEqual(t, cmd.Type, tt.wantTypes[i])
+			}
+		})
+	}
+}
+
+func TestParse_ErrorPosition(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		wantPos     Position
+		wantPointer string
+	}{
+		{
+			name:        "invalid key",
+			input:       "GET !",
+			wantPos:     Position{Offset: 4, Line: 1, Column: 5},
+			wantPointer: "GET !\n    ^",
+		},
+		{
+			name:        "extra token",
+			input:       "SET foo bar baz",
+			wantPos:     Position{Offset: 12, Line: 1, Column: 13},
+			wantPointer: "SET foo bar baz\n            ^",
+		},
+		{
+			name:        "unterminated string points at its quote",
+			input:       "SET foo 'bar",
+			wantPos:     Position{Offset: 8, Line: 1, Column: 9},
+			wantPointer: "SET foo 'bar\n        ^",
+		},
+		{
+			name:        "second line",
+			input:       "SET foo\n\t'é' bar",
+			wantPos:     Position{Offset: 14, Line: 2, Column: 6},
+			wantPointer: "\t'é' bar\n\t    ^",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.input)
+
+			var syntaxErr *SyntaxError
+			test.AssertTrue(t, errors.As(err, &syntaxErr))
+			test.AssertEqual(t, syntaxErr.Pos, tt.wantPos)
+			test.AssertEqual(t, syntaxErr.Pointer(), tt.wantPointer)
 		})
 	}
 }
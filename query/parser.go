@@ -0,0 +1,354 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parser is a recursive descent parser over the tokens of the lexer, with
+// one token of lookahead.
+//
+//	script      = [ statement ] { ";" [ statement ] }
+//	statement   = set | get | delete | transaction | savepoint | rollback
+//	            | release | debug | info | "DBSIZE" | "EXIT" | "HELP"
+//	set         = "SET" key value
+//	get         = "GET" key
+//	delete      = "DELETE" key
+//	transaction = [ "TRANSACTION" ] ( "BEGIN" | "COMMIT" | "ABORT" )
+//	savepoint   = "SAVEPOINT" name
+//	rollback    = "ROLLBACK" [ "TO" name ]
+//	release     = "RELEASE" name
+//	debug       = "DEBUG" ( "VERSIONS" key | "SNAPSHOT" | "TX" )
+//	info        = "INFO" [ identifier ]
+//	name        = identifier | integer | float
+//	key, value  = name | string | blob | "?"
+//
+// A ROLLBACK without a savepoint aborts the transaction.
+type parser struct {
+	lexer *lexer
+	token Token
+
+	// placeholders counts the placeholders parsed so far.
+	placeholders int
+}
+
+func newParser(input string) (*parser, error) {
+	p := &parser{lexer: newLexer(input)}
+
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p *parser) next() error {
+	token, err := p.lexer.Next()
+	if err != nil {
+		return err
+	}
+
+	p.token = token
+	return nil
+}
+
+// parseScript parses the statements of the whole input, there has to be at
+// least one. Placeholders are numbered across all of them.
+func (p *parser) parseScript() ([]Statement, error) {
+	var statements []Statement
+
+	for {
+		switch p.token.Kind {
+		case TokenEOF:
+			if len(statements) == 0 {
+				return nil, p.errorf(InvalidCommandError, "expected a command")
+			}
+
+			return statements, nil
+
+		case TokenSemicolon:
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		statement, err := p.parseStatement()
+		if err != nil {
+			return nil, err
+		}
+
+		statements = append(statements, statement)
+	}
+}
+
+// parseStatement parses the statement starting at the current token, up to
+// the semicolon or the end of the input after it.
+func (p *parser) parseStatement() (Statement, error) {
+	if p.token.Kind != TokenIdent {
+		return nil, p.errorf(InvalidCommandError, "expected a command, found %s", p.token)
+	}
+
+	start := p.token.Pos
+	keyword := strings.ToUpper(p.token.Text)
+
+	var statement Statement
+	var err error
+
+	switch keyword {
+	case SET:
+		statement, err = p.parseSet(start)
+	case GET:
+		statement, err = p.parseKeyStatement(func(key Name) Statement {
+			return &GetStatement{StartPos: start, Key: key}
+		})
+	case DELETE:
+		statement, err = p.parseKeyStatement(func(key Name) Statement {
+			return &DeleteStatement{StartPos: start, Key: key}
+		})
+	case LOCK:
+		statement, err = p.parseKeyStatement(func(key Name) Statement {
+			return &LockStatement{StartPos: start, Key: key}
+		})
+	case TRANSACTION:
+		statement, err = p.parseTransaction(start)
+	case BEGIN, COMMIT, ABORT:
+		statement, err = p.parseTransactionAction(start)
+	case SAVEPOINT:
+		statement, err = p.parseSavepoint(start, CommandSavepoint)
+	case ROLLBACK:
+		statement, err = p.parseRollback(start)
+	case RELEASE:
+		statement, err = p.parseSavepoint(start, CommandRelease)
+	case DEBUG:
+		statement, err = p.parseDebug(start)
+	case INFO:
+		statement, err = p.parseInfo(start)
+	case DBSIZE:
+		statement, err = &DbSizeStatement{StartPos: start}, p.next()
+	case EXIT:
+		statement, err = &ExitStatement{StartPos: start}, p.next()
+	case HELP:
+		statement, err = &HelpStatement{StartPos: start}, p.next()
+	default:
+		return nil, p.errorf(InvalidCommandError, "unknown command %s", p.token.Text)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.atEnd() {
+		return nil, p.errorf(InvalidNumberOfTokens, "unexpected %s after %s", p.token, keyword)
+	}
+
+	return statement, nil
+}
+
+func (p *parser) parseSet(start Position) (Statement, error) {
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	key, err := p.parseKey()
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+
+	return &SetStatement{StartPos: start, Key: key, Value: value}, nil
+}
+
+func (p *parser) parseKeyStatement(build func(key Name) Statement) (Statement, error) {
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	key, err := p.parseKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return build(key), nil
+}
+
+func (p *parser) parseTransaction(start Position) (Statement, error) {
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	if p.atEnd() {
+		return nil, p.errorf(InvalidNumberOfTokens, "expected BEGIN, COMMIT or ABORT")
+	}
+
+	return p.parseTransactionAction(start)
+}
+
+// parseTransactionAction parses the BEGIN, COMMIT or ABORT that is the
+// current token.
+func (p *parser) parseTransactionAction(start Position) (Statement, error) {
+	var action CommandType
+
+	switch strings.ToUpper(p.token.Text) {
+	case BEGIN:
+		action = CommandBegin
+	case COMMIT:
+		action = CommandCommit
+	case ABORT:
+		action = CommandAbort
+	default:
+		return nil, p.errorf(InvalidCommandError, "expected BEGIN, COMMIT or ABORT, found %s", p.token)
+	}
+
+	return &TransactionStatement{StartPos: start, Action: action}, p.next()
+}
+
+func (p *parser) parseRollback(start Position) (Statement, error) {
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	if p.atEnd() {
+		return &TransactionStatement{StartPos: start, Action: CommandAbort}, nil
+	}
+
+	if strings.ToUpper(p.token.Text) != TO {
+		return nil, p.errorf(InvalidCommandError, "expected TO, found %s", p.token)
+	}
+
+	return p.parseSavepoint(start, CommandRollbackTo)
+}
+
+func (p *parser) parseDebug(start Position) (Statement, error) {
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	if p.atEnd() {
+		return nil, p.errorf(InvalidNumberOfTokens, "expected VERSIONS, SNAPSHOT or TX")
+	}
+
+	switch strings.ToUpper(p.token.Text) {
+	case VERSIONS:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+
+		return &DebugStatement{StartPos: start, Action: CommandDebugVersions, Key: key}, nil
+	case SNAPSHOT:
+		return &DebugStatement{StartPos: start, Action: CommandDebugSnapshot}, p.next()
+	case TX:
+		return &DebugStatement{StartPos: start, Action: CommandDebugTx}, p.next()
+	default:
+		return nil, p.errorf(InvalidCommandError, "expected VERSIONS, SNAPSHOT or TX, found %s", p.token)
+	}
+}
+
+func (p *parser) parseInfo(start Position) (Statement, error) {
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	if p.atEnd() {
+		return &InfoStatement{StartPos: start}, nil
+	}
+
+	if p.token.Kind != TokenIdent {
+		return nil, p.errorf(InvalidCommandError, "expected a section, found %s", p.token)
+	}
+
+	section := Name{NamePos: p.token.Pos, Text: strings.ToLower(p.token.Text)}
+	return &InfoStatement{StartPos: start, Section: section}, p.next()
+}
+
+// parseSavepoint parses the savepoint name after the keyword that is the
+// current token.
+func (p *parser) parseSavepoint(start Position, action CommandType) (Statement, error) {
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	if p.atEnd() {
+		return nil, p.errorf(InvalidNumberOfTokens, "expected a savepoint name")
+	}
+
+	name, ok := p.name()
+	if !ok {
+		return nil, p.errorf(InvalidSavepointNameError, "found %s", p.token)
+	}
+
+	return &SavepointStatement{StartPos: start, Action: action, Name: name}, p.next()
+}
+
+// parseKey parses a key, which can be any string: one that is not a bare
+// word is written as a string or a blob.
+func (p *parser) parseKey() (Name, error) {
+	if p.atEnd() {
+		return Name{}, p.errorf(InvalidNumberOfTokens, "expected a key")
+	}
+
+	key, ok := p.name()
+
+	switch {
+	case ok:
+	case p.token.Kind == TokenString, p.token.Kind == TokenBlob:
+		key = Name{NamePos: p.token.Pos, Text: string(p.token.Value)}
+	case p.token.Kind == TokenPlaceholder:
+		p.placeholders++
+		key = Name{NamePos: p.token.Pos, Placeholder: p.placeholders}
+	default:
+		return Name{}, p.errorf(InvalidKeyError, "found %s", p.token)
+	}
+
+	return key, p.next()
+}
+
+func (p *parser) parseValue() (Literal, error) {
+	switch p.token.Kind {
+	case TokenEOF, TokenSemicolon:
+		return Literal{}, p.errorf(InvalidNumberOfTokens, "expected a value")
+	case TokenIdent, TokenInt, TokenFloat, TokenString, TokenBlob:
+		value := Literal{Token: p.token}
+		return value, p.next()
+	case TokenPlaceholder:
+		p.placeholders++
+		value := Literal{Token: p.token, Placeholder: p.placeholders}
+		return value, p.next()
+	default:
+		return Literal{}, p.errorf(InvalidLiteralError, "expected a value, found %s", p.token)
+	}
+}
+
+// name returns the current token as a name, if it is a bare word.
+func (p *parser) name() (Name, bool) {
+	switch p.token.Kind {
+	case TokenIdent, TokenInt, TokenFloat:
+		return Name{NamePos: p.token.Pos, Text: p.token.Text}, true
+	default:
+		return Name{}, false
+	}
+}
+
+// atEnd reports whether the current statement ends at the current token.
+func (p *parser) atEnd() bool {
+	return p.token.Kind == TokenEOF || p.token.Kind == TokenSemicolon
+}
+
+func (p *parser) errorf(err error, format string, args ...any) error {
+	return &SyntaxError{
+		Err:    err,
+		Pos:    p.token.Pos,
+		Detail: fmt.Sprintf(format, args...),
+		Input:  p.lexer.input,
+	}
+}
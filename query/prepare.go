@@ -0,0 +1,45 @@
+package query
+
+import "fmt"
+
+// Prepared is a query parsed once to be run many times, with arguments in
+// place of its ? placeholders. Arguments are bound as they are, so they need
+// no quoting or escaping and cannot change the statements of the query.
+type Prepared struct {
+	statements   []Statement
+	placeholders int
+}
+
+// Prepare parses one or more commands separated by semicolons, in which keys
+// and values may be given as ? placeholders, e.g. "SET ? ?".
+func Prepare(input string) (*Prepared, error) {
+	statements, placeholders, err := parseScript(input)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Prepared{
+		statements:   statements,
+		placeholders: placeholders,
+	}, nil
+}
+
+// NumArgs returns how many arguments the query takes.
+func (p *Prepared) NumArgs() int {
+	return p.placeholders
+}
+
+// Bind returns the commands of the query, with the arguments in place of the
+// placeholders in the order they appear.
+func (p *Prepared) Bind(args ...[]byte) ([]*Command, error) {
+	if len(args) != p.placeholders {
+		return nil, fmt.Errorf("%w: the query takes %d, got %d", ArgumentCountError, p.placeholders, len(args))
+	}
+
+	commands := make([]*Command, len(p.statements))
+	for i, statement := range p.statements {
+		commands[i] = toCommand(statement, args)
+	}
+
+	return commands, nil
+}
@@ -0,0 +1,83 @@
+package query
+
+import (
+	"kv/test"
+	"testing"
+)
+
+func TestPrepare(t *testing.T) {
+	t.Run("it binds arguments in order", func(t *testing.T) {
+		prepared, err := Prepare("BEGIN; SET ? ?; GET ?; DELETE k; COMMIT")
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, prepared.NumArgs(), 3)
+
+		commands, err := prepared.Bind([]byte("a"), []byte("1"), []byte("b"))
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, len(commands), 5)
+		test.AssertEqual(t, commands[1].Key, "a")
+		test.AssertBytesEqual(t, commands[1].Value, []byte("1"))
+		test.AssertEqual(t, commands[2].Key, "b")
+		test.AssertEqual(t, commands[3].Key, "k")
+	})
+
+	t.Run("it takes arguments as they are", func(t *testing.T) {
+		prepared, err := Prepare("SET ? ?")
+		test.AssertNoError(t, err)
+
+		key := []byte("it's; DELETE x")
+		value := []byte("\"\x00; COMMIT")
+
+		commands, err := prepared.Bind(key, value)
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, len(commands), 1)
+		test.AssertEqual(t, commands[0].Key, string(key))
+		test.AssertBytesEqual(t, commands[0].Value, value)
+	})
+
+	t.Run("it can be bound again with other arguments", func(t *testing.T) {
+		prepared, err := Prepare("SET k ?")
+		test.AssertNoError(t, err)
+
+		arg := []byte("first")
+		first, err := prepared.Bind(arg)
+		test.AssertNoError(t, err)
+
+		copy(arg, "again")
+		second, err := prepared.Bind(arg)
+		test.AssertNoError(t, err)
+
+		test.AssertBytesEqual(t, first[0].Value, []byte("first"))
+		test.AssertBytesEqual(t, second[0].Value, []byte("again"))
+	})
+
+	t.Run("it binds a nil argument as an empty value", func(t *testing.T) {
+		prepared, err := Prepare("SET k ?")
+		test.AssertNoError(t, err)
+
+		commands, err := prepared.Bind(nil)
+		test.AssertNoError(t, err)
+		test.AssertBytesEqual(t, commands[0].Value, []byte{})
+		test.AssertFalse(t, commands[0].Value == nil)
+	})
+
+	t.Run("it fails on the wrong number of arguments", func(t *testing.T) {
+		prepared, err := Prepare("SET ? ?")
+		test.AssertNoError(t, err)
+
+		_, err = prepared.Bind([]byte("a"))
+		test.AssertError(t, err, ArgumentCountError)
+
+		_, err = prepared.Bind([]byte("a"), []byte("b"), []byte("c"))
+		test.AssertError(t, err, ArgumentCountError)
+	})
+
+	t.Run("it does not take placeholders for savepoint names", func(t *testing.T) {
+		_, err := Prepare("SAVEPOINT ?")
+		test.AssertError(t, err, InvalidSavepointNameError)
+	})
+
+	t.Run("Parse fails on placeholders", func(t *testing.T) {
+		_, err := Parse("GET ?")
+		test.AssertError(t, err, ArgumentCountError)
+	})
+}
@@ -0,0 +1,58 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Quote returns data as a double quoted string of the query language, which
+// reads back as the same bytes.
+func Quote(data []byte) string {
+	var builder strings.Builder
+
+	builder.WriteByte('"')
+	writeEscaped(&builder, data, '"')
+	builder.WriteByte('"')
+
+	return builder.String()
+}
+
+// Escape returns data with backslashes, control characters and anything else
+// that is not printable UTF-8 written as escape sequences, leaving quotes as
+// they are.
+func Escape(data []byte) string {
+	var builder strings.Builder
+	writeEscaped(&builder, data, 0)
+
+	return builder.String()
+}
+
+func writeEscaped(builder *strings.Builder, data []byte, quote byte) {
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+
+		switch {
+		case r == '\\' || quote != 0 && r == rune(quote):
+			builder.WriteByte('\\')
+			builder.WriteRune(r)
+		case r == '\n':
+			builder.WriteString(`\n`)
+		case r == '\r':
+			builder.WriteString(`\r`)
+		case r == '\t':
+			builder.WriteString(`\t`)
+		case r == 0:
+			builder.WriteString(`\0`)
+		case r == utf8.RuneError && size == 1, !unicode.IsPrint(r):
+			for _, b := range data[:size] {
+				_, _ = fmt.Fprintf(builder, `\x%02x`, b)
+			}
+		default:
+			builder.Write(data[:size])
+		}
+
+		data = data[size:]
+	}
+}
@@ -0,0 +1,33 @@
+package query
+
+import (
+	"kv/test"
+	"testing"
+)
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		want  string
+		plain string
+	}{
+		{name: "printable", data: []byte("héllo world"), want: `"héllo world"`, plain: `héllo world`},
+		{name: "quotes and backslashes", data: []byte(`a"b'c\`), want: `"a\"b'c\\"`, plain: `a"b'c\\`},
+		{name: "control characters", data: []byte("a\nb\tc\r\x00\x7f"), want: `"a\nb\tc\r\0\x7f"`, plain: `a\nb\tc\r\0\x7f`},
+		{name: "invalid UTF-8", data: []byte{0xff, 'a', 0xc3}, want: `"\xffa\xc3"`, plain: `\xffa\xc3`},
+		{name: "empty", data: []byte{}, want: `""`, plain: ``},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			test.AssertEqual(t, Quote(tt.data), tt.want)
+			test.AssertEqual(t, Escape(tt.data), tt.plain)
+
+			tokens, err := Tokenize(Quote(tt.data))
+			test.AssertNoError(t, err)
+			test.AssertEqual(t, tokens[0].Kind, TokenString)
+			test.AssertBytesEqual(t, tokens[0].Value, tt.data)
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package query
+
+import "fmt"
+
+type TokenKind uint8
+
+const (
+	TokenEOF TokenKind = iota
+	// TokenIllegal is a character that starts no token.
+	TokenIllegal
+	// TokenIdent is a bare word, e.g. a keyword, a key or a savepoint name.
+	TokenIdent
+	TokenInt
+	TokenFloat
+	// TokenString is a single or double quoted string.
+	TokenString
+	// TokenBlob is binary data written in hex, x'00ff' or 0x00ff, or in
+	// base64, b64'AP8=' or b64:AP8=.
+	TokenBlob
+	// TokenSemicolon separates statements.
+	TokenSemicolon
+	// TokenPlaceholder is a ?, which stands for an argument of a prepared
+	// query.
+	TokenPlaceholder
+)
+
+func (k TokenKind) String() string {
+	switch k {
+	case TokenEOF:
+		return "end of input"
+	case TokenIllegal:
+		return "illegal character"
+	case TokenIdent:
+		return "identifier"
+	case TokenInt:
+		return "integer"
+	case TokenFloat:
+		return "float"
+	case TokenString:
+		return "string"
+	case TokenBlob:
+		return "blob"
+	case TokenSemicolon:
+		return "semicolon"
+	case TokenPlaceholder:
+		return "placeholder"
+	default:
+		return fmt.Sprintf("token(%d)", k)
+	}
+}
+
+// Position is a place in the input. Lines and columns start at 1, columns
+// count characters rather than bytes.
+type Position struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+type Token struct {
+	Kind TokenKind
+	Pos  Position
+
+	// Text is the token as written in the input.
+	Text string
+
+	// Value is the decoded content of strings and blobs.
+	Value []byte
+}
+
+func (t Token) String() string {
+	if t.Kind == TokenEOF {
+		return t.Kind.String()
+	}
+
+	return fmt.Sprintf("%s %q", t.Kind, t.Text)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"kv/encryption"
+	"kv/storage"
+
+	"github.com/rs/zerolog/log"
+)
+
+// runReencrypt rewrites the log and the manifests with the active encryption
+// key. To rotate keys, add a key with a higher ID, run it, then drop the old
+// key. Without any keys loaded it decrypts the store instead. It is also how
+// a store gets encrypted for the first time, being the only place unencrypted
+// manifests are read with keys loaded.
+func runReencrypt(cfg Config) (err error) {
+	if cfg.ReadOnly {
+		return errors.New("re-encryption rewrites the store and cannot run read-only")
+	}
+
+	var closers Disposer
+	defer func() {
+		err = errors.Join(err, closers.Dispose())
+	}()
+
+	fs, err := openDataDir(cfg, &closers)
+	if err != nil {
+		return err
+	}
+
+	storageManager := storage.NewManager(fs)
+
+	// Tracked early so the manifests it opened are closed last.
+	closers.Track(storageManager)
+
+	keyring, err := encryption.LoadKeyring(cfg.EncryptionKeyFile, cfg.EncryptionKeyEnv)
+	if err != nil {
+		return fmt.Errorf("failed to load encryption keys: %w", err)
+	}
+
+	writeAheadLog, err := bootstrapWriteAheadLog(storageManager, keyring, true, cfg, &closers)
+	if err != nil {
+		return err
+	}
+
+	if err = writeAheadLog.Reencrypt(); err != nil {
+		return fmt.Errorf("failed to re-encrypt log: %w", err)
+	}
+
+	txManifest, err := openTxManifest(storageManager, keyring, true, cfg, &closers)
+	if err != nil {
+		return err
+	}
+
+	if err = txManifest.Reseal(); err != nil {
+		return fmt.Errorf("failed to re-encrypt tx manifest: %w", err)
+	}
+
+	log.Info().Uint32("key_id", keyring.ActiveKeyID()).Msg("reencrypt: finished")
+	return nil
+}
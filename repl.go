@@ -1,159 +1,453 @@
 package main
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
-	"kv/engine/tx"
-	"kv/kvstore"
+	"io"
 	"kv/query"
+	"kv/session"
 	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
 )
 
-// TOOD: Clean up
-func startRepl(txManager *tx.Manager, kvStore *kvstore.KVStore) error {
-	reader := bufio.NewScanner(os.Stdin)
+type replOptions struct {
+	// ScriptFile, if set, is run instead of reading commands from standard
+	// input.
+	ScriptFile string
 
-	var currentTx *tx.Transaction
+	// HistoryFile keeps the lines entered at a terminal across sessions,
+	// up to HistorySize of them. Empty keeps history in memory only.
+	HistoryFile string
+	HistorySize int
 
-	fmt.Println("KV server started. Type commands (or 'HELP' for help):")
+	Session session.Options
+}
 
-	running := true
+// repl runs commands read from a terminal, a pipe or a script file. Commands
+// span several lines while a quoted value is left open or a line ends with a
+// backslash, several of them can be given at once separated by semicolons.
+// Lines starting with a backslash are meta commands, which change how the
+// REPL itself behaves. At a terminal, Ctrl-C discards the command being
+// typed, and Ctrl-C or Ctrl-D on an empty line exits.
+type repl struct {
+	session *session.Session
+
+	input  lineReader
+	out    io.Writer
+	script bool
+
+	timing bool
+	format outputFormat
+
+	// line is where the current command starts, failed counts commands
+	// that failed, both are reported for scripts.
+	line   int
+	failed int
+}
 
-	for running {
-		fmt.Print("> ")
+func newRepl(backend session.Backend, options replOptions) (*repl, error) {
+	r := &repl{
+		session: session.New(backend, options.Session),
+		format:  formatText,
+	}
 
-		if !reader.Scan() {
-			return reader.Err()
+	switch {
+	case options.ScriptFile != "":
+		file, err := os.Open(options.ScriptFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open script: %w", err)
 		}
 
-		cmd, err := query.Parse(reader.Text())
+		r.input = newPlainReader(file, false)
+		r.script = true
+
+	case term.IsTerminal(int(os.Stdin.Fd())):
+		input, err := newTerminalReader(openHistory(options.HistoryFile, options.HistorySize), completeLine)
 		if err != nil {
-			fmt.Println("ERR:", err)
-			continue
+			return nil, err
 		}
 
-		switch cmd.Type {
+		r.input = input
+
+	default:
+		r.input = newPlainReader(os.Stdin, true)
+	}
 
-		case query.CommandExit:
-			running = false
+	r.out = r.input.Output()
+	return r, nil
+}
+
+// Run runs commands until EXIT or the end of the input. A transaction left
+// open is aborted. A script fails if any of its commands failed.
+func (r *repl) Run() error {
+	defer r.session.Abort()
+
+	if !r.script {
+		r.println("KV server started. Type commands (or 'HELP' for help):")
+	}
+
+	lines := 0
+
+	for {
+		input, read, err := r.readCommand()
+		r.line = lines + 1
+		lines += read
+
+		if errors.Is(err, io.EOF) {
 			break
+		}
 
-		case query.CommandHelp:
-			printHelp()
+		if err != nil {
+			return err
+		}
 
-		case query.CommandBegin:
-			if currentTx != nil {
-				fmt.Println("ERR: transaction already active")
-				continue
-			}
+		if strings.TrimSpace(input) == "" {
+			continue
+		}
 
-			tx, err := txManager.Begin()
-			if err != nil {
-				fmt.Println("ERR:", err)
-				continue
-			}
+		start := time.Now()
+		if exit := r.execute(input); exit {
+			break
+		}
 
-			currentTx = tx
-			fmt.Printf("OK (tx %d started)\n", tx.ID)
+		if r.timing {
+			r.printf("Time: %.3f ms\n", float64(time.Since(start).Microseconds())/1000)
+		}
+	}
 
-		case query.CommandCommit:
-			if currentTx == nil {
-				fmt.Println("ERR: no active transaction")
-				continue
-			}
+	if r.script && r.failed > 0 {
+		return fmt.Errorf("script failed, failed commands: %d", r.failed)
+	}
 
-			if err := currentTx.Commit(); err != nil {
-				fmt.Println("ERR:", err)
-				continue
-			}
+	return nil
+}
 
-			currentTx = nil
-			fmt.Println("OK")
+// Close releases the input, putting a terminal back into the mode it was
+// found in.
+func (r *repl) Close() error {
+	return r.input.Close()
+}
 
-		case query.CommandAbort:
-			if currentTx == nil {
-				fmt.Println("ERR: no active transaction")
-				continue
-			}
+// readCommand reads the lines of the next command and returns how many it
+// read. Running out of input in the middle of a command returns what was
+// read, for the parser to complain about. A command discarded with Ctrl-C
+// comes back empty, Ctrl-C on an empty line ends the input.
+func (r *repl) readCommand() (string, int, error) {
+	line, err := r.input.ReadLine(prompt)
+	if errors.Is(err, errInterrupted) {
+		if line == "" {
+			return "", 0, io.EOF
+		}
 
-			currentTx.Abort()
-			currentTx = nil
-			fmt.Println("OK")
+		return "", 1, nil
+	}
 
-		case query.CommandSet:
-			if currentTx == nil {
-				fmt.Println("ERR: no active transaction")
-				continue
-			}
+	if err != nil {
+		return "", 0, err
+	}
 
-			if err := kvStore.Set(cmd.Key, cmd.Value, currentTx); err != nil {
-				fmt.Println("ERR:", err)
-				continue
-			}
+	command := line
+	read := 1
 
-			fmt.Println("OK")
+	for {
+		rest, ok := continuesOnNextLine(command)
+		if !ok {
+			return command, read, nil
+		}
 
-		case query.CommandGet:
-			if currentTx == nil {
-				fmt.Println("ERR: no active transaction")
-				continue
-			}
+		line, err = r.input.ReadLine(continuationPrompt)
+		if errors.Is(err, io.EOF) {
+			return command, read, nil
+		}
 
-			val, err := kvStore.Get(cmd.Key, currentTx)
-			if err != nil {
-				fmt.Println("ERR:", err)
-				continue
-			}
+		if errors.Is(err, errInterrupted) {
+			return "", read + 1, nil
+		}
 
-			if val == nil {
-				fmt.Println("(nil)")
-			} else {
-				fmt.Println(string(val))
-			}
+		if err != nil {
+			return "", read, err
+		}
 
-		case query.CommandDelete:
-			if currentTx == nil {
-				fmt.Println("ERR: no active transaction")
-				continue
-			}
+		command = rest + "\n" + line
+		read++
+	}
+}
 
-			if err := kvStore.Delete(cmd.Key, currentTx); err != nil {
-				fmt.Println("ERR:", err)
-				continue
-			}
+// continuesOnNextLine reports whether a command goes on on the next line,
+// returning it without the trailing backslash that may say so.
+func continuesOnNextLine(command string) (string, bool) {
+	if rest, ok := strings.CutSuffix(command, `\`); ok && !strings.HasPrefix(command, `\`) {
+		return rest, true
+	}
+
+	_, err := query.Tokenize(command)
+	return command, errors.Is(err, query.UnterminatedStringError)
+}
+
+// execute runs the commands of an input and reports whether the REPL should
+// exit.
+func (r *repl) execute(input string) bool {
+	if strings.HasPrefix(strings.TrimSpace(input), `\`) {
+		r.executeMeta(strings.Fields(input))
+		return false
+	}
+
+	commands, err := query.Parse(input)
+	if err != nil {
+		r.fail(err)
+		return false
+	}
 
-			fmt.Println("OK")
+	exit := false
 
+	for _, result := range r.session.Execute(commands) {
+		switch {
+		case errors.Is(result.Err, session.SkippedError):
+			r.println("SKIPPED")
+		case result.Err != nil:
+			r.fail(result.Err)
 		default:
-			fmt.Println("ERR: unsupported command")
+			exit = r.respond(result) || exit
 		}
 	}
 
-	return nil
+	return exit
 }
 
-func printHelp() {
-	fmt.Println()
-	fmt.Println("AVAILABLE COMMANDS")
-	fmt.Println("─────────────────────────────────────────────────────────────────────────────────────")
-	fmt.Printf("  %-24s | %-23s | %s\n", "Name", "Usage", "Description")
-	fmt.Println("─────────────────────────────────────────────────────────────────────────────────────")
+// respond prints the result of a command that succeeded and reports whether
+// it was EXIT.
+func (r *repl) respond(result session.Result) bool {
+	switch result.Command.Type {
+	case query.CommandExit:
+		return true
+	case query.CommandHelp:
+		r.printHelp()
+	case query.CommandBegin:
+		r.printf("OK (tx %d started)\n", result.TxID)
+	case query.CommandGet:
+		r.println(r.format.formatValue(result.Command.Key, result.Value))
+	case query.CommandDbSize:
+		r.printf("%d\n", result.Count)
+	default:
+		if result.Table != nil {
+			r.printTable(result.Table)
+			break
+		}
 
-	order := []query.CommandType{
-		query.CommandBegin,
-		query.CommandCommit,
-		query.CommandAbort,
-		query.CommandGet,
-		query.CommandSet,
-		query.CommandDelete,
-		query.CommandHelp,
-		query.CommandExit,
+		r.println("OK")
 	}
 
-	for _, cmdType := range order {
+	return false
+}
+
+func (r *repl) executeMeta(args []string) {
+	switch args[0] {
+	case `\timing`:
+		switch {
+		case len(args) == 1:
+			r.timing = !r.timing
+		case len(args) == 2 && (args[1] == "on" || args[1] == "off"):
+			r.timing = args[1] == "on"
+		default:
+			r.fail(errors.New(`usage: \timing [on|off]`))
+			return
+		}
+
+		if r.timing {
+			r.println("Timing is on.")
+		} else {
+			r.println("Timing is off.")
+		}
+
+	case `\autocommit`:
+		switch {
+		case len(args) == 1:
+			r.session.SetAutoCommit(!r.session.AutoCommit())
+		case len(args) == 2 && (args[1] == "on" || args[1] == "off"):
+			r.session.SetAutoCommit(args[1] == "on")
+		default:
+			r.fail(errors.New(`usage: \autocommit [on|off]`))
+			return
+		}
+
+		if r.session.AutoCommit() {
+			r.println("Auto-commit is on.")
+		} else {
+			r.println("Auto-commit is off.")
+		}
+
+	case `\format`:
+		if len(args) > 2 {
+			r.fail(fmt.Errorf(`usage: \format [%s]`, joinFormats("|")))
+			return
+		}
+
+		if len(args) == 2 {
+			format, err := parseOutputFormat(args[1])
+			if err != nil {
+				r.fail(err)
+				return
+			}
+
+			r.format = format
+		}
+
+		r.printf("Output format is %s.\n", r.format)
+
+	default:
+		r.fail(fmt.Errorf("unknown meta command %s", args[0]))
+	}
+}
+
+func (r *repl) printTable(table *session.Table) {
+	for _, line := range r.format.formatTable(table) {
+		r.println(line)
+	}
+}
+
+func (r *repl) fail(err error) {
+	r.failed++
+
+	if r.script {
+		r.printf("ERR (line %d): %v\n", r.line, err)
+	} else {
+		r.println("ERR:", err)
+	}
+
+	var syntaxErr *query.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		r.println(syntaxErr.Pointer())
+	}
+}
+
+func (r *repl) println(a ...any) {
+	_, _ = fmt.Fprintln(r.out, a...)
+}
+
+func (r *repl) printf(format string, a ...any) {
+	_, _ = fmt.Fprintf(r.out, format, a...)
+}
+
+// commandOrder is the order commands are listed in by HELP.
+var commandOrder = []query.CommandType{
+	query.CommandBegin,
+	query.CommandCommit,
+	query.CommandAbort,
+	query.CommandSavepoint,
+	query.CommandRollbackTo,
+	query.CommandRelease,
+	query.CommandGet,
+	query.CommandSet,
+	query.CommandDelete,
+	query.CommandLock,
+	query.CommandDebugVersions,
+	query.CommandDebugSnapshot,
+	query.CommandDebugTx,
+	query.CommandInfo,
+	query.CommandDbSize,
+	query.CommandHelp,
+	query.CommandExit,
+}
+
+var metaCommands = []query.CommandMeta{
+	{
+		Name:        `\timing`,
+		Usage:       `\timing [on|off]`,
+		Description: "Show how long each command takes",
+	},
+	{
+		Name:        `\autocommit`,
+		Usage:       `\autocommit [on|off]`,
+		Description: "Run commands outside of transactions",
+	},
+	{
+		Name:        `\format`,
+		Usage:       `\format [` + joinFormats("|") + `]`,
+		Description: "Set how values are printed",
+	},
+}
+
+func (r *repl) printHelp() {
+	r.println()
+	r.println("AVAILABLE COMMANDS")
+	r.println("─────────────────────────────────────────────────────────────────────────────────────")
+	r.printf("  %-24s | %-23s | %s\n", "Name", "Usage", "Description")
+	r.println("─────────────────────────────────────────────────────────────────────────────────────")
+
+	for _, cmdType := range commandOrder {
 		meta := query.CommandRegistry[cmdType]
-		fmt.Printf("- %-25s %-25s %s\n", meta.Name, meta.Usage, meta.Description)
+		r.printf("- %-25s %-25s %s\n", meta.Name, meta.Usage, meta.Description)
+	}
+
+	for _, meta := range metaCommands {
+		r.printf("- %-25s %-25s %s\n", meta.Name, meta.Usage, meta.Description)
+	}
+
+	r.println()
+}
+
+// completeLine completes the command or meta command argument in front of
+// the cursor, as far as all candidates agree.
+func completeLine(line string, pos int) (string, int, bool) {
+	before := line[:pos]
+	prefix := strings.TrimLeft(before, " ")
+
+	var candidates []string
+
+	if arg, ok := strings.CutPrefix(prefix, `\format `); ok {
+		prefix = arg
+
+		for _, format := range outputFormats {
+			candidates = append(candidates, string(format))
+		}
+	} else {
+		for _, cmdType := range commandOrder {
+			candidates = append(candidates, query.CommandRegistry[cmdType].Name)
+		}
+
+		for _, meta := range metaCommands {
+			candidates = append(candidates, meta.Name)
+		}
+	}
+
+	var matches []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(strings.ToUpper(candidate), strings.ToUpper(prefix)) {
+			matches = append(matches, candidate)
+		}
+	}
+
+	if len(matches) == 0 {
+		return "", 0, false
+	}
+
+	completion := matches[0]
+	for _, match := range matches[1:] {
+		for !strings.HasPrefix(strings.ToUpper(match), strings.ToUpper(completion)) {
+			completion = completion[:len(completion)-1]
+		}
+	}
+
+	if len(matches) == 1 {
+		completion += " "
+	}
+
+	if len(completion) <= len(prefix) {
+		return "", 0, false
+	}
+
+	completed := before[:len(before)-len(prefix)] + completion
+	return completed + line[pos:], len(completed), true
+}
+
+func joinFormats(separator string) string {
+	names := make([]string, len(outputFormats))
+	for i, format := range outputFormats {
+		names[i] = string(format)
 	}
 
-	fmt.Println()
+	return strings.Join(names, separator)
 }
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+const (
+	prompt             = "> "
+	continuationPrompt = "... "
+)
+
+// errInterrupted is returned by a terminal for a line discarded with Ctrl-C.
+var errInterrupted = errors.New("interrupted")
+
+// lineReader feeds the REPL one line of input at a time.
+type lineReader interface {
+	// ReadLine returns the next line without its line break, io.EOF once
+	// the input is exhausted. A terminal also returns io.EOF for Ctrl-D on
+	// an empty line, and errInterrupted along with the line for Ctrl-C.
+	ReadLine(prompt string) (string, error)
+	// Output is where responses go, the terminal has to be written to
+	// through its own writer while it is in raw mode.
+	Output() io.Writer
+	Close() error
+}
+
+// terminalReader reads from an interactive terminal, with line editing,
+// history and completion.
+type terminalReader struct {
+	terminal *term.Terminal
+	input    *interruptReader
+	fd       int
+	state    *term.State
+	history  *fileHistory
+
+	// interrupted is set when Ctrl-C discarded the line being read.
+	interrupted bool
+	discarded   string
+}
+
+func newTerminalReader(history *fileHistory, complete func(line string, pos int) (string, int, bool)) (*terminalReader, error) {
+	fd := int(os.Stdin.Fd())
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up terminal: %w", err)
+	}
+
+	input := &interruptReader{reader: os.Stdin}
+
+	terminal := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{input, os.Stdout}, prompt)
+
+	if width, height, err := term.GetSize(fd); err == nil && width > 0 {
+		_ = terminal.SetSize(width, height)
+	}
+
+	r := &terminalReader{
+		terminal: terminal,
+		input:    input,
+		fd:       fd,
+		state:    state,
+		history:  history,
+	}
+
+	terminal.History = history
+	terminal.AutoCompleteCallback = func(line string, pos int, key rune) (string, int, bool) {
+		switch key {
+		case keyInterrupt:
+			r.interrupt(line)
+			return "", 0, true
+		case '\t':
+			return complete(line, pos)
+		default:
+			return "", 0, false
+		}
+	}
+
+	return r, nil
+}
+
+func (r *terminalReader) ReadLine(prompt string) (string, error) {
+	r.terminal.SetPrompt(prompt)
+	r.interrupted = false
+
+	line, err := r.terminal.ReadLine()
+	if r.interrupted {
+		return r.discarded, errInterrupted
+	}
+
+	return line, err
+}
+
+// interrupt clears the line for Ctrl-C and ends it, keeping what it held for
+// ReadLine to return.
+func (r *terminalReader) interrupt(line string) {
+	r.interrupted = true
+	r.discarded = line
+	r.input.enter()
+}
+
+func (r *terminalReader) Output() io.Writer {
+	return r.terminal
+}
+
+// Close puts the terminal back into the mode it was found in.
+func (r *terminalReader) Close() error {
+	return errors.Join(term.Restore(r.fd, r.state), r.history.Close())
+}
+
+// keyInterrupt stands in for Ctrl-C on its way to the terminal, which would
+// otherwise end ReadLine with io.EOF whatever the line holds. Unlike Ctrl-C,
+// the terminal hands it to the completion callback along with the line.
+const keyInterrupt = 0x1c
+
+// interruptReader passes input on to the terminal with Ctrl-C replaced by
+// keyInterrupt. Input is passed up to the first Ctrl-C at a time, so that
+// what follows it lands on a new line.
+type interruptReader struct {
+	reader  io.Reader
+	buf     [256]byte
+	pending []byte
+}
+
+func (r *interruptReader) Read(p []byte) (int, error) {
+	if len(r.pending) == 0 {
+		n, err := r.reader.Read(r.buf[:])
+		if n == 0 {
+			return 0, err
+		}
+
+		r.pending = r.buf[:n]
+	}
+
+	data := r.pending
+	if i := bytes.IndexByte(data, 3); i >= 0 {
+		data[i] = keyInterrupt
+		data = data[:i+1]
+	}
+
+	n := copy(p, data)
+	r.pending = r.pending[n:]
+
+	return n, nil
+}
+
+// enter has the terminal read an Enter key next, ending the line.
+func (r *interruptReader) enter() {
+	r.pending = append([]byte{'\r'}, r.pending...)
+}
+
+// plainReader reads from a pipe or a script file. Prompts are only shown
+// for standard input, so that piped sessions look like interactive ones.
+type plainReader struct {
+	scanner *bufio.Scanner
+	closer  io.Closer
+	prompts bool
+}
+
+func newPlainReader(input io.Reader, prompts bool) *plainReader {
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(nil, 16*1024*1024)
+
+	reader := &plainReader{scanner: scanner, prompts: prompts}
+	if closer, ok := input.(io.Closer); ok && input != os.Stdin {
+		reader.closer = closer
+	}
+
+	return reader
+}
+
+func (r *plainReader) ReadLine(prompt string) (string, error) {
+	if r.prompts {
+		fmt.Print(prompt)
+	}
+
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return "", err
+		}
+
+		return "", io.EOF
+	}
+
+	return strings.TrimSuffix(r.scanner.Text(), "\r"), nil
+}
+
+func (r *plainReader) Output() io.Writer {
+	return os.Stdout
+}
+
+func (r *plainReader) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+
+	return r.closer.Close()
+}
+
+// fileHistory keeps the lines entered at the terminal, most recent last in
+// a file, so that they are there again in the next session. It implements
+// term.History.
+type fileHistory struct {
+	entries []string
+	limit   int
+	file    *os.File
+}
+
+// openHistory loads the history kept at path, an empty path keeps it in
+// memory only. Failing to open the file is not fatal, the session goes on
+// without persistent history.
+func openHistory(path string, limit int) *fileHistory {
+	history := &fileHistory{limit: limit}
+
+	if path == "" {
+		return history
+	}
+
+	if data, err := os.ReadFile(path); err == nil {
+		lines := 0
+
+		for line := range strings.Lines(string(data)) {
+			history.append(strings.TrimSuffix(line, "\n"))
+			lines++
+		}
+
+		// The file is only appended to while in use, it is cut back to the
+		// limit once it has grown well past it.
+		if lines > 2*limit {
+			_ = os.WriteFile(path, []byte(strings.Join(history.entries, "\n")+"\n"), 0600)
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err == nil {
+		history.file = file
+	}
+
+	return history
+}
+
+func (h *fileHistory) Add(entry string) {
+	if strings.TrimSpace(entry) == "" {
+		return
+	}
+
+	if len(h.entries) > 0 && h.entries[len(h.entries)-1] == entry {
+		return
+	}
+
+	h.append(entry)
+
+	if h.file != nil {
+		_, _ = h.file.WriteString(entry + "\n")
+	}
+}
+
+func (h *fileHistory) Len() int {
+	return len(h.entries)
+}
+
+func (h *fileHistory) At(idx int) string {
+	return h.entries[len(h.entries)-1-idx]
+}
+
+func (h *fileHistory) Close() error {
+	if h.file == nil {
+		return nil
+	}
+
+	return h.file.Close()
+}
+
+func (h *fileHistory) append(entry string) {
+	h.entries = append(h.entries, entry)
+
+	if len(h.entries) > h.limit {
+		h.entries = h.entries[len(h.entries)-h.limit:]
+	}
+}
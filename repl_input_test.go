@@ -0,0 +1,28 @@
+package main
+
+import (
+	"io"
+	"kv/test"
+	"strings"
+	"testing"
+)
+
+func TestInterruptReader(t *testing.T) {
+	t.Run("it passes input up to a Ctrl-C at a time", func(t *testing.T) {
+		r := &interruptReader{reader: strings.NewReader("ab\x03cd\x03")}
+		buf := make([]byte, 16)
+
+		n, err := r.Read(buf)
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, string(buf[:n]), "ab\x1c")
+
+		r.enter()
+
+		n, err = r.Read(buf)
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, string(buf[:n]), "\rcd\x1c")
+
+		_, err = r.Read(buf)
+		test.AssertError(t, err, io.EOF)
+	})
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"kv/query"
+	"kv/session"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// outputFormat is how the REPL prints values, which may be binary.
+type outputFormat string
+
+const (
+	// formatText prints values as text, with bytes that are not printable
+	// escaped as in the query language.
+	formatText outputFormat = "text"
+	// formatRaw prints values as they are.
+	formatRaw outputFormat = "raw"
+	// formatQuoted prints values as double quoted strings that can be
+	// typed back in as they are.
+	formatQuoted outputFormat = "quoted"
+	// formatHex prints values as hexadecimal digits.
+	formatHex outputFormat = "hex"
+	// formatJSON prints a JSON object per value. Values that are not valid
+	// UTF-8 are encoded in base64, which the object says.
+	formatJSON outputFormat = "json"
+)
+
+var outputFormats = []outputFormat{formatText, formatRaw, formatQuoted, formatHex, formatJSON}
+
+func parseOutputFormat(s string) (outputFormat, error) {
+	format := outputFormat(s)
+
+	if !slices.Contains(outputFormats, format) {
+		return "", fmt.Errorf("unknown format %q, expected one of %v", s, outputFormats)
+	}
+
+	return format, nil
+}
+
+func (f outputFormat) formatValue(key string, value []byte) string {
+	if value == nil && f != formatJSON {
+		return "(nil)"
+	}
+
+	switch f {
+	case formatText:
+		return query.Escape(value)
+
+	case formatQuoted:
+		return query.Quote(value)
+
+	case formatHex:
+		return hex.EncodeToString(value)
+
+	case formatJSON:
+		object := struct {
+			Key      string  `json:"key"`
+			Value    *string `json:"value"`
+			Encoding string  `json:"encoding,omitempty"`
+		}{Key: key}
+
+		if value != nil {
+			encoded := string(value)
+			if !utf8.Valid(value) {
+				encoded = base64.StdEncoding.EncodeToString(value)
+				object.Encoding = "base64"
+			}
+
+			object.Value = &encoded
+		}
+
+		data, _ := json.Marshal(object)
+		return string(data)
+
+	default:
+		return string(value)
+	}
+}
+
+// formatTable lays a table out in aligned columns, or as a JSON object per
+// row.
+func (f outputFormat) formatTable(table *session.Table) []string {
+	if f == formatJSON {
+		lines := make([]string, len(table.Rows))
+
+		for i, row := range table.Rows {
+			object := make(map[string]string, len(row))
+			for j, cell := range row {
+				object[table.Columns[j]] = cell
+			}
+
+			data, _ := json.Marshal(object)
+			lines[i] = string(data)
+		}
+
+		return lines
+	}
+
+	widths := make([]int, len(table.Columns))
+	for i, column := range table.Columns {
+		widths[i] = utf8.RuneCountInString(column)
+	}
+
+	for _, row := range table.Rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+
+	line := func(cells []string) string {
+		padded := make([]string, len(cells))
+		for i, cell := range cells {
+			padded[i] = cell + strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell))
+		}
+
+		return strings.TrimRight(strings.Join(padded, " | "), " ")
+	}
+
+	separators := make([]string, len(widths))
+	for i, width := range widths {
+		separators[i] = strings.Repeat("-", width)
+	}
+
+	lines := []string{line(table.Columns), strings.Join(separators, "-+-")}
+	for _, row := range table.Rows {
+		lines = append(lines, line(row))
+	}
+
+	return append(lines, fmt.Sprintf("(%d rows)", len(table.Rows)))
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"kv/session"
+	"kv/test"
+	"testing"
+)
+
+func TestOutputFormat_FormatValue(t *testing.T) {
+	tests := []struct {
+		format outputFormat
+		value  []byte
+		want   string
+	}{
+		{formatText, []byte("a\tb\xff"), `a\tb\xff`},
+		{formatText, nil, "(nil)"},
+		{formatRaw, []byte("a\tb"), "a\tb"},
+		{formatRaw, nil, "(nil)"},
+		{formatQuoted, []byte(`say "hi"`), `"say \"hi\""`},
+		{formatQuoted, nil, "(nil)"},
+		{formatHex, []byte{0x01, 0xab}, "01ab"},
+		{formatHex, nil, "(nil)"},
+		{formatJSON, []byte("value"), `{"key":"k","value":"value"}`},
+		{formatJSON, []byte{0xff, 0x00}, `{"key":"k","value":"/wA=","encoding":"base64"}`},
+		{formatJSON, nil, `{"key":"k","value":null}`},
+	}
+
+	for _, tt := range tests {
+		t.Run("it formats "+string(tt.format)+" values", func(t *testing.T) {
+			test.AssertEqual(t, tt.format.formatValue("k", tt.value), tt.want)
+		})
+	}
+}
+
+func TestOutputFormat_FormatTable(t *testing.T) {
+	table := &session.Table{
+		Columns: []string{"name", "value"},
+		Rows: [][]string{
+			{"pid", "42"},
+			{"data_dir", "./internals"},
+		},
+	}
+
+	tests := []struct {
+		format outputFormat
+		want   []string
+	}{
+		{formatText, []string{
+			"name     | value",
+			"---------+------------",
+			"pid      | 42",
+			"data_dir | ./internals",
+			"(2 rows)",
+		}},
+		{formatJSON, []string{
+			`{"name":"pid","value":"42"}`,
+			`{"name":"data_dir","value":"./internals"}`,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run("it lays out a table as "+string(tt.format), func(t *testing.T) {
+			got := tt.format.formatTable(table)
+
+			test.AssertEqual(t, len(got), len(tt.want))
+			for i := range tt.want {
+				test.AssertEqual(t, got[i], tt.want[i])
+			}
+		})
+	}
+
+	t.Run("it aligns columns by runes", func(t *testing.T) {
+		got := formatText.formatTable(&session.Table{
+			Columns: []string{"k"},
+			Rows:    [][]string{{"äö"}},
+		})
+
+		test.AssertEqual(t, got[0], "k")
+		test.AssertEqual(t, got[1], "--")
+	})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"kv/test"
+	"testing"
+)
+
+// scriptedReader returns its lines in turn, with the error given for each.
+type scriptedReader struct {
+	lines []string
+	errs  []error
+}
+
+func (r *scriptedReader) ReadLine(prompt string) (string, error) {
+	if len(r.lines) == 0 {
+		return "", io.EOF
+	}
+
+	line, err := r.lines[0], r.errs[0]
+	r.lines, r.errs = r.lines[1:], r.errs[1:]
+
+	return line, err
+}
+
+func (r *scriptedReader) Output() io.Writer {
+	return io.Discard
+}
+
+func (r *scriptedReader) Close() error {
+	return nil
+}
+
+func TestRepl_ReadCommand(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		errs  []error
+		want  string
+		read  int
+		err   error
+	}{
+		{"a command", []string{"GET a"}, []error{nil}, "GET a", 1, nil},
+		{"a command over two lines", []string{`SET a \`, "1"}, []error{nil, nil}, "SET a \n1", 2, nil},
+		{"a line discarded with Ctrl-C", []string{"GET a"}, []error{errInterrupted}, "", 1, nil},
+		{"a command discarded with Ctrl-C", []string{`SET a \`, ""}, []error{nil, errInterrupted}, "", 2, nil},
+		{"Ctrl-C on an empty line", []string{""}, []error{errInterrupted}, "", 0, io.EOF},
+	}
+
+	for _, tt := range tests {
+		t.Run("it reads "+tt.name, func(t *testing.T) {
+			r := &repl{input: &scriptedReader{lines: tt.lines, errs: tt.errs}}
+
+			command, read, err := r.readCommand()
+
+			test.AssertTrue(t, errors.Is(err, tt.err))
+			test.AssertEqual(t, command, tt.want)
+			test.AssertEqual(t, read, tt.read)
+		})
+	}
+}
+
+func TestCompleteLine(t *testing.T) {
+	tests := []struct {
+		name      string
+		line      string
+		pos       int
+		want      string
+		wantPos   int
+		completed bool
+	}{
+		{"a single match with a trailing space", "ge", 2, "GET ", 4, true},
+		{"leading spaces kept", "  lo", 4, "  LOCK ", 7, true},
+		{"several matches up to their common prefix", "deb", 3, "DEBUG ", 6, true},
+		{"several matches without a longer common prefix", "de", 2, "", 0, false},
+		{"no match", "xyz", 3, "", 0, false},
+		{"a meta command", `\ti`, 3, `\timing `, 8, true},
+		{"a format argument", `\format j`, 9, `\format json `, 13, true},
+		{"format arguments without a common prefix", `\format `, 8, "", 0, false},
+		{"the cursor in the middle of the line", "ge a", 2, "GET  a", 4, true},
+	}
+
+	for _, tt := range tests {
+		t.Run("it completes "+tt.name, func(t *testing.T) {
+			got, pos, completed := completeLine(tt.line, tt.pos)
+
+			test.AssertEqual(t, completed, tt.completed)
+			test.AssertEqual(t, got, tt.want)
+			test.AssertEqual(t, pos, tt.wantPos)
+		})
+	}
+}
+
+func TestContinuesOnNextLine(t *testing.T) {
+	tests := []struct {
+		name      string
+		command   string
+		want      string
+		continues bool
+	}{
+		{"a complete command", "SET a 1", "SET a 1", false},
+		{"a trailing backslash", `SET a \`, "SET a ", true},
+		{"a meta command ending in a backslash", `\format \`, `\format \`, false},
+		{"an unterminated quote", `SET a "one`, `SET a "one`, true},
+		{"a terminated quote", `SET a "one"`, `SET a "one"`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run("it handles "+tt.name, func(t *testing.T) {
+			got, continues := continuesOnNextLine(tt.command)
+
+			test.AssertEqual(t, continues, tt.continues)
+			test.AssertEqual(t, got, tt.want)
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package session
+
+import (
+	"kv/engine/tx"
+	"strconv"
+	"strings"
+)
+
+// debugVersions lists the versions of a key from the newest, as the
+// transaction sees them.
+func (s *Session) debugVersions(key string, transaction *tx.Transaction) *Table {
+	table := &Table{Columns: []string{"#", "xmin", "xmax", "frozen", "alive", "size", "visible"}}
+
+	chain, ok := s.versionMap.GetChain(key)
+	if !ok {
+		return table
+	}
+
+	i := 0
+	for version := chain.Head(); version != nil; version = version.PreviousVersion() {
+		xMin, xMax := version.XMin(), version.XMax()
+
+		table.Rows = append(table.Rows, []string{
+			strconv.Itoa(i),
+			formatID(xMin),
+			formatID(xMax),
+			yesNo(xMin.IsFrozen()),
+			yesNo(xMax.IsAlive()),
+			strconv.Itoa(len(version.Value)),
+			yesNo(transaction.CanSee(xMin, xMax)),
+		})
+
+		i++
+	}
+
+	return table
+}
+
+func debugSnapshot(transaction *tx.Transaction) *Table {
+	snapshot := transaction.Snapshot()
+
+	active := snapshot.Active()
+	ids := make([]string, len(active))
+	for i, id := range active {
+		ids[i] = formatID(id)
+	}
+
+	return &Table{
+		Columns: []string{"tx", "xmin", "xmax", "active"},
+		Rows: [][]string{{
+			formatID(transaction.ID),
+			formatID(snapshot.XMin()),
+			formatID(snapshot.XMax()),
+			strings.Join(ids, ","),
+		}},
+	}
+}
+
+// debugTx lists the active transactions, marking the one of the session.
+func (s *Session) debugTx() *Table {
+	table := &Table{Columns: []string{"tx", "xmin", "writes", "locks", "session"}}
+
+	for _, transaction := range s.txManager.ActiveTransactions() {
+		table.Rows = append(table.Rows, []string{
+			formatID(transaction.ID),
+			formatID(transaction.Snapshot().XMin()),
+			strconv.Itoa(transaction.WriteCount()),
+			strconv.Itoa(transaction.LockCount()),
+			yesNo(transaction == s.currentTx),
+		})
+	}
+
+	return table
+}
+
+func formatID(id tx.ID) string {
+	return strconv.FormatUint(id.Uint64(), 10)
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+
+	return "no"
+}
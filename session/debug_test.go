@@ -0,0 +1,87 @@
+package session
+
+import (
+	"kv/test"
+	"strconv"
+	"testing"
+)
+
+func TestSession_Debug(t *testing.T) {
+	t.Run("it lists the versions of a key, newest first", func(t *testing.T) {
+		s, _, _ := setup(Options{AutoCommit: true})
+
+		execute(t, s, "SET a 1; SET a 22")
+		results := execute(t, s, "BEGIN; DELETE a; DEBUG VERSIONS a")
+
+		table := results[2].Table
+		test.AssertEqual(t, len(table.Rows), 2)
+
+		txID := strconv.FormatUint(results[0].TxID.Uint64(), 10)
+
+		newest := table.Rows[0]
+		test.AssertEqual(t, newest[2], txID)
+		test.AssertEqual(t, newest[4], "no")
+		test.AssertEqual(t, newest[5], "2")
+		test.AssertEqual(t, newest[6], "no")
+
+		oldest := table.Rows[1]
+		test.AssertEqual(t, oldest[4], "no")
+		test.AssertEqual(t, oldest[5], "1")
+		test.AssertEqual(t, oldest[6], "no")
+	})
+
+	t.Run("it lists no versions of a missing key", func(t *testing.T) {
+		s, _, _ := setup(Options{AutoCommit: true})
+
+		results := execute(t, s, "DEBUG VERSIONS missing")
+
+		test.AssertNoError(t, results[0].Err)
+		test.AssertEqual(t, len(results[0].Table.Rows), 0)
+	})
+
+	t.Run("it shows the snapshot of the open transaction", func(t *testing.T) {
+		s, txManager, _ := setup(Options{})
+
+		other, err := txManager.Begin()
+		test.AssertNoError(t, err)
+
+		results := execute(t, s, "BEGIN; DEBUG SNAPSHOT")
+
+		txID := strconv.FormatUint(results[0].TxID.Uint64(), 10)
+		otherID := strconv.FormatUint(other.ID.Uint64(), 10)
+
+		test.AssertEqual(t, len(results[1].Table.Rows), 1)
+
+		row := results[1].Table.Rows[0]
+		test.AssertEqual(t, row[0], txID)
+		test.AssertEqual(t, row[1], otherID)
+		test.AssertEqual(t, row[2], txID)
+		test.AssertEqual(t, row[3], otherID+","+txID)
+	})
+
+	t.Run("it needs a transaction for the snapshot outside of auto-commit", func(t *testing.T) {
+		s, _, _ := setup(Options{})
+
+		results := execute(t, s, "DEBUG SNAPSHOT")
+		test.AssertError(t, results[0].Err, NoActiveTransactionError)
+	})
+
+	t.Run("it lists active transactions", func(t *testing.T) {
+		s, txManager, _ := setup(Options{})
+
+		other, err := txManager.Begin()
+		test.AssertNoError(t, err)
+
+		results := execute(t, s, "BEGIN; SET a 1; LOCK b; DEBUG TX")
+		rows := results[3].Table.Rows
+
+		test.AssertEqual(t, len(rows), 2)
+		test.AssertEqual(t, rows[0][0], strconv.FormatUint(other.ID.Uint64(), 10))
+		test.AssertEqual(t, rows[0][2], "0")
+		test.AssertEqual(t, rows[0][3], "0")
+		test.AssertEqual(t, rows[0][4], "no")
+		test.AssertEqual(t, rows[1][2], "1")
+		test.AssertEqual(t, rows[1][3], "1")
+		test.AssertEqual(t, rows[1][4], "yes")
+	})
+}
@@ -0,0 +1,103 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"kv/engine/mvcc"
+	"kv/engine/tx"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"time"
+)
+
+var UnknownSectionError = errors.New("unknown section")
+
+// infoSections are the sections of INFO, in the order they are listed.
+var infoSections = []string{"server", "wal", "transactions", "mvcc"}
+
+// info lists stats of the store by section, all of them or only the one
+// asked for.
+func (s *Session) info(section string) (*Table, error) {
+	if section != "" && !slices.Contains(infoSections, section) {
+		return nil, fmt.Errorf("%w %q, expected one of %v", UnknownSectionError, section, infoSections)
+	}
+
+	table := &Table{Columns: []string{"section", "name", "value"}}
+
+	add := func(section, name, value string) {
+		table.Rows = append(table.Rows, []string{section, name, value})
+	}
+
+	if section == "" || section == "server" {
+		add("server", "pid", strconv.Itoa(os.Getpid()))
+		add("server", "go_version", runtime.Version())
+		add("server", "uptime", time.Since(s.backend.StartedAt).Round(time.Second).String())
+		add("server", "data_dir", s.backend.DataDir)
+		add("server", "read_only", yesNo(s.backend.ReadOnly))
+		add("server", "auto_commit", yesNo(s.options.AutoCommit))
+	}
+
+	if section == "" || section == "wal" {
+		logStats, err := s.backend.WAL.LogStats()
+		if err != nil {
+			return nil, err
+		}
+
+		encoderStats := s.backend.WAL.Stats()
+
+		add("wal", "segments", strconv.Itoa(logStats.Segments))
+		add("wal", "bytes", strconv.FormatInt(logStats.Bytes, 10))
+		add("wal", "current_segment", strconv.FormatUint(logStats.CurrentSegment, 10))
+		add("wal", "log_start", strconv.FormatUint(logStats.LogStart, 10))
+		add("wal", "start_lsn", strconv.FormatUint(uint64(logStats.StartLSN), 10))
+		add("wal", "end_lsn", strconv.FormatUint(uint64(logStats.EndLSN), 10))
+		add("wal", "records_appended", strconv.FormatUint(encoderStats.Records, 10))
+		add("wal", "compression_ratio", strconv.FormatFloat(encoderStats.CompressionRatio(), 'f', 2, 64))
+	}
+
+	if section == "" || section == "transactions" {
+		txStats, err := s.txManager.Stats()
+		if err != nil {
+			return nil, err
+		}
+
+		add("transactions", "active", strconv.Itoa(txStats.Active))
+		add("transactions", "next_id", formatID(txStats.NextID))
+		add("transactions", "reserved_until", formatID(txStats.ReservedUntil))
+		add("transactions", "horizon", formatID(txStats.Horizon))
+
+		retryStats := s.backend.Retrier.Stats()
+
+		add("transactions", "retried_calls", strconv.FormatUint(retryStats.Calls-retryStats.RetriesPerCall[0], 10))
+		add("transactions", "retries", strconv.FormatUint(retryStats.Retries, 10))
+		add("transactions", "retries_exhausted", strconv.FormatUint(retryStats.Exhausted, 10))
+	}
+
+	if section == "" || section == "mvcc" {
+		mvccStats := s.versionMap.Stats()
+
+		add("mvcc", "keys", strconv.Itoa(mvccStats.Keys))
+		add("mvcc", "versions", strconv.Itoa(mvccStats.Versions))
+		add("mvcc", "avg_chain_length", strconv.FormatFloat(mvccStats.AverageChainLength(), 'f', 2, 64))
+	}
+
+	return table, nil
+}
+
+// dbSize counts the keys the transaction sees. A visible version without a
+// value is a deleted key, as mvcc.Store.Get reads it.
+func (s *Session) dbSize(transaction *tx.Transaction) int {
+	count := 0
+
+	s.versionMap.Range(func(key string, chain *mvcc.VersionChain) bool {
+		if version := chain.FindVisible(transaction); version != nil && version.Value != nil {
+			count++
+		}
+
+		return true
+	})
+
+	return count
+}
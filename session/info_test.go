@@ -0,0 +1,107 @@
+package session
+
+import (
+	"kv/engine/mvcc"
+	"kv/test"
+	"testing"
+)
+
+func infoValues(table *Table, section string) map[string]string {
+	values := make(map[string]string)
+
+	for _, row := range table.Rows {
+		if row[0] == section {
+			values[row[1]] = row[2]
+		}
+	}
+
+	return values
+}
+
+func TestSession_Info(t *testing.T) {
+	t.Run("it lists all sections", func(t *testing.T) {
+		s, _, _ := setup(Options{AutoCommit: true})
+
+		execute(t, s, "SET a 1; SET a 2; SET b 1")
+		results := execute(t, s, "BEGIN; INFO")
+
+		test.AssertNoError(t, results[1].Err)
+		table := results[1].Table
+
+		server := infoValues(table, "server")
+		test.AssertEqual(t, server["data_dir"], "data")
+		test.AssertEqual(t, server["auto_commit"], "yes")
+
+		logStats := infoValues(table, "wal")
+		test.AssertEqual(t, logStats["segments"], "1")
+		test.AssertNotEqual(t, logStats["bytes"], "0")
+
+		transactions := infoValues(table, "transactions")
+		test.AssertEqual(t, transactions["active"], "1")
+
+		mvccStats := infoValues(table, "mvcc")
+		test.AssertEqual(t, mvccStats["keys"], "2")
+		test.AssertEqual(t, mvccStats["versions"], "3")
+		test.AssertEqual(t, mvccStats["avg_chain_length"], "1.50")
+	})
+
+	t.Run("it lists one section", func(t *testing.T) {
+		s, _, _ := setup(Options{})
+
+		results := execute(t, s, "INFO mvcc")
+
+		test.AssertNoError(t, results[0].Err)
+		test.AssertEqual(t, len(results[0].Table.Rows), 3)
+		test.AssertEqual(t, len(infoValues(results[0].Table, "mvcc")), 3)
+	})
+
+	t.Run("it fails for an unknown section", func(t *testing.T) {
+		s, _, _ := setup(Options{})
+
+		results := execute(t, s, "INFO clients")
+
+		test.AssertError(t, results[0].Err, UnknownSectionError)
+	})
+}
+
+func TestSession_DbSize(t *testing.T) {
+	t.Run("it counts the keys visible to the transaction", func(t *testing.T) {
+		s, _, _ := setup(Options{AutoCommit: true})
+
+		execute(t, s, "SET a 1; SET b 1; SET c 1; DELETE c")
+		results := execute(t, s, "DBSIZE; BEGIN; SET d 1; DBSIZE; ABORT; DBSIZE")
+
+		test.AssertNoError(t, results[0].Err)
+		test.AssertEqual(t, results[0].Count, 2)
+		test.AssertEqual(t, results[3].Count, 3)
+		test.AssertEqual(t, results[5].Count, 2)
+	})
+
+	t.Run("it does not count deleted keys", func(t *testing.T) {
+		s, txManager, _ := setup(Options{AutoCommit: true})
+
+		execute(t, s, "SET a 1; SET b 1")
+
+		// A version without a value, as a delete leaves it behind.
+		transaction, err := txManager.Begin()
+		test.AssertNoError(t, err)
+		chain := s.versionMap.GetOrCreateChain("c")
+		test.AssertTrue(t, chain.CompareHeadAndSwap(nil, mvcc.NewVersion("c", nil, transaction.ID)))
+		test.AssertNoError(t, transaction.Commit())
+
+		results := execute(t, s, "DBSIZE; BEGIN; DELETE a; DBSIZE; COMMIT; DBSIZE")
+
+		test.AssertNoError(t, results[0].Err)
+		test.AssertEqual(t, results[0].Count, 2)
+		test.AssertEqual(t, results[3].Count, 1)
+		test.AssertEqual(t, results[5].Count, 1)
+	})
+
+	t.Run("it needs a transaction without auto-commit", func(t *testing.T) {
+		s, _, _ := setup(Options{})
+
+		results := execute(t, s, "DBSIZE")
+
+		test.AssertError(t, results[0].Err, NoActiveTransactionError)
+	})
+}
@@ -0,0 +1,298 @@
+package session
+
+import (
+	"errors"
+	"kv/engine/mvcc"
+	"kv/engine/tx"
+	"kv/engine/wal"
+	"kv/kvstore"
+	"kv/query"
+	"time"
+)
+
+var NoActiveTransactionError = errors.New("no active transaction")
+var TransactionActiveError = errors.New("transaction already active")
+var SkippedError = errors.New("skipped, transaction rolled back")
+var UnsupportedCommandError = errors.New("unsupported command")
+
+// Result is the outcome of one command.
+type Result struct {
+	Command *query.Command
+
+	// Value is what GET read, nil for a key that is not there.
+	Value []byte
+
+	// TxID is the transaction BEGIN started.
+	TxID tx.ID
+
+	// Count is the number of keys DBSIZE counted.
+	Count int
+
+	// Table is what commands that report on the store itself return.
+	Table *Table
+
+	Err error
+}
+
+type Table struct {
+	Columns []string
+	Rows    [][]string
+}
+
+// Backend is the store a session runs commands on.
+type Backend struct {
+	TxManager *tx.Manager
+	KVStore   *kvstore.KVStore
+
+	// Retrier runs the commands of auto-commit mode, shared by the sessions
+	// so that its stats cover all of them.
+	Retrier *kvstore.Retrier
+
+	// VersionMap and WAL are looked into by DEBUG, INFO and DBSIZE.
+	VersionMap *mvcc.VersionMap
+	WAL        *wal.WriteAheadLog
+
+	// DataDir, ReadOnly and StartedAt describe the server for INFO.
+	DataDir   string
+	ReadOnly  bool
+	StartedAt time.Time
+}
+
+type Options struct {
+	// AutoCommit runs a SET, GET or DELETE given outside of a transaction in
+	// a transaction of its own, committed right after it.
+	AutoCommit bool
+
+	// AutoCommitRetries is how many more times such a command is tried when
+	// it conflicts with another transaction, backing off as
+	// kvstore.DefaultRetryPolicy does.
+	AutoCommitRetries int
+}
+
+// Session runs commands on behalf of one client, keeping the transaction it
+// has open between calls.
+type Session struct {
+	backend    Backend
+	txManager  *tx.Manager
+	kvStore    *kvstore.KVStore
+	versionMap *mvcc.VersionMap
+	options    Options
+
+	currentTx *tx.Transaction
+}
+
+func New(backend Backend, options Options) *Session {
+	return &Session{
+		backend:    backend,
+		txManager:  backend.TxManager,
+		kvStore:    backend.KVStore,
+		versionMap: backend.VersionMap,
+		options:    options,
+	}
+}
+
+// Execute runs commands in order and returns a result for each of them.
+//
+// A transaction begun by one of the commands makes a block with those after
+// it, up to its COMMIT or ABORT, which is all or nothing: if a command in it
+// fails, the transaction is aborted and the rest of the block is skipped.
+// Outside of blocks, a failed command does not stop the ones after it.
+// Nothing runs after EXIT.
+func (s *Session) Execute(commands []*query.Command) []Result {
+	results := make([]Result, len(commands))
+
+	inBlock := false
+	skipping := false
+	exited := false
+
+	for i, cmd := range commands {
+		if exited || skipping {
+			results[i] = Result{Command: cmd, Err: SkippedError}
+
+			if skipping && endsTransaction(cmd) {
+				skipping = false
+			}
+
+			continue
+		}
+
+		results[i] = s.execute(cmd)
+
+		switch {
+		case cmd.Type == query.CommandExit:
+			exited = true
+		case results[i].Err != nil && inBlock:
+			s.Abort()
+			inBlock = false
+			skipping = !endsTransaction(cmd)
+		case cmd.Type == query.CommandBegin && results[i].Err == nil:
+			inBlock = true
+		case endsTransaction(cmd):
+			inBlock = false
+		}
+	}
+
+	return results
+}
+
+func (s *Session) AutoCommit() bool {
+	return s.options.AutoCommit
+}
+
+func (s *Session) SetAutoCommit(autoCommit bool) {
+	s.options.AutoCommit = autoCommit
+}
+
+// InTransaction reports whether a transaction is open.
+func (s *Session) InTransaction() bool {
+	return s.currentTx != nil
+}
+
+// Abort aborts the open transaction, if there is one.
+func (s *Session) Abort() {
+	if s.currentTx != nil {
+		s.currentTx.Abort()
+		s.currentTx = nil
+	}
+}
+
+func (s *Session) execute(cmd *query.Command) Result {
+	result := Result{Command: cmd}
+
+	switch cmd.Type {
+	case query.CommandExit, query.CommandHelp:
+		// Left to the client.
+
+	case query.CommandBegin:
+		if s.currentTx != nil {
+			result.Err = TransactionActiveError
+			break
+		}
+
+		transaction, err := s.txManager.Begin()
+		if err != nil {
+			result.Err = err
+			break
+		}
+
+		s.currentTx = transaction
+		result.TxID = transaction.ID
+
+	case query.CommandCommit:
+		if result.Err = s.requireTx(); result.Err != nil {
+			break
+		}
+
+		if result.Err = s.currentTx.Commit(); result.Err == nil {
+			s.currentTx = nil
+		}
+
+	case query.CommandAbort:
+		if result.Err = s.requireTx(); result.Err == nil {
+			s.Abort()
+		}
+
+	case query.CommandSavepoint:
+		if result.Err = s.requireTx(); result.Err == nil {
+			result.Err = s.currentTx.Savepoint(cmd.Savepoint)
+		}
+
+	case query.CommandRollbackTo:
+		if result.Err = s.requireTx(); result.Err == nil {
+			result.Err = s.currentTx.RollbackTo(cmd.Savepoint)
+		}
+
+	case query.CommandRelease:
+		if result.Err = s.requireTx(); result.Err == nil {
+			result.Err = s.currentTx.Release(cmd.Savepoint)
+		}
+
+	case query.CommandSet:
+		result.Err = s.run(func(transaction *tx.Transaction) error {
+			return s.kvStore.Set(cmd.Key, cmd.Value, transaction)
+		})
+
+	case query.CommandGet:
+		// A missing key is a nil Value, not an error that would roll back
+		// the block it is in.
+		result.Err = s.run(func(transaction *tx.Transaction) (err error) {
+			result.Value, err = s.kvStore.Get(cmd.Key, transaction)
+			if errors.Is(err, mvcc.KeyNotFoundError) {
+				return nil
+			}
+
+			return err
+		})
+
+	case query.CommandDelete:
+		result.Err = s.run(func(transaction *tx.Transaction) error {
+			return s.kvStore.Delete(cmd.Key, transaction)
+		})
+
+	case query.CommandLock:
+		// Outside of a transaction the lock would be released right away.
+		if result.Err = s.requireTx(); result.Err == nil {
+			result.Err = s.kvStore.Lock(cmd.Key, s.currentTx)
+		}
+
+	case query.CommandDebugVersions:
+		result.Err = s.run(func(transaction *tx.Transaction) error {
+			result.Table = s.debugVersions(cmd.Key, transaction)
+			return nil
+		})
+
+	case query.CommandDebugSnapshot:
+		result.Err = s.run(func(transaction *tx.Transaction) error {
+			result.Table = debugSnapshot(transaction)
+			return nil
+		})
+
+	case query.CommandDebugTx:
+		result.Table = s.debugTx()
+
+	case query.CommandInfo:
+		result.Table, result.Err = s.info(cmd.Section)
+
+	case query.CommandDbSize:
+		result.Err = s.run(func(transaction *tx.Transaction) error {
+			result.Count = s.dbSize(transaction)
+			return nil
+		})
+
+	default:
+		result.Err = UnsupportedCommandError
+	}
+
+	return result
+}
+
+// run runs a command that needs a transaction in the open one, or in
+// auto-commit mode in a transaction of its own. Such a command is tried
+// again when it fails on a conflict, by then its transaction was aborted and
+// a new one sees the change it conflicted with.
+func (s *Session) run(command func(transaction *tx.Transaction) error) error {
+	if s.currentTx != nil || !s.options.AutoCommit {
+		if err := s.requireTx(); err != nil {
+			return err
+		}
+
+		return command(s.currentTx)
+	}
+
+	policy := kvstore.DefaultRetryPolicy()
+	policy.MaxAttempts = s.options.AutoCommitRetries + 1
+
+	return s.backend.Retrier.RunInTransaction(command, policy)
+}
+
+func (s *Session) requireTx() error {
+	if s.currentTx == nil {
+		return NoActiveTransactionError
+	}
+
+	return nil
+}
+
+func endsTransaction(cmd *query.Command) bool {
+	return cmd.Type == query.CommandCommit || cmd.Type == query.CommandAbort
+}
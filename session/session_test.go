@@ -0,0 +1,241 @@
+package session
+
+import (
+	"kv/engine"
+	"kv/engine/mvcc"
+	"kv/engine/tx"
+	"kv/engine/wal"
+	"kv/kvstore"
+	"kv/observability"
+	"kv/query"
+	"kv/storage"
+	storagemocks "kv/storage/mocks"
+	"kv/test"
+	"testing"
+	"time"
+)
+
+func setup(options Options) (*Session, *tx.Manager, *kvstore.KVStore) {
+	observability.DisableLogging()
+
+	log, err := wal.NewLog(wal.NewManifest(storagemocks.NewFile()), wal.LogOptions{
+		FS:            storage.NewMemFS(),
+		LogsDirectory: "log",
+		SegmentSize:   64 * 1024,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	writeAheadLog := wal.NewWriteAheadLog(wal.Options{WriterBufferSize: 4096}, log)
+
+	txManager := tx.NewManager(tx.NewManifest(storagemocks.NewFile()), writeAheadLog, tx.ManagerOptions{
+		ReservedIDsPerBatch:   1000,
+		MaxActiveTransactions: 1000,
+	})
+
+	versionMap := mvcc.NewVersionMap()
+	storageEngine := engine.New(mvcc.NewStore(versionMap, mvcc.StoreOptions{}), writeAheadLog)
+	kvStore := kvstore.New(storageEngine, kvstore.Options{
+		Validation: kvstore.ValidationOptions{MaxKeySize: 16, MaxValueSize: 16},
+	})
+
+	backend := Backend{
+		TxManager:  txManager,
+		KVStore:    kvStore,
+		Retrier:    kvstore.NewRetrier(txManager),
+		VersionMap: versionMap,
+		WAL:        writeAheadLog,
+		DataDir:    "data",
+		StartedAt:  time.Now(),
+	}
+
+	return New(backend, options), txManager, kvStore
+}
+
+func execute(t *testing.T, s *Session, input string) []Result {
+	t.Helper()
+
+	commands, err := query.Parse(input)
+	test.AssertNoError(t, err)
+
+	return s.Execute(commands)
+}
+
+func TestSession_Execute(t *testing.T) {
+	t.Run("it runs a block and returns a result per command", func(t *testing.T) {
+		s, _, _ := setup(Options{})
+
+		results := execute(t, s, "BEGIN; SET a 1; GET a; COMMIT")
+
+		test.AssertEqual(t, len(results), 4)
+		for _, result := range results {
+			test.AssertNoError(t, result.Err)
+		}
+
+		test.AssertNotEqual(t, results[0].TxID, tx.ID(0))
+		test.AssertBytesEqual(t, results[2].Value, []byte("1"))
+		test.AssertFalse(t, s.InTransaction())
+
+		results = execute(t, s, "BEGIN; GET a; ABORT")
+		test.AssertBytesEqual(t, results[1].Value, []byte("1"))
+	})
+
+	t.Run("it reads a missing key as nil without rolling back the block", func(t *testing.T) {
+		s, _, _ := setup(Options{})
+
+		results := execute(t, s, "BEGIN; SET a 1; GET b; COMMIT")
+
+		for _, result := range results {
+			test.AssertNoError(t, result.Err)
+		}
+
+		test.AssertTrue(t, results[2].Value == nil)
+
+		results = execute(t, s, "BEGIN; GET a; ABORT")
+		test.AssertBytesEqual(t, results[1].Value, []byte("1"))
+	})
+
+	t.Run("it rolls back a block when a command fails", func(t *testing.T) {
+		s, txManager, _ := setup(Options{})
+
+		results := execute(t, s, "BEGIN; SET b 1; SET key-longer-than-the-limit 2; SET c 3; COMMIT; BEGIN")
+
+		test.AssertNoError(t, results[1].Err)
+		test.AssertError(t, results[2].Err, kvstore.ErrKeyTooLong)
+		test.AssertError(t, results[3].Err, SkippedError)
+		test.AssertError(t, results[4].Err, SkippedError)
+		test.AssertNoError(t, results[5].Err)
+		test.AssertEqual(t, txManager.ActiveCount(), 1)
+
+		results = execute(t, s, "GET b; ABORT")
+		test.AssertNoError(t, results[0].Err)
+		test.AssertTrue(t, results[0].Value == nil)
+	})
+
+	t.Run("it skips the rest of the input when a block has no end", func(t *testing.T) {
+		s, txManager, _ := setup(Options{})
+
+		results := execute(t, s, "BEGIN; RELEASE sp1; SET a 1")
+
+		test.AssertError(t, results[1].Err, tx.SavepointNotFoundError)
+		test.AssertError(t, results[2].Err, SkippedError)
+		test.AssertFalse(t, s.InTransaction())
+		test.AssertEqual(t, txManager.ActiveCount(), 0)
+	})
+
+	t.Run("it keeps a transaction begun earlier open when a command fails", func(t *testing.T) {
+		s, _, _ := setup(Options{})
+
+		execute(t, s, "BEGIN")
+		results := execute(t, s, "SET key-longer-than-the-limit 1; SET a 1")
+
+		test.AssertError(t, results[0].Err, kvstore.ErrKeyTooLong)
+		test.AssertNoError(t, results[1].Err)
+		test.AssertTrue(t, s.InTransaction())
+	})
+
+	t.Run("it fails commands outside of a transaction", func(t *testing.T) {
+		s, _, _ := setup(Options{})
+
+		results := execute(t, s, "SET a 1; COMMIT; BEGIN; BEGIN")
+
+		test.AssertError(t, results[0].Err, NoActiveTransactionError)
+		test.AssertError(t, results[1].Err, NoActiveTransactionError)
+		test.AssertNoError(t, results[2].Err)
+		test.AssertError(t, results[3].Err, TransactionActiveError)
+		test.AssertFalse(t, s.InTransaction())
+	})
+
+	t.Run("it runs nothing after EXIT", func(t *testing.T) {
+		s, _, _ := setup(Options{})
+
+		results := execute(t, s, "EXIT; BEGIN")
+
+		test.AssertNoError(t, results[0].Err)
+		test.AssertError(t, results[1].Err, SkippedError)
+		test.AssertFalse(t, s.InTransaction())
+	})
+}
+
+func TestSession_AutoCommit(t *testing.T) {
+	t.Run("it commits a command given outside of a transaction", func(t *testing.T) {
+		s, txManager, _ := setup(Options{AutoCommit: true})
+
+		results := execute(t, s, "SET a 1; GET a; DELETE a; GET a")
+
+		test.AssertNoError(t, results[0].Err)
+		test.AssertBytesEqual(t, results[1].Value, []byte("1"))
+		test.AssertNoError(t, results[2].Err)
+		test.AssertNoError(t, results[3].Err)
+		test.AssertTrue(t, results[3].Value == nil)
+		test.AssertFalse(t, s.InTransaction())
+		test.AssertEqual(t, txManager.ActiveCount(), 0)
+	})
+
+	t.Run("it leaves explicit transactions alone", func(t *testing.T) {
+		s, _, _ := setup(Options{AutoCommit: true})
+
+		results := execute(t, s, "BEGIN; SET a 1; ABORT; GET a; SAVEPOINT sp1")
+
+		test.AssertNoError(t, results[1].Err)
+		test.AssertNoError(t, results[3].Err)
+		test.AssertTrue(t, results[3].Value == nil)
+		test.AssertError(t, results[4].Err, NoActiveTransactionError)
+	})
+
+	t.Run("it gives up on a conflict after the retries", func(t *testing.T) {
+		s, txManager, kvStore := setup(Options{AutoCommit: true, AutoCommitRetries: 2})
+
+		execute(t, s, "SET a 1")
+
+		other, err := txManager.Begin()
+		test.AssertNoError(t, err)
+		test.AssertNoError(t, kvStore.Set("a", []byte("2"), other))
+
+		results := execute(t, s, "SET a 3")
+
+		test.AssertError(t, results[0].Err, mvcc.SerializationError)
+		test.AssertEqual(t, txManager.ActiveCount(), 1)
+
+		test.AssertNoError(t, other.Commit())
+
+		results = execute(t, s, "SET a 3; GET a")
+		test.AssertNoError(t, results[0].Err)
+		test.AssertBytesEqual(t, results[1].Value, []byte("3"))
+	})
+}
+
+func TestSession_Lock(t *testing.T) {
+	t.Run("it reads the latest value of a locked key", func(t *testing.T) {
+		s, txManager, kvStore := setup(Options{})
+
+		results := execute(t, s, "BEGIN; LOCK a; GET a")
+		test.AssertNoError(t, results[1].Err)
+		test.AssertNoError(t, results[2].Err)
+		test.AssertTrue(t, results[2].Value == nil)
+
+		results = execute(t, s, "COMMIT; BEGIN")
+		test.AssertNoError(t, results[0].Err)
+
+		other, err := txManager.Begin()
+		test.AssertNoError(t, err)
+		test.AssertNoError(t, kvStore.Set("a", []byte("1"), other))
+		test.AssertNoError(t, other.Commit())
+
+		results = execute(t, s, "GET a; LOCK a; GET a; SET a 2; COMMIT")
+		test.AssertNoError(t, results[0].Err)
+		test.AssertTrue(t, results[0].Value == nil)
+		test.AssertBytesEqual(t, results[2].Value, []byte("1"))
+		test.AssertNoError(t, results[3].Err)
+		test.AssertNoError(t, results[4].Err)
+	})
+
+	t.Run("it needs a transaction", func(t *testing.T) {
+		s, _, _ := setup(Options{AutoCommit: true})
+
+		results := execute(t, s, "LOCK a")
+
+		test.AssertError(t, results[0].Err, NoActiveTransactionError)
+	})
+}
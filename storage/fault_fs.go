@@ -0,0 +1,567 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+)
+
+var CrashedError = errors.New("storage: file system crashed")
+var InjectedFaultError = errors.New("storage: injected fault")
+
+// faultSectorSize is the unit in which unsynced data survives a crash. The
+// part of a write that falls within one sector is either kept or lost as a
+// whole, as disks guarantee for sector writes.
+const faultSectorSize = 512
+
+// FaultOp is an operation a FaultFS can be made to fail.
+type FaultOp int
+
+const (
+	FaultOpOpen FaultOp = iota
+	FaultOpWrite
+	FaultOpSync
+	FaultOpRename
+	FaultOpRemove
+)
+
+func (op FaultOp) String() string {
+	switch op {
+	case FaultOpOpen:
+		return "open"
+	case FaultOpWrite:
+		return "write"
+	case FaultOpSync:
+		return "sync"
+	case FaultOpRename:
+		return "rename"
+	case FaultOpRemove:
+		return "remove"
+	default:
+		return "unknown"
+	}
+}
+
+// FaultFS wraps an FS to test how the store copes with failing disks and
+// power loss. It keeps the data of each file as of its last sync apart from
+// writes made since, and the files created, renamed and removed since their
+// directory was last synced, so Crash can lose either. Directories are
+// durable once created.
+type FaultFS struct {
+	fs    FS
+	mutex sync.Mutex
+	rand  *rand.Rand
+	fail  func(op FaultOp, name string) error
+
+	// nodes tracks files by path, following renames.
+	nodes map[string]*faultNode
+
+	// entries are changes to directories not synced yet, oldest first.
+	entries []faultEntry
+
+	// epoch is bumped on every crash, handles opened before stop working.
+	epoch uint64
+}
+
+type faultNode struct {
+	durable []byte
+	pending []faultWrite
+}
+
+type faultWrite struct {
+	offset int64
+	data   []byte
+}
+
+// faultEntry is a change to the entries of a directory, with a way to undo
+// it on crash.
+type faultEntry struct {
+	directories []string
+	undo        func() error
+}
+
+// NewFaultFS wraps fs. The seed drives which unsynced writes survive a crash
+// and how injected write faults tear writes, so a failing run can be
+// repeated.
+func NewFaultFS(fs FS, seed int64) *FaultFS {
+	return &FaultFS{
+		fs:    fs,
+		rand:  rand.New(rand.NewSource(seed)),
+		nodes: make(map[string]*faultNode),
+	}
+}
+
+// FailWith makes every operation consult fail first. A non-nil error fails
+// the operation, a failing write may still write some of its sectors.
+// Passing nil stops injecting faults.
+func (f *FaultFS) FailWith(fail func(op FaultOp, name string) error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.fail = fail
+}
+
+// Crash simulates a power loss. Writes that were not synced are lost, except
+// for a random selection of their sectors that made it to the disk anyway.
+// Of the directory changes not synced, the most recent ones from a random
+// point on are lost. Handles opened before the crash fail with CrashedError
+// from then on.
+func (f *FaultFS) Crash() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.epoch++
+
+	names := make([]string, 0, len(f.nodes))
+	for name := range f.nodes {
+		names = append(names, name)
+	}
+
+	// Going through files in a fixed order keeps crashes repeatable.
+	sort.Strings(names)
+
+	var err error
+
+	for _, name := range names {
+		node := f.nodes[name]
+		content := node.durable
+
+		for _, w := range node.pending {
+			content = f.applySurvivingSectors(content, w)
+		}
+
+		node.durable = content
+		node.pending = nil
+
+		err = errors.Join(err, f.restore(name, content))
+	}
+
+	kept := f.rand.Intn(len(f.entries) + 1)
+
+	for i := len(f.entries) - 1; i >= kept; i-- {
+		err = errors.Join(err, f.entries[i].undo())
+	}
+
+	f.entries = nil
+	return err
+}
+
+func (f *FaultFS) Open(name string, flag int) (FileHandle, error) {
+	name = filepath.Clean(name)
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if err := f.inject(FaultOpOpen, name); err != nil {
+		return nil, err
+	}
+
+	node, err := f.node(name)
+	if err != nil && (!os.IsNotExist(err) || flag&os.O_CREATE == 0) {
+		return nil, err
+	}
+
+	file, err := f.fs.Open(name, flag)
+	if err != nil {
+		return nil, err
+	}
+
+	if node == nil {
+		node = &faultNode{}
+		f.nodes[name] = node
+
+		f.recordEntry(func() error {
+			delete(f.nodes, name)
+			return f.fs.Remove(name)
+		}, name)
+	}
+
+	if flag&os.O_TRUNC != 0 && isWritable(flag) {
+		node.durable = nil
+		node.pending = nil
+	}
+
+	return &faultFile{
+		fs:     f,
+		file:   file,
+		name:   name,
+		node:   node,
+		append: flag&os.O_APPEND != 0,
+		epoch:  f.epoch,
+	}, nil
+}
+
+func (f *FaultFS) Create(name string) (FileHandle, error) {
+	return f.Open(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+}
+
+func (f *FaultFS) Remove(name string) error {
+	name = filepath.Clean(name)
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if err := f.inject(FaultOpRemove, name); err != nil {
+		return err
+	}
+
+	node, err := f.node(name)
+	if err != nil {
+		return err
+	}
+
+	if err = f.fs.Remove(name); err != nil {
+		return err
+	}
+
+	delete(f.nodes, name)
+
+	f.recordEntry(func() error {
+		f.nodes[name] = node
+		return f.restore(name, node.durable)
+	}, name)
+
+	return nil
+}
+
+func (f *FaultFS) Rename(oldName, newName string) error {
+	oldName, newName = filepath.Clean(oldName), filepath.Clean(newName)
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if err := f.inject(FaultOpRename, oldName); err != nil {
+		return err
+	}
+
+	node, err := f.node(oldName)
+	if err != nil {
+		return err
+	}
+
+	replaced, err := f.node(newName)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err = f.fs.Rename(oldName, newName); err != nil {
+		return err
+	}
+
+	delete(f.nodes, oldName)
+	f.nodes[newName] = node
+
+	f.recordEntry(func() error {
+		if err := f.fs.Rename(newName, oldName); err != nil {
+			return err
+		}
+
+		f.nodes[oldName] = node
+		delete(f.nodes, newName)
+
+		if replaced == nil {
+			return nil
+		}
+
+		f.nodes[newName] = replaced
+		return f.restore(newName, replaced.durable)
+	}, oldName, newName)
+
+	return nil
+}
+
+func (f *FaultFS) List(directory string) ([]string, error) {
+	return f.fs.List(directory)
+}
+
+func (f *FaultFS) MkdirAll(directory string) error {
+	return f.fs.MkdirAll(directory)
+}
+
+func (f *FaultFS) SyncDir(directory string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if err := f.inject(FaultOpSync, directory); err != nil {
+		return err
+	}
+
+	if err := f.fs.SyncDir(directory); err != nil {
+		return err
+	}
+
+	directory = filepath.Clean(directory)
+	pending := f.entries[:0]
+
+	for _, entry := range f.entries {
+		if !slices.Contains(entry.directories, directory) {
+			pending = append(pending, entry)
+		}
+	}
+
+	f.entries = pending
+	return nil
+}
+
+// recordEntry remembers a change to the directories of the given files until
+// they are synced.
+func (f *FaultFS) recordEntry(undo func() error, names ...string) {
+	entry := faultEntry{undo: undo}
+
+	for _, name := range names {
+		entry.directories = append(entry.directories, filepath.Dir(name))
+	}
+
+	f.entries = append(f.entries, entry)
+}
+
+func (f *FaultFS) inject(op FaultOp, name string) error {
+	if f.fail == nil {
+		return nil
+	}
+
+	return f.fail(op, name)
+}
+
+// node returns the tracked state of a file, picking up files that existed
+// before they were first opened through the FaultFS as durable.
+func (f *FaultFS) node(name string) (*faultNode, error) {
+	if node, ok := f.nodes[name]; ok {
+		return node, nil
+	}
+
+	content, err := ReadFile(f.fs, name)
+	if err != nil {
+		return nil, err
+	}
+
+	node := &faultNode{durable: content}
+	f.nodes[name] = node
+
+	return node, nil
+}
+
+// applySurvivingSectors applies the sectors of a write that survived a crash
+// to content.
+func (f *FaultFS) applySurvivingSectors(content []byte, w faultWrite) []byte {
+	for start := int64(0); start < int64(len(w.data)); {
+		offset := w.offset + start
+		end := min(int64(len(w.data)), start+faultSectorSize-offset%faultSectorSize)
+
+		if f.rand.Intn(2) == 0 {
+			content = writeAt(content, w.data[start:end], offset)
+		}
+
+		start = end
+	}
+
+	return content
+}
+
+// tornLength picks how much of a failing write still reaches the file. Like
+// on a disk, only whole sectors make it, a write that does not reach the end
+// of a sector leaves it untouched.
+func (f *FaultFS) tornLength(offset int64, length int) int {
+	cuts := []int{0}
+
+	for end := faultSectorSize - offset%faultSectorSize; end < int64(length); end += faultSectorSize {
+		cuts = append(cuts, int(end))
+	}
+
+	return cuts[f.rand.Intn(len(cuts))]
+}
+
+// restore replaces the content of a file with what survived a crash.
+func (f *FaultFS) restore(name string, content []byte) error {
+	file, err := f.fs.Create(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.WriteAt(content, 0)
+	return errors.Join(err, file.Close())
+}
+
+type faultFile struct {
+	fs     *FaultFS
+	file   FileHandle
+	name   string
+	node   *faultNode
+	append bool
+	epoch  uint64
+}
+
+func (f *faultFile) Read(p []byte) (int, error) {
+	if err := f.check(); err != nil {
+		return 0, err
+	}
+
+	return f.file.Read(p)
+}
+
+func (f *faultFile) ReadAt(p []byte, offset int64) (int, error) {
+	if err := f.check(); err != nil {
+		return 0, err
+	}
+
+	return f.file.ReadAt(p, offset)
+}
+
+func (f *faultFile) Seek(offset int64, whence int) (int64, error) {
+	if err := f.check(); err != nil {
+		return 0, err
+	}
+
+	return f.file.Seek(offset, whence)
+}
+
+func (f *faultFile) Write(p []byte) (int, error) {
+	f.fs.mutex.Lock()
+	defer f.fs.mutex.Unlock()
+
+	offset, err := f.file.Seek(0, io.SeekCurrent)
+	if f.append {
+		offset, err = f.file.Size()
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return f.write(p, offset, f.file.Write)
+}
+
+func (f *faultFile) WriteAt(p []byte, offset int64) (int, error) {
+	f.fs.mutex.Lock()
+	defer f.fs.mutex.Unlock()
+
+	return f.write(p, offset, func(p []byte) (int, error) {
+		return f.file.WriteAt(p, offset)
+	})
+}
+
+func (f *faultFile) Size() (int64, error) {
+	if err := f.check(); err != nil {
+		return 0, err
+	}
+
+	return f.file.Size()
+}
+
+func (f *faultFile) Allocate(size int64) error {
+	f.fs.mutex.Lock()
+	defer f.fs.mutex.Unlock()
+
+	if err := f.checkLocked(); err != nil {
+		return err
+	}
+
+	current, err := f.file.Size()
+	if err != nil {
+		return err
+	}
+
+	if err = f.file.Allocate(size); err != nil {
+		return err
+	}
+
+	// Until synced, the space allocated is as good as a write of zeroes.
+	if size > current {
+		f.node.pending = append(f.node.pending, faultWrite{offset: current, data: make([]byte, size-current)})
+	}
+
+	return nil
+}
+
+func (f *faultFile) Sync() error {
+	return f.sync(f.file.Sync)
+}
+
+func (f *faultFile) Datasync() error {
+	return f.sync(f.file.Datasync)
+}
+
+// Close releases the file even after a crash, so that stores abandoned by a
+// crash can still be torn down.
+func (f *faultFile) Close() error {
+	return f.file.Close()
+}
+
+func (f *faultFile) write(p []byte, offset int64, write func(p []byte) (int, error)) (int, error) {
+	if err := f.checkLocked(); err != nil {
+		return 0, err
+	}
+
+	fault := f.fs.inject(FaultOpWrite, f.name)
+	if fault != nil {
+		p = p[:f.fs.tornLength(offset, len(p))]
+	}
+
+	n, err := write(p)
+
+	if n > 0 {
+		data := make([]byte, n)
+		copy(data, p)
+		f.node.pending = append(f.node.pending, faultWrite{offset: offset, data: data})
+	}
+
+	if fault != nil {
+		return n, fault
+	}
+
+	return n, err
+}
+
+func (f *faultFile) sync(sync func() error) error {
+	f.fs.mutex.Lock()
+	defer f.fs.mutex.Unlock()
+
+	if err := f.checkLocked(); err != nil {
+		return err
+	}
+
+	if err := f.fs.inject(FaultOpSync, f.name); err != nil {
+		return err
+	}
+
+	if err := sync(); err != nil {
+		return err
+	}
+
+	for _, w := range f.node.pending {
+		f.node.durable = writeAt(f.node.durable, w.data, w.offset)
+	}
+
+	f.node.pending = nil
+	return nil
+}
+
+func (f *faultFile) check() error {
+	f.fs.mutex.Lock()
+	defer f.fs.mutex.Unlock()
+
+	return f.checkLocked()
+}
+
+func (f *faultFile) checkLocked() error {
+	if f.epoch != f.fs.epoch {
+		return CrashedError
+	}
+
+	return nil
+}
+
+// writeAt writes p into buf at offset, growing buf with zeroes as needed.
+func writeAt(buf []byte, p []byte, offset int64) []byte {
+	if end := offset + int64(len(p)); end > int64(len(buf)) {
+		buf = append(buf, make([]byte, end-int64(len(buf)))...)
+	}
+
+	copy(buf[offset:], p)
+	return buf
+}
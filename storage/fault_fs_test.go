@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"bytes"
+	"kv/test"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFaultFS(t *testing.T) {
+	setup := func(t *testing.T, seed int64) (*FaultFS, FileHandle) {
+		t.Helper()
+
+		fs := NewFaultFS(NewMemFS(), seed)
+
+		file, err := fs.Create("file")
+		test.AssertNoError(t, err)
+		test.AssertNoError(t, fs.SyncDir("."))
+
+		return fs, file
+	}
+
+	t.Run("it keeps synced data through a crash", func(t *testing.T) {
+		fs, file := setup(t, 1)
+
+		_, _ = file.Write([]byte("synced"))
+		test.AssertNoError(t, file.Sync())
+		test.AssertNoError(t, fs.Crash())
+
+		data, err := ReadFile(fs, "file")
+		test.AssertNoError(t, err)
+		test.AssertBytesEqual(t, data[:6], []byte("synced"))
+	})
+
+	t.Run("it keeps or loses each sector of unsynced data as a whole", func(t *testing.T) {
+		for seed := range int64(20) {
+			fs, file := setup(t, seed)
+
+			_, _ = file.WriteAt(bytes.Repeat([]byte{1}, faultSectorSize), 0)
+			_, _ = file.WriteAt(bytes.Repeat([]byte{2}, faultSectorSize), faultSectorSize)
+			test.AssertNoError(t, fs.Crash())
+
+			data, err := ReadFile(fs, "file")
+			test.AssertNoError(t, err)
+
+			for sector := 0; sector*faultSectorSize < len(data); sector++ {
+				chunk := data[sector*faultSectorSize : (sector+1)*faultSectorSize]
+				whole := bytes.Equal(chunk, bytes.Repeat([]byte{byte(sector + 1)}, faultSectorSize))
+				test.AssertTrue(t, whole || bytes.Equal(chunk, make([]byte, faultSectorSize)))
+			}
+		}
+	})
+
+	t.Run("it loses unsynced data on some crashes", func(t *testing.T) {
+		lost := false
+
+		for seed := range int64(20) {
+			fs, file := setup(t, seed)
+
+			_, _ = file.Write([]byte("unsynced"))
+			test.AssertNoError(t, fs.Crash())
+
+			data, _ := ReadFile(fs, "file")
+			lost = lost || len(data) == 0
+		}
+
+		test.AssertTrue(t, lost)
+	})
+
+	t.Run("it fails handles opened before a crash", func(t *testing.T) {
+		fs, file := setup(t, 1)
+		test.AssertNoError(t, fs.Crash())
+
+		_, err := file.Write([]byte("data"))
+		test.AssertError(t, err, CrashedError)
+		test.AssertError(t, file.Sync(), CrashedError)
+		test.AssertNoError(t, file.Close())
+	})
+
+	t.Run("it tears writes that fail", func(t *testing.T) {
+		fs, file := setup(t, 1)
+		fs.FailWith(func(op FaultOp, name string) error {
+			if op == FaultOpWrite {
+				return InjectedFaultError
+			}
+
+			return nil
+		})
+
+		n, err := file.Write(make([]byte, faultSectorSize*4+10))
+		test.AssertError(t, err, InjectedFaultError)
+		test.AssertEqual(t, n%faultSectorSize, 0)
+
+		size, _ := file.Size()
+		test.AssertEqual(t, size, int64(n))
+	})
+
+	t.Run("it does not make data durable when a sync fails", func(t *testing.T) {
+		fs, file := setup(t, 1)
+		fs.FailWith(func(op FaultOp, name string) error {
+			if op == FaultOpSync {
+				return InjectedFaultError
+			}
+
+			return nil
+		})
+
+		_, _ = file.Write(bytes.Repeat([]byte{1}, faultSectorSize*8))
+		test.AssertError(t, file.Sync(), InjectedFaultError)
+
+		fs.FailWith(nil)
+		test.AssertNoError(t, fs.Crash())
+
+		data, _ := ReadFile(fs, "file")
+		test.AssertTrue(t, bytes.Count(data, []byte{1}) < faultSectorSize*8)
+	})
+
+	t.Run("it loses directory changes that were not synced", func(t *testing.T) {
+		lost := 0
+
+		for seed := range int64(20) {
+			fs, file := setup(t, seed)
+			_, _ = file.Write([]byte("synced"))
+			test.AssertNoError(t, file.Sync())
+
+			created, _ := fs.Create("created")
+			_ = created.Close()
+			test.AssertNoError(t, fs.Rename("file", "renamed"))
+			test.AssertNoError(t, fs.Crash())
+
+			names, err := fs.List(".")
+			test.AssertNoError(t, err)
+
+			switch strings.Join(names, ",") {
+			case "created,renamed":
+			case "created,file":
+				lost++
+			case "file":
+				lost++
+
+				data, _ := ReadFile(fs, "file")
+				test.AssertBytesEqual(t, data, []byte("synced"))
+			default:
+				t.Fatalf("unexpected files after crash: %v", names)
+			}
+		}
+
+		test.AssertTrue(t, lost > 0)
+	})
+
+	t.Run("it follows files through renames", func(t *testing.T) {
+		fs, file := setup(t, 1)
+
+		_, _ = file.Write([]byte("synced"))
+		test.AssertNoError(t, file.Sync())
+		test.AssertNoError(t, fs.Rename("file", "renamed"))
+		test.AssertNoError(t, fs.SyncDir("."))
+		test.AssertNoError(t, fs.Crash())
+
+		data, err := ReadFile(fs, "renamed")
+		test.AssertNoError(t, err)
+		test.AssertBytesEqual(t, data, []byte("synced"))
+
+		_, err = fs.Open("file", os.O_RDONLY)
+		test.AssertTrue(t, os.IsNotExist(err))
+	})
+}
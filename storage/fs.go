@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"io"
+	"os"
+)
+
+// FS is the file system the store keeps its files in. Paths use the host
+// separator. Errors for missing files match os.ErrNotExist.
+type FS interface {
+	// Open opens a file with os.O_* flags, creating it with mode 0644.
+	Open(name string, flag int) (FileHandle, error)
+	// Create opens a file for reading and writing, truncating it if it
+	// exists.
+	Create(name string) (FileHandle, error)
+	Remove(name string) error
+	Rename(oldName, newName string) error
+	// List returns the names of the entries in a directory, sorted.
+	List(directory string) ([]string, error)
+	MkdirAll(directory string) error
+	// SyncDir makes changes to the entries of a directory durable, e.g. a
+	// file created or renamed in it.
+	SyncDir(directory string) error
+}
+
+// FileHandle is a file opened through an FS.
+type FileHandle interface {
+	File
+	io.ReaderAt
+	io.WriterAt
+
+	Size() (int64, error)
+	// Allocate reserves space for the file to grow to the given size without
+	// further metadata changes. It never shrinks the file.
+	Allocate(size int64) error
+	// Datasync flushes file data, but not metadata that is not needed to
+	// read it back, such as modification times.
+	Datasync() error
+}
+
+// Exists reports whether a file exists.
+func Exists(fs FS, name string) (bool, error) {
+	file, err := fs.Open(name, os.O_RDONLY)
+
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, file.Close()
+}
+
+// FileSize returns the size of a file.
+func FileSize(fs FS, name string) (int64, error) {
+	file, err := fs.Open(name, os.O_RDONLY)
+	if err != nil {
+		return 0, err
+	}
+
+	size, err := file.Size()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return size, err
+}
+
+// ReadFile returns the whole content of a file.
+func ReadFile(fs FS, name string) ([]byte, error) {
+	file, err := fs.Open(name, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return data, err
+}
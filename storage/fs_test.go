@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"io"
+	"kv/test"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFS(t *testing.T) {
+	implementations := map[string]func(t *testing.T) (FS, string){
+		"os": func(t *testing.T) (FS, string) {
+			return NewOSFS(), t.TempDir()
+		},
+		"memory": func(t *testing.T) (FS, string) {
+			return NewMemFS(), "root"
+		},
+	}
+
+	for name, setup := range implementations {
+		t.Run(name, func(t *testing.T) {
+			testFS(t, setup)
+		})
+	}
+}
+
+func testFS(t *testing.T, setup func(t *testing.T) (FS, string)) {
+	t.Run("it writes and reads back a file", func(t *testing.T) {
+		fs, root := setup(t)
+		test.AssertNoError(t, fs.MkdirAll(root))
+		path := filepath.Join(root, "file")
+
+		file, err := fs.Create(path)
+		test.AssertNoError(t, err)
+
+		_, err = file.Write([]byte("hello"))
+		test.AssertNoError(t, err)
+		_, err = file.WriteAt([]byte("J"), 0)
+		test.AssertNoError(t, err)
+		test.AssertNoError(t, file.Datasync())
+		test.AssertNoError(t, file.Close())
+
+		data, err := ReadFile(fs, path)
+		test.AssertNoError(t, err)
+		test.AssertBytesEqual(t, data, []byte("Jello"))
+	})
+
+	t.Run("it returns not exist for missing files", func(t *testing.T) {
+		fs, root := setup(t)
+		test.AssertNoError(t, fs.MkdirAll(root))
+
+		_, err := fs.Open(filepath.Join(root, "missing"), os.O_RDONLY)
+		test.AssertTrue(t, os.IsNotExist(err))
+
+		exists, err := Exists(fs, filepath.Join(root, "missing"))
+		test.AssertNoError(t, err)
+		test.AssertFalse(t, exists)
+	})
+
+	t.Run("it refuses to create files in missing directories", func(t *testing.T) {
+		fs, root := setup(t)
+
+		_, err := fs.Create(filepath.Join(root, "missing", "file"))
+		test.AssertTrue(t, os.IsNotExist(err))
+	})
+
+	t.Run("it allocates space without shrinking", func(t *testing.T) {
+		fs, root := setup(t)
+		test.AssertNoError(t, fs.MkdirAll(root))
+
+		file, err := fs.Create(filepath.Join(root, "file"))
+		test.AssertNoError(t, err)
+		defer func() {
+			_ = file.Close()
+		}()
+
+		test.AssertNoError(t, file.Allocate(64))
+		test.AssertNoError(t, file.Allocate(16))
+
+		size, err := file.Size()
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, size, int64(64))
+	})
+
+	t.Run("it reports EOF when reading past the end", func(t *testing.T) {
+		fs, root := setup(t)
+		test.AssertNoError(t, fs.MkdirAll(root))
+
+		file, err := fs.Create(filepath.Join(root, "file"))
+		test.AssertNoError(t, err)
+		defer func() {
+			_ = file.Close()
+		}()
+
+		_, _ = file.Write([]byte("abc"))
+
+		buf := make([]byte, 4)
+		n, err := file.ReadAt(buf, 1)
+		test.AssertError(t, err, io.EOF)
+		test.AssertEqual(t, n, 2)
+	})
+
+	t.Run("it lists, renames and removes files", func(t *testing.T) {
+		fs, root := setup(t)
+		test.AssertNoError(t, fs.MkdirAll(filepath.Join(root, "sub")))
+
+		for _, name := range []string{"b", "a"} {
+			file, err := fs.Create(filepath.Join(root, name))
+			test.AssertNoError(t, err)
+			test.AssertNoError(t, file.Close())
+		}
+
+		names, err := fs.List(root)
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, len(names), 3)
+		test.AssertEqual(t, names[0], "a")
+		test.AssertEqual(t, names[1], "b")
+		test.AssertEqual(t, names[2], "sub")
+
+		test.AssertNoError(t, fs.Rename(filepath.Join(root, "a"), filepath.Join(root, "sub", "c")))
+		test.AssertNoError(t, fs.Remove(filepath.Join(root, "b")))
+		test.AssertNoError(t, fs.SyncDir(root))
+
+		names, _ = fs.List(root)
+		test.AssertEqual(t, len(names), 1)
+
+		names, _ = fs.List(filepath.Join(root, "sub"))
+		test.AssertEqual(t, len(names), 1)
+		test.AssertEqual(t, names[0], "c")
+	})
+}
+
+func TestManager(t *testing.T) {
+	t.Run("it returns the same handle for a file opened twice", func(t *testing.T) {
+		manager := NewManager(NewMemFS())
+
+		first, err := manager.Open(filepath.Join("dir", "file"), os.O_RDWR|os.O_CREATE)
+		test.AssertNoError(t, err)
+
+		second, err := manager.Open(filepath.Join("dir", "file"), os.O_RDWR|os.O_CREATE)
+		test.AssertNoError(t, err)
+		test.AssertTrue(t, first == second)
+	})
+
+	t.Run("it closes all opened files", func(t *testing.T) {
+		manager := NewManager(NewMemFS())
+
+		file, err := manager.Open("file", os.O_RDWR|os.O_CREATE)
+		test.AssertNoError(t, err)
+
+		test.AssertNoError(t, manager.Close())
+		test.AssertError(t, file.Close(), FileClosedError)
+	})
+}
+
+func TestReadOnlyFS(t *testing.T) {
+	setup := func(t *testing.T) *ReadOnlyFS {
+		t.Helper()
+
+		fs := NewMemFS()
+		test.AssertNoError(t, fs.MkdirAll("dir"))
+
+		file, err := fs.Create(filepath.Join("dir", "file"))
+		test.AssertNoError(t, err)
+		_, err = file.Write([]byte("data"))
+		test.AssertNoError(t, err)
+		test.AssertNoError(t, file.Close())
+
+		return NewReadOnlyFS(fs)
+	}
+
+	t.Run("it reads existing files", func(t *testing.T) {
+		fs := setup(t)
+
+		data, err := ReadFile(fs, filepath.Join("dir", "file"))
+		test.AssertNoError(t, err)
+		test.AssertBytesEqual(t, data, []byte("data"))
+
+		test.AssertNoError(t, fs.MkdirAll("dir"))
+	})
+
+	t.Run("it refuses any change", func(t *testing.T) {
+		fs := setup(t)
+		path := filepath.Join("dir", "file")
+
+		_, err := fs.Open(path, os.O_RDWR)
+		test.AssertError(t, err, ReadOnlyError)
+		_, err = fs.Create(filepath.Join("dir", "other"))
+		test.AssertError(t, err, ReadOnlyError)
+		test.AssertError(t, fs.Remove(path), ReadOnlyError)
+		test.AssertError(t, fs.Rename(path, filepath.Join("dir", "other")), ReadOnlyError)
+		test.AssertError(t, fs.MkdirAll("other"), ReadOnlyError)
+
+		names, err := fs.List("dir")
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, len(names), 1)
+	})
+}
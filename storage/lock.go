@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+var LockedError = errors.New("storage: locked by another process")
+
+// FileLock is an exclusive, advisory lock held on a file of the host file
+// system, e.g. to keep a second process off a data directory. The operating
+// system drops it along with the process, so a crash never leaves it behind.
+type FileLock struct {
+	file *os.File
+}
+
+// LockFile locks the file at path, creating it if needed. It does not wait
+// for a lock held elsewhere, but fails with LockedError right away. The file
+// holds the ID of the locking process, for the error to name it.
+func LockFile(path string) (*FileLock, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = lock(file); err != nil {
+		if errors.Is(err, LockedError) {
+			holder, _ := io.ReadAll(io.LimitReader(file, 32))
+			err = fmt.Errorf("%w: %s is held by process %s", LockedError, path, bytes.TrimSpace(holder))
+		}
+
+		return nil, errors.Join(err, file.Close())
+	}
+
+	if err = file.Truncate(0); err != nil {
+		return nil, errors.Join(err, file.Close())
+	}
+
+	if _, err = file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		return nil, errors.Join(err, file.Close())
+	}
+
+	return &FileLock{file: file}, nil
+}
+
+// Close releases the lock. The file stays, removing it could let a process
+// that opened it meanwhile lock a file no one else sees any more.
+func (l *FileLock) Close() error {
+	return l.file.Close()
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package storage
+
+import "os"
+
+// lock is a no-op where flock is not available, such platforms rely on a
+// single process being started per data directory.
+func lock(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,39 @@
+//go:build unix
+
+package storage
+
+import (
+	"kv/test"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLockFile(t *testing.T) {
+	t.Run("it fails while the lock is held", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "LOCK")
+
+		held, err := LockFile(path)
+		test.AssertNoError(t, err)
+
+		_, err = LockFile(path)
+		test.AssertError(t, err, LockedError)
+		test.AssertTrue(t, strings.Contains(err.Error(), strconv.Itoa(os.Getpid())))
+
+		test.AssertNoError(t, held.Close())
+	})
+
+	t.Run("it locks again once released", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "LOCK")
+
+		held, err := LockFile(path)
+		test.AssertNoError(t, err)
+		test.AssertNoError(t, held.Close())
+
+		held, err = LockFile(path)
+		test.AssertNoError(t, err)
+		test.AssertNoError(t, held.Close())
+	})
+}
@@ -2,44 +2,80 @@ package storage
 
 import (
 	"errors"
-	"os"
 	"path/filepath"
+	"sync"
 )
 
 var FileNotOpenError = errors.New("file manager: file not open")
 
+// Manager opens files through an FS and keeps them open for the lifetime of
+// the store. Opening the same file twice returns the same handle. Close
+// closes every file it opened.
 type Manager struct {
-	files map[string]*os.File
+	fs    FS
+	mutex sync.Mutex
+	files map[string]FileHandle
 }
 
-func NewManager() *Manager {
-	return &Manager{make(map[string]*os.File)}
+func NewManager(fs FS) *Manager {
+	return &Manager{
+		fs:    fs,
+		files: make(map[string]FileHandle),
+	}
 }
 
-func (fm *Manager) Open(filename string, flag int) (*os.File, error) {
-	dir := filepath.Dir(filename)
-
-	if err := EnsureDirectoryExists(dir); err != nil {
-		return nil, err
-	}
+func (fm *Manager) FS() FS {
+	return fm.fs
+}
 
-	file, err := fm.get(filename)
+func (fm *Manager) Open(filename string, flag int) (FileHandle, error) {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
 
-	if err == nil {
+	if file, err := fm.get(filename); err == nil {
 		return file, nil
 	}
 
-	file, err = os.OpenFile(filename, flag, 0644)
+	if err := fm.fs.MkdirAll(filepath.Dir(filename)); err != nil {
+		return nil, err
+	}
 
+	exists, err := Exists(fm.fs, filename)
 	if err != nil {
 		return nil, err
 	}
 
+	file, err := fm.fs.Open(filename, flag)
+	if err != nil {
+		return nil, err
+	}
+
+	// Creating the file changed its directory, which needs a sync of its own.
+	if !exists {
+		if err = fm.fs.SyncDir(filepath.Dir(filename)); err != nil {
+			return nil, errors.Join(err, file.Close())
+		}
+	}
+
 	fm.files[filename] = file
 	return file, nil
 }
 
-func (fm *Manager) get(filename string) (*os.File, error) {
+func (fm *Manager) Close() error {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+
+	var err error
+
+	for filename, file := range fm.files {
+		err = errors.Join(err, file.Close())
+		delete(fm.files, filename)
+	}
+
+	return err
+}
+
+func (fm *Manager) get(filename string) (FileHandle, error) {
 	file, ok := fm.files[filename]
 
 	if !ok {
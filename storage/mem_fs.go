@@ -0,0 +1,390 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+var FileClosedError = errors.New("storage: file closed")
+
+// MemFS is an FS kept in memory, for tests that should not touch the disk.
+// Like a real file system, files can only be created in directories that
+// exist.
+type MemFS struct {
+	mutex       sync.Mutex
+	files       map[string]*memNode
+	directories map[string]struct{}
+}
+
+type memNode struct {
+	mutex sync.RWMutex
+	data  []byte
+}
+
+func NewMemFS() *MemFS {
+	return &MemFS{
+		files:       make(map[string]*memNode),
+		directories: map[string]struct{}{".": {}, string(filepath.Separator): {}},
+	}
+}
+
+func (m *MemFS) Open(name string, flag int) (FileHandle, error) {
+	name = filepath.Clean(name)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	node, ok := m.files[name]
+
+	switch {
+	case ok && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, pathError("open", name, fs.ErrExist)
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, pathError("open", name, fs.ErrNotExist)
+	case !ok:
+		if _, ok = m.directories[filepath.Dir(name)]; !ok {
+			return nil, pathError("open", name, fs.ErrNotExist)
+		}
+
+		if _, ok = m.directories[name]; ok {
+			return nil, pathError("open", name, fs.ErrExist)
+		}
+
+		node = &memNode{}
+		m.files[name] = node
+	}
+
+	if flag&os.O_TRUNC != 0 && isWritable(flag) {
+		node.mutex.Lock()
+		node.data = nil
+		node.mutex.Unlock()
+	}
+
+	return &memFile{
+		node:     node,
+		name:     name,
+		flag:     flag,
+		readable: flag&os.O_WRONLY == 0,
+		writable: isWritable(flag),
+	}, nil
+}
+
+func (m *MemFS) Create(name string) (FileHandle, error) {
+	return m.Open(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+}
+
+func (m *MemFS) Remove(name string) error {
+	name = filepath.Clean(name)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.files[name]; ok {
+		delete(m.files, name)
+		return nil
+	}
+
+	if _, ok := m.directories[name]; ok {
+		if len(m.children(name)) > 0 {
+			return pathError("remove", name, errors.New("directory not empty"))
+		}
+
+		delete(m.directories, name)
+		return nil
+	}
+
+	return pathError("remove", name, fs.ErrNotExist)
+}
+
+func (m *MemFS) Rename(oldName, newName string) error {
+	oldName, newName = filepath.Clean(oldName), filepath.Clean(newName)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	node, ok := m.files[oldName]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: fs.ErrNotExist}
+	}
+
+	if _, ok = m.directories[filepath.Dir(newName)]; !ok {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: fs.ErrNotExist}
+	}
+
+	delete(m.files, oldName)
+	m.files[newName] = node
+
+	return nil
+}
+
+func (m *MemFS) List(directory string) ([]string, error) {
+	directory = filepath.Clean(directory)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.directories[directory]; !ok {
+		return nil, pathError("open", directory, fs.ErrNotExist)
+	}
+
+	names := m.children(directory)
+	sort.Strings(names)
+
+	return names, nil
+}
+
+func (m *MemFS) MkdirAll(directory string) error {
+	directory = filepath.Clean(directory)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for dir := directory; ; dir = filepath.Dir(dir) {
+		if _, ok := m.files[dir]; ok {
+			return pathError("mkdir", dir, errors.New("not a directory"))
+		}
+
+		m.directories[dir] = struct{}{}
+
+		if parent := filepath.Dir(dir); parent == dir {
+			return nil
+		}
+	}
+}
+
+func (m *MemFS) SyncDir(directory string) error {
+	directory = filepath.Clean(directory)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.directories[directory]; !ok {
+		return pathError("open", directory, fs.ErrNotExist)
+	}
+
+	return nil
+}
+
+// children returns the names of the direct entries of a directory.
+func (m *MemFS) children(directory string) []string {
+	var names []string
+
+	add := func(path string) {
+		if path != directory && filepath.Dir(path) == directory {
+			names = append(names, filepath.Base(path))
+		}
+	}
+
+	for path := range m.files {
+		add(path)
+	}
+
+	for path := range m.directories {
+		add(path)
+	}
+
+	return names
+}
+
+type memFile struct {
+	node     *memNode
+	name     string
+	flag     int
+	readable bool
+	writable bool
+
+	mutex  sync.Mutex
+	offset int64
+	closed bool
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	n, err := f.readAt(p, f.offset)
+	f.offset += int64(n)
+
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+
+	return n, err
+}
+
+func (f *memFile) ReadAt(p []byte, offset int64) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.readAt(p, offset)
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	offset := f.offset
+
+	if f.flag&os.O_APPEND != 0 {
+		f.node.mutex.RLock()
+		offset = int64(len(f.node.data))
+		f.node.mutex.RUnlock()
+	}
+
+	n, err := f.writeAt(p, offset)
+	f.offset = offset + int64(n)
+
+	return n, err
+}
+
+func (f *memFile) WriteAt(p []byte, offset int64) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.flag&os.O_APPEND != 0 {
+		return 0, pathError("write", f.name, errors.New("WriteAt on a file opened with O_APPEND"))
+	}
+
+	return f.writeAt(p, offset)
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.closed {
+		return 0, FileClosedError
+	}
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		f.node.mutex.RLock()
+		offset += int64(len(f.node.data))
+		f.node.mutex.RUnlock()
+	default:
+		return 0, pathError("seek", f.name, errors.New("invalid whence"))
+	}
+
+	if offset < 0 {
+		return 0, pathError("seek", f.name, errors.New("negative position"))
+	}
+
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *memFile) Size() (int64, error) {
+	if f.isClosed() {
+		return 0, FileClosedError
+	}
+
+	f.node.mutex.RLock()
+	defer f.node.mutex.RUnlock()
+
+	return int64(len(f.node.data)), nil
+}
+
+func (f *memFile) Allocate(size int64) error {
+	if f.isClosed() {
+		return FileClosedError
+	}
+
+	f.node.mutex.Lock()
+	defer f.node.mutex.Unlock()
+
+	if int64(len(f.node.data)) < size {
+		f.node.data = append(f.node.data, make([]byte, size-int64(len(f.node.data)))...)
+	}
+
+	return nil
+}
+
+func (f *memFile) Sync() error {
+	if f.isClosed() {
+		return FileClosedError
+	}
+
+	return nil
+}
+
+func (f *memFile) Datasync() error {
+	return f.Sync()
+}
+
+func (f *memFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.closed {
+		return FileClosedError
+	}
+
+	f.closed = true
+	return nil
+}
+
+func (f *memFile) isClosed() bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.closed
+}
+
+func (f *memFile) readAt(p []byte, offset int64) (int, error) {
+	if f.closed {
+		return 0, FileClosedError
+	}
+
+	if !f.readable {
+		return 0, pathError("read", f.name, errors.New("file not open for reading"))
+	}
+
+	f.node.mutex.RLock()
+	defer f.node.mutex.RUnlock()
+
+	if offset >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, f.node.data[offset:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (f *memFile) writeAt(p []byte, offset int64) (int, error) {
+	if f.closed {
+		return 0, FileClosedError
+	}
+
+	if !f.writable {
+		return 0, pathError("write", f.name, errors.New("file not open for writing"))
+	}
+
+	f.node.mutex.Lock()
+	defer f.node.mutex.Unlock()
+
+	if end := offset + int64(len(p)); end > int64(len(f.node.data)) {
+		f.node.data = append(f.node.data, make([]byte, end-int64(len(f.node.data)))...)
+	}
+
+	return copy(f.node.data[offset:], p), nil
+}
+
+func isWritable(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR) != 0
+}
+
+func pathError(op, path string, err error) error {
+	return &fs.PathError{Op: op, Path: path, Err: err}
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"sort"
+)
+
+// OSFS is the FS of the host operating system.
+type OSFS struct{}
+
+func NewOSFS() *OSFS {
+	return &OSFS{}
+}
+
+func (OSFS) Open(name string, flag int) (FileHandle, error) {
+	file, err := os.OpenFile(name, flag, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &osFile{file}, nil
+}
+
+func (fs OSFS) Create(name string) (FileHandle, error) {
+	return fs.Open(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+}
+
+func (OSFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (OSFS) Rename(oldName, newName string) error {
+	return os.Rename(oldName, newName)
+}
+
+func (OSFS) List(directory string) ([]string, error) {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+func (OSFS) MkdirAll(directory string) error {
+	return os.MkdirAll(directory, 0755)
+}
+
+func (OSFS) SyncDir(directory string) error {
+	dir, err := os.Open(directory)
+	if err != nil {
+		return err
+	}
+
+	return errors.Join(dir.Sync(), dir.Close())
+}
+
+type osFile struct {
+	*os.File
+}
+
+func (f *osFile) Size() (int64, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	return fi.Size(), nil
+}
+
+func (f *osFile) Allocate(size int64) error {
+	current, err := f.Size()
+	if err != nil || current >= size {
+		return err
+	}
+
+	return allocate(f.File, size)
+}
+
+func (f *osFile) Datasync() error {
+	return datasync(f.File)
+}
@@ -0,0 +1,28 @@
+//go:build linux
+
+package storage
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// allocate reserves disk space for the whole file, so later writes within it
+// do not change file metadata. Falls back to extending the file on file
+// systems without fallocate support.
+func allocate(file *os.File, size int64) error {
+	err := syscall.Fallocate(int(file.Fd()), 0, 0, size)
+
+	if errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS) {
+		return file.Truncate(size)
+	}
+
+	return err
+}
+
+// datasync flushes file data without waiting for unrelated metadata, such as
+// modification times, to reach the disk.
+func datasync(file *os.File) error {
+	return syscall.Fdatasync(int(file.Fd()))
+}
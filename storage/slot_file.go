@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+var SlotsCorruptedError = errors.New("storage: no intact slot")
+var SlotOverflowError = errors.New("storage: content does not fit into a slot")
+
+// Slots are a sector each, so that a write torn within one slot never
+// reaches into the other.
+const (
+	slotSize           = 512
+	slotMagicSize      = 4
+	slotGenerationSize = 8
+	slotLengthSize     = 4
+	slotChecksumSize   = 4
+	slotHeaderSize     = slotMagicSize + slotGenerationSize + slotLengthSize
+
+	// MaxSlotContent is the largest content a SlotFile holds.
+	MaxSlotContent = slotSize - slotHeaderSize - slotChecksumSize
+)
+
+var slotMagic = []byte("KVS1")
+
+// SlotFile keeps the content of a small file, e.g. a manifest, in two slots
+// written in turns. A write never touches the slot holding the latest
+// content, so a crash in the middle of it leaves that content intact. The
+// slot with the highest generation and a valid checksum holds the latest
+// content.
+//
+// Each slot is laid out as magic (4 bytes), generation (8), content length
+// (4), the content and a CRC32 of everything before it.
+type SlotFile struct {
+	file File
+
+	loaded     bool
+	slot       int
+	generation uint64
+}
+
+func NewSlotFile(file File) *SlotFile {
+	return &SlotFile{file: file}
+}
+
+// Read returns the latest content, nil for an empty file. A file written
+// before slots were used is returned as is.
+func (f *SlotFile) Read() ([]byte, error) {
+	if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(f.file)
+	if err != nil {
+		return nil, err
+	}
+
+	f.loaded = true
+	f.slot, f.generation = 1, 0
+
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	var latest []byte
+	found := false
+
+	for slot := range 2 {
+		content, generation, ok := decodeSlot(data, slot)
+
+		if ok && (!found || generation > f.generation) {
+			latest, found = content, true
+			f.slot, f.generation = slot, generation
+		}
+	}
+
+	if found {
+		return latest, nil
+	}
+
+	if bytes.HasPrefix(data, slotMagic) {
+		return nil, SlotsCorruptedError
+	}
+
+	// Content from before slots, kept in place of the first slot until the
+	// second one is written. A torn write of the second slot leaves zeroes
+	// behind the old content, which are not part of it.
+	f.slot = 0
+
+	if len(data) > slotSize {
+		return bytes.TrimRight(data[:slotSize], "\x00"), nil
+	}
+
+	return data, nil
+}
+
+// Write replaces the content, syncing the file before it returns.
+func (f *SlotFile) Write(content []byte) error {
+	if len(content) > MaxSlotContent {
+		return SlotOverflowError
+	}
+
+	if !f.loaded {
+		if _, err := f.Read(); err != nil {
+			return err
+		}
+	}
+
+	slot, generation := 1-f.slot, f.generation+1
+
+	buf := make([]byte, 0, slotHeaderSize+len(content)+slotChecksumSize)
+	buf = append(buf, slotMagic...)
+	buf = binary.LittleEndian.AppendUint64(buf, generation)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(content)))
+	buf = append(buf, content...)
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+
+	if _, err := f.file.Seek(int64(slot*slotSize), io.SeekStart); err != nil {
+		return err
+	}
+
+	if _, err := f.file.Write(buf); err != nil {
+		return err
+	}
+
+	if err := f.file.Sync(); err != nil {
+		return err
+	}
+
+	f.slot, f.generation = slot, generation
+	return nil
+}
+
+func decodeSlot(data []byte, slot int) (content []byte, generation uint64, ok bool) {
+	start := slot * slotSize
+	if len(data) < start+slotHeaderSize+slotChecksumSize {
+		return nil, 0, false
+	}
+
+	buf := data[start:min(len(data), start+slotSize)]
+
+	if !bytes.HasPrefix(buf, slotMagic) {
+		return nil, 0, false
+	}
+
+	generation = binary.LittleEndian.Uint64(buf[slotMagicSize:])
+	length := int(binary.LittleEndian.Uint32(buf[slotMagicSize+slotGenerationSize:]))
+
+	if length > len(buf)-slotHeaderSize-slotChecksumSize {
+		return nil, 0, false
+	}
+
+	end := slotHeaderSize + length
+	if crc32.ChecksumIEEE(buf[:end]) != binary.LittleEndian.Uint32(buf[end:]) {
+		return nil, 0, false
+	}
+
+	return buf[slotHeaderSize:end], generation, true
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"kv/storage/mocks"
+	"kv/test"
+	"testing"
+)
+
+func TestSlotFile(t *testing.T) {
+	t.Run("it reads back the latest content", func(t *testing.T) {
+		file := mocks.NewFile()
+
+		for _, content := range []string{"first", "second", "third"} {
+			test.AssertNoError(t, NewSlotFile(file).Write([]byte(content)))
+		}
+
+		content, err := NewSlotFile(file).Read()
+		test.AssertNoError(t, err)
+		test.AssertBytesEqual(t, content, []byte("third"))
+	})
+
+	t.Run("it returns nil for an empty file", func(t *testing.T) {
+		content, err := NewSlotFile(mocks.NewFile()).Read()
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, len(content), 0)
+	})
+
+	t.Run("it keeps the previous content when a write is torn", func(t *testing.T) {
+		file := mocks.NewFile()
+		slots := NewSlotFile(file)
+		_ = slots.Write([]byte("first"))
+		_ = slots.Write([]byte("second"))
+
+		file.Data[slotSize+slotHeaderSize] ^= 0xff
+
+		content, err := NewSlotFile(file).Read()
+		test.AssertNoError(t, err)
+		test.AssertBytesEqual(t, content, []byte("first"))
+	})
+
+	t.Run("it refuses a file without intact slots", func(t *testing.T) {
+		file := mocks.NewFile()
+		_ = NewSlotFile(file).Write([]byte("first"))
+
+		file.Data[slotHeaderSize] ^= 0xff
+
+		_, err := NewSlotFile(file).Read()
+		test.AssertError(t, err, SlotsCorruptedError)
+	})
+
+	t.Run("it reads and replaces content written before slots", func(t *testing.T) {
+		file := mocks.NewFile()
+		_, _ = file.Write([]byte("legacy"))
+
+		slots := NewSlotFile(file)
+		content, err := slots.Read()
+		test.AssertNoError(t, err)
+		test.AssertBytesEqual(t, content, []byte("legacy"))
+
+		test.AssertNoError(t, slots.Write([]byte("new")))
+
+		content, err = NewSlotFile(file).Read()
+		test.AssertNoError(t, err)
+		test.AssertBytesEqual(t, content, []byte("new"))
+	})
+
+	t.Run("it refuses content larger than a slot", func(t *testing.T) {
+		err := NewSlotFile(mocks.NewFile()).Write(make([]byte, MaxSlotContent+1))
+		test.AssertError(t, err, SlotOverflowError)
+	})
+}